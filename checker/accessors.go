@@ -0,0 +1,52 @@
+package checker
+
+import (
+	"github.com/rancher/go-rancher-metadata/metadata"
+)
+
+// The metadata accessors return the references currently held by
+// the peer, which are shared with the watcher: callers must not
+// modify them. They're nil for fixed targets.
+
+// Host returns the metadata of the host running the peer
+func (p *Peer) Host() *metadata.Host {
+	p.Lock()
+	defer p.Unlock()
+	return p.host
+}
+
+// Container returns the metadata of the checked container
+func (p *Peer) Container() *metadata.Container {
+	p.Lock()
+	defer p.Unlock()
+	return p.container
+}
+
+// CCContainer returns the metadata of the connectivity-check
+// container running on the host of the peer
+func (p *Peer) CCContainer() *metadata.Container {
+	p.Lock()
+	defer p.Unlock()
+	return p.ccContainer
+}
+
+// UUID returns the uuid of the peer
+func (p *Peer) UUID() string {
+	return p.uuid
+}
+
+// SetUserData attaches data of the caller to the peer, e.g. the
+// service object it stands for, sparing a map by uuid. The package
+// never interprets it. It's lost when the peer is removed.
+func (p *Peer) SetUserData(data interface{}) {
+	p.Lock()
+	defer p.Unlock()
+	p.userData = data
+}
+
+// UserData returns the data attached by SetUserData, nil if none
+func (p *Peer) UserData() interface{} {
+	p.Lock()
+	defer p.Unlock()
+	return p.userData
+}
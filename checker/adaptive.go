@@ -0,0 +1,55 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/go-rancher-metadata/metadata"
+)
+
+// growInterval doubles the interval between the checks of a steadily
+// reachable peer, up to MaxAdaptiveInterval. It must be called with
+// the lock held.
+func (p *Peer) growInterval() {
+	if p.config.MaxAdaptiveInterval <= p.baseInterval() {
+		return
+	}
+	max := time.Duration(p.config.MaxAdaptiveInterval) * time.Millisecond
+	interval := 2 * p.checkIntervalDuration()
+	if interval > max {
+		interval = max
+	}
+	if interval != p.adaptiveInterval {
+		p.debugf("Peer(%v): steadily reachable, checking every %v", p.uuid, interval)
+	}
+	p.adaptiveInterval = interval
+}
+
+// resetInterval goes back to checking every CheckInterval, it must
+// be called with the lock held
+func (p *Peer) resetInterval() {
+	if p.adaptiveInterval != 0 {
+		p.debugf("Peer(%v): back to checking every %v", p.uuid, time.Duration(p.baseInterval())*time.Millisecond)
+	}
+	p.adaptiveInterval = 0
+}
+
+// metadataChanged informs if the states found in metadata, or the
+// IP, differ from the ones of the peer, it must be called with the
+// lock held
+func (p *Peer) metadataChanged(container, ccContainer *metadata.Container, host *metadata.Host) bool {
+	if (p.container == nil) != (container == nil) ||
+		(p.ccContainer == nil) != (ccContainer == nil) ||
+		(p.host == nil) != (host == nil) {
+		return true
+	}
+	if container != nil && (container.State != p.container.State || container.PrimaryIp != p.container.PrimaryIp) {
+		return true
+	}
+	if ccContainer != nil && ccContainer.State != p.ccContainer.State {
+		return true
+	}
+	if host != nil && (host.State != p.host.State || host.AgentState != p.host.AgentState) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package checker
+
+import (
+	"time"
+)
+
+// minAdaptiveTimeout keeps the adaptive timeout of the nearest peers
+// from being so tight that a hiccup makes them time out
+const minAdaptiveTimeout = 100 * time.Millisecond
+
+// connectionTimeout returns the timeout, in milliseconds, of the next
+// check of the peer. With PeerConfig.AdaptiveTimeoutFactor it's the
+// average latency of the peer times the factor, within
+// minAdaptiveTimeout and AdaptiveTimeoutCeiling, the ConnectionTimeout
+// otherwise or until the latency is known. It must be called with the
+// lock held.
+func (p *Peer) connectionTimeout() int {
+	if p.config.AdaptiveTimeoutFactor <= 0 {
+		return p.config.ConnectionTimeout
+	}
+	latency := p.avgLatency
+	if latency == 0 {
+		latency = p.baselineLatency
+	}
+	if latency == 0 {
+		return p.config.ConnectionTimeout
+	}
+
+	timeout := time.Duration(p.config.AdaptiveTimeoutFactor * float64(latency))
+	if timeout < minAdaptiveTimeout {
+		timeout = minAdaptiveTimeout
+	}
+	ceiling := p.config.AdaptiveTimeoutCeiling
+	if ceiling <= 0 {
+		ceiling = p.config.minCheckInterval()
+	}
+	if max := time.Duration(ceiling) * time.Millisecond; timeout > max {
+		timeout = max
+	}
+	return int(timeout / time.Millisecond)
+}
+
+// ConnectionTimeout returns the timeout applied to the next check of
+// the peer, see PeerConfig.AdaptiveTimeoutFactor
+func (p *Peer) ConnectionTimeout() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return time.Duration(p.connectionTimeout()) * time.Millisecond
+}
@@ -0,0 +1,98 @@
+package checker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+const (
+	// DefaultStateWriteInterval is the default minimum interval,
+	// in milliseconds, between two writes of the state of the peers
+	DefaultStateWriteInterval = 10000
+)
+
+// StateWriter writes the reachability of a peer back to where the
+// operators look, e.g. as a label of its container through the
+// Rancher API, see RancherStateWriter
+type StateWriter interface {
+	WriteState(status PeerStatus) error
+}
+
+// stateExporter coalesces the transitions of the peers and hands
+// the latest state of each to the StateWriter at most once per
+// interval, so that flapping peers don't hammer the API
+type stateExporter struct {
+	sync.Mutex
+	writer   StateWriter
+	interval time.Duration
+	pending  map[string]PeerStatus
+	exit     chan struct{}
+	done     chan struct{}
+}
+
+func newStateExporter(writer StateWriter, intervalMs int) *stateExporter {
+	if intervalMs <= 0 {
+		intervalMs = DefaultStateWriteInterval
+	}
+	return &stateExporter{
+		writer:   writer,
+		interval: time.Duration(intervalMs) * time.Millisecond,
+		pending:  make(map[string]PeerStatus),
+		exit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (e *stateExporter) add(status PeerStatus) {
+	e.Lock()
+	defer e.Unlock()
+	e.pending[status.UUID] = status
+}
+
+// flush writes the pending states, the ones failing to be written
+// are written again at the next flush, unless a newer state of their
+// peer is pending by then
+func (e *stateExporter) flush() {
+	e.Lock()
+	pending := e.pending
+	e.pending = make(map[string]PeerStatus)
+	e.Unlock()
+
+	failed := make(map[string]PeerStatus)
+	for uuid, status := range pending {
+		if err := e.writer.WriteState(status); err != nil {
+			log.Errorf("error writing state of peer %v, retrying at the next write: %v", uuid, err)
+			failed[uuid] = status
+		}
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	for uuid, status := range failed {
+		if _, ok := e.pending[uuid]; !ok {
+			e.pending[uuid] = status
+		}
+	}
+}
+
+func (e *stateExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.exit:
+			e.flush()
+			return
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+func (e *stateExporter) stop() {
+	close(e.exit)
+	<-e.done
+}
@@ -0,0 +1,167 @@
+package checker
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/log"
+)
+
+// asyncLogger hands the logs of the checks to a goroutine through a
+// bounded buffer, so that a slow log backend can't delay the checks.
+// When the buffer is full the logs are dropped and counted. The logs
+// go to out, the default Logger when nil. A nil asyncLogger, or one
+// without a buffer, logs synchronously.
+type asyncLogger struct {
+	entries         chan logEntry
+	exit            chan struct{}
+	done            chan struct{}
+	dropped         uint64
+	syncTransitions bool
+	out             Logger
+	sync            *asyncLogger
+}
+
+type logEntry struct {
+	level logrus.Level
+	msg   string
+	out   Logger
+}
+
+func newAsyncLogger(size int, syncTransitions bool, out Logger) *asyncLogger {
+	return &asyncLogger{
+		entries:         make(chan logEntry, size),
+		exit:            make(chan struct{}),
+		done:            make(chan struct{}),
+		syncTransitions: syncTransitions,
+		out:             out,
+		sync:            newSyncLogger(out),
+	}
+}
+
+// newSyncLogger returns an asyncLogger logging synchronously to out,
+// nil for the default Logger
+func newSyncLogger(out Logger) *asyncLogger {
+	if out == nil {
+		return nil
+	}
+	return &asyncLogger{out: out}
+}
+
+// async informs if the logs go through the buffer and its goroutine
+func (l *asyncLogger) async() bool {
+	return l != nil && l.entries != nil
+}
+
+func (l *asyncLogger) run() {
+	defer close(l.done)
+	for {
+		select {
+		case e := <-l.entries:
+			e.write()
+		case <-l.exit:
+			for {
+				select {
+				case e := <-l.entries:
+					e.write()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop writes the logs still buffered and stops the goroutine,
+// the logs coming after are dropped
+func (l *asyncLogger) stop() {
+	close(l.exit)
+	<-l.done
+}
+
+func (e logEntry) write() {
+	switch e.level {
+	case logrus.DebugLevel:
+		e.out.Debugf("%s", e.msg)
+	case logrus.InfoLevel:
+		e.out.Infof("%s", e.msg)
+	case logrus.WarnLevel:
+		e.out.Warnf("%s", e.msg)
+	default:
+		e.out.Errorf("%s", e.msg)
+	}
+}
+
+func (l *asyncLogger) logf(level logrus.Level, format string, args ...interface{}) {
+	var out Logger
+	if l != nil {
+		out = l.out
+	}
+	// A Logger of the caller filters the levels itself
+	if out == nil {
+		if log.GetLevel() < level {
+			return
+		}
+		out = rancherLogger{}
+	}
+	e := logEntry{level: level, msg: fmt.Sprintf(format, args...), out: out}
+	if !l.async() {
+		e.write()
+		return
+	}
+	select {
+	case <-l.exit:
+		atomic.AddUint64(&l.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case l.entries <- e:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+func (l *asyncLogger) Debugf(format string, args ...interface{}) {
+	l.logf(logrus.DebugLevel, format, args...)
+}
+
+// forceDebugf logs a debug log whatever the level of the logs, as an
+// info log so that it isn't filtered out
+func (l *asyncLogger) forceDebugf(format string, args ...interface{}) {
+	l.logf(logrus.InfoLevel, "[debug] "+format, args...)
+}
+
+func (l *asyncLogger) Infof(format string, args ...interface{}) {
+	l.logf(logrus.InfoLevel, format, args...)
+}
+
+func (l *asyncLogger) Warnf(format string, args ...interface{}) {
+	l.logf(logrus.WarnLevel, format, args...)
+}
+
+func (l *asyncLogger) Errorf(format string, args ...interface{}) {
+	l.logf(logrus.ErrorLevel, format, args...)
+}
+
+// forTransitions returns the logger of the transitions, a synchronous
+// one if so configured
+func (l *asyncLogger) forTransitions() *asyncLogger {
+	if l == nil {
+		return nil
+	}
+	if l.syncTransitions {
+		return l.sync
+	}
+	return l
+}
+
+// Dropped returns the number of logs dropped because the
+// buffer was full
+func (l *asyncLogger) Dropped() uint64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&l.dropped)
+}
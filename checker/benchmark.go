@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// BenchResult holds the outcome of a Benchmark run
+type BenchResult struct {
+	Attempts  int
+	Successes int
+	Errors    map[utils.FailureReason]int
+	Min       time.Duration
+	Max       time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+}
+
+// Benchmark issues n probes against the peer, with up to concurrency
+// of them in flight, and collects latency stats. It doesn't affect
+// the health of the peer. It stops early if ctx is done.
+func (p *Peer) Benchmark(ctx context.Context, n int, concurrency int) BenchResult {
+	result := BenchResult{Errors: make(map[utils.FailureReason]int)}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	p.Lock()
+	probe, ok := p.probe()
+	checker, err := p.modeChecker()
+	p.Unlock()
+	if err != nil || !ok {
+		result.Errors[utils.FailureOther] = n
+		result.Attempts = n
+		return result
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	latencies := make([]time.Duration, 0, n)
+	sem := make(chan struct{}, concurrency)
+
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			ok, err := checker.Check(probe)
+			latency := time.Since(start)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Attempts++
+			if ok {
+				result.Successes++
+				latencies = append(latencies, latency)
+			} else {
+				result.Errors[utils.ReasonOf(err)]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.Min = latencies[0]
+		result.Max = latencies[len(latencies)-1]
+		result.P50 = percentile(latencies, 50)
+		result.P90 = percentile(latencies, 90)
+		result.P99 = percentile(latencies, 99)
+	}
+	return result
+}
+
+// percentile expects sorted to be sorted in ascending order
+func percentile(sorted []time.Duration, pct int) time.Duration {
+	i := (len(sorted)*pct+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,149 @@
+package checker
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+const (
+	// DefaultBreakerCooldown is the default time, in milliseconds,
+	// the peers of a host whose breaker opened wait before a trial
+	DefaultBreakerCooldown = 30000
+
+	// BreakerClosed lets the peers of the host be checked
+	BreakerClosed = "closed"
+	// BreakerOpen holds the checks of the peers of the host, the path
+	// to it being broken
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets a single peer of the host be checked, as a
+	// trial telling whether the path to it works again
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerState is the state of the circuit breaker of a destination
+// host, see Config.BreakerThreshold
+type BreakerState struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+	// Trial is the uuid of the peer checked while half-open
+	Trial string `json:"trial,omitempty"`
+}
+
+// breakers are circuit breakers by destination host shared by all the
+// peers of a watcher: once Threshold checks in a row of the peers of a
+// host failed, none of them is checked for the cooldown, then a single
+// one is, as a trial closing the breaker on success or opening it
+// again on failure
+type breakers struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	hosts     map[string]*BreakerState
+	trialAt   map[string]time.Time
+}
+
+func newBreakers(threshold, cooldownMs int) *breakers {
+	if threshold <= 0 {
+		return nil
+	}
+	if cooldownMs <= 0 {
+		cooldownMs = DefaultBreakerCooldown
+	}
+	return &breakers{
+		threshold: threshold,
+		cooldown:  time.Duration(cooldownMs) * time.Millisecond,
+		hosts:     make(map[string]*BreakerState),
+		trialAt:   make(map[string]time.Time),
+	}
+}
+
+// allow informs if the peer with the given uuid can check the host,
+// taking the trial when the cooldown is over. A trial whose result
+// never came is handed over after another cooldown. A nil breakers
+// allows everything.
+func (b *breakers) allow(host, uuid string, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	s, found := b.hosts[host]
+	if !found {
+		return true
+	}
+	switch s.State {
+	case BreakerOpen:
+		if now.Sub(s.OpenedAt) < b.cooldown {
+			return false
+		}
+		s.State = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if s.Trial != uuid && now.Sub(b.trialAt[host]) < b.cooldown {
+			return false
+		}
+	default:
+		return true
+	}
+	s.Trial = uuid
+	b.trialAt[host] = now
+	return true
+}
+
+// record accounts for the result of a check of a peer of the host
+func (b *breakers) record(host, uuid string, ok bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	s, found := b.hosts[host]
+	if !found {
+		s = &BreakerState{State: BreakerClosed}
+		b.hosts[host] = s
+	}
+	switch {
+	case ok:
+		if s.State != BreakerClosed {
+			log.Infof("PeersWatcher: path to host %v works again, closing its breaker", host)
+		}
+		delete(b.hosts, host)
+		delete(b.trialAt, host)
+	case s.State == BreakerHalfOpen && s.Trial == uuid:
+		log.Errorf("PeersWatcher: trial of host %v by peer %v failed, opening its breaker again", host, uuid)
+		s.State, s.OpenedAt, s.Trial = BreakerOpen, now, ""
+		s.Failures++
+	case s.State == BreakerClosed:
+		if s.Failures++; s.Failures >= b.threshold {
+			log.Errorf("PeersWatcher: %v checks of host %v failed in a row, opening its breaker for %v", s.Failures, host, b.cooldown)
+			s.State, s.OpenedAt = BreakerOpen, now
+		}
+	}
+}
+
+func (b *breakers) states() map[string]BreakerState {
+	states := make(map[string]BreakerState)
+	if b == nil {
+		return states
+	}
+	b.Lock()
+	defer b.Unlock()
+	for host, s := range b.hosts {
+		states[host] = *s
+	}
+	return states
+}
+
+// Breakers returns the state of the circuit breakers by destination
+// host, the hosts whose checks last succeeded being left out, see
+// Config.BreakerThreshold
+func (pw *PeersWatcher) Breakers() map[string]BreakerState {
+	return pw.breakers.states()
+}
+
+func (pw *PeersWatcher) breakersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, pw.Breakers())
+}
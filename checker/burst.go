@@ -0,0 +1,81 @@
+package checker
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// maxBurstJitter is the upper bound of the random spacing
+	// between the probes of a burst
+	maxBurstJitter = 50 * time.Millisecond
+)
+
+// recordVariance updates the moving variance of the latency, it
+// must be called with the lock held before recordLatency
+func (p *Peer) recordVariance(latency time.Duration) {
+	if p.avgLatency == 0 {
+		return
+	}
+	diff := float64(latency - p.avgLatency)
+	p.latencyVariance = (1 - latencyWeight) * (p.latencyVariance + latencyWeight*diff*diff)
+}
+
+func (p *Peer) latencyStdDev() time.Duration {
+	return time.Duration(math.Sqrt(p.latencyVariance))
+}
+
+// shouldBurst informs if the latency became unstable enough
+// to look for packet loss
+func (p *Peer) shouldBurst() bool {
+	if p.config.BurstSize <= 0 || p.config.BurstJitterThreshold <= 0 {
+		return false
+	}
+	return p.latencyStdDev() > time.Duration(p.config.BurstJitterThreshold)*time.Millisecond
+}
+
+// burst sends a short burst of jittered probes and records the
+// fraction that failed. It doesn't affect the reachability of the
+// peer. It must be called without the lock held, which isn't held
+// while the probes are spaced out and sent either.
+func (p *Peer) burst(checker Checker) {
+	p.Lock()
+	probe, ok := p.probe()
+	host := p.getHostIP()
+	jitters := make([]time.Duration, p.config.BurstSize)
+	for i := range jitters {
+		jitters[i] = time.Duration(p.random.Int63n(int64(maxBurstJitter)))
+	}
+	if !ok || len(jitters) == 0 {
+		p.bursting = false
+		p.Unlock()
+		return
+	}
+	p.Unlock()
+
+	failed := 0
+	for _, jitter := range jitters {
+		time.Sleep(jitter)
+		release := p.limiter.acquire(host)
+		ok, _ := checker.Check(probe)
+		release()
+		if !ok {
+			failed++
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.bursting = false
+	p.lossRate = float64(failed) / float64(len(jitters))
+	p.logger.Infof("Peer(%v, %v, %v): latency jitter %v, burst of %v probes lost %.0f%%",
+		p.uuid, p.getHostIP(), p.getIP(), p.latencyStdDev(), len(jitters), p.lossRate*100)
+}
+
+// LossRate returns the fraction of probes lost during the most
+// recent burst, bursts being sent when the latency becomes unstable
+func (p *Peer) LossRate() float64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.lossRate
+}
@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"time"
+)
+
+// recordCheckCadence measures the time between the starts of two
+// checks of the loop, averaged like the latency, the checks forced by
+// CheckNow aren't counted. It must be called with the lock held.
+func (p *Peer) recordCheckCadence() {
+	now := p.now()
+	if !p.lastLoopCheck.IsZero() {
+		if interval := now.Sub(p.lastLoopCheck); interval > 0 {
+			if p.actualInterval == 0 {
+				p.actualInterval = interval
+			} else {
+				p.actualInterval = time.Duration(latencyWeight*float64(interval) + (1-latencyWeight)*float64(p.actualInterval))
+			}
+		}
+	}
+	p.lastLoopCheck = now
+}
+
+// ActualInterval returns the average time between the starts of two
+// checks of the peer, 0 until it was checked twice. It's more than the
+// configured interval when the checks take long enough to delay the
+// next ones, e.g. with a ConnectionTimeout close to the interval.
+func (p *Peer) ActualInterval() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.actualInterval
+}
+
+// IntervalDrift returns how much longer than configured the average
+// time between two checks of the peer is, see ActualInterval
+func (p *Peer) IntervalDrift() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	if p.actualInterval == 0 {
+		return 0
+	}
+	return p.actualInterval - p.checkIntervalDuration()
+}
@@ -0,0 +1,74 @@
+package checker
+
+import (
+	"github.com/rancher/log"
+)
+
+const (
+	canaryName = "canary"
+
+	// SelfExclude leaves the peers on the host of this node out of
+	// the checks
+	SelfExclude = "exclude"
+	// SelfInclude checks the peers on the host of this node like the
+	// others, over the network
+	SelfInclude = "include"
+	// SelfCanary leaves the peers on the host of this node out of the
+	// checks and checks this node over the loopback instead, as with
+	// Config.Canary
+	SelfCanary = "canary"
+)
+
+// startCanary starts checking the ping endpoint of this node over the
+// loopback when Config.Canary is set, or SelfPolicy is SelfCanary. The
+// canary isn't one of the peers nor of the targets, it tells whether
+// this node can check at all. It must be called with the lock held.
+func (pw *PeersWatcher) startCanary() error {
+	canary := pw.config.Canary || pw.config.SelfPolicy == SelfCanary
+	if !canary || pw.config.Port <= 0 {
+		return nil
+	}
+	t := Target{
+		Name: canaryName,
+		IP:   "127.0.0.1",
+		Port: pw.config.Port,
+		Path: defaultCheckPath,
+		Mode: ModeHTTP,
+	}
+	log.Infof("PeersWatcher: checking canary: %+v", t)
+	aPeer := pw.newPeer(t.Name)
+	aPeer.target = &t
+	// The transitions of the canary are only logged, they're not
+	// the ones of a peer
+	aPeer.config.OnStateChange = nil
+	aPeer.onTransition = nil
+	// Nor does the canary go through the proxy or to a rewritten IP,
+	// it checks the very path to this node
+	aPeer.config.ConnectProxy = ""
+	aPeer.config.RewriteIP = nil
+	pw.assignSeed(aPeer)
+	pw.canary = aPeer
+	return aPeer.Start()
+}
+
+// canaryHealthy informs if the canary, if any, is reachable, which it
+// is taken to be until first checked. It must be called with the lock
+// held, a check of the canary in flight isn't waited for.
+func (pw *PeersWatcher) canaryHealthy() bool {
+	if pw.canary == nil {
+		return true
+	}
+	status := pw.canary.lastStatus()
+	return status.LastChecked.IsZero() || status.Reachable
+}
+
+// CanaryHealthy informs if this node can reach its own ping endpoint,
+// see Config.Canary. When it can't, the checker or the local network
+// stack is likely broken, rather than the peers being unreachable. It's
+// true when there is no canary, and until the canary is first checked.
+// Both Ok and NetworkHealthy are false while it isn't.
+func (pw *PeersWatcher) CanaryHealthy() bool {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.canaryHealthy()
+}
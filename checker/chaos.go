@@ -0,0 +1,56 @@
+package checker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+var errInjectedFailure = &utils.CheckError{
+	Reason: utils.FailureInjected,
+	Err:    errors.New("failure injected on purpose"),
+}
+
+// InjectFailure makes the checks of the peer fail for the given
+// duration without probing it, e.g. to drill the alerting. The
+// failures carry utils.FailureInjected as their reason, in the logs,
+// the metrics and the status alike. A duration of 0 stops injecting.
+func (p *Peer) InjectFailure(d time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	if d <= 0 {
+		p.logger.Infof("Peer(%v): no longer injecting failures", p.uuid)
+		p.injectedUntil = time.Time{}
+		return
+	}
+	p.injectedUntil = p.now().Add(d)
+	p.logger.Infof("Peer(%v): injecting failures until %v", p.uuid, p.injectedUntil)
+}
+
+// failureInjected informs if the checks are to fail on purpose, it
+// must be called with the lock held
+func (p *Peer) failureInjected() bool {
+	return p.now().Before(p.injectedUntil)
+}
+
+// InjectFailures makes the checks of the peers with the given uuids,
+// or of all the peers and targets when none is given, fail for the
+// given duration, see Peer.InjectFailure
+func (pw *PeersWatcher) InjectFailures(d time.Duration, uuids ...string) {
+	log.Infof("PeersWatcher: injecting failures for %v into peers %v", d, uuids)
+	wanted := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		wanted[uuid] = true
+	}
+	pw.Lock()
+	peers := pw.allPeers()
+	pw.Unlock()
+
+	for _, aPeer := range peers {
+		if len(uuids) == 0 || wanted[aPeer.uuid] {
+			aPeer.InjectFailure(d)
+		}
+	}
+}
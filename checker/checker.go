@@ -20,5 +20,15 @@ type ConnectivityChecker interface {
 
 // New returns a new instance of ConnectivityChecker
 func New(port, syncInterval, connectTimeout int, mc metadata.Client) (*PeersWatcher, error) {
-	return NewPeersWatcher(port, syncInterval, connectTimeout, mc)
+	cfg := DefaultConfig()
+	cfg.Port = port
+	cfg.CheckInterval = syncInterval
+	cfg.ConnectionTimeout = connectTimeout
+	return NewWithConfig(cfg, mc)
+}
+
+// NewWithConfig returns a new instance of ConnectivityChecker
+// using the given Config
+func NewWithConfig(cfg Config, mc metadata.Client) (*PeersWatcher, error) {
+	return NewPeersWatcher(cfg, mc)
 }
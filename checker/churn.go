@@ -0,0 +1,109 @@
+package checker
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultChurnWindow is the default window, in milliseconds, over
+	// which the churn rate is computed
+	DefaultChurnWindow = 300000
+)
+
+// churnSink is implemented by the MetricsSinks exporting the churn
+// rate, the value being read when exported
+type churnSink interface {
+	SetChurnRate(rate func() float64)
+}
+
+// churn counts the transitions of all the peers in a rolling window,
+// by second so that the memory is bounded by the window. The first
+// transition of a peer, becoming reachable once first checked, isn't
+// churn.
+type churn struct {
+	sync.Mutex
+	buckets []uint64
+	// seconds is the second, since the epoch, each bucket counts
+	seconds []int64
+	seen    map[string]bool
+}
+
+func newChurn(windowMs int) *churn {
+	if windowMs <= 0 {
+		windowMs = DefaultChurnWindow
+	}
+	n := windowMs / 1000
+	if n < 1 {
+		n = 1
+	}
+	return &churn{
+		buckets: make([]uint64, n),
+		seconds: make([]int64, n),
+		seen:    make(map[string]bool),
+	}
+}
+
+// record counts a transition of the peer, a nil churn discards it
+func (c *churn) record(uuid string, at time.Time) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if !c.seen[uuid] {
+		c.seen[uuid] = true
+		return
+	}
+	second := at.Unix()
+	i := int(second % int64(len(c.buckets)))
+	if c.seconds[i] != second {
+		c.seconds[i], c.buckets[i] = second, 0
+	}
+	c.buckets[i]++
+}
+
+// forget drops the peer, its coming back not being churn
+func (c *churn) forget(uuid string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	delete(c.seen, uuid)
+}
+
+// rekey moves the peer to its new uuid, once rebound
+func (c *churn) rekey(from, to string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.seen[from] {
+		delete(c.seen, from)
+		c.seen[to] = true
+	}
+}
+
+// rate returns the transitions per minute over the window up to now
+func (c *churn) rate(now time.Time) float64 {
+	c.Lock()
+	defer c.Unlock()
+	second := now.Unix()
+	n := int64(len(c.buckets))
+	var total uint64
+	for i, s := range c.seconds {
+		if s > second-n && s <= second {
+			total += c.buckets[i]
+		}
+	}
+	return float64(total) / (float64(n) / 60)
+}
+
+// ChurnRate returns the number of transitions per minute of all the
+// peers over the last Config.ChurnWindow, a rising rate telling the
+// overlay is getting unstable before the peers are down for good
+func (pw *PeersWatcher) ChurnRate() float64 {
+	return pw.churn.rate(clockOrReal(pw.config.Clock).Now())
+}
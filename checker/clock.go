@@ -0,0 +1,69 @@
+package checker
+
+import (
+	"time"
+)
+
+const (
+	// DefaultMaxClockSkew is the default tolerance, in milliseconds,
+	// beyond which the time elapsed since the last check is
+	// considered bogus
+	DefaultMaxClockSkew = 60000
+)
+
+// Clock is the source of time of the peers and of the watcher,
+// it allows tests to control the passing of time
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends
+	// the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// Now returns the current time, including a monotonic reading
+// so that measuring elapsed time is immune to wall clock changes
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After is time.After
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
+
+func (p *Peer) now() time.Time {
+	return clockOrReal(p.config.Clock).Now()
+}
+
+func (p *Peer) after(d time.Duration) <-chan time.Time {
+	return clockOrReal(p.config.Clock).After(d)
+}
+
+// sinceLastChecked returns the time elapsed since the last check.
+// When the clock jumped, i.e. the elapsed time is negative or
+// beyond the interval by more than the tolerated skew, it's
+// counted as a single interval, so a jump neither stalls the
+// checks nor lets them burst. It must be called with the lock held.
+func (p *Peer) sinceLastChecked() time.Duration {
+	elapsed := p.now().Sub(p.lastChecked)
+	if p.lastChecked.IsZero() {
+		return elapsed
+	}
+
+	interval := p.checkIntervalDuration()
+	maxSkew := time.Duration(p.config.MaxClockSkew) * time.Millisecond
+	if elapsed < 0 || elapsed > interval+maxSkew {
+		p.debugf("Peer(%v): clock jump detected, %v elapsed since last check, counting it as %v", p.uuid, elapsed, interval)
+		return interval
+	}
+	return elapsed
+}
@@ -0,0 +1,955 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+const (
+	// maxCheckJitter bounds, in milliseconds, how much earlier
+	// than CheckInterval a peer may wake up for its next check
+	maxCheckJitter = 1000
+
+	// ConsiderStrict checks a peer only when both its container and
+	// the connectivity-check container of its host are running
+	ConsiderStrict = "strict"
+	// ConsiderLenient checks a peer as long as its container is
+	// running, e.g. while the connectivity-check container restarts
+	// during a rolling deploy
+	ConsiderLenient = "lenient"
+)
+
+// Config holds the settings used by the PeersWatcher
+type Config struct {
+	// Port on which the webserver listens
+	Port int
+
+	// Targets are fixed endpoints checked in addition to the peers
+	// found in metadata
+	Targets []Target
+
+	// MaxConcurrentChecks bounds how many checks run concurrently
+	// overall, 0 means no limit
+	MaxConcurrentChecks int
+
+	// ProbeOrder is the order in which the peers checked together,
+	// when started or on a recheck, are checked: ProbeOrderInterleaved
+	// (default) alternates between their hosts, their next checks
+	// being spread over the interval in that order, ProbeOrderListed
+	// keeps the order they're found in
+	ProbeOrder string
+
+	// RampPeriod, when not 0, makes the bound of concurrent checks
+	// ramp up from RampInitialChecks to MaxConcurrentChecks over
+	// this period after starting
+	RampPeriod int
+
+	// RampInitialChecks is the bound of concurrent checks right
+	// after starting when ramping up
+	RampInitialChecks int
+
+	// StartupRate, when not 0, is the most new peers started every
+	// StartupInterval milliseconds, DefaultStartupInterval by default,
+	// the others waiting in a queue, see StartupQueueDepth. It smooths
+	// the creation of goroutines when a large service scales up.
+	StartupRate     int
+	StartupInterval int
+
+	// StateWriter, when set, is handed the state of the peers when
+	// they change, at most once per StateWriteInterval
+	StateWriter StateWriter
+
+	// StateWriteInterval is the minimum interval between two writes
+	// of the state of a peer
+	StateWriteInterval int
+
+	// Replica makes the watcher only report the statuses of the peers
+	// it ingests, see Ingest, never checking them, e.g. for a central
+	// aggregator. The snapshot written by another instance at
+	// ReplicaSnapshot, if any, is ingested every CheckInterval.
+	Replica         bool
+	ReplicaSnapshot string
+
+	// MetadataDebounce is the window, in milliseconds, within which
+	// the changes told by MetadataChanged are coalesced before
+	// updating the peers, DefaultMetadataDebounce when 0
+	MetadataDebounce int
+
+	// SampleSize, when not 0, bounds how many of the peers found in
+	// metadata are checked, a random sample of them, so that the load
+	// doesn't grow with the square of the size of huge services
+	SampleSize int
+
+	// SampleInterval is the interval at which a new sample of the
+	// peers is drawn, 0 keeps the sample, only replacing the peers
+	// going away
+	SampleInterval int
+
+	// OpenConnectionsWarning is the number of open connections of the
+	// checks at which a warning is logged, as the checker may be about
+	// to exhaust its file descriptors or ephemeral ports, 0 disables it
+	OpenConnectionsWarning int
+
+	// AsyncLogBuffer, when not 0, makes the checks log through a buffer
+	// of this many entries written by a goroutine, so that a slow log
+	// backend can't delay them. The logs are dropped when it's full.
+	AsyncLogBuffer int
+
+	// Logger, when set, receives the logs of the peers instead of
+	// github.com/rancher/log, filtering their levels itself
+	Logger Logger
+
+	// SyncTransitionLogs keeps logging the transitions of the peers
+	// synchronously when AsyncLogBuffer is set, so that none is lost
+	SyncTransitionLogs bool
+
+	// RuntimeStatsInterval, when not 0, is the interval at which the
+	// number of goroutines and the heap used by the checker are logged
+	// along with the number of peers
+	RuntimeStatsInterval int
+
+	// HistoryFile, when set, is the file the results of all the checks
+	// are appended to as JSON lines, see ReplayHistory
+	HistoryFile string
+
+	// HistoryMaxSize is the size, in bytes, beyond which the history
+	// file is rotated, DefaultHistoryMaxSize when 0
+	HistoryMaxSize int
+
+	// HistoryMaxFiles is the number of rotated history files kept,
+	// DefaultHistoryMaxFiles when 0
+	HistoryMaxFiles int
+
+	// ShutdownTimeout is how long, in milliseconds, Stop waits for the
+	// checks and the other goroutines of the watcher to end,
+	// DefaultShutdownTimeout when 0
+	ShutdownTimeout int
+
+	// FlowCollector, when set, is the host:port of the collector the
+	// flow records of the checks are sent to over UDP, see FlowRecord
+	FlowCollector string
+
+	// FlowBatchSize is the number of flow records sent together,
+	// DefaultFlowBatchSize when 0
+	FlowBatchSize int
+
+	// FlowFlushInterval is the interval, in milliseconds, at which the
+	// flow records are sent even if fewer than FlowBatchSize,
+	// DefaultFlowFlushInterval when 0
+	FlowFlushInterval int
+
+	// EventLogSize is the number of transitions of the peers kept
+	// for the /events endpoint, DefaultEventLogSize when 0
+	EventLogSize int
+
+	// ChurnWindow is the window, in milliseconds, over which the
+	// transitions of the peers are counted for the churn rate,
+	// DefaultChurnWindow when 0, see PeersWatcher.ChurnRate
+	ChurnWindow int
+
+	// BreakerThreshold, when not 0, is the number of checks in a row
+	// of the peers of a destination host, whichever they are, failing
+	// for the path to the host to be deemed broken: its peers stop
+	// being checked for BreakerCooldown, DefaultBreakerCooldown when 0,
+	// then a single one is as a trial, see PeersWatcher.Breakers
+	BreakerThreshold int
+	BreakerCooldown  int
+
+	// HealthyFraction and UnhealthyFraction, when set, are the
+	// thresholds of the fraction of reachable peers of NetworkHealthy:
+	// the network becomes unhealthy once below UnhealthyFraction for
+	// NetworkHealthDelay, and healthy again once at or above
+	// HealthyFraction as long
+	HealthyFraction   float64
+	UnhealthyFraction float64
+
+	// NetworkHealthDelay is how long, in milliseconds, a threshold
+	// must be crossed for NetworkHealthy to change
+	NetworkHealthDelay int
+
+	// OnNetworkHealthChange, when set, is called every time
+	// NetworkHealthy changes. It's called without the lock of the
+	// watcher held.
+	OnNetworkHealthChange func(healthy bool)
+
+	// OnDrainChange, when set, is called every time the node begins
+	// or ends draining, see PeersWatcher.BeginDrain. It's called
+	// without the lock of the watcher held.
+	OnDrainChange func(draining bool)
+
+	// SettlingPeriod, when not 0, is how long, in milliseconds, the
+	// transitions of the peers aren't reported after starting, while
+	// there is no baseline yet. The peers are checked as usual, and
+	// their state is reported once it's over.
+	SettlingPeriod int
+
+	// ClampConnectionTimeout makes a ConnectionTimeout too long for
+	// the CheckInterval be shortened, instead of only warning about it
+	ClampConnectionTimeout bool
+
+	// Schedule, when set, are the daily windows during which the peers
+	// are checked at another interval than CheckInterval
+	Schedule []ScheduleWindow
+
+	// ScheduleLocation is the time zone of the Schedule, UTC when nil
+	ScheduleLocation *time.Location
+
+	// StatusGroupLabel, when set, is the label of the containers by
+	// which the peers are grouped on /status
+	StatusGroupLabel string
+
+	// Canary makes this node check its own ping endpoint over the
+	// loopback, which should always be reachable. When it isn't, the
+	// checker or the local network stack is likely broken rather than
+	// the peers, see PeersWatcher.CanaryHealthy, and Ok is false.
+	Canary bool
+
+	// SelfPolicy tells how the peers on the host of this node, found
+	// by its UUID or its AgentIP, are checked: SelfExclude (default),
+	// SelfInclude or SelfCanary
+	SelfPolicy string
+
+	// ProbeCacheTTL, when not 0, is how long in milliseconds the
+	// result of a probe is shared with the other peers probing the
+	// same endpoint, e.g. the one of their host. It only applies to
+	// the modes not recording more than the result, e.g. ModeTCP and
+	// the host checks, and not with VerifyNonce.
+	ProbeCacheTTL int
+
+	// RecheckInterval is the minimum interval between two rechecks of
+	// all the peers requested through /recheck, DefaultRecheckInterval
+	// when 0
+	RecheckInterval int
+
+	// AllowSeedCollisions keeps the peers whose random sources got the
+	// same seed as they are, by default the seed of all but one of
+	// them is changed so that their checks aren't synchronized
+	AllowSeedCollisions bool
+
+	// StuckCheckMultiple is how many check intervals a peer can go
+	// without being checked before being reported stuck, e.g.
+	// because of a bug or a deadlock, DefaultStuckCheckMultiple
+	// when 0
+	StuckCheckMultiple int
+
+	// QuorumWithoutPeers is the result of QuorumReachable
+	// when there are no peers to consider
+	QuorumWithoutPeers bool
+
+	PeerConfig
+}
+
+// PeerConfig holds the settings used by each Peer while checking.
+// All the durations are in milliseconds.
+type PeerConfig struct {
+	// CheckInterval between two consecutive checks of a peer
+	CheckInterval int
+
+	// JitterDistribution is how the checks of a peer are spread
+	// before the end of CheckInterval: JitterUniform (default) or
+	// JitterExponential
+	JitterDistribution string
+
+	// LoopOrder is whether a peer checks then sleeps, LoopProbeFirst
+	// (default), or sleeps then checks, LoopSleepFirst
+	LoopOrder string
+
+	// ConnectionTimeout bounds the whole check
+	ConnectionTimeout int
+
+	// AdaptiveTimeoutFactor, when set, replaces the ConnectionTimeout
+	// of a peer by its average latency times the factor once known, so
+	// that distant peers don't time out while the near ones aren't
+	// given too much slack. The timeout is capped by
+	// AdaptiveTimeoutCeiling, the CheckInterval less the jitter when 0.
+	AdaptiveTimeoutFactor  float64
+	AdaptiveTimeoutCeiling int
+
+	// ConnectTimeout bounds establishing the connection to the peer,
+	// 0 means only ConnectionTimeout applies
+	ConnectTimeout int
+
+	// ReadTimeout bounds waiting for and reading the response once
+	// connected, 0 means only ConnectionTimeout applies
+	ReadTimeout int
+
+	// DSCP value used to mark the packets of the checks,
+	// 0 leaves them unmarked
+	DSCP int
+
+	// TCPMSS, when not 0, clamps the TCP maximum segment size of the
+	// connections of the checks, forcing smaller segments to tell
+	// path MTU issues, see also MTUProbeSize. Only supported on linux,
+	// elsewhere it's logged and ignored.
+	TCPMSS int
+
+	// DisableKeepAlives makes every check use a new connection,
+	// by default connections to a peer are reused across checks
+	DisableKeepAlives bool
+
+	// KeepAliveIdle, KeepAliveInterval and KeepAliveCount tune the
+	// TCP keepalive of the connections to the peers, so that a broken
+	// connection kept for reuse is detected promptly. The durations
+	// are in milliseconds, rounded up to seconds, 0 keeps the default
+	// of the system. They're only supported on linux.
+	KeepAliveIdle     int
+	KeepAliveInterval int
+	KeepAliveCount    int
+
+	// IdleConnTimeout bounds how long an idle connection to a
+	// peer is kept for reuse, 0 means no limit
+	IdleConnTimeout int
+
+	// MaxIdleConnsPerPeer bounds the idle connections kept for
+	// reuse to each peer, 0 uses the default of net/http
+	MaxIdleConnsPerPeer int
+
+	// MaxConnsPerPeer bounds the connections to each peer, so that
+	// a misbehaving one can't hold many of them open, 0 means no limit
+	MaxConnsPerPeer int
+
+	// VerboseFailures logs, at debug level, the request and the
+	// response of the failed HTTP checks
+	VerboseFailures bool
+
+	// RedactHeaders are the headers whose values aren't logged
+	// with VerboseFailures, e.g. Authorization
+	RedactHeaders []string
+
+	// VerifyNonce makes the HTTP checks send a nonce the peer must
+	// echo back, so that a response from an intermediary isn't
+	// mistaken for one from the peer. Peers not echoing it are
+	// considered unreachable.
+	VerifyNonce bool
+
+	// RecoverySuccesses is the number of checks in a row which must
+	// succeed for an unreachable peer to be reported reachable again,
+	// 1 when 0. Its count goes up on every success regardless.
+	RecoverySuccesses int
+
+	// WindowSize, when not 0, makes the reachability of a peer the one
+	// of the sliding window of its last WindowSize results rather than
+	// of the counter: it's reachable while at least WindowMinSuccesses
+	// of them, a majority when 0, succeeded. It's more robust on lossy
+	// links.
+	WindowSize         int
+	WindowMinSuccesses int
+
+	// RetryBudget, when not 0, is the most retries a peer has for its
+	// failed checks, refilled by RetryRefill retries per minute,
+	// RetryBudget when 0. The retries can burst during a blip but not
+	// go on during an outage, the failures being recorded without
+	// retrying once the budget is spent.
+	RetryBudget int
+	RetryRefill float64
+
+	// SuccessRateMinChecks is the number of checks of a peer after
+	// which its success rate is reported as confident, see
+	// Peer.SuccessRate, DefaultSuccessRateMinChecks when 0
+	SuccessRateMinChecks int
+
+	// FailureWeights maps the reasons of the failures to how much they
+	// change the count of a peer, e.g. 0 for utils.FailureRefused to
+	// not count a refused connection, the host being up. The reasons
+	// not mapped, and the positive weights, change it by -1.
+	FailureWeights map[utils.FailureReason]int
+
+	// FailFast makes a single failure mark the peer as unreachable,
+	// instead of decrementing the count one failure at a time
+	FailFast bool
+
+	// MaxAdaptiveInterval, when not 0, lets the interval between the
+	// checks of a steadily reachable peer double, up to this cap. It's
+	// back to CheckInterval on a failure or when the peer changes in
+	// metadata.
+	MaxAdaptiveInterval int
+
+	// MaxCheckInterval, when not 0, is the longest a considered peer
+	// goes without being checked, capping MaxAdaptiveInterval and the
+	// intervals of the Schedule. It must not be less than
+	// CheckInterval.
+	MaxCheckInterval int
+
+	// LatencyIntervalMin and LatencyIntervalMax, when both set, let the
+	// interval between the checks of a reachable peer follow its
+	// latency: up to twice as long while fast and stable, shorter as it
+	// gets slower than its baseline or more variable, within these
+	// bounds. This is on top of MaxAdaptiveInterval, failures still
+	// reset the interval.
+	LatencyIntervalMin int
+	LatencyIntervalMax int
+
+	// WarmupProbes is the number of probes sent to a peer first seen,
+	// before its first check, to measure its baseline latency and warm
+	// up the connections. They don't affect its reachability.
+	WarmupProbes int
+
+	// MaxLatency, when not 0, is the latency, in milliseconds, above
+	// which a check failed with FailureTooSlow even though the peer
+	// answered, for the services whose latency is part of their health,
+	// see PeersWatcher.SetPeerMaxLatency to set it by peer
+	MaxLatency int
+
+	// DegradedLatency is the average latency above which a reachable
+	// peer is classified as degraded, 0 disables the classification
+	DegradedLatency int
+
+	// DegradedPeriod, when not 0, is how long, in milliseconds, the
+	// count of a reachable peer must stay below its maximum, its checks
+	// failing now and then without it going down, for it to be
+	// classified as degraded, see Peer.Degraded
+	DegradedPeriod int
+
+	// HourlyWindowDays, when not 0, keeps the results of the checks of
+	// the peers by hour of the day over as many days, see
+	// Peer.HourlySuccessRates
+	HourlyWindowDays int
+
+	// MaxChecksPerHost bounds how many checks run concurrently
+	// against the same destination host, 0 means no limit
+	MaxChecksPerHost int
+
+	// BurstSize is the number of probes sent to measure the packet
+	// loss when the latency becomes unstable, 0 disables the bursts
+	BurstSize int
+
+	// BurstJitterThreshold is the standard deviation of the latency
+	// above which a burst is sent
+	BurstJitterThreshold int
+
+	// MaxClockSkew is the tolerance beyond which the time elapsed
+	// since the last check is considered the result of a clock jump
+	MaxClockSkew int
+
+	// Seed, when set, seeds the random source scheduling the checks
+	// of each peer along with its uuid, instead of the IP of its host,
+	// making the schedule reproducible
+	Seed *int64
+
+	// Clock, when set, is used instead of the system clock, for
+	// telling the time and for waiting between the checks
+	Clock Clock
+
+	// Scheduler, when set, decides when each peer is checked instead
+	// of waiting for the check interval minus the jitter
+	Scheduler Scheduler
+
+	// MTUProbeSize, when not 0, makes a failed HTTP check be followed
+	// by a tiny probe and a probe of this many bytes, to detect MTU or
+	// fragmentation issues, see Peer.SuspectedMTUIssue
+	MTUProbeSize int
+
+	// ConfirmDownPort, when not 0, is the port of a secondary endpoint
+	// on the host of a peer, e.g. its agent, checked when the peer
+	// becomes unreachable to tell apart the container being down from
+	// the path to the host being down
+	ConfirmDownPort int
+
+	// ConfirmDownPath is the path requested on the secondary endpoint
+	// in the modes working over HTTP
+	ConfirmDownPath string
+
+	// ConfirmDownMode is the mode used to check the secondary
+	// endpoint, ModeTCP by default
+	ConfirmDownMode string
+
+	// ResetCountOnRebind makes a peer whose container was recreated
+	// start over as a new peer would, by default it keeps its state
+	// so that a redeploy doesn't show as a loss of reachability
+	ResetCountOnRebind bool
+
+	// DiagnosticPath and DiagnosticField, when set, make every
+	// successful check fetch the JSON document served at the path
+	// along the checked endpoint and extract the number at the field,
+	// e.g. the loss or the queue depth seen by the peer, see
+	// Peer.DiagnosticValue. The field is a path of object keys and
+	// array indexes separated by dots. Only the modes working over
+	// HTTP support it.
+	DiagnosticPath  string
+	DiagnosticField string
+
+	// MetadataGracePeriod, when not 0, keeps checking a peer with its
+	// last known metadata for up to this long when its container, host
+	// or connectivity-check container goes missing from metadata,
+	// instead of skipping it right away
+	MetadataGracePeriod int
+
+	// StaleAfter, when not 0, is how long, in milliseconds, the
+	// container of a peer goes without being updated in metadata for
+	// the peer to be deemed defunct, and only checked every
+	// StaleCheckInterval, DefaultStaleCheckInterval when 0, to save the
+	// checks of the zombie containers metadata didn't clean up. When
+	// StaleTimestampLabel is set, the label of the container holding
+	// when it was last updated, RFC 3339 or seconds since the epoch, is
+	// used rather than the last time it was seen changing.
+	StaleAfter          int
+	StaleCheckInterval  int
+	StaleTimestampLabel string
+
+	// ConsiderPolicy tells which containers must be running for a
+	// peer to be checked, ConsiderStrict (default) or ConsiderLenient
+	ConsiderPolicy string
+
+	// HostCheck, HostCheckAlso or HostCheckOnly, makes the agent of the
+	// host of the peers found in metadata be checked in addition to or
+	// instead of their container, see Peer.HostReachable. It requires
+	// HostCheckPort.
+	HostCheck string
+
+	// HostCheckPort is the port of the agent of the hosts
+	HostCheckPort int
+
+	// HostCheckPath is the path requested on the agent of the hosts
+	// in the modes working over HTTP
+	HostCheckPath string
+
+	// HostCheckMode is the mode used to check the agent of the
+	// hosts, ModeTCP by default
+	HostCheckMode string
+
+	// LivenessPath, when set, is checked on the peers in ModeHTTP
+	// rather than their ping endpoint. ReadinessPath, when set, is
+	// checked too once a peer is live, see Peer.Live and Peer.Ready.
+	// ReadinessPolicy tells whether a peer must be ready to be
+	// reachable: ReadinessReport (default) or ReadinessRequire. Both
+	// endpoints are up on any 2xx status, unless HealthExpected is set,
+	// the body they must then answer.
+	LivenessPath    string
+	ReadinessPath   string
+	ReadinessPolicy string
+	HealthExpected  string
+
+	// VIPCheck probes the VIP of the service of the peers, when the
+	// metadata has one, along with their container IP. VIPPolicy
+	// tells how both are combined into the reachability of a peer:
+	// VIPPolicyDirect (default), VIPPolicyBoth or VIPPolicyEither.
+	VIPCheck  bool
+	VIPPolicy string
+
+	// HostPort probes the peers whose container maps the checked port
+	// on its host through the AgentIP and the mapped port rather than
+	// the PrimaryIp, for the host networking or port mapped services,
+	// see PeerStatus.Endpoint. The ports mode still probes the
+	// PrimaryIp.
+	HostPort bool
+
+	// NeighborCheck looks up the peers on the same subnet in the
+	// neighbor table after probing them, reporting their layer 2
+	// reachability apart, see Peer.NeighborReachable. Only supported
+	// on linux, for IPv4.
+	NeighborCheck bool
+
+	// LocalIPPolicy tells how the peers whose PrimaryIp is one of this
+	// host are handled, e.g. this host via the overlay: LocalIPProbe
+	// (default), LocalIPSkip or LocalIPReachable
+	LocalIPPolicy string
+
+	// ConcurrentHostCheck, with HostCheckAlso, probes the agent of
+	// the host and the container of a peer at the same time, within
+	// MaxChecksPerHost, so that a check takes as long as the slowest
+	// of them rather than both
+	ConcurrentHostCheck bool
+
+	// ConcurrentTargets probes all the targets of a peer at the same
+	// time, within MaxChecksPerHost: its container, the agent of its
+	// host with HostCheckAlso and its VIP with VIPCheck. The targets
+	// not probed before the next check is due count as failed.
+	ConcurrentTargets bool
+
+	// Mode used to check the peers, one of ModeHTTP (default),
+	// ModeHTTPS, ModeTCP, ModeUnix, ModeTLS, ModeHTTP3, ModePorts,
+	// ModeParallel
+	Mode string
+
+	// RelayAddress, when set, is the address, in host:port form, of
+	// the peer asked to check the others in ModeHTTP too, along with
+	// their direct check, e.g. a node B when validating the paths
+	// A->B->C from A. Its answers are reported apart, see
+	// Peer.RelayResult, the reachability of the peers staying the one
+	// of their direct check.
+	RelayAddress string
+
+	// ParallelConnections is the number of connections established
+	// at the same time in ModeParallel, DefaultParallelConnections
+	// when 0, bounded by MaxConcurrentChecks. ParallelMinSuccesses is
+	// how many of them are to succeed, all when 0.
+	ParallelConnections  int
+	ParallelMinSuccesses int
+
+	// RequestsPerCheck, when more than 1, makes each check in the modes
+	// working over HTTP send as many requests, RequestsConcurrency at a
+	// time, 1 when 0, within MaxConcurrentChecks. The check succeeds
+	// when RequestsMinSuccesses of them do, all when 0, catching the
+	// intermittent failures a single request misses.
+	RequestsPerCheck     int
+	RequestsMinSuccesses int
+	RequestsConcurrency  int
+
+	// Ports are the ports of the peers checked in ModePorts, and
+	// PortsPolicy tells whether all of them, PortsAll (default), or
+	// any of them, PortsAny, are to be open for a peer to be reachable
+	Ports       []int
+	PortsPolicy string
+
+	// CheckMethod is the method of the requests of the HTTP checks,
+	// GET when empty. With HEAD only the status code of the response
+	// is checked, not its body.
+	CheckMethod string
+
+	// CheckBody is sent with the requests of the HTTP checks, e.g.
+	// when CheckMethod is POST
+	CheckBody string
+
+	// CheckContentType, when set, is the media type the responses of
+	// the HTTP checks must have, e.g. text/plain for the pong, so that
+	// the error pages of a proxy answering with a 200 aren't taken for
+	// it. With NegotiateSchema the pong is application/json.
+	CheckContentType string
+
+	// ExpectedHeader, when set, is a header in the Name: value form the
+	// responses of the HTTP checks must carry, e.g. X-Health: ok, for
+	// the health endpoints telling their status through a header. The
+	// ExpectedHeaderLabel of a container overrides it. With HeaderOnly
+	// the body isn't compared.
+	ExpectedHeader string
+	HeaderOnly     bool
+
+	// TLSPort is the port of the peers checked in ModeTLS, ModeHTTPS
+	// and ModeHTTP3, DefaultTLSPort when 0
+	TLSPort int
+
+	// TLSServerName is the name sent and validated in ModeTLS and
+	// ModeHTTPS, the IP of the peer when empty
+	TLSServerName string
+
+	// TLSVerify makes the checks in ModeTLS and ModeHTTPS validate the
+	// certificate of the peers, its chain, name and expiry, instead of
+	// only completing the handshake
+	TLSVerify bool
+
+	// ClientCertFile and ClientKeyFile, PEM encoded, are the client
+	// certificate the checks in ModeTLS and ModeHTTPS present to the
+	// peers requiring mutual TLS. They're reloaded when they change,
+	// e.g. on rotation. The peers rejecting it fail the checks with
+	// utils.FailureTLSAuth.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// HTTP2 makes the checks over HTTP speak HTTP/2 without TLS, for
+	// the peers whose health server only speaks HTTP/2. By default
+	// they speak HTTP/1.1, which every server supports, so that they
+	// behave the same whatever the peers support.
+	HTTP2 bool
+
+	// SocketPath is the unix socket the checks connect to in ModeUnix
+	SocketPath string
+
+	// ProbeByName makes the peers found in metadata be checked by the
+	// name of their container rather than by their PrimaryIp, relying
+	// on DNS. The resolved IPs are compared to the PrimaryIp to detect
+	// stale DNS, see Peer.DNSMismatch.
+	ProbeByName bool
+
+	// SourceAddresses, when set, are the local IPs or the interfaces
+	// the checks of a peer originate from in turn, so that a broken
+	// path out of one of them shows, see Peer.SourceReachability
+	SourceAddresses []string
+
+	// SourcePortPolicy is how the source port of the checks is
+	// picked, e.g. to reproduce conntrack issues: SourcePortRandom
+	// (default) by the system, SourcePortFixed always SourcePort, or
+	// SourcePortPool each port within SourcePortMin and SourcePortMax
+	// in turn
+	SourcePortPolicy string
+	SourcePort       int
+	SourcePortMin    int
+	SourcePortMax    int
+
+	// NegotiateSchema asks the peers to answer the HTTP checks with
+	// the latest schema of the ping response they know, the peers of
+	// older versions answering a plain pong, see Peer.SchemaVersion
+	NegotiateSchema bool
+
+	// DebugPeersOnly drops the debug logs of the peers but the ones
+	// whose debug is enabled, see PeersWatcher.SetPeerDebug, so that a
+	// single peer can be looked into in a huge cluster
+	DebugPeersOnly bool
+
+	// SourceIdentity, when set, is sent with the HTTP checks so that
+	// the checked peers log who is checking them, e.g. the name or IP
+	// of the host of this node
+	SourceIdentity string
+
+	// CorrelationIDs sends a new correlation ID with every HTTP check,
+	// logged on both sides, see Peer.CorrelationID
+	CorrelationIDs bool
+
+	// ConnectProxy, when set, is the address of an HTTP proxy the
+	// checks are tunneled through with CONNECT, for the peers only
+	// reachable through it
+	ConnectProxy string
+
+	// MeasureConnectTime measures how long establishing the TCP
+	// connection of the HTTP and TCP checks takes, apart from their
+	// latency, telling a slow network from a slow app, see
+	// Peer.LastConnectTime
+	MeasureConnectTime bool
+
+	// ReasonStreaks counts the consecutive failed checks by reason,
+	// telling 10 straight timeouts from alternating refusals and
+	// timeouts, see Peer.ReasonStreaks
+	ReasonStreaks bool
+
+	// DialFunc, when set, establishes the connections of the checks
+	// instead of the standard dialer, e.g. to go through the connect
+	// helper of a service mesh sidecar
+	DialFunc utils.DialFunc
+
+	// HTTP3Transport is the QUIC RoundTripper the checks in ModeHTTP3
+	// go through, e.g. the one of quic-go, this build having none of
+	// its own: only the programs embedding the checker can set it,
+	// there's no flag for it. It sets the TLS settings of these checks.
+	HTTP3Transport http.RoundTripper
+
+	// RewriteIP, when set, maps the IP of a peer to the one to check,
+	// e.g. to go through an address translation layer. Returning false
+	// skips the check. It's called on every check, so it should be
+	// cheap.
+	RewriteIP func(ip string) (string, bool)
+
+	// Metrics, when set, receives the metrics of the checks
+	Metrics MetricsSink
+
+	// Tracer, when set, is used to wrap every check in a span, and each
+	// of its requests in a child span, see Probe.Context
+	Tracer Tracer
+
+	// NotifyPolicy tells what happens to the notifications of
+	// Peer.Notify to a subscriber not keeping up: DeliveryDropOldest
+	// (default), DeliveryDropNewest or DeliveryBlock, waiting up to
+	// NotifyTimeout milliseconds, DefaultNotifyTimeout when 0.
+	// NotifyBuffer is how many notifications a subscriber can have
+	// pending, 1 when 0.
+	NotifyPolicy  string
+	NotifyTimeout int
+	NotifyBuffer  int
+
+	// OnStateChange, when set, is called every time a peer becomes
+	// reachable or unreachable. It's called without the lock of the
+	// peer held.
+	OnStateChange func(peer *Peer, reachable bool)
+
+	// OnHealthClassChange, when set, is called every time the
+	// HealthClass of a peer goes in or out of HealthDegraded. It's
+	// called without the lock of the peer held.
+	OnHealthClassChange func(peer *Peer, from, to string)
+
+	// OnConsiderChange, when set, is called when a peer becomes
+	// eligible for the checks and when it stops being so, e.g. its
+	// host went inactive or its container stopped or was removed.
+	// It's called without the lock of the peer held.
+	OnConsiderChange func(peer *Peer, considered bool)
+}
+
+// DefaultConfig returns the Config used when nothing is customized
+func DefaultConfig() Config {
+	return Config{
+		Port: DefaultServerPort,
+		PeerConfig: PeerConfig{
+			CheckInterval:     DefaultCheckInterval,
+			ConnectionTimeout: DefaultPeerConnectionTimeoutInterval,
+			Mode:              ModeHTTP,
+			ConsiderPolicy:    ConsiderStrict,
+			MaxClockSkew:      DefaultMaxClockSkew,
+		},
+	}
+}
+
+// minCheckInterval returns the shortest interval, in milliseconds,
+// between two checks of a peer once accounted for the jitter
+func (c PeerConfig) minCheckInterval() int {
+	if c.CheckInterval > maxCheckJitter {
+		return c.CheckInterval - maxCheckJitter
+	}
+	return c.CheckInterval
+}
+
+// validateTimeouts returns an error when a check may last longer
+// than the interval between two checks, making the checks of a peer
+// pile up
+func (c PeerConfig) validateTimeouts() error {
+	if min := c.minCheckInterval(); c.ConnectionTimeout >= min {
+		return fmt.Errorf("connection timeout %vms isn't less than the check interval %vms, %vms once accounted for the jitter",
+			c.ConnectionTimeout, c.CheckInterval, min)
+	}
+	return nil
+}
+
+// Validate checks that the settings are consistent and that the
+// ones picking among choices, e.g. the Mode, name one of them. It
+// returns an Errors of a *ValidationError per invalid setting.
+func (c Config) Validate() error {
+	var errs Errors
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+	if err := c.validateMaxCheckInterval(); err != nil {
+		invalid("MaxCheckInterval", "%v", err)
+	}
+	modes := []struct {
+		field, value string
+	}{
+		{"Mode", c.Mode},
+		{"HostCheckMode", c.HostCheckMode},
+		{"ConfirmDownMode", c.ConfirmDownMode},
+	}
+	for _, mode := range modes {
+		if _, err := getChecker(mode.value); mode.value != "" && err != nil {
+			invalid(mode.field, "%v", err)
+		}
+	}
+	for i, t := range c.Targets {
+		if _, err := getChecker(t.Mode); err != nil {
+			invalid(fmt.Sprintf("Targets[%v].Mode", i), "%v", err)
+		}
+	}
+	if c.MaxLatency > 0 && c.MaxLatency >= c.ConnectionTimeout && c.ConnectionTimeout > 0 {
+		invalid("MaxLatency", "%vms isn't less than the connection timeout %vms", c.MaxLatency, c.ConnectionTimeout)
+	}
+	if c.SourcePortPolicy == SourcePortFixed && c.SourcePort <= 0 {
+		invalid("SourcePort", "none configured for source port policy %v", SourcePortFixed)
+	}
+	if c.SourcePortPolicy == SourcePortPool && (c.SourcePortMin <= 0 || c.SourcePortMax < c.SourcePortMin) {
+		invalid("SourcePortMin", "no range of ports configured for source port policy %v", SourcePortPool)
+	}
+	if _, _, ok := parseExpectedHeader(c.ExpectedHeader); c.ExpectedHeader != "" && !ok {
+		invalid("ExpectedHeader", "%q isn't in the Name: value form", c.ExpectedHeader)
+	}
+	if c.WindowMinSuccesses > c.WindowSize && c.WindowSize > 0 {
+		invalid("WindowMinSuccesses", "%v is more than the window of %v results", c.WindowMinSuccesses, c.WindowSize)
+	}
+	if c.LatencyIntervalMin > 0 && c.LatencyIntervalMax > 0 {
+		if c.LatencyIntervalMin > c.LatencyIntervalMax {
+			invalid("LatencyIntervalMin", "%vms is more than LatencyIntervalMax %vms", c.LatencyIntervalMin, c.LatencyIntervalMax)
+		} else if c.LatencyIntervalMin-maxCheckJitter <= c.ConnectionTimeout {
+			invalid("LatencyIntervalMin", "%vms, %vms once accounted for the jitter, isn't more than the connection timeout %vms",
+				c.LatencyIntervalMin, c.LatencyIntervalMin-maxCheckJitter, c.ConnectionTimeout)
+		}
+	}
+	if c.TCPMSS < 0 || c.TCPMSS > 65535 {
+		invalid("TCPMSS", "%v is out of range", c.TCPMSS)
+	}
+	if c.FlowCollector != "" {
+		if _, _, err := net.SplitHostPort(c.FlowCollector); err != nil {
+			invalid("FlowCollector", "%v", err)
+		}
+	}
+	if c.RequestsMinSuccesses > c.RequestsPerCheck && c.RequestsPerCheck > 1 {
+		invalid("RequestsMinSuccesses", "%v is more than the %v requests per check", c.RequestsMinSuccesses, c.RequestsPerCheck)
+	}
+	if c.Mode == ModeHTTP3 && c.HTTP3Transport == nil {
+		invalid("HTTP3Transport", "none configured for mode %v", ModeHTTP3)
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		invalid("ClientKeyFile", "the client certificate and key files go together")
+	}
+	if c.RelayAddress != "" {
+		if _, _, err := net.SplitHostPort(c.RelayAddress); err != nil {
+			invalid("RelayAddress", "%v", err)
+		}
+	}
+	if c.Mode == ModePorts && len(c.Ports) == 0 {
+		invalid("Ports", "none configured for mode %v", ModePorts)
+	}
+	choices := []struct {
+		field, value string
+		valid        []string
+	}{
+		{"ConsiderPolicy", c.ConsiderPolicy, []string{ConsiderStrict, ConsiderLenient}},
+		{"HostCheck", c.HostCheck, []string{HostCheckAlso, HostCheckOnly}},
+		{"PortsPolicy", c.PortsPolicy, []string{PortsAll, PortsAny}},
+		{"ProbeOrder", c.ProbeOrder, []string{ProbeOrderInterleaved, ProbeOrderListed}},
+		{"SelfPolicy", c.SelfPolicy, []string{SelfExclude, SelfInclude, SelfCanary}},
+		{"NotifyPolicy", c.NotifyPolicy, []string{DeliveryDropOldest, DeliveryDropNewest, DeliveryBlock}},
+		{"SourcePortPolicy", c.SourcePortPolicy, []string{SourcePortRandom, SourcePortFixed, SourcePortPool}},
+		{"LoopOrder", c.LoopOrder, []string{LoopProbeFirst, LoopSleepFirst}},
+		{"JitterDistribution", c.JitterDistribution, []string{JitterUniform, JitterExponential}},
+		{"ReadinessPolicy", c.ReadinessPolicy, []string{ReadinessReport, ReadinessRequire}},
+		{"VIPPolicy", c.VIPPolicy, []string{VIPPolicyDirect, VIPPolicyBoth, VIPPolicyEither}},
+		{"LocalIPPolicy", c.LocalIPPolicy, []string{LocalIPProbe, LocalIPSkip, LocalIPReachable}},
+	}
+	for _, choice := range choices {
+		if choice.value != "" && !contains(choice.valid, choice.value) {
+			invalid(choice.field, "unknown value %v, expected one of %v", choice.value, choice.valid)
+		}
+	}
+	return errs.errOrNil()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMaxCheckInterval checks that the cap of the intervals is
+// not less than the interval it caps
+func (c PeerConfig) validateMaxCheckInterval() error {
+	if c.MaxCheckInterval > 0 && c.MaxCheckInterval < c.CheckInterval {
+		return fmt.Errorf("max check interval %vms is less than the check interval %vms",
+			c.MaxCheckInterval, c.CheckInterval)
+	}
+	return nil
+}
+
+// checkTimeouts warns about the timeouts letting the checks pile up,
+// clamping them if so configured. The intervals of the Schedule too
+// short for the timeout are clamped to the shortest one letting a
+// check end before the next one.
+func (c *Config) checkTimeouts() {
+	defer c.clampSchedule()
+	err := c.validateTimeouts()
+	if err == nil {
+		return
+	}
+	if !c.ClampConnectionTimeout {
+		log.Warnf("%v, the checks may overlap", err)
+		return
+	}
+	timeout := c.minCheckInterval() - 1
+	if timeout < 1 {
+		timeout = 1
+	}
+	log.Warnf("%v, clamping it to %vms", err, timeout)
+	c.ConnectionTimeout = timeout
+}
+
+// clampSchedule raises the intervals of the Schedule which aren't
+// more than the ConnectionTimeout, once accounted for the jitter
+func (c *Config) clampSchedule() {
+	min := c.ConnectionTimeout + maxCheckJitter + 1
+	var windows []ScheduleWindow
+	for i, w := range c.Schedule {
+		if w.Interval >= min {
+			continue
+		}
+		if windows == nil {
+			// The windows of the caller are left as they are
+			windows = append([]ScheduleWindow(nil), c.Schedule...)
+		}
+		log.Warnf("schedule window %v: interval %vms isn't more than the connection timeout %vms once accounted for the jitter, clamping it to %vms",
+			i, w.Interval, c.ConnectionTimeout, min)
+		windows[i].Interval = min
+	}
+	if windows != nil {
+		c.Schedule = windows
+	}
+}
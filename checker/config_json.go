@@ -0,0 +1,88 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/rancher/log"
+)
+
+// redactedMarkers are the parts of the names of the settings whose
+// values aren't exported by ConfigJSON
+var redactedMarkers = []string{"Token", "Password", "Secret"}
+
+const redacted = "REDACTED"
+
+// configMap returns the settings of the Config by name, the ones of
+// the embedded PeerConfig included. The hooks and the other settings
+// set from code, e.g. Clock or Metrics, are only told to be set.
+func configMap(v reflect.Value, settings map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			configMap(value, settings)
+			continue
+		}
+		switch {
+		case isRedacted(field.Name):
+			if !value.IsZero() {
+				settings[field.Name] = redacted
+			}
+		case field.Type.Kind() == reflect.Func || field.Type.Kind() == reflect.Interface:
+			settings[field.Name] = !value.IsNil()
+		default:
+			if s, ok := value.Interface().(fmt.Stringer); ok && field.Type.Kind() == reflect.Ptr {
+				if !value.IsNil() {
+					settings[field.Name] = s.String()
+				}
+				continue
+			}
+			settings[field.Name] = value.Interface()
+		}
+	}
+}
+
+func isRedacted(name string) bool {
+	for _, marker := range redactedMarkers {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigJSON returns the effective Config of the watcher, once
+// validated and adjusted, as a JSON object of the settings by name.
+// The secrets are redacted. It's nil when a setting can't be encoded,
+// e.g. a fraction being NaN.
+func (pw *PeersWatcher) ConfigJSON() []byte {
+	pw.Lock()
+	config := pw.config
+	config.PeerConfig = pw.peerConfig()
+	pw.Unlock()
+	settings := make(map[string]interface{})
+	configMap(reflect.ValueOf(config), settings)
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		log.Errorf("error encoding the configuration: %v", err)
+		return nil
+	}
+	return data
+}
+
+func (pw *PeersWatcher) configHandler(w http.ResponseWriter, r *http.Request) {
+	data := pw.ConfigJSON()
+	if data == nil {
+		http.Error(w, "error encoding the configuration", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
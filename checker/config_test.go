@@ -0,0 +1,152 @@
+package checker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateTimeoutsAccountsForJitter(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.validateTimeouts(); err != nil {
+		t.Fatalf("expected the default config to be valid, got %v", err)
+	}
+
+	// Less than CheckInterval, but not once the jitter is removed
+	cfg.CheckInterval = 5000
+	cfg.ConnectionTimeout = 4500
+	if err := cfg.validateTimeouts(); err == nil {
+		t.Fatalf("expected a timeout beyond the interval minus the jitter to be rejected")
+	}
+}
+
+func TestCheckTimeoutsClamps(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckInterval = 5000
+	cfg.ConnectionTimeout = 6000
+	cfg.ClampConnectionTimeout = true
+	cfg.checkTimeouts()
+	if err := cfg.validateTimeouts(); err != nil {
+		t.Fatalf("expected the clamped config to be valid, got %v", err)
+	}
+}
+
+func TestCheckTimeoutsClampsScheduleWindows(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConnectionTimeout = 2000
+	windows := []ScheduleWindow{{Start: 0, End: 60, Interval: 1500}, {Start: 60, End: 120, Interval: 10000}}
+	cfg.Schedule = windows
+	cfg.checkTimeouts()
+	if got := cfg.Schedule[0].Interval; got != 2000+maxCheckJitter+1 {
+		t.Fatalf("expected the window shorter than the timeout to be clamped, got %vms", got)
+	}
+	if got := cfg.Schedule[1].Interval; got != 10000 {
+		t.Fatalf("expected the longer window to be kept, got %vms", got)
+	}
+	if windows[0].Interval != 1500 {
+		t.Fatalf("expected the windows of the caller to be left as they are")
+	}
+}
+
+func TestValidateMaxCheckInterval(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxCheckInterval = cfg.CheckInterval
+	if err := cfg.validateMaxCheckInterval(); err != nil {
+		t.Fatalf("expected a cap equal to the check interval to be valid, got %v", err)
+	}
+	cfg.MaxCheckInterval = cfg.CheckInterval - 1
+	if err := cfg.validateMaxCheckInterval(); err == nil {
+		t.Fatalf("expected a cap below the check interval to be rejected")
+	}
+}
+
+func TestValidateAggregatesValidationErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the default config to be valid, got %v", err)
+	}
+
+	cfg.Mode = "carrier-pigeon"
+	cfg.ProbeOrder = "random"
+	cfg.MaxCheckInterval = cfg.CheckInterval - 1
+	errs, ok := cfg.Validate().(Errors)
+	if !ok {
+		t.Fatalf("expected Errors, got %T", cfg.Validate())
+	}
+	var fields []string
+	for _, err := range errs {
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Fatalf("expected a *ValidationError, got %T", err)
+		}
+		fields = append(fields, verr.Field)
+	}
+	expected := []string{"MaxCheckInterval", "Mode", "ProbeOrder"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected the invalid fields %v, got %v", expected, fields)
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ScheduleLocation = time.UTC
+	cfg.OnStateChange = func(*Peer, bool) {}
+	pw := &PeersWatcher{config: cfg}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal(pw.ConfigJSON(), &settings); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	expected := map[string]interface{}{
+		"CheckInterval":    float64(DefaultCheckInterval),
+		"Mode":             ModeHTTP,
+		"ScheduleLocation": "UTC",
+		"OnStateChange":    true,
+		"Tracer":           false,
+	}
+	for name, value := range expected {
+		if settings[name] != value {
+			t.Errorf("expected %v to be %v, got %v", name, value, settings[name])
+		}
+	}
+}
+
+func TestRegisterChecker(t *testing.T) {
+	const mode = "semaphore"
+	if err := RegisterChecker(mode, &testChecker{}); err != nil {
+		t.Fatalf("expected the mode to be registered, got %v", err)
+	}
+	defer delete(checkers, mode)
+	if err := RegisterChecker(mode, &testChecker{}); err == nil {
+		t.Fatalf("expected registering the mode twice to fail")
+	}
+	if err := RegisterChecker(ModeHTTP, &testChecker{}); err == nil {
+		t.Fatalf("expected registering a built-in mode to fail")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Mode = mode
+	cfg.Targets = []Target{{Name: "t1", Mode: mode}, {Name: "t2", Mode: "smoke-signal"}}
+	errs, ok := cfg.Validate().(Errors)
+	if !ok || len(errs) != 1 || errs[0].(*ValidationError).Field != "Targets[1].Mode" {
+		t.Fatalf("expected only the unregistered mode of the target to be invalid, got %v", cfg.Validate())
+	}
+}
+
+func TestParseTargetIPv6(t *testing.T) {
+	for s, expected := range map[string]Target{
+		"[fd00::1]:8080/healthz": {IP: "fd00::1", Port: 8080, Path: "/healthz"},
+		"tcp://[fd00::1]:6443":   {IP: "fd00::1", Port: 6443, Path: defaultCheckPath},
+		"[fd00::1]/healthz":      {IP: "fd00::1", Port: DefaultCheckPort, Path: "/healthz"},
+		"fd00::1":                {IP: "fd00::1", Port: DefaultCheckPort, Path: defaultCheckPath},
+	} {
+		target, err := ParseTarget(s)
+		if err != nil {
+			t.Fatalf("error parsing %v: %v", s, err)
+		}
+		if target.IP != expected.IP || target.Port != expected.Port || target.Path != expected.Path {
+			t.Fatalf("expected %v to be parsed as %+v, got %+v", s, expected, target)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package checker
+
+import (
+	"net"
+	"strconv"
+)
+
+const (
+	// DownCauseContainer is reported when the peer became unreachable
+	// while the secondary endpoint of its host was still reachable
+	DownCauseContainer = "container"
+	// DownCauseHostPath is reported when the secondary endpoint of the
+	// host of the peer was unreachable too
+	DownCauseHostPath = "host path"
+)
+
+// hostEndpointProbe returns the probe of an endpoint on the host of
+// the peer, checked in the given mode, ModeTCP by default. It must be
+// called with the lock held.
+func (p *Peer) hostEndpointProbe(mode string, port int, path string) (Checker, Probe, error) {
+	if mode == "" {
+		mode = ModeTCP
+	}
+	checker, err := getChecker(mode)
+	if err != nil {
+		return nil, Probe{}, err
+	}
+	return checker, Probe{
+		Address:  net.JoinHostPort(p.getHostIP(), strconv.Itoa(port)),
+		Path:     path,
+		Expected: expectedResponse,
+		Options:  p.reachabilityOptions(),
+	}, nil
+}
+
+// confirmDown probes the secondary endpoint on the host of a peer
+// which just became unreachable, telling apart the container being
+// down from the whole path to its host being down. It must be called
+// with the lock held.
+func (p *Peer) confirmDown() {
+	if p.config.ConfirmDownPort <= 0 || p.getHostIP() == "" {
+		return
+	}
+
+	checker, probe, err := p.hostEndpointProbe(p.config.ConfirmDownMode, p.config.ConfirmDownPort, p.config.ConfirmDownPath)
+	if err != nil {
+		p.logger.Errorf("Peer(%v): confirm down: %v", p.uuid, err)
+		return
+	}
+	release := p.limiter.acquire(p.getHostIP())
+	ok, err := checker.Check(probe)
+	release()
+
+	if ok {
+		p.downCause = DownCauseContainer
+		p.logger.Errorf("Peer(%v, %v, %v): host endpoint %v is reachable, the container is down",
+			p.uuid, p.getHostIP(), p.getIP(), probe.Address)
+	} else {
+		p.downCause = DownCauseHostPath
+		p.logger.Errorf("Peer(%v, %v, %v): host endpoint %v is unreachable too (%v), the path to the host is down",
+			p.uuid, p.getHostIP(), p.getIP(), probe.Address, err)
+	}
+}
@@ -0,0 +1,15 @@
+package checker
+
+// updateConsidered records whether the peer is considered, queueing
+// the OnConsiderChange hook when it changed. A peer starts as not
+// considered, so the hook also tells when it's first considered. It
+// must be called with the lock held.
+func (p *Peer) updateConsidered(considered bool) {
+	if considered == p.considered {
+		return
+	}
+	p.considered = considered
+	if hook := p.config.OnConsiderChange; hook != nil {
+		p.queueEvent(func() { hook(p, considered) })
+	}
+}
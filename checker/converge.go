@@ -0,0 +1,52 @@
+package checker
+
+import (
+	"context"
+	"time"
+)
+
+// convergePollInterval is how often WaitConverged looks at the peers
+const convergePollInterval = 100 * time.Millisecond
+
+// converged informs if every considered peer and target was checked
+// at least once and the connectivity state held for two rounds in a
+// row, with no transition of the network health pending. It must be
+// called with the lock held.
+func (pw *PeersWatcher) converged() bool {
+	if !pw.started || pw.okRounds < 1 || !pw.healthCrossingSince.IsZero() {
+		return false
+	}
+	peers := append([]*Peer(nil), pw.targetPeers...)
+	for _, aPeer := range pw.peers {
+		peers = append(peers, aPeer)
+	}
+	for _, aPeer := range peers {
+		status := aPeer.lastStatus()
+		if status.Considered && status.Enabled && status.Selected && status.LastChecked.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitConverged blocks until the watcher has a complete and stable
+// picture of the peers: every considered one was checked at least
+// once, and the connectivity state reported by Ok settled. It returns
+// the error of ctx when done before.
+func (pw *PeersWatcher) WaitConverged(ctx context.Context) error {
+	ticker := time.NewTicker(convergePollInterval)
+	defer ticker.Stop()
+	for {
+		pw.Lock()
+		converged := pw.converged()
+		pw.Unlock()
+		if converged {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package checker
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// newCorrelationID returns a correlation ID formatted as a random
+// UUID, drawn from the random source of a peer
+func newCorrelationID(random *rand.Rand) string {
+	var b [16]byte
+	random.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// CorrelationID returns the correlation ID sent with the last check
+// of the peer, empty without PeerConfig.CorrelationIDs
+func (p *Peer) CorrelationID() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.correlationID
+}
@@ -0,0 +1,46 @@
+package checker
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"uuid", "host", "ip", "reachable", "failureCount", "downSince", "lastLatencyMs", "successRate"}
+
+// ExportCSV writes a Snapshot of the peers and targets as CSV, one
+// row per peer sorted by uuid after a header, for spreadsheets. The
+// failureCount is the number of checks failed in a row, downSince is
+// in RFC 3339 and empty while reachable.
+func (pw *PeersWatcher) ExportCSV(w io.Writer) error {
+	statuses := pw.Snapshot()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].UUID < statuses[j].UUID })
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		downSince := ""
+		if !status.DownSince.IsZero() {
+			downSince = status.DownSince.UTC().Format(time.RFC3339)
+		}
+		row := []string{
+			status.UUID,
+			status.HostIP,
+			status.IP,
+			strconv.FormatBool(status.Reachable),
+			strconv.Itoa(status.ConsecutiveFailures),
+			downSince,
+			strconv.FormatFloat(status.LastLatency.Seconds()*1000, 'f', -1, 64),
+			strconv.FormatFloat(status.SuccessRate, 'f', -1, 64),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,58 @@
+package checker
+
+import (
+	"github.com/rancher/log"
+)
+
+// debugf logs the debug logs of the peer: always when its debug is
+// enabled, see SetDebug, otherwise only at the debug level and unless
+// PeerConfig.DebugPeersOnly
+func (p *Peer) debugf(format string, args ...interface{}) {
+	if p.debug {
+		p.logger.forceDebugf(format, args...)
+		return
+	}
+	if p.config.DebugPeersOnly {
+		return
+	}
+	p.logger.Debugf(format, args...)
+}
+
+// SetDebug enables or disables the debug logs of the peer whatever
+// the level of the logs, to look into a single peer
+func (p *Peer) SetDebug(enabled bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.debug = enabled
+}
+
+// Debug informs if the debug logs of the peer are enabled, see
+// SetDebug
+func (p *Peer) Debug() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.debug
+}
+
+// SetPeerDebug enables or disables the debug logs of the peer with
+// the given uuid, see Peer.SetDebug. It's remembered for the peer
+// coming back, e.g. after its container was restarted.
+func (pw *PeersWatcher) SetPeerDebug(uuid string, enabled bool) {
+	log.Infof("PeersWatcher: debug logs of peer %v enabled=%v", uuid, enabled)
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if pw.debugPeers == nil {
+		pw.debugPeers = make(map[string]bool)
+	}
+	if enabled {
+		pw.debugPeers[uuid] = true
+	} else {
+		delete(pw.debugPeers, uuid)
+	}
+	peers := pw.peersWithUUID(uuid)
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.SetDebug(enabled)
+	}
+}
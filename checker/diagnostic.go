@@ -0,0 +1,44 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// diagnosticEnabled informs if a value is fetched from the diagnostic
+// endpoint of the peer, which is only done in the modes working over
+// HTTP. It must be called with the lock held.
+func (p *Peer) diagnosticEnabled() bool {
+	if p.config.DiagnosticPath == "" || p.config.DiagnosticField == "" {
+		return false
+	}
+	mode := p.mode()
+	return mode == ModeHTTP || mode == ModeUnix
+}
+
+// fetchDiagnostic records the value of the diagnostic endpoint of the
+// peer, served along the endpoint of the given probe, it must be
+// called with the lock held
+func (p *Peer) fetchDiagnostic(probe Probe) {
+	url := fmt.Sprintf("http://%v%v", probe.Address, p.config.DiagnosticPath)
+	release := p.limiter.acquire(p.getHostIP())
+	value, err := utils.FetchJSONValue(url, p.config.DiagnosticField, probe.Options)
+	release()
+	if err != nil {
+		p.debugf("Peer(%v): fetching %v from %v: %v", p.uuid, p.config.DiagnosticField, url, err)
+		p.hasDiagnosticValue = false
+		return
+	}
+	p.diagnosticValue = value
+	p.hasDiagnosticValue = true
+}
+
+// DiagnosticValue returns the value last extracted from the diagnostic
+// endpoint of the peer, see PeerConfig.DiagnosticPath, and whether
+// there is one
+func (p *Peer) DiagnosticValue() (float64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.diagnosticValue, p.hasDiagnosticValue
+}
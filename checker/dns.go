@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// nameResolution is the result of resolving the name of the container
+// of a peer
+type nameResolution struct {
+	name  string
+	addrs []string
+	err   error
+}
+
+// resolveName resolves, when probing by name, the name of the
+// container of the peer for probeHost. It must be called without the
+// lock held, a slow resolver not blocking the readers of the peer.
+func (p *Peer) resolveName() {
+	p.Lock()
+	if !p.config.ProbeByName || p.container == nil || p.container.Name == "" {
+		p.Unlock()
+		return
+	}
+	name, timeout := p.container.Name, p.config.ConnectionTimeout
+	p.Unlock()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+
+	p.Lock()
+	p.resolution = &nameResolution{name: name, addrs: addrs, err: err}
+	p.Unlock()
+}
+
+// probeHost returns the host to probe: the IP of the peer, or the
+// name of its container when probing by name. In the latter case the
+// name, as last resolved by resolveName, tells if DNS agrees with the
+// PrimaryIp found in metadata. It must be called with the lock held.
+func (p *Peer) probeHost() string {
+	ip := p.getIP()
+	if !p.config.ProbeByName || p.container == nil || p.container.Name == "" {
+		return ip
+	}
+
+	name := p.container.Name
+	r := p.resolution
+	if r == nil || r.name != name {
+		return name
+	}
+	if r.err != nil {
+		p.debugf("Peer(%v): couldn't resolve %v: %v", p.uuid, name, r.err)
+		return name
+	}
+
+	mismatch := ip != ""
+	for _, addr := range r.addrs {
+		if addr == ip {
+			mismatch = false
+			break
+		}
+	}
+	if mismatch != p.dnsMismatch {
+		if mismatch {
+			p.logger.Errorf("Peer(%v, %v, %v): %v resolves to %v, not to the PrimaryIp", p.uuid, p.getHostIP(), ip, name, r.addrs)
+		} else {
+			p.logger.Infof("Peer(%v, %v, %v): %v resolves to the PrimaryIp again", p.uuid, p.getHostIP(), ip, name)
+		}
+	}
+	p.dnsMismatch = mismatch
+	return name
+}
+
+// DNSMismatch informs if, when probing by name, the name of the
+// container of the peer last resolved to IPs other than its
+// PrimaryIp, a sign of stale DNS or of an IP reused
+func (p *Peer) DNSMismatch() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.dnsMismatch
+}
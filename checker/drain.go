@@ -0,0 +1,74 @@
+package checker
+
+import (
+	"github.com/rancher/log"
+)
+
+// drainSink is implemented by the MetricsSinks reporting whether the
+// node is draining, see BeginDrain
+type drainSink interface {
+	SetDraining(draining bool)
+}
+
+// BeginDrain stops checking the peers and reports this node as not
+// ok until EndDrain, so that upstream routing reacts before the node
+// is drained. Unlike disabling the peers it's signaled, see
+// Config.OnDrainChange.
+func (pw *PeersWatcher) BeginDrain() {
+	pw.setDraining(true)
+}
+
+// EndDrain resumes the checks stopped by BeginDrain
+func (pw *PeersWatcher) EndDrain() {
+	pw.setDraining(false)
+}
+
+// Draining informs if the node is being drained, see BeginDrain
+func (pw *PeersWatcher) Draining() bool {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.draining
+}
+
+func (pw *PeersWatcher) setDraining(draining bool) {
+	pw.settingsMu.Lock()
+	pw.Lock()
+	if pw.draining == draining {
+		pw.Unlock()
+		pw.settingsMu.Unlock()
+		return
+	}
+	pw.draining = draining
+	if draining {
+		log.Infof("PeersWatcher: draining, checks stopped")
+	} else {
+		log.Infof("PeersWatcher: drain ended, checks resumed")
+	}
+	peers := pw.allPeers()
+	if s, ok := pw.config.Metrics.(drainSink); ok {
+		s.SetDraining(draining)
+	}
+	if hook := pw.config.OnDrainChange; hook != nil {
+		pw.queueEvent(func() { hook(draining) })
+	}
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.setDraining(draining)
+	}
+	pw.settingsMu.Unlock()
+	pw.fireEvents()
+}
+
+func (p *Peer) setDraining(draining bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.draining = draining
+}
+
+// Draining informs if the checks of the peer are stopped because the
+// node is being drained, see PeersWatcher.BeginDrain
+func (p *Peer) Draining() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.draining
+}
@@ -0,0 +1,53 @@
+package checker
+
+// SetEnabled enables or disables the checks of the peer. Unlike
+// Shutdown the peer keeps its state and can be enabled again, and
+// unlike a quarantine it's not probed at all while disabled.
+func (p *Peer) SetEnabled(enabled bool) {
+	p.Lock()
+	defer p.Unlock()
+	if p.disabled == !enabled {
+		return
+	}
+	p.logger.Infof("Peer(%v): enabled=%v", p.uuid, enabled)
+	p.disabled = !enabled
+}
+
+// Enabled informs if the peer is being checked
+func (p *Peer) Enabled() bool {
+	p.Lock()
+	defer p.Unlock()
+	return !p.disabled
+}
+
+// SetPeerEnabled enables or disables the checks of the peer with
+// the given uuid, see Peer.SetEnabled. It sticks if the peer goes
+// away from metadata and comes back. Disabled peers don't count
+// in the connectivity state.
+func (pw *PeersWatcher) SetPeerEnabled(uuid string, enabled bool) {
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if pw.disabled == nil {
+		pw.disabled = make(map[string]bool)
+	}
+	if enabled {
+		delete(pw.disabled, uuid)
+	} else {
+		pw.disabled[uuid] = true
+	}
+	peers := pw.peersWithUUID(uuid)
+	pw.Unlock()
+
+	for _, aPeer := range peers {
+		aPeer.SetEnabled(enabled)
+	}
+}
+
+// active informs if the peer is to be checked, being
+// enabled and selected, and the node not draining
+func (p *Peer) active() bool {
+	p.Lock()
+	defer p.Unlock()
+	return !p.disabled && !p.unselected && !p.draining
+}
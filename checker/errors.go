@@ -0,0 +1,37 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors aggregates several errors into one
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// errOrNil returns nil when there are no errors, so that a nil
+// Errors is never returned as a non-nil error
+func (e Errors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidationError tells which setting of a Config is invalid, and why
+type ValidationError struct {
+	// Field is the name of the setting in Config, e.g. MaxCheckInterval
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %v: %v", e.Field, e.Reason)
+}
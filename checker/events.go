@@ -0,0 +1,88 @@
+package checker
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+const (
+	// DefaultEventLogSize is the default number of transitions
+	// kept by the event log
+	DefaultEventLogSize = 100
+
+	stateReachable   = "reachable"
+	stateUnreachable = "unreachable"
+)
+
+// Event records a change of reachability of a peer
+type Event struct {
+	Time   time.Time           `json:"time"`
+	UUID   string              `json:"uuid"`
+	From   string              `json:"from"`
+	To     string              `json:"to"`
+	Reason utils.FailureReason `json:"reason,omitempty"`
+}
+
+// eventLog keeps the last transitions of all the peers in a ring
+// buffer, the oldest being overwritten once it's full
+type eventLog struct {
+	sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = DefaultEventLogSize
+	}
+	return &eventLog{events: make([]Event, size)}
+}
+
+func (l *eventLog) add(e Event) {
+	l.Lock()
+	defer l.Unlock()
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the events kept, oldest first
+func (l *eventLog) list() []Event {
+	l.Lock()
+	defer l.Unlock()
+	if !l.full {
+		return append([]Event(nil), l.events[:l.next]...)
+	}
+	events := make([]Event, 0, len(l.events))
+	events = append(events, l.events[l.next:]...)
+	return append(events, l.events[:l.next]...)
+}
+
+func newEvent(status PeerStatus, at time.Time) Event {
+	e := Event{
+		Time:   at,
+		UUID:   status.UUID,
+		From:   stateUnreachable,
+		To:     stateReachable,
+		Reason: status.FailureReason,
+	}
+	if !status.Reachable {
+		e.From, e.To = e.To, e.From
+	}
+	return e
+}
+
+// Events returns the last transitions of the peers, oldest first
+func (pw *PeersWatcher) Events() []Event {
+	return pw.events.list()
+}
+
+func (pw *PeersWatcher) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, pw.Events())
+}
@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+func TestEventLogKeepsLastEvents(t *testing.T) {
+	l := newEventLog(3)
+	for _, uuid := range []string{"a", "b", "c", "d", "e"} {
+		l.add(Event{UUID: uuid})
+	}
+
+	events := l.list()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %v", len(events))
+	}
+	for i, uuid := range []string{"c", "d", "e"} {
+		if events[i].UUID != uuid {
+			t.Errorf("expected event %v to be of %v, got %v", i, uuid, events[i].UUID)
+		}
+	}
+}
+
+func TestResultsServiceStreamsTransitions(t *testing.T) {
+	pw := &PeersWatcher{events: newEventLog(10), results: newResultsStream()}
+	at := time.Unix(1500000000, 0)
+	transition := func(e Event) {
+		pw.events.add(e)
+		pw.results.publish(e)
+	}
+	transition(Event{Time: at, UUID: "old"})
+	transition(Event{Time: at.Add(time.Second), UUID: "a"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan Event)
+	done := make(chan error)
+	go func() {
+		done <- pw.ResultsService().StreamResults(ctx, at, func(e Event) error {
+			received <- e
+			return nil
+		})
+	}()
+	if e := <-received; e.UUID != "a" {
+		t.Fatalf("expected the replay of a, got %v", e.UUID)
+	}
+	transition(Event{Time: at.Add(2 * time.Second), UUID: "b"})
+	if e := <-received; e.UUID != "b" {
+		t.Fatalf("expected b streamed, got %v", e.UUID)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected the stream to end with the context, got %v", err)
+	}
+}
+
+func TestTransitionEventsAreOfTheTransition(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	tc.err = &utils.CheckError{Reason: utils.FailureRefused, Err: errors.New("refused")}
+	var events []Event
+	p.onTransition = func(peer *Peer, e Event) {
+		events = append(events, e)
+	}
+	check := func(ok bool) {
+		tc.Lock()
+		tc.ok = ok
+		tc.Unlock()
+		p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+		p.doWork()
+	}
+
+	check(true)
+	for i := 0; i < 10 && len(p.pendingEvents) < 2; i++ {
+		check(false)
+	}
+	// Recovered before the events are delivered
+	for i := 0; i < 10 && len(p.pendingEvents) < 3; i++ {
+		check(true)
+	}
+	p.fireEvents()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 transitions, got %+v", events)
+	}
+	if events[1].To != stateUnreachable || events[1].Reason != utils.FailureRefused {
+		t.Fatalf("expected the second transition to be down with its reason, got %+v", events[1])
+	}
+	if events[2].To != stateReachable || events[2].Reason != "" {
+		t.Fatalf("expected the last transition to be up, got %+v", events[2])
+	}
+}
@@ -0,0 +1,38 @@
+package checker
+
+import (
+	"strings"
+)
+
+const (
+	// ExpectedHeaderLabel is the label of a container setting the
+	// header the responses of its checks must carry, in the same
+	// Name: value form as PeerConfig.ExpectedHeader
+	ExpectedHeaderLabel = "io.rancher.cc.expected_header"
+)
+
+// parseExpectedHeader splits a header in the Name: value form
+func parseExpectedHeader(s string) (string, string, bool) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
+// expectedHeader returns the header the responses of the HTTP checks
+// of the peer must carry, the one of its ExpectedHeaderLabel if any
+// and valid, otherwise the one of PeerConfig.ExpectedHeader. It must
+// be called with the lock held.
+func (p *Peer) expectedHeader() (string, string) {
+	if p.container != nil {
+		if s, ok := p.container.Labels[ExpectedHeaderLabel]; ok {
+			if name, value, ok := parseExpectedHeader(s); ok {
+				return name, value
+			}
+			p.debugf("Peer(%v): ignoring label %v=%v, expected Name: value", p.uuid, ExpectedHeaderLabel, s)
+		}
+	}
+	name, value, _ := parseExpectedHeader(p.config.ExpectedHeader)
+	return name, value
+}
@@ -0,0 +1,38 @@
+package checker
+
+import (
+	"time"
+)
+
+// trackSaturation records since when the count of the peer is below
+// maxCount without being 0, see PeerConfig.DegradedPeriod. It must be
+// called with the lock held.
+func (p *Peer) trackSaturation() {
+	if p.count == 0 || p.count >= maxCount {
+		p.unsaturatedSince = time.Time{}
+		return
+	}
+	if p.unsaturatedSince.IsZero() {
+		p.unsaturatedSince = p.now()
+	}
+}
+
+// degraded informs if the count of the peer stayed below maxCount for
+// DegradedPeriod without hitting 0, a peer flaky but not down. It
+// must be called with the lock held.
+func (p *Peer) degraded() bool {
+	if p.config.DegradedPeriod <= 0 || p.unsaturatedSince.IsZero() {
+		return false
+	}
+	return p.now().Sub(p.unsaturatedSince) >= time.Duration(p.config.DegradedPeriod)*time.Millisecond
+}
+
+// Degraded informs if the peer is chronically flaky: its checks keep
+// failing now and then, its count staying below its maximum for
+// PeerConfig.DegradedPeriod, while never enough for it to be down.
+// It's classified as HealthDegraded meanwhile.
+func (p *Peer) Degraded() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.degraded()
+}
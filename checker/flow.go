@@ -0,0 +1,231 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+const (
+	// DefaultFlowBatchSize is the default number of flow records
+	// sent together
+	DefaultFlowBatchSize = 20
+
+	// DefaultFlowFlushInterval is the default interval, in
+	// milliseconds, at which the pending flow records are sent
+	DefaultFlowFlushInterval = 5000
+
+	flowBuffer = 1024
+
+	protocolTCP = 6
+	flowSuccess = "success"
+)
+
+// FlowRecord describes the traffic of a check the way flow analysis
+// tools do, the fields being named after the IPFIX information
+// elements. The source is unknown when the check failed before
+// connecting, and Octets only counts the body of the response.
+type FlowRecord struct {
+	Start    int64  `json:"flowStartMilliseconds"`
+	End      int64  `json:"flowEndMilliseconds"`
+	SrcIPv4  string `json:"sourceIPv4Address,omitempty"`
+	SrcIPv6  string `json:"sourceIPv6Address,omitempty"`
+	SrcPort  int    `json:"sourceTransportPort,omitempty"`
+	DstIPv4  string `json:"destinationIPv4Address,omitempty"`
+	DstIPv6  string `json:"destinationIPv6Address,omitempty"`
+	DstPort  int    `json:"destinationTransportPort"`
+	Protocol int    `json:"protocolIdentifier"`
+	Octets   int64  `json:"octetDeltaCount"`
+	UUID     string `json:"peer"`
+	Result   string `json:"result"`
+}
+
+// flowExporter sends the flow records of the checks to a collector
+// over UDP, batched as JSON lines in a datagram, from a goroutine so
+// that the checks never wait for it. The records are dropped when
+// the buffer is full or the collector can't be reached, the latter
+// being logged once until it can be again.
+type flowExporter struct {
+	address   string
+	batchSize int
+	interval  time.Duration
+	records   chan FlowRecord
+	exit      chan struct{}
+	done      chan struct{}
+	dropped   uint64
+
+	conn    net.Conn
+	failing bool
+}
+
+func newFlowExporter(address string, batchSize, intervalMs int) *flowExporter {
+	if batchSize <= 0 {
+		batchSize = DefaultFlowBatchSize
+	}
+	if intervalMs <= 0 {
+		intervalMs = DefaultFlowFlushInterval
+	}
+	return &flowExporter{
+		address:   address,
+		batchSize: batchSize,
+		interval:  time.Duration(intervalMs) * time.Millisecond,
+		records:   make(chan FlowRecord, flowBuffer),
+		exit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// record queues a record to be sent, a nil flowExporter discards it
+func (e *flowExporter) record(r FlowRecord) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.records <- r:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *flowExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	batch := make([]FlowRecord, 0, e.batchSize)
+	for {
+		select {
+		case r := <-e.records:
+			if batch = append(batch, r); len(batch) >= e.batchSize {
+				batch = e.send(batch)
+			}
+		case <-ticker.C:
+			batch = e.send(batch)
+		case <-e.exit:
+			for {
+				select {
+				case r := <-e.records:
+					if batch = append(batch, r); len(batch) >= e.batchSize {
+						batch = e.send(batch)
+					}
+				default:
+					e.send(batch)
+					if e.conn != nil {
+						e.conn.Close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop sends the records still buffered and stops the goroutine
+func (e *flowExporter) stop() {
+	close(e.exit)
+	<-e.done
+}
+
+// send sends the batch in a datagram, connecting first if not
+// connected yet or the last send failed, and returns the batch
+// emptied for reuse
+func (e *flowExporter) send(batch []FlowRecord) []FlowRecord {
+	if len(batch) == 0 {
+		return batch
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range batch {
+		if err := enc.Encode(r); err != nil {
+			log.Errorf("error encoding flow record: %v", err)
+		}
+	}
+	err := e.write(buf.Bytes())
+	if err != nil {
+		atomic.AddUint64(&e.dropped, uint64(len(batch)))
+		if !e.failing {
+			log.Errorf("error sending flow records to %v, dropping them until it works again: %v", e.address, err)
+		}
+	} else if e.failing {
+		log.Infof("sending flow records to %v again", e.address)
+	}
+	e.failing = err != nil
+	return batch[:0]
+}
+
+func (e *flowExporter) write(b []byte) error {
+	if e.conn == nil {
+		conn, err := net.Dial("udp", e.address)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	if _, err := e.conn.Write(b); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Dropped returns the number of flow records dropped because the
+// buffer was full or the collector couldn't be reached
+func (e *flowExporter) Dropped() uint64 {
+	if e == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// recordFlow hands the flow of the check that just ended to the
+// flowExporter, if any, it must be called with the lock held. The
+// checks over a unix socket make no flow.
+func (p *Peer) recordFlow(ok bool, latency time.Duration) {
+	if p.flows == nil || p.mode() == ModeUnix {
+		return
+	}
+	end := p.now()
+	r := FlowRecord{
+		Start:    end.Add(-latency).UnixNano() / int64(time.Millisecond),
+		End:      end.UnixNano() / int64(time.Millisecond),
+		Protocol: protocolTCP,
+		Octets:   p.lastBodyBytes,
+		UUID:     p.uuid,
+		Result:   flowSuccess,
+	}
+	if !ok {
+		r.Result = string(p.failureReason)
+	}
+	var ip string
+	ip, r.DstPort = splitFlowAddress(p.lastAddress)
+	r.DstIPv4, r.DstIPv6 = flowIP(ip)
+	ip, r.SrcPort = splitFlowAddress(p.lastLocalAddress)
+	r.SrcIPv4, r.SrcIPv6 = flowIP(ip)
+	p.flows.record(r)
+}
+
+func splitFlowAddress(address string) (string, int) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0
+	}
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+// flowIP returns ip as the IPv4 or the IPv6 address of a record
+func flowIP(ip string) (string, string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", ""
+	}
+	if parsed.To4() != nil {
+		return ip, ""
+	}
+	return "", ip
+}
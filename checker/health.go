@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"time"
+)
+
+const (
+	// HealthHealthy is the class of a reachable peer answering fast
+	HealthHealthy = "healthy"
+	// HealthDegraded is the class of a reachable peer answering slowly
+	HealthDegraded = "degraded"
+	// HealthDown is the class of an unreachable peer
+	HealthDown = "down"
+
+	// latencyWeight is the weight of the newest sample in the
+	// moving average of the latency
+	latencyWeight = 0.3
+)
+
+// recordLatency updates the latency stats with the latency of
+// a successful check, it must be called with the lock held
+func (p *Peer) recordLatency(latency time.Duration) {
+	p.lastLatency = latency
+	if p.avgLatency == 0 {
+		p.avgLatency = latency
+		return
+	}
+	p.avgLatency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(p.avgLatency))
+}
+
+func (p *Peer) healthClass() string {
+	if !p.reportedReachable {
+		return HealthDown
+	}
+	if p.degraded() {
+		return HealthDegraded
+	}
+	if p.config.DegradedLatency > 0 &&
+		p.avgLatency > time.Duration(p.config.DegradedLatency)*time.Millisecond {
+		return HealthDegraded
+	}
+	return HealthHealthy
+}
+
+// HealthClass returns one of HealthHealthy, HealthDegraded or
+// HealthDown based on the reachability and the recent latency
+func (p *Peer) HealthClass() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.healthClass()
+}
+
+// updateHealthClass reports the changes of health class in and out
+// of degraded, the ones in and out of down being reported as
+// reachability transitions. It must be called with the lock held.
+func (p *Peer) updateHealthClass() {
+	p.trackSaturation()
+	class := p.healthClass()
+	previous := p.lastHealthClass
+	p.lastHealthClass = class
+	if class == previous || (class != HealthDegraded && previous != HealthDegraded) {
+		return
+	}
+
+	if class == HealthDegraded && p.degraded() {
+		p.logger.Warnf("Peer(%v, %v, %v): became degraded, flaky for %v (count: %v)", p.uuid, p.getHostIP(), p.getIP(), p.now().Sub(p.unsaturatedSince), p.count)
+	} else if class == HealthDegraded {
+		p.logger.Warnf("Peer(%v, %v, %v): became degraded (latency: %v)", p.uuid, p.getHostIP(), p.getIP(), p.relativeLatency(p.avgLatency))
+	} else {
+		p.logger.Infof("Peer(%v, %v, %v): no longer degraded, now %v (latency: %v)", p.uuid, p.getHostIP(), p.getIP(), class, p.relativeLatency(p.avgLatency))
+	}
+	if hook := p.config.OnHealthClassChange; hook != nil {
+		p.queueEvent(func() { hook(p, previous, class) })
+	}
+}
+
+// LastLatency returns the latency of the last successful check
+func (p *Peer) LastLatency() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.lastLatency
+}
+
+// LastConnectTime returns how long establishing the connection of the
+// last check that connected took, the TCP handshake alone, see
+// PeerConfig.MeasureConnectTime. Compared with LastLatency it tells a
+// slow network from a slow app.
+func (p *Peer) LastConnectTime() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.lastConnectTime
+}
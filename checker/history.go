@@ -0,0 +1,271 @@
+package checker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+const (
+	// DefaultHistoryMaxSize is the default size, in bytes, beyond
+	// which the history file is rotated
+	DefaultHistoryMaxSize = 10 * 1024 * 1024
+
+	// DefaultHistoryMaxFiles is the default number of rotated
+	// history files kept
+	DefaultHistoryMaxFiles = 3
+
+	historyBuffer = 1024
+)
+
+// CheckRecord is the result of a check as written to the history file
+type CheckRecord struct {
+	Time          time.Time           `json:"time"`
+	UUID          string              `json:"uuid"`
+	OK            bool                `json:"ok"`
+	Latency       time.Duration       `json:"latency"`
+	FailureReason utils.FailureReason `json:"failureReason,omitempty"`
+	Count         int                 `json:"count"`
+	Source        string              `json:"source,omitempty"`
+	Endpoint      string              `json:"endpoint,omitempty"`
+}
+
+// historyWriter appends the results of the checks to a file as JSON
+// lines, rotating it once too big. The writes are done by a goroutine
+// through a buffer, the records being dropped when it's full so that
+// the disk never slows the checks down.
+type historyWriter struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	records  chan CheckRecord
+	exit     chan struct{}
+	done     chan struct{}
+	dropped  uint64
+
+	file *os.File
+	size int64
+}
+
+func newHistoryWriter(path string, maxSize, maxFiles int) *historyWriter {
+	if maxSize <= 0 {
+		maxSize = DefaultHistoryMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultHistoryMaxFiles
+	}
+	return &historyWriter{
+		path:     path,
+		maxSize:  int64(maxSize),
+		maxFiles: maxFiles,
+		records:  make(chan CheckRecord, historyBuffer),
+		exit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// record queues a record to be written, a nil historyWriter
+// discards it
+func (h *historyWriter) record(r CheckRecord) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.records <- r:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped because the buffer was
+// full
+func (h *historyWriter) Dropped() uint64 {
+	if h == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&h.dropped)
+}
+
+func (h *historyWriter) run() {
+	defer close(h.done)
+	defer h.close()
+	for {
+		select {
+		case r := <-h.records:
+			h.write(r)
+		case <-h.exit:
+			for {
+				select {
+				case r := <-h.records:
+					h.write(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop writes the records still buffered and closes the file
+func (h *historyWriter) stop() {
+	close(h.exit)
+	<-h.done
+}
+
+func (h *historyWriter) write(r CheckRecord) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Errorf("error encoding check record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if h.file != nil && h.size+int64(len(line)) > h.maxSize {
+		h.rotate()
+	}
+	if h.file == nil {
+		if err := h.open(); err != nil {
+			log.Errorf("error opening history file %v: %v", h.path, err)
+			return
+		}
+	}
+	n, err := h.file.Write(line)
+	h.size += int64(n)
+	if err != nil {
+		log.Errorf("error writing history file %v: %v", h.path, err)
+	}
+}
+
+func (h *historyWriter) open() error {
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// The records are appended after the last complete line, not
+	// after the torn one a crash while writing may have left
+	size, err := trimTornLine(f, info.Size())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if size != info.Size() {
+		log.Warnf("history file %v: dropped a torn last line of %v bytes", h.path, info.Size()-size)
+	}
+	h.file, h.size = f, size
+	return nil
+}
+
+// trimTornLine truncates the file of the given size after its last
+// complete line and returns its new size
+func trimTornLine(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	trimmed := int64(0)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return size, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			trimmed = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if trimmed == size {
+		return size, nil
+	}
+	return trimmed, f.Truncate(trimmed)
+}
+
+func (h *historyWriter) close() {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+// rotate shifts the history files, path becoming path.1, path.1
+// becoming path.2, and so on, the oldest being removed
+func (h *historyWriter) rotate() {
+	h.close()
+	os.Remove(rotatedPath(h.path, h.maxFiles))
+	for i := h.maxFiles - 1; i >= 1; i-- {
+		os.Rename(rotatedPath(h.path, i), rotatedPath(h.path, i+1))
+	}
+	if err := os.Rename(h.path, rotatedPath(h.path, 1)); err != nil {
+		log.Errorf("error rotating history file %v: %v", h.path, err)
+	}
+}
+
+func rotatedPath(path string, i int) string {
+	return fmt.Sprintf("%v.%v", path, i)
+}
+
+// ReplayHistory calls fn with every record of the history written
+// to path, including the rotated files, oldest first. An undecodable
+// last line of a file, torn by a crash while writing it, is skipped,
+// one in the middle of a file fails the replay.
+func ReplayHistory(path string, fn func(CheckRecord)) error {
+	var paths []string
+	for i := 1; ; i++ {
+		p := rotatedPath(path, i)
+		if _, err := os.Stat(p); err != nil {
+			break
+		}
+		paths = append([]string{p}, paths...)
+	}
+	paths = append(paths, path)
+
+	for _, p := range paths {
+		if err := replayFile(p, fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayFile(path string, fn func(CheckRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var torn error
+	for scanner.Scan() {
+		if torn != nil {
+			// The undecodable line wasn't the last one
+			return torn
+		}
+		var r CheckRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			torn = fmt.Errorf("error decoding %v: %v", path, err)
+			continue
+		}
+		fn(r)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if torn != nil {
+		log.Warnf("skipping the torn last line of %v: %v", path, torn)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package checker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+func TestHistoryRotatesAndReplaysInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history.json")
+	// Small enough for every record to be in its own file
+	h := newHistoryWriter(path, 10, 2)
+	go h.run()
+	for _, uuid := range []string{"a", "b", "c", "d"} {
+		h.record(CheckRecord{UUID: uuid})
+	}
+	h.stop()
+
+	var uuids []string
+	if err := ReplayHistory(path, func(r CheckRecord) {
+		uuids = append(uuids, r.UUID)
+	}); err != nil {
+		t.Fatalf("error replaying history: %v", err)
+	}
+	// The oldest record got rotated away
+	if len(uuids) != 3 || uuids[0] != "b" || uuids[1] != "c" || uuids[2] != "d" {
+		t.Fatalf("expected records b, c, d, got %v", uuids)
+	}
+}
+
+func TestHistorySkipsTornLastLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A crash while writing the last record tore it
+	path := filepath.Join(dir, "history.json")
+	if err := ioutil.WriteFile(path, []byte(`{"uuid":"a"}`+"\n"+`{"uuid":"b","o`), 0644); err != nil {
+		t.Fatalf("error writing history: %v", err)
+	}
+	replay := func() ([]string, error) {
+		var uuids []string
+		err := ReplayHistory(path, func(r CheckRecord) {
+			uuids = append(uuids, r.UUID)
+		})
+		return uuids, err
+	}
+	if uuids, err := replay(); err != nil || len(uuids) != 1 || uuids[0] != "a" {
+		t.Fatalf("expected record a with the torn line skipped, got %v, err=%v", uuids, err)
+	}
+
+	// The next records are written after the last complete line
+	h := newHistoryWriter(path, 0, 0)
+	go h.run()
+	h.record(CheckRecord{UUID: "c"})
+	h.stop()
+	if uuids, err := replay(); err != nil || len(uuids) != 2 || uuids[0] != "a" || uuids[1] != "c" {
+		t.Fatalf("expected records a, c, got %v, err=%v", uuids, err)
+	}
+
+	// A corrupted line in the middle fails the replay
+	if err := ioutil.WriteFile(path, []byte(`{"uuid":"a"}`+"\n"+`{"uu`+"\n"+`{"uuid":"c"}`+"\n"), 0644); err != nil {
+		t.Fatalf("error writing history: %v", err)
+	}
+	if _, err := replay(); err == nil {
+		t.Fatalf("expected the corrupted history to fail the replay")
+	}
+}
+
+func TestHistoryCountsDroppedRecords(t *testing.T) {
+	// Not running, the writer lets the records pile up in the buffer
+	h := newHistoryWriter(filepath.Join(os.TempDir(), "unused.json"), 0, 0)
+	for i := 0; i < historyBuffer+2; i++ {
+		h.record(CheckRecord{UUID: "a"})
+	}
+	if dropped := h.Dropped(); dropped != 2 {
+		t.Fatalf("expected 2 records dropped, got %v", dropped)
+	}
+	if dropped := (&PeersWatcher{}).DroppedHistory(); dropped != 0 {
+		t.Fatalf("expected no record dropped without history, got %v", dropped)
+	}
+}
+
+func TestFlowExporterBatchesRecords(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer collector.Close()
+
+	e := newFlowExporter(collector.LocalAddr().String(), 2, 60000)
+	go e.run()
+	for _, uuid := range []string{"a", "b", "c"} {
+		e.record(FlowRecord{UUID: uuid, DstPort: 8080, Protocol: protocolTCP, Result: flowSuccess})
+	}
+	e.stop()
+
+	var batches [][]string
+	buf := make([]byte, 65536)
+	for len(batches) < 2 {
+		collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := collector.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("error reading flow records: %v", err)
+		}
+		var uuids []string
+		for _, line := range strings.Split(strings.TrimSpace(string(buf[:n])), "\n") {
+			var r FlowRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				t.Fatalf("error decoding flow record %q: %v", line, err)
+			}
+			uuids = append(uuids, r.UUID)
+		}
+		batches = append(batches, uuids)
+	}
+	if !reflect.DeepEqual(batches, [][]string{{"a", "b"}, {"c"}}) {
+		t.Fatalf("expected batches [[a b] [c]], got %v", batches)
+	}
+	if dropped := e.Dropped(); dropped != 0 {
+		t.Fatalf("expected no dropped record, got %v", dropped)
+	}
+}
+
+func TestSimulateAppliesSettings(t *testing.T) {
+	at := time.Unix(1500000000, 0)
+	var records []CheckRecord
+	for i, ok := range []bool{true, true, true, false, false, true} {
+		r := CheckRecord{Time: at.Add(time.Duration(i) * time.Second), UUID: "c1", OK: ok}
+		if !ok {
+			r.FailureReason = utils.FailureConnectTimeout
+		}
+		records = append(records, r)
+	}
+
+	cfg := DefaultConfig().PeerConfig
+	if events := Simulate(records, cfg); len(events) != 1 || events[0].To != stateReachable {
+		t.Fatalf("expected the peer to only become reachable, got %+v", events)
+	}
+	cfg.FailFast = true
+	events := Simulate(records, cfg)
+	expected := []Event{
+		{Time: at, UUID: "c1", From: stateUnreachable, To: stateReachable},
+		{Time: at.Add(3 * time.Second), UUID: "c1", From: stateReachable, To: stateUnreachable, Reason: utils.FailureConnectTimeout},
+		{Time: at.Add(5 * time.Second), UUID: "c1", From: stateUnreachable, To: stateReachable},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected with FailFast:\n%+v\ngot:\n%+v", expected, events)
+	}
+}
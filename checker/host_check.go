@@ -0,0 +1,97 @@
+package checker
+
+import "time"
+
+const (
+	// HostCheckAlso checks the agent of the host of a peer in addition
+	// to its container, reporting the host reachability separately
+	HostCheckAlso = "also"
+	// HostCheckOnly checks the agent of the host of a peer instead of
+	// its container, the reachability of the peer being the one of
+	// its host
+	HostCheckOnly = "only"
+)
+
+// hostCheckEnabled informs if the agent of the host of the peer is
+// checked, fixed targets having no host. It must be called with the
+// lock held.
+func (p *Peer) hostCheckEnabled() bool {
+	return p.target == nil && p.config.HostCheckPort > 0 &&
+		(p.config.HostCheck == HostCheckAlso || p.config.HostCheck == HostCheckOnly)
+}
+
+// hostCheckMode returns the mode of the host checks
+func hostCheckMode(mode string) string {
+	if mode == "" {
+		return ModeTCP
+	}
+	return mode
+}
+
+// hostProbe returns the probe of the agent of the host of the peer,
+// it must be called with the lock held
+func (p *Peer) hostProbe() (Checker, Probe, error) {
+	return p.hostEndpointProbe(p.config.HostCheckMode, p.config.HostCheckPort, p.config.HostCheckPath)
+}
+
+// checkHost probes the agent of the host of the peer along with its
+// container, it must be called with the lock held
+func (p *Peer) checkHost() {
+	checker, probe, err := p.hostProbe()
+	if err != nil {
+		p.logger.Errorf("Peer(%v): host check: %v", p.uuid, err)
+		return
+	}
+	release := p.limiter.acquire(p.getHostIP())
+	start := time.Now()
+	ok, err := p.cachedCheck(hostCheckMode(p.config.HostCheckMode), checker, probe)
+	p.hostLatency = time.Since(start)
+	release()
+	p.setHostReachable(ok, err)
+}
+
+// startHostCheck probes the agent of the host of the peer in the
+// background, see startTarget. It must be called with the lock held.
+func (p *Peer) startHostCheck(deadline time.Time) <-chan targetResult {
+	checker, probe, err := p.hostProbe()
+	if err != nil {
+		p.logger.Errorf("Peer(%v): host check: %v", p.uuid, err)
+		result := make(chan targetResult, 1)
+		result <- targetResult{err: err}
+		return result
+	}
+	mode := hostCheckMode(p.config.HostCheckMode)
+	return p.startTarget("host check", deadline, func() (bool, error) {
+		return p.cachedCheck(mode, checker, probe)
+	})
+}
+
+// setHostReachable records the result of a check of the agent of
+// the host, it must be called with the lock held
+func (p *Peer) setHostReachable(ok bool, err error) {
+	if ok != p.hostReachable {
+		if ok {
+			p.logger.Infof("Peer(%v, %v, %v): host became reachable", p.uuid, p.getHostIP(), p.getIP())
+		} else {
+			p.logger.Errorf("Peer(%v, %v, %v): host became unreachable (%v)", p.uuid, p.getHostIP(), p.getIP(), err)
+		}
+	}
+	p.hostReachable = ok
+}
+
+// HostReachable informs if the agent of the host of the peer was
+// reachable on its last check, it's false when the host isn't
+// checked, see PeerConfig.HostCheck
+func (p *Peer) HostReachable() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.hostReachable
+}
+
+// HostLatency returns how long the last check of the agent of the
+// host of the peer took, 0 when the host isn't checked
+func (p *Peer) HostLatency() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.hostLatency
+}
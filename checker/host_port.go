@@ -0,0 +1,52 @@
+package checker
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mappedPort returns the host IP and port the given private port of
+// a container is mapped to, found in the ports of its metadata in the
+// [hostIP:]publicPort:privatePort[/protocol] form
+func mappedPort(ports []string, private int) (string, int, bool) {
+	for _, mapping := range ports {
+		if i := strings.Index(mapping, "/"); i >= 0 {
+			if mapping[i+1:] != "tcp" {
+				continue
+			}
+			mapping = mapping[:i]
+		}
+		parts := strings.Split(mapping, ":")
+		if len(parts) < 2 || parts[len(parts)-1] != strconv.Itoa(private) {
+			continue
+		}
+		public, err := strconv.Atoi(parts[len(parts)-2])
+		if err != nil || public <= 0 {
+			continue
+		}
+		hostIP := ""
+		if len(parts) > 2 {
+			hostIP = parts[0]
+		}
+		return hostIP, public, true
+	}
+	return "", 0, false
+}
+
+// hostPortEndpoint returns the host IP and port to probe instead of
+// the PrimaryIp of the peer when PeerConfig.HostPort is set and its
+// container maps the given port on its host. It must be called with
+// the lock held.
+func (p *Peer) hostPortEndpoint(port int) (string, int, bool) {
+	if !p.config.HostPort || p.target != nil || p.container == nil {
+		return "", 0, false
+	}
+	hostIP, public, ok := mappedPort(p.container.Ports, port)
+	if !ok {
+		return "", 0, false
+	}
+	if hostIP == "" || hostIP == "0.0.0.0" {
+		hostIP = p.getHostIP()
+	}
+	return hostIP, public, hostIP != ""
+}
@@ -0,0 +1,91 @@
+package checker
+
+import (
+	"time"
+)
+
+// hourBucket counts the checks of an hour of a day
+type hourBucket struct {
+	// day is the day, since the epoch, the counts are of
+	day       int64
+	attempts  uint32
+	successes uint32
+}
+
+// hourlyRates keeps the results of the checks by hour of the day over
+// the last days, a day per slot of a ring reused once its day left the
+// window, so that recording is a couple of increments and the memory
+// is bounded by the window
+type hourlyRates struct {
+	days [][24]hourBucket
+}
+
+func newHourlyRates(days int) *hourlyRates {
+	return &hourlyRates{days: make([][24]hourBucket, days)}
+}
+
+// dayOf returns the day of t, in its location, since the epoch
+func dayOf(t time.Time) int64 {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / int64(24*time.Hour/time.Second)
+}
+
+func (h *hourlyRates) record(at time.Time, ok bool) {
+	day := dayOf(at)
+	b := &h.days[int(day%int64(len(h.days)))][at.Hour()]
+	if b.day != day {
+		*b = hourBucket{day: day}
+	}
+	b.attempts++
+	if ok {
+		b.successes++
+	}
+}
+
+func (h *hourlyRates) rates(now time.Time) [24]float64 {
+	today := dayOf(now)
+	var attempts, successes [24]uint64
+	for _, hours := range h.days {
+		for hour, b := range hours {
+			if b.attempts == 0 || today-b.day >= int64(len(h.days)) || b.day > today {
+				continue
+			}
+			attempts[hour] += uint64(b.attempts)
+			successes[hour] += uint64(b.successes)
+		}
+	}
+	var rates [24]float64
+	for hour := range rates {
+		rates[hour] = -1
+		if attempts[hour] > 0 {
+			rates[hour] = float64(successes[hour]) / float64(attempts[hour])
+		}
+	}
+	return rates
+}
+
+// recordHourly accounts for the result of a check in the hourly
+// success rates, if enabled, it must be called with the lock held
+func (p *Peer) recordHourly(ok bool) {
+	if p.config.HourlyWindowDays <= 0 {
+		return
+	}
+	if p.hourly == nil || len(p.hourly.days) != p.config.HourlyWindowDays {
+		p.hourly = newHourlyRates(p.config.HourlyWindowDays)
+	}
+	p.hourly.record(p.now(), ok)
+}
+
+// HourlySuccessRates returns the success rate of the checks of the
+// peer by hour of the day, in the local time, over the last
+// PeerConfig.HourlyWindowDays days, telling the failures happening at
+// the same time every day, e.g. during a batch job. The hours without
+// check, all of them when disabled, are -1.
+func (p *Peer) HourlySuccessRates() [24]float64 {
+	p.Lock()
+	defer p.Unlock()
+	if p.hourly == nil {
+		return newHourlyRates(1).rates(p.now())
+	}
+	return p.hourly.rates(p.now())
+}
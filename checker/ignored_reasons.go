@@ -0,0 +1,72 @@
+package checker
+
+import (
+	"sort"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+func reasonSet(reasons []utils.FailureReason) map[utils.FailureReason]bool {
+	if len(reasons) == 0 {
+		return nil
+	}
+	set := make(map[utils.FailureReason]bool, len(reasons))
+	for _, reason := range reasons {
+		set[reason] = true
+	}
+	return set
+}
+
+// SetIgnoredReasons makes the failed checks of the given reasons
+// neutral for the peer, e.g. refusals for a peer behind a firewall
+// always refusing on one port, whatever PeerConfig.FailureWeights
+func (p *Peer) SetIgnoredReasons(reasons []utils.FailureReason) {
+	p.Lock()
+	defer p.Unlock()
+	p.ignoredReasons = reasonSet(reasons)
+}
+
+// IgnoredReasons returns the reasons of the failed checks that are
+// neutral for the peer, sorted, see SetIgnoredReasons
+func (p *Peer) IgnoredReasons() []utils.FailureReason {
+	p.Lock()
+	defer p.Unlock()
+	return p.ignoredReasonsList()
+}
+
+// ignoredReasonsList must be called with the lock held
+func (p *Peer) ignoredReasonsList() []utils.FailureReason {
+	if len(p.ignoredReasons) == 0 {
+		return nil
+	}
+	reasons := make([]utils.FailureReason, 0, len(p.ignoredReasons))
+	for reason := range p.ignoredReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	return reasons
+}
+
+// SetPeerIgnoredReasons makes the failed checks of the given reasons
+// neutral for the peer with the given uuid, see Peer.SetIgnoredReasons.
+// It's remembered for the peer coming back, no reasons clearing it.
+func (pw *PeersWatcher) SetPeerIgnoredReasons(uuid string, reasons []utils.FailureReason) {
+	log.Infof("PeersWatcher: ignoring the failures of peer %v for reasons %v", uuid, reasons)
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if pw.ignoredReasons == nil {
+		pw.ignoredReasons = make(map[string][]utils.FailureReason)
+	}
+	if len(reasons) > 0 {
+		pw.ignoredReasons[uuid] = append([]utils.FailureReason(nil), reasons...)
+	} else {
+		delete(pw.ignoredReasons, uuid)
+	}
+	peers := pw.peersWithUUID(uuid)
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.SetIgnoredReasons(reasons)
+	}
+}
@@ -0,0 +1,36 @@
+package checker
+
+const (
+	// LoopProbeFirst makes a peer check then sleep, the first check
+	// being done right away
+	LoopProbeFirst = "probe-first"
+	// LoopSleepFirst makes a peer sleep then check, each check ending
+	// an interval, e.g. to line up with an external tick
+	LoopSleepFirst = "sleep-first"
+
+	// JitterUniform spreads the checks of a peer evenly within
+	// maxCheckJitter before the end of the interval
+	JitterUniform = "uniform"
+	// JitterExponential draws the jitter of the checks of a peer from
+	// an exponential distribution, truncated to maxCheckJitter, so that
+	// the checks of many peers arrive closer to a Poisson process
+	JitterExponential = "exponential"
+)
+
+// checkJitter returns how much earlier, in milliseconds, the next
+// check of the peer is done, see PeerConfig.JitterDistribution. It's
+// drawn from the random source of the peer and always less than
+// maxCheckJitter, so that the interval is never shorter than
+// minCheckInterval. It must be called with the lock held.
+func (p *Peer) checkJitter() int {
+	if p.config.JitterDistribution != JitterExponential {
+		return p.random.Intn(maxCheckJitter)
+	}
+	// The mean being a quarter of the bound, the truncation seldom
+	// applies and barely skews the distribution
+	for {
+		if jitter := int(p.random.ExpFloat64() * maxCheckJitter / 4); jitter < maxCheckJitter {
+			return jitter
+		}
+	}
+}
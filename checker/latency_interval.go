@@ -0,0 +1,38 @@
+package checker
+
+import (
+	"time"
+)
+
+// latencyIntervalEnabled informs if the interval of the peer follows
+// its latency, see PeerConfig.LatencyIntervalMin
+func (p *Peer) latencyIntervalEnabled() bool {
+	return p.config.LatencyIntervalMin > 0 && p.config.LatencyIntervalMax > 0
+}
+
+// latencyInterval scales the interval by how fast and stable the
+// latency of the reachable peer is: twice as long when steady at its
+// baseline, shorter as it gets slower or more variable, within
+// LatencyIntervalMin and LatencyIntervalMax. It must be called with
+// the lock held.
+func (p *Peer) latencyInterval(interval time.Duration) time.Duration {
+	if !p.latencyIntervalEnabled() || p.count == 0 || p.avgLatency == 0 {
+		return interval
+	}
+	relative := 1.0
+	if p.baselineLatency > 0 && p.avgLatency > p.baselineLatency {
+		relative = float64(p.avgLatency) / float64(p.baselineLatency)
+	}
+	variation := float64(p.latencyStdDev()) / float64(p.avgLatency)
+	scaled := time.Duration(2 * float64(interval) / (relative * (1 + 4*variation)))
+
+	min := time.Duration(p.config.LatencyIntervalMin) * time.Millisecond
+	max := time.Duration(p.config.LatencyIntervalMax) * time.Millisecond
+	if scaled < min {
+		return min
+	}
+	if scaled > max {
+		return max
+	}
+	return scaled
+}
@@ -0,0 +1,128 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultShutdownTimeout is the default time, in milliseconds,
+	// Stop waits for the goroutines of the watcher to end
+	DefaultShutdownTimeout = 10000
+)
+
+// lifecycle keeps track of the goroutines of the watcher besides the
+// checks of the peers, so that Stop can wait for all of them, and
+// tell which didn't end in time
+type lifecycle struct {
+	sync.Mutex
+	running map[int]*goroutine
+	next    int
+}
+
+type goroutine struct {
+	name string
+	done chan struct{}
+	// late is set once reported as still running
+	late bool
+}
+
+// goRun runs f in a goroutine known by name
+func (l *lifecycle) goRun(name string, f func()) {
+	g := &goroutine{name: name, done: make(chan struct{})}
+	l.Lock()
+	if l.running == nil {
+		l.running = make(map[int]*goroutine)
+	}
+	id := l.next
+	l.next++
+	l.running[id] = g
+	l.Unlock()
+
+	go func() {
+		defer func() {
+			l.Lock()
+			delete(l.running, id)
+			l.Unlock()
+			close(g.done)
+		}()
+		f()
+	}()
+}
+
+// goRun runs f in a goroutine known by name to the lifecycle of the
+// watcher of the peer, if any, so that Stop waits for it too
+func (p *Peer) goRun(name string, f func()) {
+	if p.lifecycle == nil {
+		go f()
+		return
+	}
+	p.lifecycle.goRun(fmt.Sprintf("%v of peer %v", name, p.uuid), f)
+}
+
+// wait waits until the deadline for the goroutines with the given
+// names, all of them when none is given, to end. It returns an error
+// per goroutine still running at the deadline, and not reported by an
+// earlier wait.
+func (l *lifecycle) wait(deadline time.Time, names ...string) Errors {
+	l.Lock()
+	var waited []*goroutine
+	for _, g := range l.running {
+		if !g.late && (len(names) == 0 || contains(names, g.name)) {
+			waited = append(waited, g)
+		}
+	}
+	l.Unlock()
+	sort.Slice(waited, func(i, j int) bool { return waited[i].name < waited[j].name })
+	return waitUntil(deadline, waited)
+}
+
+// waitUntil waits until the deadline for the goroutines to end,
+// returning an error per goroutine still running then
+func waitUntil(deadline time.Time, goroutines []*goroutine) Errors {
+	var errs Errors
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	expired := false
+	for _, g := range goroutines {
+		if !expired {
+			select {
+			case <-g.done:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		select {
+		case <-g.done:
+		default:
+			g.late = true
+			errs = append(errs, fmt.Errorf("%v didn't stop within the shutdown timeout", g.name))
+		}
+	}
+	return errs
+}
+
+// shutdownDeadline returns when Stop gives up waiting for the
+// goroutines, see Config.ShutdownTimeout
+func (pw *PeersWatcher) shutdownDeadline() time.Time {
+	timeout := pw.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	return time.Now().Add(time.Duration(timeout) * time.Millisecond)
+}
+
+// waitPeers waits until the deadline for the checks of the peers
+// shut down to end, returning an error per peer still checking then
+func waitPeers(deadline time.Time, peers []*Peer) Errors {
+	var goroutines []*goroutine
+	for _, aPeer := range peers {
+		if aPeer.done != nil {
+			goroutines = append(goroutines, &goroutine{name: fmt.Sprintf("checks of peer %v", aPeer.uuid), done: aPeer.done})
+		}
+	}
+	return waitUntil(deadline, goroutines)
+}
@@ -0,0 +1,245 @@
+package checker
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter bounds how many checks run concurrently, overall and
+// against the same destination host. It's shared by all the peers
+// of a watcher. Optionally, the overall bound ramps up from
+// rampInitial to global over rampPeriod after the limiter is
+// created, smoothing the transient of starting many peers at once.
+type limiter struct {
+	sync.Mutex
+	// released is signaled whenever a check is over or the bound rises
+	// during the ramp up, the checks waiting for their turn on it
+	released  *sync.Cond
+	rampTimer *time.Timer
+	perHost   int
+	hosts     map[string]chan struct{}
+
+	global      int
+	inFlight    int
+	rampStart   time.Time
+	rampPeriod  time.Duration
+	rampInitial int
+
+	hostInFlight    map[string]int
+	maxHostInFlight int
+}
+
+func newLimiter(perHost int) *limiter {
+	l := &limiter{
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+	l.released = sync.NewCond(&l.Mutex)
+	return l
+}
+
+// setRamp configures the overall bound, and its ramp up which
+// starts now when period is not 0
+func (l *limiter) setRamp(global, initial int, period time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.global = global
+	l.rampInitial = initial
+	l.rampPeriod = period
+	l.rampStart = time.Now()
+	l.released.Broadcast()
+}
+
+// allowed returns the current overall bound, 0 meaning no
+// bound. It must be called with the lock held.
+func (l *limiter) allowed() int {
+	if l.rampPeriod <= 0 {
+		return l.global
+	}
+	elapsed := time.Since(l.rampStart)
+	if elapsed >= l.rampPeriod {
+		return l.global
+	}
+	initial := l.rampInitial
+	if initial < 1 {
+		initial = 1
+	}
+	if l.global <= 0 {
+		// Without an overall bound, the initial one is held
+		// during the whole period
+		return initial
+	}
+	n := initial + int(float64(l.global-initial)*float64(elapsed)/float64(l.rampPeriod))
+	return n
+}
+
+// wakeOnRamp arms, during the ramp up, a timer waking the checks
+// waiting once the bound rises. It must be called with the lock held.
+func (l *limiter) wakeOnRamp() {
+	if l.rampTimer != nil || l.rampPeriod <= 0 {
+		return
+	}
+	elapsed := time.Since(l.rampStart)
+	if elapsed >= l.rampPeriod {
+		return
+	}
+	next := l.rampPeriod - elapsed
+	initial := l.rampInitial
+	if initial < 1 {
+		initial = 1
+	}
+	// Without an overall bound, the initial one holds until the end
+	if l.global > initial {
+		step := l.rampPeriod / time.Duration(l.global-initial)
+		if step < time.Millisecond {
+			step = time.Millisecond
+		}
+		if untilStep := step - elapsed%step; untilStep < next {
+			next = untilStep
+		}
+	}
+	l.rampTimer = time.AfterFunc(next, func() {
+		l.Lock()
+		l.rampTimer = nil
+		l.Unlock()
+		l.released.Broadcast()
+	})
+}
+
+// Allowed returns the current bound of concurrent checks, 0 meaning
+// no bound
+func (l *limiter) Allowed() int {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.allowed()
+}
+
+// acquire blocks until a check against host can run and
+// returns the function releasing it
+func (l *limiter) acquire(host string) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	// The slot of the host is taken first, so that the checks waiting
+	// for a busy host don't hold the overall slots the checks of the
+	// other hosts could use
+	var sem chan struct{}
+	if l.perHost > 0 {
+		l.Lock()
+		sem = l.hostSem(host)
+		l.Unlock()
+		sem <- struct{}{}
+	}
+
+	l.Lock()
+	for {
+		allowed := l.allowed()
+		if allowed <= 0 || l.inFlight < allowed {
+			l.inFlight++
+			break
+		}
+		l.wakeOnRamp()
+		l.released.Wait()
+	}
+	l.Unlock()
+
+	l.startOnHost(host)
+	return func() {
+		l.doneOnHost(host)
+		if sem != nil {
+			<-sem
+		}
+		l.releaseGlobal()
+	}
+}
+
+// tryAcquire is acquire without waiting, it returns false when a
+// check against host can't run right away
+func (l *limiter) tryAcquire(host string) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	l.Lock()
+	if allowed := l.allowed(); allowed > 0 && l.inFlight >= allowed {
+		l.Unlock()
+		return nil, false
+	}
+	var sem chan struct{}
+	if l.perHost > 0 {
+		sem = l.hostSem(host)
+		select {
+		case sem <- struct{}{}:
+		default:
+			l.Unlock()
+			return nil, false
+		}
+	}
+	l.inFlight++
+	l.Unlock()
+
+	l.startOnHost(host)
+	return func() {
+		l.doneOnHost(host)
+		if sem != nil {
+			<-sem
+		}
+		l.releaseGlobal()
+	}, true
+}
+
+// hostSem returns the semaphore bounding the checks against host, it
+// must be called with the lock held
+func (l *limiter) hostSem(host string) chan struct{} {
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, l.perHost)
+		l.hosts[host] = sem
+	}
+	return sem
+}
+
+// releaseGlobal releases the overall slot of a check that is over
+func (l *limiter) releaseGlobal() {
+	l.Lock()
+	l.inFlight--
+	l.Unlock()
+	l.released.Signal()
+}
+
+// startOnHost counts a check starting against host
+func (l *limiter) startOnHost(host string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.hostInFlight == nil {
+		l.hostInFlight = make(map[string]int)
+	}
+	l.hostInFlight[host]++
+	if l.hostInFlight[host] > l.maxHostInFlight {
+		l.maxHostInFlight = l.hostInFlight[host]
+	}
+}
+
+// doneOnHost counts a check against host being over
+func (l *limiter) doneOnHost(host string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.hostInFlight[host]--; l.hostInFlight[host] <= 0 {
+		delete(l.hostInFlight, host)
+	}
+}
+
+// MaxHostConcurrency returns the most checks which ran at the same
+// time against a single host so far
+func (l *limiter) MaxHostConcurrency() int {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.maxHostInFlight
+}
@@ -0,0 +1,84 @@
+package checker
+
+import (
+	"net"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+const (
+	// LocalIPProbe checks the peers whose PrimaryIp is local like the
+	// others
+	LocalIPProbe = "probe"
+	// LocalIPSkip doesn't check the peers whose PrimaryIp is local,
+	// leaving them out of the connectivity state
+	LocalIPSkip = "skip"
+	// LocalIPReachable doesn't check the peers whose PrimaryIp is
+	// local, reporting them reachable
+	LocalIPReachable = "reachable"
+)
+
+// localAddresses returns the AgentIP of this host along with the
+// addresses of its interfaces
+func localAddresses(selfIP string) map[string]bool {
+	local := make(map[string]bool)
+	if selfIP != "" {
+		local[selfIP] = true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Errorf("PeersWatcher: error listing the local addresses: %v", err)
+		return local
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			local[ipNet.IP.String()] = true
+		}
+	}
+	return local
+}
+
+// setLocalAddresses records the addresses of this host, the map being
+// shared by all the peers and never modified
+func (p *Peer) setLocalAddresses(local map[string]bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.localAddresses = local
+}
+
+// localIP informs if the PrimaryIp of the peer is one of this host,
+// e.g. this host via the overlay, where a probe may loop back. It
+// must be called with the lock held.
+func (p *Peer) localIP() bool {
+	return p.target == nil && p.localAddresses[p.getIP()]
+}
+
+// skippedLocal informs if the peer is left out of the connectivity
+// state, see LocalIPSkip
+func (p *Peer) skippedLocal() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.config.LocalIPPolicy == LocalIPSkip && p.localIP()
+}
+
+// handleLocalIP handles the check of a peer whose PrimaryIp is local
+// as asked by PeerConfig.LocalIPPolicy, it returns true if the peer
+// isn't to be probed. It must be called with the lock held.
+func (p *Peer) handleLocalIP() bool {
+	if !p.localIP() {
+		return false
+	}
+	switch p.config.LocalIPPolicy {
+	case LocalIPSkip:
+		p.debugf("Peer(%v, %v, %v): PrimaryIp is local, skipping check", p.uuid, p.getHostIP(), p.getIP())
+		return true
+	case LocalIPReachable:
+		p.debugf("Peer(%v, %v, %v): PrimaryIp is local, reporting it reachable", p.uuid, p.getHostIP(), p.getIP())
+		p.failureReason = utils.FailureNone
+		p.updateSuccess()
+		return true
+	}
+	p.debugf("Peer(%v, %v, %v): PrimaryIp is local, checking it anyway", p.uuid, p.getHostIP(), p.getIP())
+	return false
+}
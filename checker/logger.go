@@ -0,0 +1,34 @@
+package checker
+
+import (
+	"github.com/rancher/log"
+)
+
+// Logger receives the logs of the peers, so that an embedder can hand
+// them to its own logging stack, see Config.Logger. The default one
+// writes to github.com/rancher/log.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// rancherLogger is the default Logger
+type rancherLogger struct{}
+
+func (rancherLogger) Debugf(format string, args ...interface{}) {
+	log.Debugf(format, args...)
+}
+
+func (rancherLogger) Infof(format string, args ...interface{}) {
+	log.Infof(format, args...)
+}
+
+func (rancherLogger) Warnf(format string, args ...interface{}) {
+	log.Warnf(format, args...)
+}
+
+func (rancherLogger) Errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+}
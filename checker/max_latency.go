@@ -0,0 +1,75 @@
+package checker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+// latencyCap returns the latency above which a check of the peer
+// fails, 0 for none, see PeerConfig.MaxLatency. It must be called
+// with the lock held.
+func (p *Peer) latencyCap() time.Duration {
+	if p.maxLatency > 0 {
+		return p.maxLatency
+	}
+	return time.Duration(p.config.MaxLatency) * time.Millisecond
+}
+
+// capLatency turns a successful request of a check slower than the
+// latency cap into a failure with FailureTooSlow, each request of a
+// check being capped on its own. It must be called with the lock
+// held.
+func (p *Peer) capLatency(ok bool, err error, latency time.Duration) (bool, error) {
+	max := p.latencyCap()
+	if !ok || max <= 0 || latency <= max {
+		return ok, err
+	}
+	p.tooSlow++
+	return false, &utils.CheckError{
+		Reason: utils.FailureTooSlow,
+		Err:    fmt.Errorf("answered in %v, more than %v", latency, max),
+	}
+}
+
+// TooSlowChecks returns the number of checks of the peer, or of their
+// requests, see PeerConfig.RequestsPerCheck, which failed only for
+// being slower than its latency cap, see PeerConfig.MaxLatency
+func (p *Peer) TooSlowChecks() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.tooSlow
+}
+
+// SetMaxLatency sets the latency above which a check of the peer
+// fails, 0 reverting to PeerConfig.MaxLatency
+func (p *Peer) SetMaxLatency(d time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	p.maxLatency = d
+}
+
+// SetPeerMaxLatency sets the latency above which a check of the peer
+// with the given uuid fails, see Peer.SetMaxLatency. It's remembered
+// for the peer coming back, 0 clearing it.
+func (pw *PeersWatcher) SetPeerMaxLatency(uuid string, d time.Duration) {
+	log.Infof("PeersWatcher: setting the latency cap of peer %v to %v", uuid, d)
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if pw.maxLatencies == nil {
+		pw.maxLatencies = make(map[string]time.Duration)
+	}
+	if d > 0 {
+		pw.maxLatencies[uuid] = d
+	} else {
+		delete(pw.maxLatencies, uuid)
+	}
+	peers := pw.peersWithUUID(uuid)
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.SetMaxLatency(d)
+	}
+}
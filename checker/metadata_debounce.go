@@ -0,0 +1,47 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// DefaultMetadataDebounce is the default window, in milliseconds,
+// within which the changes of metadata are coalesced
+const DefaultMetadataDebounce = 1000
+
+// MetadataChanged tells the watcher that metadata changed, e.g. from
+// metadata.Client.OnChange, so that the peers are updated without
+// waiting for the next round. The changes coming within
+// Config.MetadataDebounce of each other are applied at once, as
+// during a deploy, at most a CheckInterval after the first one.
+func (pw *PeersWatcher) MetadataChanged() {
+	select {
+	case pw.metadataChanges <- struct{}{}:
+	default:
+		// A change is pending already
+	}
+}
+
+// debounceMetadataChanges waits for the changes of metadata to settle
+// once one came, it returns false when the watcher is stopped
+func (pw *PeersWatcher) debounceMetadataChanges() bool {
+	window := pw.config.MetadataDebounce
+	if window <= 0 {
+		window = DefaultMetadataDebounce
+	}
+	clock := clockOrReal(pw.config.Clock)
+	maxWait := clock.After(pw.checkInterval())
+	for {
+		select {
+		case <-pw.exit:
+			return false
+		case <-pw.metadataChanges:
+			log.Debugf("PeersWatcher: metadata changed again, waiting for it to settle")
+		case <-clock.After(time.Duration(window) * time.Millisecond):
+			return true
+		case <-maxWait:
+			return true
+		}
+	}
+}
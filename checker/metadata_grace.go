@@ -0,0 +1,55 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/go-rancher-metadata/metadata"
+)
+
+// retainMetadata returns the metadata to keep for the peer: when some
+// of it went missing, the last known good is kept for up to
+// MetadataGracePeriod so that a blip of metadata doesn't stop the
+// checks. It must be called with the lock held.
+func (p *Peer) retainMetadata(container, ccContainer *metadata.Container, host *metadata.Host) (*metadata.Container, *metadata.Container, *metadata.Host) {
+	missing := (container == nil && p.container != nil) ||
+		(ccContainer == nil && p.ccContainer != nil) ||
+		(host == nil && p.host != nil)
+	if !missing || p.config.MetadataGracePeriod <= 0 {
+		p.metadataMissingSince = time.Time{}
+		return container, ccContainer, host
+	}
+
+	now := p.now()
+	if p.metadataMissingSince.IsZero() {
+		p.metadataMissingSince = now
+		p.logger.Infof("Peer(%v): metadata missing, checking with the last known for up to %vms", p.uuid, p.config.MetadataGracePeriod)
+	}
+	if now.Sub(p.metadataMissingSince) > time.Duration(p.config.MetadataGracePeriod)*time.Millisecond {
+		p.logger.Infof("Peer(%v): metadata still missing after %vms, no longer checking", p.uuid, p.config.MetadataGracePeriod)
+		return container, ccContainer, host
+	}
+
+	if container == nil {
+		container = p.container
+	}
+	if ccContainer == nil {
+		ccContainer = p.ccContainer
+	}
+	if host == nil {
+		host = p.host
+	}
+	return container, ccContainer, host
+}
+
+// retainMissing informs if the peer, its container missing from
+// metadata, is kept and checked with the last known metadata, i.e.
+// for up to MetadataGracePeriod since it went missing
+func (p *Peer) retainMissing() bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.config.MetadataGracePeriod <= 0 || p.container == nil {
+		return false
+	}
+	container, _, _ := p.retainMetadata(nil, p.ccContainer, p.host)
+	return container != nil
+}
@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// MetricsSink receives the metrics of the checks, decoupling them
+// from the system they're exported to. The peers are identified by
+// their uuid. The calls are made with the lock of the peer held, so
+// they must not block.
+type MetricsSink interface {
+	IncrSuccess(peer string)
+	IncrFailure(peer string, reason utils.FailureReason)
+	ObserveLatency(peer string, latency time.Duration)
+	SetReachable(peer string, reachable bool)
+}
+
+// forgetSink is implemented by the MetricsSinks keeping series by
+// peer, told to drop the ones of a peer once it's removed
+type forgetSink interface {
+	Forget(peer string)
+}
+
+type noopSink struct{}
+
+func (noopSink) IncrSuccess(string)                      {}
+func (noopSink) IncrFailure(string, utils.FailureReason) {}
+func (noopSink) ObserveLatency(string, time.Duration)    {}
+func (noopSink) SetReachable(string, bool)               {}
+
+func (p *Peer) metrics() MetricsSink {
+	if p.config.Metrics == nil {
+		return noopSink{}
+	}
+	return p.config.Metrics
+}
+
+// forgetMetrics drops the series of the removed peer from the
+// MetricsSink, if it keeps them
+func (pw *PeersWatcher) forgetMetrics(uuid string) {
+	if s, ok := pw.config.Metrics.(forgetSink); ok {
+		s.Forget(uuid)
+	}
+}
+
+// recordMetrics hands the result of a check to the MetricsSink,
+// it must be called with the lock held
+func (p *Peer) recordMetrics(ok bool, latency time.Duration) {
+	m := p.metrics()
+	if ok {
+		m.IncrSuccess(p.uuid)
+		m.ObserveLatency(p.uuid, latency)
+	} else {
+		m.IncrFailure(p.uuid, p.failureReason)
+	}
+	m.SetReachable(p.uuid, p.reportedReachable)
+}
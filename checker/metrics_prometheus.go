@@ -0,0 +1,209 @@
+package checker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// PrometheusSink is a MetricsSink keeping the metrics in memory and
+// serving them in the Prometheus text format, it's served on /metrics
+// when set as the sink of the watcher
+type PrometheusSink struct {
+	sync.Mutex
+	successes  map[string]uint64
+	failures   map[failureKey]uint64
+	latencySum map[string]float64
+	latencyNum map[string]uint64
+	reachable  map[string]bool
+	draining   bool
+	churnRate  func() float64
+	maxHost    func() int
+}
+
+type failureKey struct {
+	peer   string
+	reason utils.FailureReason
+}
+
+// NewPrometheusSink returns an empty PrometheusSink
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		successes:  make(map[string]uint64),
+		failures:   make(map[failureKey]uint64),
+		latencySum: make(map[string]float64),
+		latencyNum: make(map[string]uint64),
+		reachable:  make(map[string]bool),
+	}
+}
+
+func (s *PrometheusSink) IncrSuccess(peer string) {
+	s.Lock()
+	defer s.Unlock()
+	s.successes[peer]++
+}
+
+func (s *PrometheusSink) IncrFailure(peer string, reason utils.FailureReason) {
+	s.Lock()
+	defer s.Unlock()
+	s.failures[failureKey{peer: peer, reason: reason}]++
+}
+
+func (s *PrometheusSink) ObserveLatency(peer string, latency time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.latencySum[peer] += latency.Seconds()
+	s.latencyNum[peer]++
+}
+
+func (s *PrometheusSink) SetReachable(peer string, reachable bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.reachable[peer] = reachable
+}
+
+// Forget drops the series of the peer, once removed
+func (s *PrometheusSink) Forget(peer string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.successes, peer)
+	for key := range s.failures {
+		if key.peer == peer {
+			delete(s.failures, key)
+		}
+	}
+	delete(s.latencySum, peer)
+	delete(s.latencyNum, peer)
+	delete(s.reachable, peer)
+}
+
+// SetDraining records whether the node is draining, see
+// PeersWatcher.BeginDrain
+func (s *PrometheusSink) SetDraining(draining bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.draining = draining
+}
+
+// SetChurnRate sets where the churn rate comes from, see
+// PeersWatcher.ChurnRate, it's read on every scrape
+func (s *PrometheusSink) SetChurnRate(rate func() float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.churnRate = rate
+}
+
+// SetMaxHostConcurrency sets where the most checks run at the same
+// time against a host come from, see PeersWatcher.MaxHostConcurrency,
+// it's read on every scrape
+func (s *PrometheusSink) SetMaxHostConcurrency(max func() int) {
+	s.Lock()
+	defer s.Unlock()
+	s.maxHost = max
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP connectivity_check_successes_total Successful checks of the peer.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_successes_total counter\n")
+	for _, peer := range sortedPeers(s.successes) {
+		fmt.Fprintf(w, "connectivity_check_successes_total{peer=%v} %v\n", quoteLabel(peer), s.successes[peer])
+	}
+
+	fmt.Fprintf(w, "# HELP connectivity_check_failures_total Failed checks of the peer, by reason.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_failures_total counter\n")
+	keys := make([]failureKey, 0, len(s.failures))
+	for key := range s.failures {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].peer != keys[j].peer {
+			return keys[i].peer < keys[j].peer
+		}
+		return keys[i].reason < keys[j].reason
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "connectivity_check_failures_total{peer=%v,reason=%v} %v\n",
+			quoteLabel(key.peer), quoteLabel(string(key.reason)), s.failures[key])
+	}
+
+	fmt.Fprintf(w, "# HELP connectivity_check_latency_seconds Latency of the successful checks of the peer.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_latency_seconds summary\n")
+	for _, peer := range sortedPeers(s.latencyNum) {
+		fmt.Fprintf(w, "connectivity_check_latency_seconds_sum{peer=%v} %v\n", quoteLabel(peer), s.latencySum[peer])
+		fmt.Fprintf(w, "connectivity_check_latency_seconds_count{peer=%v} %v\n", quoteLabel(peer), s.latencyNum[peer])
+	}
+
+	fmt.Fprintf(w, "# HELP connectivity_check_reachable Whether the peer is reachable.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_reachable gauge\n")
+	peers := make([]string, 0, len(s.reachable))
+	for peer := range s.reachable {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		v := 0
+		if s.reachable[peer] {
+			v = 1
+		}
+		fmt.Fprintf(w, "connectivity_check_reachable{peer=%v} %v\n", quoteLabel(peer), v)
+	}
+
+	fmt.Fprintf(w, "# HELP connectivity_check_draining Whether the node is draining, its checks stopped.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_draining gauge\n")
+	v := 0
+	if s.draining {
+		v = 1
+	}
+	fmt.Fprintf(w, "connectivity_check_draining %v\n", v)
+
+	fmt.Fprintf(w, "# HELP connectivity_check_open_connections Connections of the checks currently open, including the idle ones.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_open_connections gauge\n")
+	fmt.Fprintf(w, "connectivity_check_open_connections %v\n", utils.OpenConnections())
+
+	total, reused := utils.ConnectionStats()
+	fmt.Fprintf(w, "# HELP connectivity_check_connections_total Connections the HTTP checks went over.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_connections_total counter\n")
+	fmt.Fprintf(w, "connectivity_check_connections_total %v\n", total)
+	fmt.Fprintf(w, "# HELP connectivity_check_connections_reused_total Idle connections reused by the HTTP checks.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_connections_reused_total counter\n")
+	fmt.Fprintf(w, "connectivity_check_connections_reused_total %v\n", reused)
+	fmt.Fprintf(w, "# HELP connectivity_check_connection_reuse_rate Fraction of the HTTP checks which reused an idle connection.\n")
+	fmt.Fprintf(w, "# TYPE connectivity_check_connection_reuse_rate gauge\n")
+	fmt.Fprintf(w, "connectivity_check_connection_reuse_rate %v\n", utils.ConnectionReuseRate())
+
+	if s.churnRate != nil {
+		fmt.Fprintf(w, "# HELP connectivity_check_churn_rate Transitions per minute of all the peers.\n")
+		fmt.Fprintf(w, "# TYPE connectivity_check_churn_rate gauge\n")
+		fmt.Fprintf(w, "connectivity_check_churn_rate %v\n", s.churnRate())
+	}
+	if s.maxHost != nil {
+		fmt.Fprintf(w, "# HELP connectivity_check_max_host_concurrency Most checks run at the same time against a single host.\n")
+		fmt.Fprintf(w, "# TYPE connectivity_check_max_host_concurrency gauge\n")
+		fmt.Fprintf(w, "connectivity_check_max_host_concurrency %v\n", s.maxHost())
+	}
+}
+
+func sortedPeers(m map[string]uint64) []string {
+	peers := make([]string, 0, len(m))
+	for peer := range m {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
@@ -0,0 +1,76 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// StatsdSink is a MetricsSink sending the metrics to a statsd
+// server over UDP, the losses being ignored
+type StatsdSink struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsdSink returns a StatsdSink sending to the statsd server
+// at the given address, the names of the metrics start with the
+// given prefix, if any
+func NewStatsdSink(address, prefix string) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsdSink{conn: conn, prefix: prefix}, nil
+}
+
+func (s *StatsdSink) send(format string, args ...interface{}) {
+	fmt.Fprint(s.conn, s.prefix+fmt.Sprintf(format, args...))
+}
+
+func (s *StatsdSink) IncrSuccess(peer string) {
+	s.send("%v.successes:1|c", statsdName(peer))
+}
+
+func (s *StatsdSink) IncrFailure(peer string, reason utils.FailureReason) {
+	s.send("%v.failures.%v:1|c", statsdName(peer), statsdName(string(reason)))
+}
+
+func (s *StatsdSink) ObserveLatency(peer string, latency time.Duration) {
+	s.send("%v.latency:%v|ms", statsdName(peer), latency.Seconds()*1000)
+}
+
+func (s *StatsdSink) SetReachable(peer string, reachable bool) {
+	v := 0
+	if reachable {
+		v = 1
+	}
+	s.send("%v.reachable:%v|g", statsdName(peer), v)
+}
+
+// SetDraining sends whether the node is draining, see
+// PeersWatcher.BeginDrain
+func (s *StatsdSink) SetDraining(draining bool) {
+	v := 0
+	if draining {
+		v = 1
+	}
+	s.send("draining:%v|g", v)
+}
+
+// Close closes the connection to the statsd server
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
+
+var statsdEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_")
+
+func statsdName(v string) string {
+	return statsdEscaper.Replace(v)
+}
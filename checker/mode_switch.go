@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"github.com/rancher/go-rancher-metadata/metadata"
+	"github.com/rancher/log"
+)
+
+const (
+	// ModeLabel is the label of a container choosing the mode
+	// used to check it, instead of the one of the watcher
+	ModeLabel = "io.rancher.cc.mode"
+)
+
+// labelMode returns the mode set by the ModeLabel of the container,
+// if any and valid
+func labelMode(container *metadata.Container) (string, bool) {
+	if container == nil {
+		return "", false
+	}
+	mode, ok := container.Labels[ModeLabel]
+	if !ok {
+		return "", false
+	}
+	if _, err := getChecker(mode); err != nil {
+		log.Errorf("container %v: ignoring label %v=%v: %v", container.UUID, ModeLabel, mode, err)
+		return "", false
+	}
+	return mode, true
+}
+
+// SetCheckMode changes the mode used to check the peer,
+// it's applied starting from the next check
+func (p *Peer) SetCheckMode(mode string) error {
+	if _, err := getChecker(mode); err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.logger.Infof("Peer(%v): switching check mode from %v to %v", p.uuid, p.mode(), mode)
+	if p.target != nil {
+		p.target.Mode = mode
+	} else {
+		p.config.Mode = mode
+	}
+	return nil
+}
+
+// CheckMode returns the mode used to check the peer
+func (p *Peer) CheckMode() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.mode()
+}
+
+// SetCheckMode changes the mode used to check all the peers found
+// in metadata, including the ones found later on. Fixed targets and
+// the peers choosing their mode through ModeLabel keep their own.
+func (pw *PeersWatcher) SetCheckMode(mode string) error {
+	if _, err := getChecker(mode); err != nil {
+		return err
+	}
+
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	pw.config.Mode = mode
+	peers := make([]*Peer, 0, len(pw.peers))
+	for _, aPeer := range pw.peers {
+		peers = append(peers, aPeer)
+	}
+	pw.Unlock()
+
+	for _, aPeer := range peers {
+		if _, ok := labelMode(aPeer.Container()); ok {
+			continue
+		}
+		if err := aPeer.SetCheckMode(mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
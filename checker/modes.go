@@ -0,0 +1,209 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+const (
+	// ModeHTTP checks a peer by requesting its ping endpoint
+	// and matching the response
+	ModeHTTP = "http"
+
+	// ModeTCP checks a peer by establishing a TCP connection to it
+	ModeTCP = "tcp"
+
+	// ModeUnix checks a peer by requesting its ping endpoint over
+	// a unix socket, for cc containers sharing a socket on the host
+	ModeUnix = "unix"
+
+	// ModeTLS checks a peer by completing a TLS handshake with it,
+	// reporting the expiry of its certificate
+	ModeTLS = "tls"
+
+	// ModeHTTPS checks a peer by requesting its ping endpoint over
+	// TLS, presenting PeerConfig.ClientCertFile to the peers requiring
+	// mutual TLS
+	ModeHTTPS = "https"
+
+	// ModeHTTP3 checks a peer by requesting its ping endpoint over
+	// HTTP/3, through PeerConfig.HTTP3Transport, validating the UDP
+	// path the TCP checks don't. It's only available to the programs
+	// embedding the checker with a QUIC transport, the binary having
+	// none.
+	ModeHTTP3 = "http3"
+
+	// DefaultTLSPort is the port of the peers checked in ModeTLS,
+	// ModeHTTPS and ModeHTTP3 when not specified
+	DefaultTLSPort = 443
+
+	// DefaultCheckPort is the port of the peers used when not specified
+	DefaultCheckPort = 80
+
+	defaultCheckPath = "/ping"
+	expectedResponse = "pong"
+)
+
+// Probe describes a single check to be done by a Checker
+type Probe struct {
+	// Address of the target in host:port form
+	Address string
+	// Path requested by the checks working over HTTP
+	Path string
+	// Expected response of the checks working over HTTP
+	Expected string
+	// Ports dialed on the host of Address in ModePorts, which are
+	// all to be open unless AnyPort is set
+	Ports   []int
+	AnyPort bool
+	// Connections established at the same time in ModeParallel, of
+	// which at least MinConnections, all when 0, are to succeed
+	Connections    int
+	MinConnections int
+	// Relay is the address, in host:port form, of the peer asked to
+	// check Address too, see PeerConfig.RelayAddress
+	Relay   string
+	Options utils.Options
+	// Context is the context of the request, carrying its span when
+	// the checks are traced, see PeerConfig.Tracer. It may be nil.
+	Context context.Context
+}
+
+// Checker is implemented by each of the check modes
+type Checker interface {
+	Check(probe Probe) (bool, error)
+}
+
+// timingChecker is implemented by the Checkers learning when
+// the peer received the request, or how long connecting took
+type timingChecker interface {
+	CheckTiming(probe Probe) (bool, utils.Timing, error)
+}
+
+type httpChecker struct{}
+
+func (c httpChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckTiming(probe)
+	return ok, err
+}
+
+func (httpChecker) CheckTiming(probe Probe) (bool, utils.Timing, error) {
+	url := fmt.Sprintf("http://%v%v", probe.Address, probe.Path)
+	return utils.IsReachableWithTiming(url, probe.Expected, probe.Options)
+}
+
+type httpsChecker struct{}
+
+func (c httpsChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckTiming(probe)
+	return ok, err
+}
+
+func (httpsChecker) CheckTiming(probe Probe) (bool, utils.Timing, error) {
+	url := fmt.Sprintf("https://%v%v", probe.Address, probe.Path)
+	return utils.IsReachableWithTiming(url, probe.Expected, probe.Options)
+}
+
+type tcpChecker struct{}
+
+func (c tcpChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckTiming(probe)
+	return ok, err
+}
+
+func (tcpChecker) CheckTiming(probe Probe) (bool, utils.Timing, error) {
+	return utils.IsTCPReachableWithTiming(probe.Address, probe.Options)
+}
+
+type http3Checker struct{}
+
+func (http3Checker) Check(probe Probe) (bool, error) {
+	if probe.Options.HTTP3Transport == nil {
+		return false, &utils.CheckError{
+			Reason: utils.FailureOther,
+			Err:    fmt.Errorf("no HTTP/3 transport configured for mode %v", ModeHTTP3),
+		}
+	}
+	url := fmt.Sprintf("https://%v%v", probe.Address, probe.Path)
+	return utils.IsReachableWithOptions(url, probe.Expected, probe.Options)
+}
+
+type unixChecker struct{}
+
+func (unixChecker) Check(probe Probe) (bool, error) {
+	if probe.Options.SocketPath == "" {
+		return false, &utils.CheckError{
+			Reason: utils.FailureOther,
+			Err:    fmt.Errorf("no socket path configured for mode %v", ModeUnix),
+		}
+	}
+	url := fmt.Sprintf("http://unix%v", probe.Path)
+	return utils.IsReachableWithOptions(url, probe.Expected, probe.Options)
+}
+
+// certChecker is implemented by the Checkers learning the
+// expiry of the certificate of the peer
+type certChecker interface {
+	CheckCert(probe Probe) (bool, time.Time, error)
+}
+
+type tlsChecker struct{}
+
+func (c tlsChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckCert(probe)
+	return ok, err
+}
+
+func (tlsChecker) CheckCert(probe Probe) (bool, time.Time, error) {
+	return utils.IsTLSReachable(probe.Address, probe.Options)
+}
+
+var checkers = map[string]Checker{
+	ModeHTTP:  httpChecker{},
+	ModeHTTPS: httpsChecker{},
+	ModeTCP:   tcpChecker{},
+	ModeUnix:  unixChecker{},
+	ModeTLS:   tlsChecker{},
+	ModeHTTP3: http3Checker{},
+
+	ModePorts:    tcpPortsChecker{},
+	ModeParallel: tcpParallelChecker{},
+}
+
+// checkersMu guards checkers against RegisterChecker
+var checkersMu sync.RWMutex
+
+// RegisterChecker makes the Checker available as the given mode, for
+// PeerConfig.Mode, ModeLabel or a Target to name, so that the peers
+// can be checked with a protocol of the embedder. It's to be called at
+// startup, before the watcher using it is created. It fails for an
+// empty mode or one already taken, the built-in ones included.
+func RegisterChecker(mode string, c Checker) error {
+	if mode == "" || c == nil {
+		return fmt.Errorf("a check mode needs a name and a Checker")
+	}
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	if _, found := checkers[mode]; found {
+		return fmt.Errorf("check mode %v already registered", mode)
+	}
+	checkers[mode] = c
+	return nil
+}
+
+func getChecker(mode string) (Checker, error) {
+	if mode == "" {
+		mode = ModeHTTP
+	}
+	checkersMu.RLock()
+	defer checkersMu.RUnlock()
+	c, ok := checkers[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown check mode: %v, neither built in nor registered", mode)
+	}
+	return c, nil
+}
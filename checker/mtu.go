@@ -0,0 +1,68 @@
+package checker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// payloadPath serves a response of the size given by the size
+	// query parameter, for the probes telling MTU issues apart
+	payloadPath = "/payload"
+
+	// maxPayloadSize bounds the size of the responses of payloadPath
+	maxPayloadSize = 65536
+
+	payloadByte = "x"
+)
+
+// payloadHandler responds with as many bytes as asked for
+func (s *Server) payloadHandler(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size < 0 || size > maxPayloadSize {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(strings.Repeat(payloadByte, size)))
+}
+
+// checkMTU probes a peer whose check just failed with a tiny and a
+// large response: only the large one failing is the sign of an MTU
+// or fragmentation issue on the path. Fixed targets aren't probed,
+// not serving the responses. It must be called with the lock held.
+func (p *Peer) checkMTU(checker Checker, probe Probe) {
+	if p.config.MTUProbeSize <= 0 || p.target != nil || p.mode() != ModeHTTP {
+		return
+	}
+
+	size := p.config.MTUProbeSize
+	if size > maxPayloadSize {
+		size = maxPayloadSize
+	}
+	probe.Options.Nonce = ""
+	tiny, large := probe, probe
+	tiny.Path, tiny.Expected = fmt.Sprintf("%v?size=1", payloadPath), payloadByte
+	large.Path, large.Expected = fmt.Sprintf("%v?size=%v", payloadPath, size), strings.Repeat(payloadByte, size)
+
+	release := p.limiter.acquire(p.getHostIP())
+	tinyOK, _ := checker.Check(tiny)
+	largeOK, err := checker.Check(large)
+	release()
+
+	suspected := tinyOK && !largeOK
+	if suspected && !p.suspectedMTU {
+		p.logger.Errorf("Peer(%v, %v, %v): responses of %v bytes fail (%v) while tiny ones get through, likely an MTU issue",
+			p.uuid, p.getHostIP(), p.getIP(), size, err)
+	}
+	p.suspectedMTU = suspected
+}
+
+// SuspectedMTUIssue informs if, when its check last failed, the peer
+// answered tiny probes but not large ones, see PeerConfig.MTUProbeSize
+func (p *Peer) SuspectedMTUIssue() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.suspectedMTU
+}
@@ -0,0 +1,61 @@
+package checker
+
+import (
+	"net"
+)
+
+// onLinkSubnet informs if ip is on the subnet of one of the interfaces
+// of this host, where it's a layer 2 neighbor
+func onLinkSubnet(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNeighbor looks up the peer in the neighbor table of this host
+// after it was probed, when PeerConfig.NeighborCheck is set and it's
+// on the same subnet, the off subnet peers being skipped. It must be
+// called with the lock held.
+func (p *Peer) checkNeighbor() {
+	p.neighborChecked = false
+	if !p.config.NeighborCheck || p.target != nil {
+		return
+	}
+	ip := net.ParseIP(p.getIP())
+	if ip == nil || !onLinkSubnet(ip) {
+		return
+	}
+	reachable, err := neighborReachable(ip)
+	if err != nil {
+		p.debugf("Peer(%v): neighbor check: %v", p.uuid, err)
+		return
+	}
+	if p.neighborRecorded && reachable != p.neighborReachable {
+		if reachable {
+			p.logger.Infof("Peer(%v, %v, %v): neighbor became reachable", p.uuid, p.getHostIP(), p.getIP())
+		} else {
+			p.logger.Errorf("Peer(%v, %v, %v): neighbor became unreachable", p.uuid, p.getHostIP(), p.getIP())
+		}
+	}
+	p.neighborChecked = true
+	p.neighborRecorded = true
+	p.neighborReachable = reachable
+}
+
+// NeighborReachable informs if the peer had a resolved entry in the
+// neighbor table of this host on its last check, a layer 2 signal
+// apart from the probe, see PeerConfig.NeighborCheck. The second value
+// is false when it wasn't checked, e.g. the peer isn't on the subnet
+// or the system isn't supported.
+func (p *Peer) NeighborReachable() (bool, bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.neighborReachable, p.neighborChecked
+}
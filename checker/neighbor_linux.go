@@ -0,0 +1,39 @@
+package checker
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	procNetARP = "/proc/net/arp"
+	// atfCom is the flag of a complete entry of the ARP table
+	atfCom = 0x2
+)
+
+// neighborReachable reads the ARP table for a complete entry of ip,
+// the IPv6 neighbors being left out
+func neighborReachable(ip net.IP) (bool, error) {
+	f, err := os.Open(procNetARP)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !ip.Equal(net.ParseIP(fields[0])) {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil {
+			return false, err
+		}
+		return flags&atfCom != 0, nil
+	}
+	return false, scanner.Err()
+}
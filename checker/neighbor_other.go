@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package checker
+
+import (
+	"errors"
+	"net"
+)
+
+func neighborReachable(ip net.IP) (bool, error) {
+	return false, errors.New("reading the neighbor table is only supported on linux")
+}
@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// updateNetworkHealth moves the overall health of the network of the
+// node through its hysteresis: it becomes unhealthy once the fraction
+// of reachable peers stayed below UnhealthyFraction for
+// NetworkHealthDelay, and healthy again once it stayed at or above
+// HealthyFraction as long. It must be called with the lock held.
+func (pw *PeersWatcher) updateNetworkHealth() {
+	if pw.config.HealthyFraction <= 0 && pw.config.UnhealthyFraction <= 0 {
+		return
+	}
+	// The statuses published by the checks in flight are used, the
+	// lock being held
+	statuses := make([]PeerStatus, 0, len(pw.peers))
+	for _, aPeer := range pw.peers {
+		statuses = append(statuses, aPeer.lastStatus())
+	}
+	fraction, considered := reachableFraction(statuses)
+	if considered == 0 {
+		// Nothing tells about the network, it's left as it is
+		pw.healthCrossingSince = time.Time{}
+		return
+	}
+
+	crossing := fraction >= pw.config.HealthyFraction
+	if !pw.networkUnhealthy {
+		crossing = fraction < pw.config.UnhealthyFraction
+	}
+	now := clockOrReal(pw.config.Clock).Now()
+	if !crossing {
+		pw.healthCrossingSince = time.Time{}
+		return
+	}
+	if pw.healthCrossingSince.IsZero() {
+		pw.healthCrossingSince = now
+	}
+	if now.Sub(pw.healthCrossingSince) < time.Duration(pw.config.NetworkHealthDelay)*time.Millisecond {
+		return
+	}
+
+	pw.healthCrossingSince = time.Time{}
+	pw.networkUnhealthy = !pw.networkUnhealthy
+	healthy := !pw.networkUnhealthy
+	if healthy {
+		log.Infof("PeersWatcher: network became healthy, %.0f%% of the peers reachable", fraction*100)
+	} else {
+		log.Errorf("PeersWatcher: network became unhealthy, %.0f%% of the peers reachable", fraction*100)
+	}
+	if hook := pw.config.OnNetworkHealthChange; hook != nil {
+		pw.queueEvent(func() { hook(healthy) })
+	}
+}
+
+// NetworkHealthy returns the overall health of the network of the
+// node, which unlike Ok doesn't flap along with a few peers, see
+// Config.HealthyFraction. Like Ok, it's false while the canary is
+// unreachable, see CanaryHealthy.
+func (pw *PeersWatcher) NetworkHealthy() bool {
+	pw.Lock()
+	defer pw.Unlock()
+	return !pw.networkUnhealthy && pw.canaryHealthy()
+}
+
+// queueEvent records a notification to be delivered by fireEvents,
+// it must be called with the lock held
+func (pw *PeersWatcher) queueEvent(event func()) {
+	pw.pendingEvents = append(pw.pendingEvents, event)
+}
+
+// fireEvents delivers the recorded notifications, it must be
+// called without the lock held so they can use the watcher
+func (pw *PeersWatcher) fireEvents() {
+	pw.Lock()
+	pending := pw.pendingEvents
+	pw.pendingEvents = nil
+	pw.Unlock()
+
+	for _, event := range pending {
+		event()
+	}
+}
@@ -0,0 +1,203 @@
+package checker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DeliveryDropOldest makes a notification to a subscriber not
+	// keeping up replace the oldest one it didn't receive yet
+	DeliveryDropOldest = "drop-oldest"
+	// DeliveryDropNewest makes a notification to a subscriber not
+	// keeping up be dropped
+	DeliveryDropNewest = "drop-newest"
+	// DeliveryBlock makes a notification to a subscriber not keeping
+	// up wait for it, up to PeerConfig.NotifyTimeout, before being
+	// dropped
+	DeliveryBlock = "block"
+
+	// DefaultNotifyTimeout is the default time, in milliseconds, a
+	// notification waits for a subscriber with DeliveryBlock
+	DefaultNotifyTimeout = 1000
+)
+
+// subscriber is a channel returned by Notify, closed once unsubscribed
+type subscriber struct {
+	sync.Mutex
+	ch     chan bool
+	closed bool
+}
+
+// Notify returns a channel receiving true when the peer becomes
+// reachable and false when it becomes unreachable, along with a
+// function to unsubscribe. A subscriber not keeping up never stalls
+// the checks for long, its notifications are dropped according to
+// PeerConfig.NotifyPolicy, see DroppedNotifications.
+func (p *Peer) Notify() (<-chan bool, func()) {
+	p.Lock()
+	defer p.Unlock()
+	if p.subscribers == nil {
+		p.subscribers = make(map[int]*subscriber)
+	}
+	id := p.nextSubscriberID
+	p.nextSubscriberID++
+	size := p.config.NotifyBuffer
+	if size <= 0 {
+		size = 1
+	}
+	sub := &subscriber{ch: make(chan bool, size)}
+	p.subscribers[id] = sub
+
+	unsubscribe := func() {
+		p.Lock()
+		_, ok := p.subscribers[id]
+		delete(p.subscribers, id)
+		p.Unlock()
+		if ok {
+			sub.Lock()
+			defer sub.Unlock()
+			sub.closed = true
+			close(sub.ch)
+		}
+	}
+	return sub.ch, unsubscribe
+}
+
+// deliver sends a notification to the subscriber according to the
+// policy, it returns false when the notification, or an older one
+// it replaced, was dropped
+func (s *subscriber) deliver(reachable bool, policy string, timeout time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.ch <- reachable:
+		return true
+	default:
+	}
+	switch policy {
+	case DeliveryDropNewest:
+		return false
+	case DeliveryBlock:
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- reachable:
+			return true
+		case <-timer.C:
+			return false
+		}
+	default:
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- reachable:
+		default:
+		}
+		return false
+	}
+}
+
+// notifySubscribers delivers a transition to all the subscribers, it
+// must be called without the lock held, a blocking delivery holding it
+// would stall the peer
+func (p *Peer) notifySubscribers(reachable bool) {
+	p.Lock()
+	subs := make([]*subscriber, 0, len(p.subscribers))
+	for _, sub := range p.subscribers {
+		subs = append(subs, sub)
+	}
+	policy := p.config.NotifyPolicy
+	timeout := time.Duration(p.config.NotifyTimeout) * time.Millisecond
+	p.Unlock()
+	if timeout <= 0 {
+		timeout = DefaultNotifyTimeout * time.Millisecond
+	}
+
+	for _, sub := range subs {
+		if !sub.deliver(reachable, policy, timeout) {
+			atomic.AddUint64(&p.droppedNotifications, 1)
+		}
+	}
+}
+
+// DroppedNotifications returns the number of notifications of the
+// transitions of the peer dropped because a subscriber didn't keep up
+func (p *Peer) DroppedNotifications() uint64 {
+	return atomic.LoadUint64(&p.droppedNotifications)
+}
+
+// DroppedNotifications returns the number of notifications of the
+// transitions of all the peers, including the removed ones, dropped
+// because a subscriber didn't keep up
+func (pw *PeersWatcher) DroppedNotifications() uint64 {
+	pw.Lock()
+	defer pw.Unlock()
+	dropped := pw.droppedByRemoved
+	for _, aPeer := range pw.allPeers() {
+		dropped += aPeer.DroppedNotifications()
+	}
+	return dropped
+}
+
+// transition logs and records a change of reachability, it
+// must be called with the lock held
+func (p *Peer) transition(reachable bool) {
+	l := p.logger.forTransitions()
+	if p.isQuarantined() {
+		p.debugf("Peer(%v, %v, %v): quarantined, not reporting reachable=%v", p.uuid, p.getHostIP(), p.getIP(), reachable)
+		return
+	}
+	if p.isSettling() {
+		p.debugf("Peer(%v, %v, %v): settling, not reporting reachable=%v yet", p.uuid, p.getHostIP(), p.getIP(), reachable)
+		return
+	}
+	if reachable {
+		l.Infof("Peer(%v, %v, %v): became reachable", p.uuid, p.getHostIP(), p.getIP())
+	} else {
+		l.Errorf("Peer(%v, %v, %v): became unreachable (reason: %v)", p.uuid, p.getHostIP(), p.getIP(), p.failureReason)
+	}
+	hook, onTransition := p.config.OnStateChange, p.onTransition
+	// The event is of the transition, the peer may have changed again
+	// by the time it's delivered
+	status := PeerStatus{UUID: p.uuid, Reachable: reachable}
+	if !reachable {
+		status.FailureReason = p.failureReason
+	}
+	event := newEvent(status, p.now())
+	p.queueEvent(func() {
+		if hook != nil {
+			hook(p, reachable)
+		}
+		if onTransition != nil {
+			onTransition(p, event)
+		}
+		p.notifySubscribers(reachable)
+	})
+}
+
+// queueEvent records a notification to be delivered by fireEvents,
+// it must be called with the lock held
+func (p *Peer) queueEvent(event func()) {
+	p.pendingEvents = append(p.pendingEvents, event)
+}
+
+// fireEvents delivers the recorded notifications to the hooks and
+// the subscribers, it must be called without the lock held so they
+// can use the peer
+func (p *Peer) fireEvents() {
+	p.Lock()
+	pending := p.pendingEvents
+	p.pendingEvents = nil
+	p.Unlock()
+
+	for _, event := range pending {
+		event()
+	}
+}
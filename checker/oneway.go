@@ -0,0 +1,45 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// recordOneWayLatencies splits the round trip of a successful check
+// into its forward and return paths, using when the peer received the
+// request by its own clock. The split is only as accurate as the
+// clocks of the nodes are in sync. When the peer didn't report when
+// it received the request only the round trip is known. It must be
+// called with the lock held.
+func (p *Peer) recordOneWayLatencies(timing utils.Timing) {
+	if timing.PeerReceived.IsZero() || timing.WroteRequest.IsZero() || timing.FirstByte.IsZero() {
+		p.forwardLatency, p.returnLatency = 0, 0
+		return
+	}
+	rtt := timing.FirstByte.Sub(timing.WroteRequest)
+	forward := timing.PeerReceived.Sub(timing.WroteRequest)
+	if forward < 0 {
+		forward = 0
+	}
+	if forward > rtt {
+		forward = rtt
+	}
+	p.forwardLatency, p.returnLatency = forward, rtt-forward
+}
+
+// ForwardLatency returns the estimated latency from this node to the
+// peer on the last successful check, 0 when unknown
+func (p *Peer) ForwardLatency() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.forwardLatency
+}
+
+// ReturnLatency returns the estimated latency from the peer back to
+// this node on the last successful check, 0 when unknown
+func (p *Peer) ReturnLatency() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.returnLatency
+}
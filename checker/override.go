@@ -0,0 +1,202 @@
+package checker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// OverrideConfig holds the check parameters overridden by OverrideFor,
+// in milliseconds, the ones left to 0 keeping their baseline
+type OverrideConfig struct {
+	CheckInterval     int `json:"checkInterval,omitempty"`
+	ConnectionTimeout int `json:"connectionTimeout,omitempty"`
+	ConnectTimeout    int `json:"connectTimeout,omitempty"`
+	ReadTimeout       int `json:"readTimeout,omitempty"`
+}
+
+func overridable(c PeerConfig) OverrideConfig {
+	return OverrideConfig{
+		CheckInterval:     c.CheckInterval,
+		ConnectionTimeout: c.ConnectionTimeout,
+		ConnectTimeout:    c.ConnectTimeout,
+		ReadTimeout:       c.ReadTimeout,
+	}
+}
+
+// applyTo sets the overridden parameters in c
+func (o OverrideConfig) applyTo(c *PeerConfig) {
+	if o.CheckInterval > 0 {
+		c.CheckInterval = o.CheckInterval
+	}
+	if o.ConnectionTimeout > 0 {
+		c.ConnectionTimeout = o.ConnectionTimeout
+	}
+	if o.ConnectTimeout > 0 {
+		c.ConnectTimeout = o.ConnectTimeout
+	}
+	if o.ReadTimeout > 0 {
+		c.ReadTimeout = o.ReadTimeout
+	}
+}
+
+// validate checks that the timeouts, once overridden in c, are still
+// less than the interval between the checks
+func (o OverrideConfig) validate(c PeerConfig) error {
+	o.applyTo(&c)
+	if err := c.validateTimeouts(); err != nil {
+		return err
+	}
+	min := c.minCheckInterval()
+	if c.ConnectTimeout >= min || c.ReadTimeout >= min {
+		return fmt.Errorf("connect timeout %vms or read timeout %vms isn't less than the check interval %vms, %vms once accounted for the jitter",
+			c.ConnectTimeout, c.ReadTimeout, c.CheckInterval, min)
+	}
+	return nil
+}
+
+// restore sets back all the parameters in c to the baseline o
+func (o OverrideConfig) restore(c *PeerConfig) {
+	c.CheckInterval = o.CheckInterval
+	c.ConnectionTimeout = o.ConnectionTimeout
+	c.ConnectTimeout = o.ConnectTimeout
+	c.ReadTimeout = o.ReadTimeout
+}
+
+// applyOverride overrides the check parameters of the peer until the
+// given time, an override in place being replaced
+func (p *Peer) applyOverride(o OverrideConfig, until time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	if p.overrideBaseline == nil {
+		baseline := overridable(p.config)
+		p.overrideBaseline = &baseline
+	} else {
+		p.overrideBaseline.restore(&p.config)
+	}
+	o.applyTo(&p.config)
+	p.overrideUntil = until
+}
+
+// revertOverride sets back the check parameters of the peer to their
+// baseline
+func (p *Peer) revertOverride() {
+	p.Lock()
+	defer p.Unlock()
+	if p.overrideBaseline == nil {
+		return
+	}
+	p.overrideBaseline.restore(&p.config)
+	p.overrideBaseline = nil
+	p.overrideUntil = time.Time{}
+}
+
+// overrideRemaining returns how long the override of the check
+// parameters lasts, 0 when there is none. It must be called with the
+// lock held.
+func (p *Peer) overrideRemaining() time.Duration {
+	if p.overrideUntil.IsZero() {
+		return 0
+	}
+	if remaining := p.overrideUntil.Sub(p.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// peerConfig returns the PeerConfig of the peers, with the override
+// in place if any. It must be called with the lock held.
+func (pw *PeersWatcher) peerConfig() PeerConfig {
+	config := pw.config.PeerConfig
+	if pw.override != nil {
+		pw.override.applyTo(&config)
+	}
+	return config
+}
+
+// checkInterval returns the interval between the rounds of checks of
+// the watcher, the one of the peers with the override in place if any
+func (pw *PeersWatcher) checkInterval() time.Duration {
+	pw.Lock()
+	defer pw.Unlock()
+	return time.Duration(pw.peerConfig().CheckInterval) * time.Millisecond
+}
+
+// OverrideFor overrides the check parameters of all the peers, e.g.
+// with tighter intervals and timeouts during an incident, reverting
+// them to their baseline once d elapsed so that they aren't forgotten.
+// An override in place is replaced, the peers found meanwhile get it
+// too. It fails when the timeouts overridden aren't less than the
+// interval.
+func (pw *PeersWatcher) OverrideFor(d time.Duration, o OverrideConfig) error {
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if err := o.validate(pw.config.PeerConfig); err != nil {
+		pw.Unlock()
+		return err
+	}
+	log.Infof("PeersWatcher: overriding the check parameters with %+v for %v", o, d)
+	clock := clockOrReal(pw.config.Clock)
+	until := clock.Now().Add(d)
+	pw.override = &o
+	pw.overrideUntil = until
+	pw.overrideGeneration++
+	generation := pw.overrideGeneration
+	peers := pw.allPeers()
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.applyOverride(o, until)
+	}
+
+	pw.lifecycle.goRun("override revert", func() {
+		select {
+		case <-pw.exit:
+		case <-clock.After(d):
+			pw.revertOverride(generation)
+		}
+	})
+	return nil
+}
+
+// RevertOverride sets back the check parameters overridden by
+// OverrideFor to their baseline right away
+func (pw *PeersWatcher) RevertOverride() {
+	pw.Lock()
+	generation := pw.overrideGeneration
+	pw.Unlock()
+	pw.revertOverride(generation)
+}
+
+// revertOverride reverts the override of the given generation, unless
+// it was replaced since
+func (pw *PeersWatcher) revertOverride(generation int) {
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if pw.override == nil || generation != pw.overrideGeneration {
+		pw.Unlock()
+		return
+	}
+	log.Infof("PeersWatcher: reverting the check parameters to %+v", overridable(pw.config.PeerConfig))
+	pw.override = nil
+	pw.overrideUntil = time.Time{}
+	peers := pw.allPeers()
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.revertOverride()
+	}
+}
+
+// ActiveOverride returns the check parameters overridden by
+// OverrideFor and how long the override lasts, nil when there is none
+func (pw *PeersWatcher) ActiveOverride() (*OverrideConfig, time.Duration) {
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.override == nil {
+		return nil, 0
+	}
+	o := *pw.override
+	return &o, pw.overrideUntil.Sub(clockOrReal(pw.config.Clock).Now())
+}
@@ -0,0 +1,78 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+const (
+	// ModeParallel checks a peer by establishing
+	// PeerConfig.ParallelConnections TCP connections to it at the same
+	// time, surfacing the limits of conntrack, of the NAT tables or of
+	// the connection rate that a single connection doesn't hit
+	ModeParallel = "parallel"
+
+	// DefaultParallelConnections is the default number of connections
+	// established at the same time in ModeParallel
+	DefaultParallelConnections = 10
+)
+
+// parallelChecker is implemented by the Checkers telling how many
+// of the connections established at the same time succeeded
+type parallelChecker interface {
+	CheckParallel(probe Probe) (bool, int, error)
+}
+
+type tcpParallelChecker struct{}
+
+func (c tcpParallelChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckParallel(probe)
+	return ok, err
+}
+
+// CheckParallel succeeds when at least MinConnections of the
+// Connections of the probe could be established, all of them when
+// MinConnections is 0
+func (tcpParallelChecker) CheckParallel(probe Probe) (bool, int, error) {
+	n := probe.Connections
+	if n <= 0 {
+		n = DefaultParallelConnections
+	}
+	min := probe.MinConnections
+	if min <= 0 || min > n {
+		min = n
+	}
+	succeeded, err := utils.ConnectParallel(probe.Address, n, probe.Options)
+	if succeeded >= min {
+		return true, succeeded, nil
+	}
+	return false, succeeded, &utils.CheckError{
+		Reason: utils.ReasonOf(err),
+		Err:    fmt.Errorf("%v of %v connections established, %v needed: %v", succeeded, n, min, err),
+	}
+}
+
+// parallelConnections returns the number of connections established
+// at the same time in ModeParallel, within the overall bound of
+// concurrent checks so that they don't exhaust the cluster themselves.
+// It must be called with the lock held.
+func (p *Peer) parallelConnections() int {
+	n := p.config.ParallelConnections
+	if n <= 0 {
+		n = DefaultParallelConnections
+	}
+	if allowed := p.limiter.Allowed(); allowed > 0 && n > allowed {
+		n = allowed
+	}
+	return n
+}
+
+// ParallelSuccesses returns how many of the connections established at
+// the same time succeeded on the last check of a peer checked in
+// ModeParallel
+func (p *Peer) ParallelSuccesses() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.parallelSuccesses
+}
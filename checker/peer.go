@@ -1,151 +1,915 @@
 package checker
 
 import (
-	"fmt"
+	"context"
 	"math/rand"
+	"net"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/rancher/connectivity-check/utils"
 	"github.com/rancher/go-rancher-metadata/metadata"
-	"github.com/rancher/log"
 )
 
+// maxCount is the count of a peer whose last checks succeeded, it
+// goes down on a failure and the peer is down at 0
+const maxCount = 3
+
 // Peer is used to hold information about remote containers in
 // the same service
 type Peer struct {
 	sync.Mutex
-	uuid              string
-	host              *metadata.Host
-	container         *metadata.Container
-	ccContainer       *metadata.Container
-	exit              chan bool
-	count             int
-	random            *rand.Rand
-	checkInterval     int
-	connectionTimeout int
-	lastChecked       time.Time
+	// droppedNotifications and stuckDeadline are first to be 64-bit
+	// aligned for the atomic operations
+	droppedNotifications uint64
+	// stuckDeadline is when the peer is stuck unless checked again, in
+	// nanoseconds since the epoch, 0 when it can't be, see updateStuck
+	stuckDeadline int64
+	// stuck is set, atomically, while the peer is stuck
+	stuck int32
+
+	uuid         string
+	host         *metadata.Host
+	container    *metadata.Container
+	ccContainer  *metadata.Container
+	target       *Target
+	exit         chan bool
+	done         chan struct{}
+	shutdownOnce sync.Once
+	// published is the status of the peer as a check started, read
+	// while the check holds the lock, see lastStatus
+	publishedMu sync.Mutex
+	published   PeerStatus
+	count       int
+	// consecutiveSuccesses and reportedReachable tell when to
+	// report the peer reachable, see PeerConfig.RecoverySuccesses
+	consecutiveSuccesses int
+	// consecutiveFailures is the number of checks failed in a row
+	consecutiveFailures int
+	window              []bool
+	windowNext          int
+	reportedReachable   bool
+	random              *rand.Rand
+	config              PeerConfig
+	limiter             *limiter
+	breakers            *breakers
+	logger              *asyncLogger
+	schedule            *schedule
+	history             *historyWriter
+	flows               *flowExporter
+	lastChecked         time.Time
+	failureReason       utils.FailureReason
+	// downSince is set when the peer becomes unreachable
+	// and cleared once it recovers
+	downSince          time.Time
+	lastRecoveredAt    time.Time
+	quarantinedUntil   time.Time
+	settlingUntil      time.Time
+	debug              bool
+	downCause          string
+	disabled           bool
+	unselected         bool
+	draining           bool
+	dnsMismatch        bool
+	resolution         *nameResolution
+	hostReachable      bool
+	suspectedMTU       bool
+	certNotAfter       time.Time
+	forwardLatency     time.Duration
+	returnLatency      time.Duration
+	lastAddress        string
+	reverseDNS         string
+	reverseDNSIP       string
+	reverseDNSAt       time.Time
+	reverseDNSPending  bool
+	considered         bool
+	hostLatency        time.Duration
+	startedAt          time.Time
+	restarts           int
+	retryTokens        float64
+	retryRefilledAt    time.Time
+	seed               int64
+	diagnosticValue    float64
+	hasDiagnosticValue bool
+	injectedUntil      time.Time
+	portResults        map[int]bool
+	relayResult        *RelayResult
+	hourly             *hourlyRates
+	maxLatency         time.Duration
+	tooSlow            uint64
+	clientCert         *utils.ClientCertificate
+	dialFuncID         uint64
+	// checker, when set, checks the peer instead of the Checker of its
+	// mode, so that the tests don't register theirs
+	checker Checker
+	// lifecycle tracks the background goroutines of the checks, see
+	// goRun
+	lifecycle           *lifecycle
+	metadataUpdatedAt   time.Time
+	lastStaleCheck      time.Time
+	staleSkips          uint64
+	uptimeSince         time.Time
+	uptime              time.Duration
+	downtime            time.Duration
+	lastLoopCheck       time.Time
+	actualInterval      time.Duration
+	probeCache          *probeCache
+	parallelSuccesses   int
+	requestSuccessRatio *float64
+	vip                 string
+	localAddresses      map[string]bool
+	vipChecked          bool
+	vipReachable        bool
+	vipLatency          time.Duration
+	directReachable     bool
+	neighborChecked     bool
+	neighborRecorded    bool
+	neighborReachable   bool
+	correlationID       string
+	live                bool
+	ready               bool
+	sourceIndex         int
+	lastSource          string
+	lastLocalAddress    string
+	lastBodyBytes       int64
+	sourceResults       map[string]bool
+	sourcePortIndex     int
+	schemaVersion       int
+	replicaStatus       *PeerStatus
+	userData            interface{}
+	reasonStreaks       map[utils.FailureReason]int
+	ignoredReasons      map[utils.FailureReason]bool
+	// metadataMissingSince is set while the last known good
+	// metadata is retained
+	metadataMissingSince time.Time
+
+	lastLatency     time.Duration
+	lastConnectTime time.Duration
+	// connsUsed counts the connections the checks went over, connsReused
+	// the idle ones kept from a previous check among them
+	connsUsed       uint64
+	connsReused     uint64
+	baselineLatency time.Duration
+	warmups         int
+	// adaptiveInterval, when not 0, replaces CheckInterval while
+	// the peer is steadily reachable
+	adaptiveInterval time.Duration
+	overrideBaseline *OverrideConfig
+	overrideUntil    time.Time
+	avgLatency       time.Duration
+	latencyVariance  float64
+	lossRate         float64
+	// bursting is set from a burst being queued until it's over
+	bursting bool
+	// phase is added once to the wait for the next check, see
+	// PeersWatcher.spreadProbes
+	phase            time.Duration
+	attempts         uint64
+	successes        uint64
+	lastHealthClass  string
+	unsaturatedSince time.Time
+
+	pendingEvents []func()
+	// onTransition, set by the watcher, is called with the event of
+	// every transition reported
+	onTransition     func(peer *Peer, event Event)
+	subscribers      map[int]*subscriber
+	nextSubscriberID int
 }
 
 func (p *Peer) setupRandom() {
-	n := time.Now().UTC().UnixNano()
-	if p.host != nil {
-		numFromIPStr := strings.Replace(p.host.AgentIP, ".", "", -1)
-		numFromIP, err := strconv.ParseInt(numFromIPStr, 10, 64)
-		if err != nil {
-			log.Errorf("Peer(%v) couldn't convert to int: %v", p.uuid, numFromIPStr)
-		}
-		n = numFromIP
+	if p.random != nil {
+		return
+	}
+	p.seedRandom(p.deriveSeed())
+}
+
+func (p *Peer) seedRandom(seed int64) {
+	p.seed = seed
+	p.random = rand.New(rand.NewSource(seed))
+}
+
+// Update refreshes the metadata the peer is checked from. The
+// target of the checks is read from it on every check, so a new
+// PrimaryIp is picked up by the next one. Missing metadata may be
+// ignored for a while, see PeerConfig.MetadataGracePeriod.
+func (p *Peer) Update(container, ccContainer *metadata.Container, host *metadata.Host) {
+	p.Lock()
+	defer p.Unlock()
+	container, ccContainer, host = p.retainMetadata(container, ccContainer, host)
+	if p.containerChanged(container) {
+		p.metadataUpdatedAt = p.now()
+	}
+	if p.metadataChanged(container, ccContainer, host) {
+		p.resetInterval()
 	}
-	rs := rand.NewSource(n)
-	p.random = rand.New(rs)
+	if p.container != nil && container != nil && p.container.PrimaryIp != container.PrimaryIp {
+		p.logger.Infof("Peer(%v): IP changed from %v to %v", p.uuid, p.container.PrimaryIp, container.PrimaryIp)
+	}
+	p.container = container
+	p.ccContainer = ccContainer
+	p.host = host
+	p.updateConsidered(p.consider())
 }
 
 // Start is used to start the checker for a peer
 func (p *Peer) Start() error {
 	p.setupRandom()
+	p.startedAt = p.now()
+	p.done = make(chan struct{})
 	go p.Run()
 	return nil
 }
 
+// Wait blocks until the checks of a started peer have stopped
+func (p *Peer) Wait() {
+	if p.done != nil {
+		<-p.done
+	}
+}
+
 func (p *Peer) getHostCheckSleepDuration() time.Duration {
-	r := p.checkInterval - p.random.Intn(1000)
+	r := p.baseInterval()
+	// A peer whose latency calls for more attention wakes up sooner
+	if p.latencyIntervalEnabled() {
+		if interval := int(p.checkIntervalDuration() / time.Millisecond); interval < r {
+			r = interval
+		}
+	}
+	r -= p.checkJitter()
 	return (time.Duration(r) * time.Millisecond)
 }
 
 func (p *Peer) getHostIP() string {
+	if p.target != nil {
+		return p.target.IP
+	}
 	if p.host != nil {
 		return p.host.AgentIP
 	}
 	return ""
 }
 
-// Run does the actual work
+func (p *Peer) getIP() string {
+	if p.target != nil {
+		return p.target.IP
+	}
+	if p.container != nil {
+		return p.container.PrimaryIp
+	}
+	return ""
+}
+
+// Run does the actual work, the check loop being restarted, after a
+// backoff, if it panics
 func (p *Peer) Run() {
+	if p.done != nil {
+		defer close(p.done)
+	}
+	backoff := watchdogInitialBackoff
+	for {
+		started := p.now()
+		if p.runRecovered() {
+			return
+		}
+		if p.now().Sub(started) > watchdogMaxBackoff {
+			backoff = watchdogInitialBackoff
+		}
+		p.logger.Errorf("Peer(%v): restarting the check loop in %v", p.uuid, backoff)
+		select {
+		case <-p.exit:
+			return
+		case <-p.after(backoff):
+		}
+		if backoff *= 2; backoff > watchdogMaxBackoff {
+			backoff = watchdogMaxBackoff
+		}
+	}
+}
+
+// runLoop checks the peer until it's shut down
+func (p *Peer) runLoop() {
+	sleepFirst := p.config.LoopOrder == LoopSleepFirst
 	for {
+		if sleepFirst {
+			p.sleep()
+		}
 		select {
 		case _, ok := <-p.exit:
 			if !ok {
-				log.Infof("Peer: %v deleted, stopping check", p.uuid)
+				p.logger.Infof("Peer: %v deleted, stopping check", p.uuid)
 				return
 			}
 		default:
-			p.doWork()
+			if p.active() {
+				p.doWork()
+				p.fireEvents()
+			}
 		}
+		if !sleepFirst {
+			p.sleep()
+		}
+	}
+}
 
-		sleepFor := p.getHostCheckSleepDuration()
-		log.Debugf("Peer(%v): sleeping for %v", p.uuid, sleepFor)
-		time.Sleep(sleepFor)
+// sleep waits for the next check, or for the peer to be shut down
+func (p *Peer) sleep() {
+	sleepFor := schedulerOrDefault(p.config.Scheduler).Next(p)
+	p.Lock()
+	p.debugf("Peer(%v): sleeping for %v", p.uuid, sleepFor)
+	p.Unlock()
+	select {
+	case <-p.exit:
+	case <-p.after(sleepFor):
 	}
 }
 
-func (p *Peer) updateFailure() {
-	if p.count > 0 {
-		p.count--
-		if p.count == 0 {
-			log.Errorf("Peer(%v, %v, %v): became unreachable", p.uuid, p.getHostIP(), p.container.PrimaryIp)
+// updateFailure records a failed check, the count going down by the
+// weight of the reason of the failure, see PeerConfig.FailureWeights
+func (p *Peer) updateFailure(reason utils.FailureReason) {
+	p.accumulateUptime()
+	p.consecutiveSuccesses = 0
+	p.consecutiveFailures++
+	p.recordReasonStreak(reason)
+	if delta := p.failureDelta(reason); delta < 0 {
+		if p.windowEnabled() {
+			p.recordWindow(false)
+		} else if p.count > 0 {
+			p.count += delta
+			if p.count < 0 || p.config.FailFast {
+				p.count = 0
+			}
+		}
+		if p.count == 0 && p.reportedReachable {
+			p.reportedReachable = false
+			p.downSince = p.now()
+			p.transition(false)
 		}
 	}
-	p.lastChecked = time.Now()
+	p.lastChecked = p.now()
+}
+
+// failureDelta returns how much the count changes on a failure of
+// the given reason, -1 unless weighted otherwise or ignored for the
+// peer, see SetIgnoredReasons
+func (p *Peer) failureDelta(reason utils.FailureReason) int {
+	if p.ignoredReasons[reason] {
+		return 0
+	}
+	delta, ok := p.config.FailureWeights[reason]
+	if !ok || delta > 0 {
+		return -1
+	}
+	return delta
 }
 
 // UpdateFailure keeps track of failure count
 func (p *Peer) UpdateFailure() {
 	p.Lock()
-	defer p.Unlock()
-	p.updateFailure()
+	p.updateFailure(utils.FailureNone)
+	p.Unlock()
+	p.fireEvents()
 }
 
+// updateSuccess records a successful check. The peer is reported
+// reachable once RecoverySuccesses checks in a row succeeded, while
+// the count goes up right away.
 func (p *Peer) updateSuccess() {
-	if p.count < 3 {
+	p.accumulateUptime()
+	p.consecutiveSuccesses++
+	p.consecutiveFailures = 0
+	p.reasonStreaks = nil
+	if p.windowEnabled() {
+		p.recordWindow(true)
+	} else if p.count < maxCount {
 		p.count++
-		if p.count == 1 {
-			log.Infof("Peer(%v, %v, %v): became reachable", p.uuid, p.getHostIP(), p.container.PrimaryIp)
+	}
+	if !p.reportedReachable && p.count > 0 && p.consecutiveSuccesses >= p.recoverySuccesses() {
+		p.reportedReachable = true
+		p.transition(true)
+		if !p.downSince.IsZero() {
+			p.lastRecoveredAt = p.now()
+			p.downSince = time.Time{}
+			p.downCause = ""
 		}
 	}
-	p.lastChecked = time.Now()
+	p.lastChecked = p.now()
+}
+
+func (p *Peer) recoverySuccesses() int {
+	if p.config.RecoverySuccesses > 1 {
+		return p.config.RecoverySuccesses
+	}
+	return 1
+}
+
+// RecentlyRecovered informs if the peer became reachable again,
+// after having been unreachable, within the given duration. It
+// allows consumers to wait for some stability before trusting
+// a recovery.
+func (p *Peer) RecentlyRecovered(within time.Duration) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.lastRecoveredAt.IsZero() || p.count == 0 {
+		return false
+	}
+	return p.now().Sub(p.lastRecoveredAt) < within
 }
 
 // UpdateSuccess keeps track of success count
 func (p *Peer) UpdateSuccess() {
 	p.Lock()
-	defer p.Unlock()
 	p.updateSuccess()
+	p.Unlock()
+	p.fireEvents()
 }
 
 func (p *Peer) doWork() error {
+	p.resolveName()
 	p.Lock()
 	defer p.Unlock()
 
 	if !p.consider() {
-		log.Debugf("Peer(%v): not considered", p.uuid)
+		p.debugf("Peer(%v): not considered", p.uuid)
 		return nil
 	}
 
 	if !p.isItTimeToCheck() {
-		log.Debugf("Peer(%v): skipping check", p.uuid)
+		p.debugf("Peer(%v): skipping check", p.uuid)
+		return nil
+	}
+
+	if p.skipStale() {
+		p.debugf("Peer(%v, %v, %v): not updated in metadata since %v, skipping check", p.uuid, p.getHostIP(), p.getIP(), p.metadataUpdated())
+		return nil
+	}
+
+	if !p.breakers.allow(p.getHostIP(), p.uuid, p.now()) {
+		p.debugf("Peer(%v, %v, %v): breaker of the host open, skipping check", p.uuid, p.getHostIP(), p.getIP())
+		return nil
+	}
+
+	p.recordCheckCadence()
+	err := p.check()
+	p.reportSettledState()
+	return err
+}
+
+// check probes the peer and updates its state, it
+// must be called with the lock held
+func (p *Peer) check() error {
+	p.publishStatus()
+	ctx := context.Background()
+	var span Span
+	if p.config.Tracer != nil {
+		ctx, span = p.config.Tracer.StartSpan(ctx, probeSpanName)
+		span.SetAttribute("peer.uuid", p.uuid)
+		span.SetAttribute("peer.host", p.getHostIP())
+		span.SetAttribute("peer.ip", p.getIP())
+		defer span.End()
+	}
+
+	checker, err := p.modeChecker()
+	if err != nil {
+		p.logger.Errorf("Peer(%v): %v", p.uuid, err)
+		return err
+	}
+
+	probe, accepted := p.probe()
+	if !accepted {
+		p.debugf("Peer(%v, %v, %v): IP rejected by the rewrite hook, skipping check", p.uuid, p.getHostIP(), p.getIP())
+		return nil
+	}
+	if p.handleLocalIP() {
 		return nil
 	}
+	if p.config.VerifyNonce {
+		probe.Options.Nonce = strconv.FormatUint(uint64(p.random.Int63()), 16)
+	}
+	if p.config.CorrelationIDs {
+		p.correlationID = newCorrelationID(p.random)
+		probe.Options.CorrelationID = p.correlationID
+		p.debugf("Peer(%v, %v, %v): checking with correlation ID %v", p.uuid, p.getHostIP(), p.getIP(), p.correlationID)
+		if span != nil {
+			span.SetAttribute("check.correlation_id", p.correlationID)
+		}
+	}
+
+	if err := p.setSource(&probe, p.nextSource()); err != nil {
+		p.logger.Errorf("Peer(%v): %v", p.uuid, err)
+		return err
+	}
+
+	if port := p.nextSourcePort(); port > 0 {
+		probe.Options.SourcePort = port
+		p.debugf("Peer(%v, %v, %v): checking from source port %v", p.uuid, p.getHostIP(), p.getIP(), port)
+	}
+
+	hostOnly := p.hostCheckEnabled() && p.config.HostCheck == HostCheckOnly
+	if hostOnly {
+		checker, probe, err = p.hostProbe()
+		if err != nil {
+			p.logger.Errorf("Peer(%v): host check: %v", p.uuid, err)
+			return err
+		}
+	}
+
+	// The spans of the requests are the children of the check's
+	probe.Context = ctx
+	p.lastAddress = probe.Address
+	p.lastLocalAddress, p.lastBodyBytes = "", 0
+	if probe.Options.SocketPath != "" {
+		p.lastAddress = probe.Options.SocketPath
+	}
+
+	if p.warmups < p.config.WarmupProbes {
+		p.warmup(checker, probe)
+	}
+
+	// The other targets of the peer are probed while its container is
+	// being probed, all of them before the deadline of the check
+	deadline := p.checkDeadline()
+	var hostResult, vipResult <-chan targetResult
+	if !hostOnly && p.hostCheckEnabled() && (p.config.ConcurrentHostCheck || p.config.ConcurrentTargets) {
+		hostResult = p.startHostCheck(deadline)
+	}
+	if !hostOnly && p.config.ConcurrentTargets {
+		vipResult = p.startVIPCheck(checker, probe, deadline)
+	}
 
-	url := fmt.Sprintf("http://%v/ping", p.container.PrimaryIp)
-	ok, err := utils.IsReachable(url, "pong", p.connectionTimeout)
+	release := p.limiter.acquire(p.getHostIP())
+	result := p.retry(checker, probe, p.runRequests(checker, probe))
+	release()
+	// The latency is the one of the last attempt, the failed ones
+	// before it telling nothing about the peer once reachable
+	ok, err, latency := result.ok, result.err, result.latency
+	if !hostOnly {
+		ok, err = p.checkReadiness(checker, probe, ok, err)
+		p.checkRelay(probe)
+		p.directReachable = ok
+		if vipResult != nil {
+			ok, err = p.recordVIP(awaitTarget(vipResult, deadline), ok, err)
+		} else {
+			ok, err = p.checkVIP(checker, probe, ok, err)
+		}
+	}
+	p.recordSourceResult(ok)
+	p.checkNeighbor()
+	p.attempts++
+	if ok {
+		p.successes++
+	}
+	p.recordHourly(ok)
+	if span != nil {
+		span.SetAttribute("check.latency_ms", latency.Seconds()*1000)
+		span.SetAttribute("check.result", ok)
+		span.SetAttribute("check.failure_reason", string(utils.ReasonOf(err)))
+	}
 	if ok {
+		p.failureReason = utils.FailureNone
+		p.suspectedMTU = false
+		p.recordVariance(latency)
+		p.recordLatency(latency)
+		if p.baselineLatency == 0 {
+			p.baselineLatency = latency
+		}
+		if !hostOnly && p.diagnosticEnabled() {
+			p.fetchDiagnostic(probe)
+		}
+		steady := p.count == maxCount
 		p.updateSuccess()
+		if steady {
+			p.growInterval()
+		}
 	} else {
-		p.updateFailure()
+		wasReachable := p.count > 0
+		p.failureReason = utils.ReasonOf(err)
+		p.resetInterval()
+		p.updateFailure(p.failureReason)
+		if p.failureReason != utils.FailureInjected {
+			if wasReachable && p.count == 0 {
+				p.confirmDown()
+			}
+			p.checkMTU(checker, probe)
+		}
+	}
+	if hostOnly {
+		p.hostLatency = latency
+		p.setHostReachable(ok, err)
+	} else if hostResult != nil {
+		result := awaitTarget(hostResult, deadline)
+		p.hostLatency = result.latency
+		p.setHostReachable(result.ok, result.err)
+	} else if p.hostCheckEnabled() {
+		p.checkHost()
+	}
+	p.recordMetrics(ok, latency)
+	if ok || p.failureReason != utils.FailureInjected {
+		p.breakers.record(p.getHostIP(), p.uuid, ok, p.now())
+	}
+	p.history.record(CheckRecord{
+		Time:          p.now(),
+		UUID:          p.uuid,
+		OK:            ok,
+		Latency:       latency,
+		FailureReason: p.failureReason,
+		Count:         p.count,
+		Source:        p.lastSource,
+		Endpoint:      p.lastAddress,
+	})
+	p.recordFlow(ok, latency)
+	p.updateHealthClass()
+	if ok && !p.bursting && p.shouldBurst() {
+		// Sent once the lock is released, see burst
+		p.bursting = true
+		p.queueEvent(func() { p.burst(checker) })
 	}
 	if err != nil {
-		log.Debugf("Peer(%v): checking reachability got err=%v", p.uuid, err)
+		p.debugf("Peer(%v): checking reachability got err=%v", p.uuid, err)
 	}
 	return nil
 }
 
+// checkResult is the result of a request of a check, with what the
+// Checker learnt along the way
+type checkResult struct {
+	ok      bool
+	err     error
+	latency time.Duration
+
+	certNotAfter      time.Time
+	parallelSuccesses int
+	portResults       map[int]bool
+	timing            utils.Timing
+}
+
+// runCheck does a request of the check and records its result, it
+// must be called with the lock held
+func (p *Peer) runCheck(checker Checker, probe Probe) checkResult {
+	if p.failureInjected() {
+		p.logger.Infof("Peer(%v, %v, %v): injected failure, not probing", p.uuid, p.getHostIP(), p.getIP())
+		return checkResult{err: errInjectedFailure}
+	}
+	r := p.probeOnce(p.mode(), checker, probe)
+	r.ok, r.err = p.capLatency(r.ok, r.err, r.latency)
+	p.recordResult(checker, r)
+	return r
+}
+
+// probeOnce does a request of the check in the given mode, through the
+// probe cache. It only uses what doesn't change once the peer is
+// created, so that the requests of a check run concurrently while the
+// caller holds the lock, see runRequests.
+func (p *Peer) probeOnce(mode string, checker Checker, probe Probe) checkResult {
+	var span Span
+	if p.config.Tracer != nil {
+		ctx := probe.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		probe.Context, span = p.config.Tracer.StartSpan(ctx, requestSpanName)
+		span.SetAttribute("request.address", probe.Address)
+		defer span.End()
+	}
+	r := p.cachedProbe(mode, probe, func() checkResult {
+		return probeWith(checker, probe)
+	})
+	if span != nil {
+		span.SetAttribute("request.ok", r.ok)
+		span.SetAttribute("request.latency", r.latency)
+	}
+	return r
+}
+
+// probeWith does a request of the check with the Checker, along with
+// what it learns on the way when it implements one of the optional
+// interfaces
+func probeWith(checker Checker, probe Probe) checkResult {
+	var r checkResult
+	start := time.Now()
+	switch c := checker.(type) {
+	case certChecker:
+		r.ok, r.certNotAfter, r.err = c.CheckCert(probe)
+	case parallelChecker:
+		r.ok, r.parallelSuccesses, r.err = c.CheckParallel(probe)
+	case portsChecker:
+		r.ok, r.portResults, r.err = c.CheckPorts(probe)
+	case timingChecker:
+		r.ok, r.timing, r.err = c.CheckTiming(probe)
+	default:
+		r.ok, r.err = checker.Check(probe)
+	}
+	r.latency = time.Since(start)
+	return r
+}
+
+// recordResult records what the Checker learnt doing a request of the
+// check, it must be called with the lock held
+func (p *Peer) recordResult(checker Checker, r checkResult) {
+	switch checker.(type) {
+	case certChecker:
+		if r.ok {
+			p.certNotAfter = r.certNotAfter
+		}
+	case parallelChecker:
+		p.parallelSuccesses = r.parallelSuccesses
+	case portsChecker:
+		p.portResults = r.portResults
+	case timingChecker:
+		p.lastLocalAddress, p.lastBodyBytes = r.timing.LocalAddress, r.timing.BodyBytes
+		if r.ok {
+			p.recordOneWayLatencies(r.timing)
+		}
+		p.schemaVersion = r.timing.SchemaVersion
+		if r.timing.Connect > 0 {
+			p.lastConnectTime = r.timing.Connect
+		}
+		if r.timing.LocalAddress != "" {
+			p.connsUsed++
+			if r.timing.Reused {
+				p.connsReused++
+			}
+		}
+	}
+}
+
+// connectionReuseRate returns the fraction of the connections the
+// checks went over which were reused, it must be called with the lock
+// held
+func (p *Peer) connectionReuseRate() float64 {
+	if p.connsUsed == 0 {
+		return 0
+	}
+	return float64(p.connsReused) / float64(p.connsUsed)
+}
+
+// CheckNow checks the peer right away, regardless of when it was
+// last checked. Peers that are not considered or are disabled are
+// not checked, nor are the ones left out of the sample, the ones of a
+// draining node and the ones whose host has its breaker open.
+func (p *Peer) CheckNow() error {
+	p.resolveName()
+	p.Lock()
+	var err error
+	if p.consider() && !p.disabled && !p.unselected && !p.draining &&
+		p.breakers.allow(p.getHostIP(), p.uuid, p.now()) {
+		err = p.check()
+	}
+	p.Unlock()
+	p.fireEvents()
+	return err
+}
+
+// DueForCheck informs if the interval since the last check has
+// elapsed, without triggering a check
+func (p *Peer) DueForCheck() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.isItTimeToCheck()
+}
+
+// NextCheckIn returns the time left until the interval since the
+// last check elapses, 0 when the peer is due for check
+func (p *Peer) NextCheckIn() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	left := p.checkIntervalDuration() - p.sinceLastChecked()
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// modeChecker returns the Checker of the mode of the peer, it must be
+// called with the lock held
+func (p *Peer) modeChecker() (Checker, error) {
+	if p.checker != nil {
+		return p.checker, nil
+	}
+	return getChecker(p.mode())
+}
+
+func (p *Peer) mode() string {
+	if p.target != nil {
+		return p.target.Mode
+	}
+	return p.config.Mode
+}
+
+// probe returns the probe checking the peer, its IP, or name when
+// probing by name, rewritten by the RewriteIP hook. It returns false
+// when the hook rejects the IP, in which case the peer must not be
+// checked.
+func (p *Peer) probe() (Probe, bool) {
+	ip := p.probeHost()
+	if p.config.RewriteIP != nil {
+		rewritten, ok := p.config.RewriteIP(ip)
+		if !ok {
+			return Probe{}, false
+		}
+		ip = rewritten
+	}
+
+	port, path := DefaultCheckPort, defaultCheckPath
+	if p.target != nil {
+		port, path = p.target.Port, p.target.Path
+	} else if p.mode() == ModeTLS || p.mode() == ModeHTTPS || p.mode() == ModeHTTP3 {
+		port = p.config.TLSPort
+		if port == 0 {
+			port = DefaultTLSPort
+		}
+	}
+	if hostIP, public, ok := p.hostPortEndpoint(port); ok {
+		p.debugf("Peer(%v, %v, %v): probing host port %v:%v mapped to port %v", p.uuid, p.getHostIP(), p.getIP(), hostIP, public, port)
+		ip, port = hostIP, public
+	}
+	probe := Probe{
+		Address:  net.JoinHostPort(ip, strconv.Itoa(port)),
+		Path:     path,
+		Expected: expectedResponse,
+		Options:  p.reachabilityOptions(),
+	}
+	switch p.mode() {
+	case ModePorts:
+		probe.Ports = p.config.Ports
+		probe.AnyPort = p.config.PortsPolicy == PortsAny
+	case ModeParallel:
+		probe.Connections = p.parallelConnections()
+		probe.MinConnections = p.config.ParallelMinSuccesses
+	}
+	if p.target == nil && p.config.LivenessPath != "" && p.mode() == ModeHTTP {
+		probe = p.healthProbe(probe, p.config.LivenessPath)
+	}
+	return probe, true
+}
+
+func (p *Peer) reachabilityOptions() utils.Options {
+	opts := utils.Options{
+		Timeout:        p.connectionTimeout(),
+		ConnectTimeout: p.config.ConnectTimeout,
+		ReadTimeout:    p.config.ReadTimeout,
+		DSCP:           p.config.DSCP,
+		MSS:            p.config.TCPMSS,
+
+		DisableKeepAlives:   p.config.DisableKeepAlives,
+		KeepAliveIdle:       p.config.KeepAliveIdle,
+		KeepAliveInterval:   p.config.KeepAliveInterval,
+		KeepAliveCount:      p.config.KeepAliveCount,
+		IdleConnTimeout:     p.config.IdleConnTimeout,
+		MaxIdleConnsPerHost: p.config.MaxIdleConnsPerPeer,
+		MaxConnsPerHost:     p.config.MaxConnsPerPeer,
+		DialFunc:            p.config.DialFunc,
+		DialFuncID:          p.dialFuncID,
+		HTTP3Transport:      p.config.HTTP3Transport,
+		Method:              p.config.CheckMethod,
+		Body:                p.config.CheckBody,
+		ContentType:         p.config.CheckContentType,
+		ConnectProxy:        p.config.ConnectProxy,
+		TraceConnect:        p.config.MeasureConnectTime,
+		VerboseFailures:     p.config.VerboseFailures,
+		RedactHeaders:       p.config.RedactHeaders,
+		TLSServerName:       p.config.TLSServerName,
+		TLSVerify:           p.config.TLSVerify,
+		ClientCertificate:   p.clientCert,
+		SourceIdentity:      p.config.SourceIdentity,
+		NegotiateSchema:     p.config.NegotiateSchema,
+		HTTP2:               p.config.HTTP2,
+	}
+	opts.ExpectedHeader, opts.ExpectedHeaderValue = p.expectedHeader()
+	opts.HeaderOnly = p.config.HeaderOnly
+	if p.mode() == ModeUnix {
+		opts.SocketPath = p.config.SocketPath
+	}
+	return opts
+}
+
+// FailureReason returns the reason of the last failed check,
+// it's empty if the last check succeeded
+func (p *Peer) FailureReason() utils.FailureReason {
+	p.Lock()
+	defer p.Unlock()
+	return p.failureReason
+}
+
+func (p *Peer) checkIntervalDuration() time.Duration {
+	interval := time.Duration(p.baseInterval()) * time.Millisecond
+	if p.adaptiveInterval != 0 {
+		interval = p.adaptiveInterval
+	}
+	interval = p.latencyInterval(interval)
+	if p.config.MaxCheckInterval > 0 {
+		if max := time.Duration(p.config.MaxCheckInterval) * time.Millisecond; interval > max {
+			return max
+		}
+	}
+	return interval
+}
+
 func (p *Peer) isItTimeToCheck() bool {
-	checkInterval := time.Duration(p.checkInterval) * time.Millisecond
-	timeSinceLastChecked := time.Now().Sub(p.lastChecked)
-	log.Debugf("Peer(%v): timeSinceLastChecked: %v (checkInterval: %v)", p.uuid, timeSinceLastChecked, checkInterval)
+	checkInterval := p.checkIntervalDuration()
+	timeSinceLastChecked := p.sinceLastChecked()
+	p.debugf("Peer(%v): timeSinceLastChecked: %v (checkInterval: %v)", p.uuid, timeSinceLastChecked, checkInterval)
 	if timeSinceLastChecked < checkInterval {
 		return false
 	}
@@ -153,26 +917,35 @@ func (p *Peer) isItTimeToCheck() bool {
 }
 
 func (p *Peer) consider() bool {
-	if p.host == nil || p.container == nil || p.ccContainer == nil {
-		log.Debugf("Peer(%v): host is not in considerable state p.host=%v p.container=%v p.ccContainer=%v", p.uuid, p.host, p.container, p.ccContainer)
+	if p.target != nil {
+		return true
+	}
+	lenient := p.config.ConsiderPolicy == ConsiderLenient
+	if p.host == nil || p.container == nil || (p.ccContainer == nil && !lenient) {
+		p.debugf("Peer(%v): host is not in considerable state p.host=%v p.container=%v p.ccContainer=%v", p.uuid, p.host, p.container, p.ccContainer)
 		return false
 	}
-	log.Debugf("Peer(%v, %v, %v): host State=%v AgentState=%v", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.host.State, p.host.AgentState)
+	p.debugf("Peer(%v, %v, %v): host State=%v AgentState=%v", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.host.State, p.host.AgentState)
 	if !(p.host.State == "active") ||
 		!(p.host.AgentState == "" || p.host.AgentState == "active") {
-		log.Debugf("Peer(%v, %v, %v): host is not in considerable state", p.uuid, p.getHostIP(), p.container.PrimaryIp)
+		p.debugf("Peer(%v, %v, %v): host is not in considerable state", p.uuid, p.getHostIP(), p.container.PrimaryIp)
 		return false
 	}
 
-	log.Debugf("Peer(%v, %v, %v): ccContainer.State=%v", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.ccContainer.State)
-	if p.ccContainer.State != "running" {
-		log.Debugf("Peer(%v, %v, %v): ccContainer is not in considerable state (running)", p.uuid, p.getHostIP(), p.container.PrimaryIp)
+	p.debugf("Peer(%v, %v, %v): container.State=%v", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.container.State)
+	if p.container.State != "running" {
+		p.debugf("Peer(%v, %v, %v): skipping, container is in state %v, not running", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.container.State)
 		return false
 	}
 
-	log.Debugf("Peer(%v, %v, %v): container.State=%v", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.container.State)
-	if p.container.State != "running" {
-		log.Debugf("Peer(%v, %v, %v): container is not in considerable state (running)", p.uuid, p.getHostIP(), p.container.PrimaryIp)
+	// In the lenient policy the app container running is enough,
+	// the ccContainer may be restarting separately
+	if lenient {
+		return true
+	}
+	p.debugf("Peer(%v, %v, %v): ccContainer.State=%v", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.ccContainer.State)
+	if p.ccContainer.State != "running" {
+		p.debugf("Peer(%v, %v, %v): skipping, ccContainer is in state %v, not running", p.uuid, p.getHostIP(), p.container.PrimaryIp, p.ccContainer.State)
 		return false
 	}
 
@@ -186,8 +959,26 @@ func (p *Peer) Consider() bool {
 	return p.consider()
 }
 
+// reachable informs if the peer is reported reachable, which it is
+// once RecoverySuccesses checks in a row succeeded and until the count
+// drops to 0
+func (p *Peer) reachable() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.reportedReachable
+}
+
 // Shutdown is used to stop check for a peer
 func (p *Peer) Shutdown() error {
-	close(p.exit)
+	p.shutdownOnce.Do(func() { close(p.exit) })
 	return nil
 }
+
+// openConnections returns the number of connections of the checks
+// open to the peer, it must be called with the lock held
+func (p *Peer) openConnections() int64 {
+	if p.lastAddress == "" {
+		return 0
+	}
+	return utils.OpenConnectionsTo(p.lastAddress)
+}
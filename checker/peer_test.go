@@ -0,0 +1,970 @@
+package checker
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/go-rancher-metadata/metadata"
+)
+
+const testMode = "test"
+
+// unsetChecker makes testMode known to the validation of the config,
+// the tests giving their checker to PeerConfig.checker
+type unsetChecker struct{}
+
+func (unsetChecker) Check(probe Probe) (bool, error) {
+	return false, fmt.Errorf("no checker set for the test")
+}
+
+func init() {
+	RegisterChecker(testMode, unsetChecker{})
+}
+
+// testChecker records the probes it's asked to do
+type testChecker struct {
+	sync.Mutex
+	probes []Probe
+	ok     bool
+	// err is returned along with the failures
+	err error
+}
+
+func (c *testChecker) Check(probe Probe) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.probes = append(c.probes, probe)
+	if !c.ok {
+		return false, c.err
+	}
+	return true, nil
+}
+
+func (c *testChecker) lastProbe() Probe {
+	c.Lock()
+	defer c.Unlock()
+	return c.probes[len(c.probes)-1]
+}
+
+func newTestPeer(ip string) (*Peer, *testChecker) {
+	tc := &testChecker{ok: true}
+	cfg := DefaultConfig().PeerConfig
+	cfg.Mode = testMode
+	container := &metadata.Container{UUID: "c1", PrimaryIp: ip, State: "running"}
+	p := &Peer{
+		uuid:        "c1",
+		host:        &metadata.Host{UUID: "h1", AgentIP: "192.168.0.1", State: "active"},
+		container:   container,
+		ccContainer: &metadata.Container{UUID: "cc1", State: "running"},
+		config:      cfg,
+		checker:     tc,
+		exit:        make(chan bool),
+	}
+	p.setupRandom()
+	return p, tc
+}
+
+func TestPeerProbesNewIPAfterUpdate(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+
+	p.doWork()
+	if got := tc.lastProbe().Address; got != "10.42.0.1:80" {
+		t.Fatalf("expected probe to 10.42.0.1:80, got %v", got)
+	}
+
+	newContainer := *p.container
+	newContainer.PrimaryIp = "10.42.0.2"
+	p.Update(&newContainer, p.ccContainer, p.host)
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+
+	p.doWork()
+	if got := tc.lastProbe().Address; got != "10.42.0.2:80" {
+		t.Fatalf("expected probe to 10.42.0.2:80 after IP change, got %v", got)
+	}
+}
+
+// fakeClock is a Clock whose time only changes when told so
+type fakeClock struct {
+	sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Add advances the time, waking up the waiters whose
+// deadline has passed
+func (c *fakeClock) Add(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// waitForWaiters blocks until n callers are waiting on After
+func (c *fakeClock) waitForWaiters(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.Lock()
+		waiting := len(c.waiters)
+		c.Unlock()
+		if waiting >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v waiters, got %v", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPeerCheckScheduleSurvivesBackwardClockJump(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.Clock = clock
+
+	p.doWork()
+	if len(tc.probes) != 1 {
+		t.Fatalf("expected 1 check, got %v", len(tc.probes))
+	}
+
+	clock.Add(p.checkIntervalDuration() / 2)
+	if p.DueForCheck() {
+		t.Fatalf("expected the peer not to be due for check within the interval")
+	}
+
+	// Without the guard the peer wouldn't be checked for an hour
+	clock.Add(-time.Hour)
+	if !p.DueForCheck() {
+		t.Fatalf("expected a backward clock jump to count as a single interval")
+	}
+	p.doWork()
+	if len(tc.probes) != 2 {
+		t.Fatalf("expected 2 checks after the clock jump, got %v", len(tc.probes))
+	}
+}
+
+func TestPeerCountsReasonStreaks(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.ReasonStreaks = true
+
+	p.updateFailure(utils.FailureConnectTimeout)
+	p.updateFailure(utils.FailureConnectTimeout)
+	if got := p.reasonStreaks[utils.FailureConnectTimeout]; got != 2 {
+		t.Fatalf("expected a streak of 2 timeouts, got %v", got)
+	}
+
+	p.updateFailure(utils.FailureRefused)
+	if got := p.reasonStreaks[utils.FailureConnectTimeout]; got != 0 {
+		t.Fatalf("expected the timeouts streak to be broken by a refusal, got %v", got)
+	}
+	if got := p.reasonStreaks[utils.FailureRefused]; got != 1 {
+		t.Fatalf("expected a streak of 1 refusal, got %v", got)
+	}
+
+	p.updateSuccess()
+	if p.ReasonStreaks() != nil {
+		t.Fatalf("expected no streak after a success, got %v", p.ReasonStreaks())
+	}
+}
+
+func TestPeerProbesHostPort(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.HostPort = true
+	p.container.Ports = []string{"0.0.0.0:53:53/udp", "0.0.0.0:8080:80/tcp"}
+
+	p.doWork()
+	if got := tc.lastProbe().Address; got != "192.168.0.1:8080" {
+		t.Fatalf("expected probe to the host port 192.168.0.1:8080, got %v", got)
+	}
+	if got := p.Status().Endpoint; got != "192.168.0.1:8080" {
+		t.Fatalf("expected the host port as the endpoint, got %v", got)
+	}
+}
+
+// panicChecker panics on every check
+type panicChecker struct{}
+
+func (panicChecker) Check(probe Probe) (bool, error) {
+	panic("bug in a check")
+}
+
+func TestPeerRestartsPanickingLoop(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p, _ := newTestPeer("10.42.0.1")
+	p.checker = panicChecker{}
+	p.config.Clock = clock
+
+	p.Start()
+	clock.waitForWaiters(t, 1)
+	if got := p.Restarts(); got != 1 {
+		t.Fatalf("expected the loop to be restarted once, got %v", got)
+	}
+	p.Shutdown()
+	p.Wait()
+}
+
+func TestPeerSlidingWindow(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.WindowSize = 4
+	p.config.WindowMinSuccesses = 2
+
+	for _, ok := range []bool{true, false, true, false, false} {
+		if ok {
+			p.updateSuccess()
+		} else {
+			p.updateFailure(utils.FailureConnectTimeout)
+		}
+	}
+	// The window is false, true, false, false once the first
+	// result went away
+	if status := p.Status(); status.Reachable || status.WindowFill != 4 || status.WindowSuccesses != 1 {
+		t.Fatalf("expected unreachable with 1 success out of 4, got %+v", status)
+	}
+	p.updateSuccess()
+	if !p.Status().Reachable {
+		t.Fatalf("expected reachable with 2 successes out of 4")
+	}
+}
+
+func TestPeerReachableOnceRecovered(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.RecoverySuccesses = 2
+
+	// The count is up after the first success, the peer isn't
+	// reported reachable before the second one
+	p.updateSuccess()
+	status := p.Status()
+	if status.Reachable || status.Count == 0 || p.HealthClass() != HealthDown || p.reachable() {
+		t.Fatalf("expected not reachable after a single success, got %+v", status)
+	}
+	p.updateSuccess()
+	if !p.Status().Reachable || !p.reachable() {
+		t.Fatalf("expected reachable after two successes")
+	}
+}
+
+func TestLimiterWakesWaitingChecks(t *testing.T) {
+	l := newLimiter(0)
+	l.setRamp(2, 1, 100*time.Millisecond)
+
+	releaseFirst := l.acquire("h1")
+	acquired := make(chan func())
+	// The second check waits for the bound to rise, the third one for
+	// a check to be over
+	for i := 0; i < 2; i++ {
+		go func() { acquired <- l.acquire("h1") }()
+	}
+	select {
+	case release := <-acquired:
+		defer release()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a check to run once the bound rose")
+	}
+	select {
+	case <-acquired:
+		t.Fatalf("expected the bound to hold")
+	case <-time.After(150 * time.Millisecond):
+	}
+	releaseFirst()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a check to run once another one is over")
+	}
+}
+
+func TestLimiterBusyHostDoesntBlockOthers(t *testing.T) {
+	l := newLimiter(1)
+	l.setRamp(2, 0, 0)
+
+	release := l.acquire("h1")
+	defer release()
+	// The second check of h1 waits for the first one, without taking
+	// the overall slot left
+	go func() { l.acquire("h1")() }()
+	time.Sleep(10 * time.Millisecond)
+	acquired := make(chan func())
+	go func() { acquired <- l.acquire("h2") }()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the check of an idle host not to wait for the busy one")
+	}
+}
+
+func TestPeerProbesByName(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.ProbeByName = true
+	p.container.Name = "localhost"
+
+	p.doWork()
+	if got := tc.lastProbe().Address; got != "localhost:80" {
+		t.Fatalf("expected probe to localhost:80, got %v", got)
+	}
+	if !p.DNSMismatch() {
+		t.Fatalf("expected localhost not to resolve to the PrimaryIp")
+	}
+
+	p.container.PrimaryIp = "127.0.0.1"
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+	p.doWork()
+	if p.DNSMismatch() {
+		t.Fatalf("expected localhost to resolve to the PrimaryIp")
+	}
+}
+
+func TestPeerRetryBudget(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p, tc := newTestPeer("10.42.0.1")
+	tc.ok = false
+	p.config.Clock = clock
+	p.config.RetryBudget = 2
+
+	p.doWork()
+	if got := len(tc.probes); got != 3 {
+		t.Fatalf("expected the failed check to be retried twice, got %v probes", got)
+	}
+	clock.Add(p.checkIntervalDuration())
+	p.doWork()
+	if got := len(tc.probes); got != 4 {
+		t.Fatalf("expected no retry once the budget is spent, got %v probes", got)
+	}
+	clock.Add(time.Minute)
+	if got := p.RetriesLeft(); got != 2 {
+		t.Fatalf("expected the budget refilled after a minute, got %v", got)
+	}
+}
+
+// slowFailureChecker fails slowly its first check, then succeeds
+// right away
+type slowFailureChecker struct {
+	delay time.Duration
+	calls int
+}
+
+func (c *slowFailureChecker) Check(probe Probe) (bool, error) {
+	c.calls++
+	if c.calls == 1 {
+		time.Sleep(c.delay)
+		return false, &utils.CheckError{Reason: utils.FailureReadTimeout}
+	}
+	return true, nil
+}
+
+func TestPeerRetriesAllRequestsOfCheck(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.Mode = ModeHTTP
+	p.config.RequestsPerCheck = 2
+	p.config.RetryBudget = 1
+	c := &alternatingChecker{}
+	p.checker = c
+
+	// A single request succeeding doesn't make the retry succeed
+	p.doWork()
+	if p.failureReason != utils.FailureStatusCode || c.checks != 4 {
+		t.Fatalf("expected the retry to fail with all its requests, got reason %q after %v requests", p.failureReason, c.checks)
+	}
+	if ratio, found := p.RequestSuccessRatio(); !found || ratio != 0.5 {
+		t.Fatalf("expected the ratio of the retry, got %v (%v)", ratio, found)
+	}
+}
+
+func TestPeerRetriedCheckLatency(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.checker = &slowFailureChecker{delay: 50 * time.Millisecond}
+	p.config.RetryBudget = 1
+
+	p.doWork()
+	if p.failureReason != utils.FailureNone {
+		t.Fatalf("expected the retried check to succeed, got reason %q", p.failureReason)
+	}
+	if p.lastLatency >= 50*time.Millisecond {
+		t.Fatalf("expected the latency of the retry alone, got %v", p.lastLatency)
+	}
+}
+
+func TestPeerDegradedWhenFlaky(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.Clock = clock
+	p.config.DegradedPeriod = 60000
+
+	// The count oscillates between 1 and 2 once at its maximum
+	for _, ok := range []bool{true, true, true, false, false, true, false, true, false, true} {
+		tc.ok = ok
+		p.doWork()
+		clock.Add(p.checkIntervalDuration())
+	}
+	if p.Degraded() {
+		t.Fatalf("expected not degraded before the degraded period")
+	}
+	for i := 0; i < 3; i++ {
+		tc.ok = false
+		p.doWork()
+		clock.Add(p.checkIntervalDuration())
+		tc.ok = true
+		p.doWork()
+		clock.Add(p.checkIntervalDuration())
+	}
+	if !p.Degraded() || p.HealthClass() != HealthDegraded {
+		t.Fatalf("expected degraded after flaking for the degraded period, count %v", p.count)
+	}
+}
+
+func TestPeerIntervalFollowsLatency(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.LatencyIntervalMin = 2000
+	p.config.LatencyIntervalMax = 8000
+	p.count = maxCount
+	p.baselineLatency = 10 * time.Millisecond
+
+	// Steady at the baseline
+	p.avgLatency = 10 * time.Millisecond
+	if got := p.checkIntervalDuration(); got != 8*time.Second {
+		t.Fatalf("expected a stable peer checked every 8s, got %v", got)
+	}
+
+	// Three times slower than the baseline
+	p.avgLatency = 30 * time.Millisecond
+	if got := p.checkIntervalDuration(); got < 3*time.Second || got > 4*time.Second {
+		t.Fatalf("expected a slow peer checked every 3.3s, got %v", got)
+	}
+	if got := p.getHostCheckSleepDuration(); got > 4*time.Second {
+		t.Fatalf("expected a slow peer to sleep less than 4s, got %v", got)
+	}
+
+	// Varying a lot
+	p.avgLatency = 10 * time.Millisecond
+	p.latencyVariance = float64(10*time.Millisecond) * float64(10*time.Millisecond)
+	if got := p.checkIntervalDuration(); got != 2*time.Second {
+		t.Fatalf("expected a variable peer checked every 2s, got %v", got)
+	}
+
+	// Failures are left to the failure handling
+	p.count = 0
+	if got := p.checkIntervalDuration(); got != DefaultCheckInterval*time.Millisecond {
+		t.Fatalf("expected an unreachable peer checked every %vms, got %v", DefaultCheckInterval, got)
+	}
+}
+
+// alternatingChecker fails every other check
+type alternatingChecker struct {
+	sync.Mutex
+	checks int
+}
+
+func (c *alternatingChecker) Check(probe Probe) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.checks++
+	if c.checks%2 == 0 {
+		return false, &utils.CheckError{Reason: utils.FailureStatusCode}
+	}
+	return true, nil
+}
+
+func TestPeerSendsRequestsPerCheck(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.Mode = ModeHTTP
+	p.config.RequestsPerCheck = 4
+	p.config.RequestsConcurrency = 2
+	c := &alternatingChecker{}
+
+	r := p.runRequests(c, Probe{})
+	if r.ok || utils.ReasonOf(r.err) != utils.FailureStatusCode {
+		t.Fatalf("expected the check to fail with all the requests needed, got ok=%v err=%v", r.ok, r.err)
+	}
+	if c.checks != 4 {
+		t.Fatalf("expected 4 requests, got %v", c.checks)
+	}
+	if ratio, found := p.RequestSuccessRatio(); !found || ratio != 0.5 {
+		t.Fatalf("expected half of the requests to succeed, got %v (%v)", ratio, found)
+	}
+
+	p.config.RequestsMinSuccesses = 2
+	if r := p.runRequests(c, Probe{}); !r.ok {
+		t.Fatalf("expected 2 successes of 4 to be enough, got %v", r.err)
+	}
+}
+
+// concurrencyChecker records the most requests it had in flight at
+// once, reporting their timing
+type concurrencyChecker struct {
+	sync.Mutex
+	inFlight, max int
+}
+
+func (c *concurrencyChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckTiming(probe)
+	return ok, err
+}
+
+func (c *concurrencyChecker) CheckTiming(probe Probe) (bool, utils.Timing, error) {
+	c.Lock()
+	c.inFlight++
+	if c.inFlight > c.max {
+		c.max = c.inFlight
+	}
+	c.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.Lock()
+	c.inFlight--
+	c.Unlock()
+	return true, utils.Timing{LocalAddress: "10.42.0.9:4242", SchemaVersion: 2}, nil
+}
+
+func TestPeerProbesTargetsConcurrently(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.VIPCheck = true
+	p.config.ConcurrentTargets = true
+	p.vip = "10.43.0.1"
+	c := &concurrencyChecker{}
+	p.checker = c
+
+	p.doWork()
+	if c.max != 2 {
+		t.Fatalf("expected the container and the VIP probed at once, got %v", c.max)
+	}
+	if !p.vipChecked || !p.vipReachable || p.vipLatency == 0 {
+		t.Fatalf("expected the VIP result and latency to be recorded, got checked=%v reachable=%v latency=%v", p.vipChecked, p.vipReachable, p.vipLatency)
+	}
+
+	// A timeout past the interval still leaves a request its timeout
+	p.config.ConnectionTimeout = 2 * p.config.CheckInterval
+	timeout := time.Duration(p.config.ConnectionTimeout) * time.Millisecond
+	if d := time.Until(p.checkDeadline()); d < timeout-time.Second {
+		t.Fatalf("expected the deadline floored at the timeout %v, got %v", timeout, d)
+	}
+
+	// A target still running at the deadline counts as failed
+	r := awaitTarget(make(chan targetResult), time.Now().Add(5*time.Millisecond))
+	if r.ok || utils.ReasonOf(r.err) != utils.FailureCheckDeadline {
+		t.Fatalf("expected the target to fail past the deadline, got ok=%v err=%v", r.ok, r.err)
+	}
+}
+
+func TestPeerRequestsWithinLimiter(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.Mode = ModeHTTP
+	p.config.RequestsPerCheck = 4
+	p.config.RequestsConcurrency = 4
+	p.limiter = newLimiter(0)
+	p.limiter.setRamp(2, 0, 0)
+	c := &concurrencyChecker{}
+
+	// The check holds a slot, a single other one is left
+	release := p.limiter.acquire(p.getHostIP())
+	r := p.runRequests(c, Probe{})
+	release()
+	if !r.ok {
+		t.Fatalf("expected the check to succeed, got %v", r.err)
+	}
+	if c.max != 2 {
+		t.Fatalf("expected 2 requests at once within the limiter, got %v", c.max)
+	}
+	if p.limiter.inFlight != 0 {
+		t.Fatalf("expected the slots of the requests to be released, got %v in flight", p.limiter.inFlight)
+	}
+	if p.lastLocalAddress != "10.42.0.9:4242" || p.schemaVersion != 2 {
+		t.Fatalf("expected the timing of the requests to be recorded, got %q and %v", p.lastLocalAddress, p.schemaVersion)
+	}
+}
+
+func TestPeersShareProbeCache(t *testing.T) {
+	cache := newProbeCache(time.Minute)
+	p1, _ := newTestPeer("10.42.0.1")
+	p2, _ := newTestPeer("10.42.0.1")
+	p1.probeCache, p2.probeCache = cache, cache
+	c := &concurrencyChecker{}
+	probe := Probe{Address: "10.42.0.1:80", Path: "/ping", Expected: expectedResponse}
+
+	if r := p1.probeOnce(ModeHTTP, c, probe); !r.ok || r.timing.LocalAddress == "" {
+		t.Fatalf("expected the probe to succeed with its timing, got %+v", r)
+	}
+	correlated := probe
+	correlated.Options.CorrelationID = "c2"
+	if r := p2.probeOnce(ModeHTTP, c, correlated); r.timing.LocalAddress == "" {
+		t.Fatalf("expected the cached timing, got %+v", r)
+	}
+	other := probe
+	other.Expected = "other"
+	p2.probeOnce(ModeHTTP, c, other)
+	if cache.hits != 1 || cache.misses != 2 {
+		t.Fatalf("expected only the same probe to be answered from the cache, got %v hits and %v misses", cache.hits, cache.misses)
+	}
+}
+
+func TestProbeCacheSurvivesPanickingProbe(t *testing.T) {
+	cache := newProbeCache(time.Minute)
+	p, _ := newTestPeer("10.42.0.1")
+	p.probeCache = cache
+	probe := Probe{Address: "10.42.0.1:80", Path: "/ping", Expected: expectedResponse}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expected the panic to go on up")
+			}
+		}()
+		p.probeOnce(ModeHTTP, panicChecker{}, probe)
+	}()
+	done := make(chan checkResult, 1)
+	go func() {
+		done <- p.probeOnce(ModeHTTP, &testChecker{ok: true}, probe)
+	}()
+	select {
+	case r := <-done:
+		if r.ok || utils.ReasonOf(r.err) != utils.FailureOther {
+			t.Fatalf("expected the failure of the panicking probe, got ok=%v err=%v", r.ok, r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the next probe not to wait for the panicking one")
+	}
+}
+
+func TestPeerCheckNowSkipsDrainingAndOpenBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.Clock = clock
+	p.breakers = newBreakers(1, 30000)
+	checks := func() int {
+		tc.Lock()
+		defer tc.Unlock()
+		return len(tc.probes)
+	}
+
+	p.draining = true
+	p.CheckNow()
+	if n := checks(); n != 0 {
+		t.Fatalf("expected no check while draining, got %v checks", n)
+	}
+	p.draining = false
+
+	// A failure opens the breaker of the host
+	tc.ok = false
+	p.CheckNow()
+	if state := p.breakers.states()["192.168.0.1"]; state.State != BreakerOpen {
+		t.Fatalf("expected the breaker to open, got %+v", state)
+	}
+	p.CheckNow()
+	if n := checks(); n != 1 {
+		t.Fatalf("expected no check while the breaker is open, got %v checks", n)
+	}
+}
+
+func TestPeersShareHostBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	b := newBreakers(2, 30000)
+	p1, _ := newTestPeer("10.42.0.1")
+	p2, tc := newTestPeer("10.42.0.2")
+	p2.uuid = "c2"
+	for _, p := range []*Peer{p1, p2} {
+		p.config.Clock = clock
+		p.checker = tc
+		p.breakers = b
+	}
+	checks := func() int {
+		tc.Lock()
+		defer tc.Unlock()
+		return len(tc.probes)
+	}
+
+	// A failure of each peer opens the breaker of their host
+	tc.ok = false
+	p1.doWork()
+	p2.doWork()
+	if state := b.states()["192.168.0.1"]; state.State != BreakerOpen {
+		t.Fatalf("expected the breaker to open, got %+v", state)
+	}
+	clock.Add(p1.checkIntervalDuration())
+	p1.doWork()
+	p2.doWork()
+	if n := checks(); n != 2 {
+		t.Fatalf("expected no check while the breaker is open, got %v checks", n)
+	}
+
+	// A single trial once the cooldown is over, failing opens it again
+	clock.Add(30 * time.Second)
+	p1.doWork()
+	p2.doWork()
+	if n := checks(); n != 3 {
+		t.Fatalf("expected a single trial, got %v checks", n-2)
+	}
+	if state := b.states()["192.168.0.1"]; state.State != BreakerOpen {
+		t.Fatalf("expected the breaker to open again, got %+v", state)
+	}
+
+	// A successful trial closes it, the other peer being checked again
+	tc.ok = true
+	clock.Add(30 * time.Second)
+	p2.doWork()
+	p1.doWork()
+	if n := checks(); n != 5 {
+		t.Fatalf("expected the trial then the other check, got %v checks", n-3)
+	}
+	if states := b.states(); len(states) != 0 {
+		t.Fatalf("expected the breaker to close after the trial, got %+v", states)
+	}
+}
+
+// recordingLogger is a Logger keeping the logs by level
+type recordingLogger struct {
+	sync.Mutex
+	logs []string
+}
+
+func (l *recordingLogger) record(level, format string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.logs = append(l.logs, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.record("info", format, args...)
+}
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.record("warn", format, args...)
+}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.record("error", format, args...)
+}
+
+func TestPeerLogsThroughCustomLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	p, tc := newTestPeer("10.42.0.1")
+	p.logger = newSyncLogger(logger)
+	p.config.FailFast = true
+
+	p.doWork()
+	tc.ok = false
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+	p.doWork()
+
+	expected := []string{
+		"info: Peer(c1, 192.168.0.1, 10.42.0.1): became reachable",
+		"error: Peer(c1, 192.168.0.1, 10.42.0.1): became unreachable (reason: )",
+	}
+	var got []string
+	logger.Lock()
+	for _, l := range logger.logs {
+		if !strings.HasPrefix(l, "debug: ") {
+			got = append(got, l)
+		}
+	}
+	logger.Unlock()
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the logs %q, got %q", expected, got)
+	}
+}
+
+func TestPeerSuccessRateConfidence(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.SuccessRateMinChecks = 3
+	tc.ok = false
+
+	p.doWork()
+	if rate, confident := p.SuccessRate(); rate != 0 || confident {
+		t.Fatalf("expected a rate of 0 not confident after a single failure, got %v, %v", rate, confident)
+	}
+	tc.ok = true
+	for i := 0; i < 2; i++ {
+		p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+		p.doWork()
+	}
+	if rate, confident := p.SuccessRate(); rate != 2.0/3 || !confident {
+		t.Fatalf("expected a confident rate of 2/3 after 3 checks, got %v, %v", rate, confident)
+	}
+}
+
+func TestPeerHourlySuccessRates(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 1, 2, 2, 30, 0, 0, time.Local)}
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.Clock = clock
+	p.config.HourlyWindowDays = 2
+
+	check := func(at time.Time, ok bool) {
+		clock.Lock()
+		clock.now = at
+		clock.Unlock()
+		tc.ok = ok
+		p.lastChecked = time.Time{}
+		p.doWork()
+	}
+	check(time.Date(2017, 1, 2, 2, 30, 0, 0, time.Local), false)
+	check(time.Date(2017, 1, 2, 14, 0, 0, 0, time.Local), true)
+	check(time.Date(2017, 1, 3, 2, 10, 0, 0, time.Local), true)
+
+	rates := p.HourlySuccessRates()
+	if rates[2] != 0.5 || rates[14] != 1 || rates[3] != -1 {
+		t.Fatalf("expected 0.5 at 2am, 1 at 2pm and none at 3am, got %v", rates)
+	}
+	// The first day leaves the window of 2 days
+	check(time.Date(2017, 1, 4, 2, 10, 0, 0, time.Local), true)
+	if rates := p.HourlySuccessRates(); rates[2] != 1 || rates[14] != -1 {
+		t.Fatalf("expected the first day out of the window, got %v", rates)
+	}
+}
+
+// slowChecker succeeds after a delay
+func TestPeerBurstsOnceUnlocked(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.BurstSize = 3
+	p.config.BurstJitterThreshold = 1
+	p.avgLatency = time.Millisecond
+	p.latencyVariance = float64(time.Second) * float64(time.Second)
+	probes := func() int {
+		tc.Lock()
+		defer tc.Unlock()
+		return len(tc.probes)
+	}
+
+	// The burst is sent once the check is over and the lock released
+	p.doWork()
+	if n := probes(); n != 1 {
+		t.Fatalf("expected the burst not to be sent by the check, got %v probes", n)
+	}
+	p.fireEvents()
+	if n := probes(); n != 4 {
+		t.Fatalf("expected a burst of 3 probes, got %v probes", n-1)
+	}
+	if p.bursting || p.LossRate() != 0 {
+		t.Fatalf("expected the burst over without loss, got bursting=%v loss=%v", p.bursting, p.LossRate())
+	}
+}
+
+// reusingChecker is a timingChecker whose checks reuse the connection
+// of the first one
+type reusingChecker struct {
+	checks int
+}
+
+func (c *reusingChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckTiming(probe)
+	return ok, err
+}
+
+func (c *reusingChecker) CheckTiming(probe Probe) (bool, utils.Timing, error) {
+	c.checks++
+	return true, utils.Timing{LocalAddress: "10.42.0.2:40000", Reused: c.checks > 1}, nil
+}
+
+func TestPeerReportsConnectionReuseRate(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.checker = &reusingChecker{}
+	for i := 0; i < 4; i++ {
+		p.CheckNow()
+	}
+	if got := p.Status().ConnectionReuseRate; got != 0.75 {
+		t.Fatalf("expected 3 of the 4 connections reused, got a rate of %v", got)
+	}
+}
+
+type slowChecker struct {
+	delay time.Duration
+}
+
+func (c slowChecker) Check(probe Probe) (bool, error) {
+	time.Sleep(c.delay)
+	return true, nil
+}
+
+func TestPeerFailsTooSlowChecks(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.checker = slowChecker{delay: 20 * time.Millisecond}
+	p.SetMaxLatency(5 * time.Millisecond)
+
+	p.doWork()
+	if p.failureReason != utils.FailureTooSlow {
+		t.Fatalf("expected the slow check to fail as too slow, got reason %q", p.failureReason)
+	}
+	if got := p.TooSlowChecks(); got != 1 {
+		t.Fatalf("expected 1 check too slow, got %v", got)
+	}
+	p.SetMaxLatency(time.Second)
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+	p.doWork()
+	if p.failureReason != utils.FailureNone || p.TooSlowChecks() != 1 {
+		t.Fatalf("expected the check within the cap to succeed, got reason %q", p.failureReason)
+	}
+}
+
+func TestPeerCapsLatencyOfEachRequest(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.checker = slowChecker{delay: 10 * time.Millisecond}
+	p.config.Mode = ModeHTTP
+	p.config.RequestsPerCheck = 3
+	p.SetMaxLatency(25 * time.Millisecond)
+
+	// The requests take longer than the cap together, not each
+	p.doWork()
+	if p.failureReason != utils.FailureNone || p.TooSlowChecks() != 0 {
+		t.Fatalf("expected the requests within the cap to succeed, got reason %q", p.failureReason)
+	}
+}
+
+func TestPeerChecksStaleContainerLessOften(t *testing.T) {
+	at := time.Unix(1500000000, 0)
+	clock := &fakeClock{now: at}
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.Clock = clock
+	p.config.StaleAfter = 3600000
+	p.config.StaleCheckInterval = 60000
+	p.config.StaleTimestampLabel = "updated"
+	p.container.Labels = map[string]string{"updated": at.Add(-2 * time.Hour).Format(time.RFC3339)}
+
+	p.doWork()
+	clock.Add(p.checkIntervalDuration())
+	p.doWork()
+	if got := len(tc.probes); got != 1 || p.StaleSkips() != 1 {
+		t.Fatalf("expected the stale peer checked once and skipped once, got %v probes, %v skips", got, p.StaleSkips())
+	}
+	clock.Add(time.Minute)
+	p.doWork()
+	if got := len(tc.probes); got != 2 {
+		t.Fatalf("expected the stale peer checked again after the stale interval, got %v probes", got)
+	}
+
+	p.container.Labels["updated"] = strconv.FormatInt(clock.Now().Unix(), 10)
+	clock.Add(p.checkIntervalDuration())
+	p.doWork()
+	if got := len(tc.probes); got != 3 || p.Stale() {
+		t.Fatalf("expected the updated peer checked as usual, got %v probes, stale=%v", got, p.Stale())
+	}
+}
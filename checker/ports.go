@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+const (
+	// ModePorts checks a peer by establishing a TCP connection to
+	// each of PeerConfig.Ports, for the services whose health is a
+	// set of listening ports
+	ModePorts = "ports"
+
+	// PortsAll reports a peer checked in ModePorts reachable when
+	// all its ports are open
+	PortsAll = "all"
+	// PortsAny reports a peer checked in ModePorts reachable when
+	// any of its ports is open
+	PortsAny = "any"
+)
+
+// portsChecker is implemented by the Checkers telling which
+// of the ports of the peer are open
+type portsChecker interface {
+	CheckPorts(probe Probe) (bool, map[int]bool, error)
+}
+
+type tcpPortsChecker struct{}
+
+func (c tcpPortsChecker) Check(probe Probe) (bool, error) {
+	ok, _, err := c.CheckPorts(probe)
+	return ok, err
+}
+
+// CheckPorts dials all the ports of the probe at the same time, on the
+// host of its Address
+func (tcpPortsChecker) CheckPorts(probe Probe) (bool, map[int]bool, error) {
+	if len(probe.Ports) == 0 {
+		return false, nil, &utils.CheckError{
+			Reason: utils.FailureOther,
+			Err:    fmt.Errorf("no ports configured for mode %v", ModePorts),
+		}
+	}
+	host, _, err := net.SplitHostPort(probe.Address)
+	if err != nil {
+		host = probe.Address
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	open := make(map[int]bool, len(probe.Ports))
+	errs := make(map[int]error)
+	for _, port := range probe.Ports {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			ok, err := utils.IsTCPReachable(net.JoinHostPort(host, strconv.Itoa(port)), probe.Options)
+			mu.Lock()
+			defer mu.Unlock()
+			open[port] = ok
+			if err != nil {
+				errs[port] = err
+			}
+		}(port)
+	}
+	wg.Wait()
+
+	var closed []int
+	for port, ok := range open {
+		if !ok {
+			closed = append(closed, port)
+		}
+	}
+	sort.Ints(closed)
+	if len(closed) == 0 || (probe.AnyPort && len(closed) < len(probe.Ports)) {
+		return true, open, nil
+	}
+	return false, open, &utils.CheckError{
+		Reason: utils.ReasonOf(errs[closed[0]]),
+		Err:    fmt.Errorf("ports %v are closed, port %v: %v", closed, closed[0], errs[closed[0]]),
+	}
+}
+
+// PortResults returns which of the ports of a peer checked in
+// ModePorts were open on its last check, nil in the other modes
+func (p *Peer) PortResults() map[int]bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.portResultsCopy()
+}
+
+// portResultsCopy must be called with the lock held
+func (p *Peer) portResultsCopy() map[int]bool {
+	if p.portResults == nil {
+		return nil
+	}
+	results := make(map[int]bool, len(p.portResults))
+	for port, ok := range p.portResults {
+		results[port] = ok
+	}
+	return results
+}
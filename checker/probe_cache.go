@@ -0,0 +1,129 @@
+package checker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// probeCache shares the results of the probes of a same endpoint done
+// within its TTL, so that the peers sharing an endpoint, e.g. the one
+// of their host, probe it once. A probe started while another one of
+// the same endpoint is running waits for its result.
+type probeCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]*probeCacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+type probeCacheEntry struct {
+	done   chan struct{}
+	at     time.Time
+	result checkResult
+}
+
+func newProbeCache(ttl time.Duration) *probeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &probeCache{ttl: ttl, entries: make(map[string]*probeCacheEntry)}
+}
+
+// probeCacheKey returns the key of the probe in the cache, the whole
+// probe but what is unique to a check, as the result of a probe tells
+// nothing about another one differing in what is expected, how it's
+// sent or where from
+func probeCacheKey(mode string, probe Probe) string {
+	probe.Options.Nonce, probe.Options.CorrelationID = "", ""
+	probe.Context = nil
+	return fmt.Sprintf("%v://%+v", mode, probe)
+}
+
+// do returns the result of a probe of the endpoint done within the
+// TTL, calling probe when there is none. A probe panicking is a
+// failure for the ones waiting for it, the panic going on up.
+func (c *probeCache) do(key string, probe func() checkResult) checkResult {
+	if c == nil {
+		return probe()
+	}
+	c.Lock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if !e.at.IsZero() && now.Sub(e.at) > c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	if e, ok := c.entries[key]; ok {
+		c.hits++
+		c.Unlock()
+		<-e.done
+		return e.result
+	}
+	c.misses++
+	e := &probeCacheEntry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.Unlock()
+
+	defer func() {
+		r := recover()
+		if r != nil {
+			e.result = checkResult{err: &utils.CheckError{
+				Reason: utils.FailureOther,
+				Err:    fmt.Errorf("probe panicked: %v", r),
+			}}
+		}
+		c.Lock()
+		e.at = time.Now()
+		c.Unlock()
+		close(e.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	e.result = probe()
+	return e.result
+}
+
+// cachedProbe does the probe through the probe cache, if any. A probe
+// carrying a nonce can't be answered for another peer, so it's always
+// done.
+func (p *Peer) cachedProbe(mode string, probe Probe, do func() checkResult) checkResult {
+	if probe.Options.Nonce != "" {
+		return do()
+	}
+	return p.probeCache.do(probeCacheKey(mode, probe), do)
+}
+
+// cachedCheck does the check through the probe cache, see cachedProbe
+func (p *Peer) cachedCheck(mode string, checker Checker, probe Probe) (bool, error) {
+	r := p.cachedProbe(mode, probe, func() checkResult {
+		var r checkResult
+		r.ok, r.err = checker.Check(probe)
+		return r
+	})
+	return r.ok, r.err
+}
+
+// hitRate returns the fraction of the probes answered from the cache
+func (c *probeCache) hitRate() float64 {
+	if c == nil {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.hits+c.misses == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(c.hits+c.misses)
+}
+
+// ProbeCacheHitRate returns the fraction of the probes whose result
+// was shared with another peer probing the same endpoint, see
+// Config.ProbeCacheTTL
+func (pw *PeersWatcher) ProbeCacheHitRate() float64 {
+	return pw.probeCache.hitRate()
+}
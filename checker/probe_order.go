@@ -0,0 +1,75 @@
+package checker
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// ProbeOrderInterleaved checks the peers checked together
+	// alternating between their hosts, so that consecutive checks
+	// target different hosts where possible
+	ProbeOrderInterleaved = "interleaved"
+	// ProbeOrderListed checks the peers checked together in the
+	// order they're found in
+	ProbeOrderListed = "listed"
+)
+
+// orderProbes returns the peers in the order they're to be checked
+// when checked together, see Config.ProbeOrder
+func (pw *PeersWatcher) orderProbes(peers []*Peer) []*Peer {
+	if pw.config.ProbeOrder == ProbeOrderListed {
+		return peers
+	}
+	return interleaveByHost(peers)
+}
+
+// spreadProbes offsets the checks driven by the scheduler of the
+// peers, ordered to be checked together, so that they keep falling
+// due in that order: the i-th of n peers waits for i/n of its interval
+// more after its first check. ProbeOrderListed leaves them as they are.
+func (pw *PeersWatcher) spreadProbes(peers []*Peer) {
+	if pw.config.ProbeOrder == ProbeOrderListed {
+		return
+	}
+	for i, aPeer := range peers {
+		aPeer.Lock()
+		aPeer.phase = aPeer.checkIntervalDuration() * time.Duration(i) / time.Duration(len(peers))
+		aPeer.Unlock()
+	}
+}
+
+// hostConcurrencySink is implemented by the MetricsSinks exporting
+// the most checks run at the same time against a host, the value
+// being read when exported
+type hostConcurrencySink interface {
+	SetMaxHostConcurrency(max func() int)
+}
+
+// interleaveByHost orders the peers taking one of each host in turn,
+// keeping the order of the peers of a same host
+func interleaveByHost(peers []*Peer) []*Peer {
+	byHost := make(map[string][]*Peer)
+	var hosts []string
+	for _, aPeer := range peers {
+		aPeer.Lock()
+		host := aPeer.getHostIP()
+		aPeer.Unlock()
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], aPeer)
+	}
+	sort.Strings(hosts)
+
+	ordered := make([]*Peer, 0, len(peers))
+	for len(ordered) < len(peers) {
+		for _, host := range hosts {
+			if queue := byHost[host]; len(queue) > 0 {
+				ordered = append(ordered, queue[0])
+				byHost[host] = queue[1:]
+			}
+		}
+	}
+	return ordered
+}
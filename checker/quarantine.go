@@ -0,0 +1,58 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// Quarantine silences the transitions of the peer with the given
+// uuid until the given time. The peer keeps being checked, but its
+// transitions are neither logged nor notified, and it's reported as
+// quarantined in the status.
+func (pw *PeersWatcher) Quarantine(uuid string, until time.Time) {
+	log.Infof("PeersWatcher: quarantining peer %v until %v", uuid, until)
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	if pw.quarantines == nil {
+		pw.quarantines = make(map[string]time.Time)
+	}
+	pw.quarantines[uuid] = until
+	peers := pw.peersWithUUID(uuid)
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.setQuarantinedUntil(until)
+	}
+}
+
+// Unquarantine lifts the quarantine of the peer with the given uuid
+func (pw *PeersWatcher) Unquarantine(uuid string) {
+	log.Infof("PeersWatcher: lifting quarantine of peer %v", uuid)
+	pw.settingsMu.Lock()
+	defer pw.settingsMu.Unlock()
+	pw.Lock()
+	delete(pw.quarantines, uuid)
+	peers := pw.peersWithUUID(uuid)
+	pw.Unlock()
+	for _, aPeer := range peers {
+		aPeer.setQuarantinedUntil(time.Time{})
+	}
+}
+
+func (p *Peer) setQuarantinedUntil(until time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	p.quarantinedUntil = until
+}
+
+func (p *Peer) isQuarantined() bool {
+	return p.now().Before(p.quarantinedUntil)
+}
+
+// Quarantined informs if the transitions of the peer are silenced
+func (p *Peer) Quarantined() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.isQuarantined()
+}
@@ -0,0 +1,77 @@
+package checker
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultQuorumFraction = 0.5
+)
+
+// QuorumReachable informs if the fraction of reachable peers, among
+// the considered, enabled and selected ones, is at least the given
+// fraction. Fixed targets are not counted. When no peer is
+// considered, the result is the QuorumWithoutPeers setting. The
+// checks in flight are not waited for, their peers count with the
+// status published by the last check.
+func (pw *PeersWatcher) QuorumReachable(fraction float64) bool {
+	pw.Lock()
+	peers := make([]*Peer, 0, len(pw.peers))
+	for _, aPeer := range pw.peers {
+		peers = append(peers, aPeer)
+	}
+	withoutPeers := pw.config.QuorumWithoutPeers
+	pw.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, aPeer := range peers {
+		statuses = append(statuses, aPeer.lastStatus())
+	}
+	reachable, considered := reachableFraction(statuses)
+	if considered == 0 {
+		return withoutPeers
+	}
+	return reachable >= fraction
+}
+
+// reachableFraction returns the fraction of reachable peers among
+// the considered, enabled and selected ones of the given statuses,
+// along with how many of them there are
+func reachableFraction(statuses []PeerStatus) (float64, int) {
+	considered, reachable := 0, 0
+	for _, status := range statuses {
+		if !status.Considered || !status.Enabled || !status.Selected {
+			continue
+		}
+		considered++
+		if status.Reachable {
+			reachable++
+		}
+	}
+	if considered == 0 {
+		return 0, 0
+	}
+	return float64(reachable) / float64(considered), considered
+}
+
+// quorumHandler reports if the fraction given by the fraction query
+// parameter, a majority by default, of the peers is reachable
+func (pw *PeersWatcher) quorumHandler(w http.ResponseWriter, r *http.Request) {
+	fraction := defaultQuorumFraction
+	if v := r.URL.Query().Get("fraction"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			http.Error(w, "invalid fraction: "+v, http.StatusBadRequest)
+			return
+		}
+		fraction = f
+	}
+
+	if pw.QuorumReachable(fraction) {
+		w.Write([]byte("OK"))
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("NOT OK"))
+	}
+}
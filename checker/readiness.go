@@ -0,0 +1,77 @@
+package checker
+
+const (
+	// ReadinessReport keeps the reachability of a peer the one of its
+	// liveness, its readiness being reported on its own
+	ReadinessReport = "report"
+	// ReadinessRequire requires a peer to be ready for it to be
+	// reachable
+	ReadinessRequire = "require"
+)
+
+// readinessCheckEnabled informs if the readiness of the peer is
+// probed along with its liveness, it must be called with the lock
+// held
+func (p *Peer) readinessCheckEnabled() bool {
+	return p.config.ReadinessPath != "" && p.target == nil && p.mode() == ModeHTTP
+}
+
+// healthProbe returns the probe of the liveness or readiness endpoint
+// of the peer at path: up on any 2xx status, or on HealthExpected when
+// set, rather than on the answer of the ping endpoint
+func (p *Peer) healthProbe(probe Probe, path string) Probe {
+	probe.Path = path
+	probe.Expected = p.config.HealthExpected
+	probe.Options.StatusOnly = p.config.HealthExpected == ""
+	return probe
+}
+
+// checkReadiness probes the ReadinessPath of the peer once its
+// liveness was, and returns the reachability of the peer combining
+// both of them as asked by PeerConfig.ReadinessPolicy. A peer which
+// isn't live isn't ready either. It must be called with the lock
+// held.
+func (p *Peer) checkReadiness(checker Checker, probe Probe, live bool, liveErr error) (bool, error) {
+	p.live = live
+	if !p.readinessCheckEnabled() {
+		p.ready = live
+		return live, liveErr
+	}
+	ready, err := live, liveErr
+	if live {
+		probe = p.healthProbe(probe, p.config.ReadinessPath)
+		release := p.limiter.acquire(p.getHostIP())
+		ready, err = checker.Check(probe)
+		release()
+	}
+	if ready != p.ready {
+		if ready {
+			p.logger.Infof("Peer(%v, %v, %v): became ready", p.uuid, p.getHostIP(), p.getIP())
+		} else {
+			p.logger.Errorf("Peer(%v, %v, %v): became not ready (%v)", p.uuid, p.getHostIP(), p.getIP(), err)
+		}
+	}
+	p.ready = ready
+
+	if p.config.ReadinessPolicy == ReadinessRequire {
+		return ready, err
+	}
+	return live, liveErr
+}
+
+// Live informs if the peer answered on its liveness endpoint on its
+// last check, see PeerConfig.LivenessPath
+func (p *Peer) Live() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.live
+}
+
+// Ready informs if the peer answered on its readiness endpoint on its
+// last check, see PeerConfig.ReadinessPath. It's the same as Live
+// when the readiness isn't checked.
+func (p *Peer) Ready() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.ready
+}
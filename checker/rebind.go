@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/go-rancher-metadata/metadata"
+	"github.com/rancher/log"
+)
+
+// Rebind moves the peer to a new container, e.g. when its container
+// was recreated by a deploy. The reachability history is kept unless
+// PeerConfig.ResetCountOnRebind is set, the peer then going down
+// until the new container is checked reachable, and the next check
+// probes the new container.
+func (p *Peer) Rebind(newContainer *metadata.Container) {
+	p.Lock()
+	defer p.Unlock()
+	p.logger.Infof("Peer(%v): rebinding to container %v (%v)", p.uuid, newContainer.UUID, newContainer.PrimaryIp)
+	if p.config.ResetCountOnRebind {
+		p.count = 0
+		p.consecutiveSuccesses = 0
+		p.consecutiveFailures = 0
+		p.downSince = time.Time{}
+		p.downCause = ""
+		// The old container being gone, it's reported down
+		if p.reportedReachable {
+			p.reportedReachable = false
+			p.transition(false)
+		}
+	}
+	p.uuid = newContainer.UUID
+	p.container = newContainer
+	p.resetInterval()
+}
+
+// recreatedPeer returns the peer whose container was replaced by the
+// given one, i.e. the peer on the same host whose container is gone
+// from metadata, nil when there is none. It must be called with the
+// lock held, while the peers still in pw.peers are unmatched.
+func (pw *PeersWatcher) recreatedPeer(mdInfo *mdInfo, container *metadata.Container) *Peer {
+	for uuid, aPeer := range pw.peers {
+		if _, stillThere := mdInfo.peerContainersMap[uuid]; stillThere {
+			continue
+		}
+		if aPeer.container != nil && aPeer.container.HostUUID == container.HostUUID {
+			return aPeer
+		}
+	}
+	return nil
+}
+
+// rebindPeer moves a peer to the container recreating its container,
+// it must be called with the lock held
+func (pw *PeersWatcher) rebindPeer(aPeer *Peer, container *metadata.Container) {
+	log.Infof("peer container %v recreated as %v", aPeer.uuid, container.UUID)
+	oldUUID := aPeer.uuid
+	delete(pw.peers, oldUUID)
+	pw.releaseSeed(aPeer)
+	aPeer.Rebind(container)
+	pw.rekeyPeer(oldUUID, container.UUID)
+	if pw.seeds != nil {
+		pw.seeds[aPeer.seed] = aPeer.uuid
+	}
+}
+
+// rekeyPeer moves what is kept by uuid of a rebound peer to its new
+// uuid, its metrics starting over under the new one. It must be called
+// with the lock held.
+func (pw *PeersWatcher) rekeyPeer(from, to string) {
+	if until, found := pw.quarantines[from]; found {
+		delete(pw.quarantines, from)
+		pw.quarantines[to] = until
+	}
+	if pw.disabled[from] {
+		delete(pw.disabled, from)
+		pw.disabled[to] = true
+	}
+	if pw.debugPeers[from] {
+		delete(pw.debugPeers, from)
+		pw.debugPeers[to] = true
+	}
+	if reasons, found := pw.ignoredReasons[from]; found {
+		delete(pw.ignoredReasons, from)
+		pw.ignoredReasons[to] = reasons
+	}
+	if d, found := pw.maxLatencies[from]; found {
+		delete(pw.maxLatencies, from)
+		pw.maxLatencies[to] = d
+	}
+	pw.churn.rekey(from, to)
+	pw.forgetMetrics(from)
+}
@@ -0,0 +1,69 @@
+package checker
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+const (
+	// DefaultRecheckInterval is the default minimum interval, in
+	// milliseconds, between two rechecks requested through /recheck
+	DefaultRecheckInterval = 10000
+)
+
+// CheckAllNow checks right away all the considered peers and
+// targets, concurrently within the limits of concurrency, and
+// returns their resulting status
+func (pw *PeersWatcher) CheckAllNow() []PeerStatus {
+	pw.Lock()
+	peers := pw.allPeers()
+	pw.Unlock()
+
+	var wg sync.WaitGroup
+	for _, aPeer := range pw.orderProbes(peers) {
+		if !aPeer.Consider() {
+			continue
+		}
+		wg.Add(1)
+		go func(aPeer *Peer) {
+			defer wg.Done()
+			aPeer.CheckNow()
+		}(aPeer)
+	}
+	wg.Wait()
+
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, aPeer := range peers {
+		statuses = append(statuses, aPeer.Status())
+	}
+	return statuses
+}
+
+// recheckHandler rechecks all the peers, at most once per
+// RecheckInterval, and reports their status
+func (pw *PeersWatcher) recheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	interval := pw.config.RecheckInterval
+	if interval <= 0 {
+		interval = DefaultRecheckInterval
+	}
+	now := clockOrReal(pw.config.Clock).Now()
+	pw.Lock()
+	if !pw.lastRecheck.IsZero() && now.Sub(pw.lastRecheck) < time.Duration(interval)*time.Millisecond {
+		pw.Unlock()
+		http.Error(w, "recheck already requested recently", http.StatusTooManyRequests)
+		return
+	}
+	pw.lastRecheck = now
+	pw.Unlock()
+
+	log.Infof("PeersWatcher: rechecking all the peers")
+	writeJSON(w, pw.CheckAllNow())
+}
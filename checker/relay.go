@@ -0,0 +1,164 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+const (
+	// maxRelayTimeout bounds, in milliseconds, how long the relay
+	// spends on the check of the target
+	maxRelayTimeout = 10000
+)
+
+// RelayResult is what PeerConfig.RelayAddress, another peer asked to
+// check a peer on behalf of the checking node, got on the last check.
+// It's kept apart from the reachability of the peer, checked directly
+// as well, since a failure may be the one of the path to the relay.
+type RelayResult struct {
+	Via string `json:"via"`
+	// Answered is false when the relay itself couldn't be asked, the
+	// other fields being left empty then
+	Answered  bool                `json:"answered"`
+	Reachable bool                `json:"reachable"`
+	Reason    utils.FailureReason `json:"reason,omitempty"`
+	LatencyMs float64             `json:"latencyMs,omitempty"`
+}
+
+// checkRelayed asks probe.Relay to check probe.Address, and returns
+// what it answered
+func checkRelayed(probe Probe) (bool, RelayResult, error) {
+	result := RelayResult{Via: probe.Relay}
+	resp, err := utils.RequestRelay(probe.Relay, probe.Address, probe.Options)
+	if err != nil {
+		return false, result, err
+	}
+	result.Answered, result.Reachable = true, resp.Reachable
+	result.Reason, result.LatencyMs = resp.Reason, resp.LatencyMs
+	if !resp.Reachable {
+		return false, result, &utils.CheckError{
+			Reason: resp.Reason,
+			Err:    fmt.Errorf("relayed by %v: %v", probe.Relay, resp.Error),
+		}
+	}
+	return true, result, nil
+}
+
+// relayCheckEnabled informs if the peer is checked through
+// RelayAddress too, along with its direct check, it must be called
+// with the lock held
+func (p *Peer) relayCheckEnabled() bool {
+	return p.config.RelayAddress != "" && p.target == nil && p.mode() == ModeHTTP
+}
+
+// checkRelay asks RelayAddress to check the peer, once it was checked
+// directly, and records what it answered. The reachability of the peer
+// stays the one of the direct check. It must be called with the lock
+// held.
+func (p *Peer) checkRelay(probe Probe) {
+	if !p.relayCheckEnabled() {
+		p.relayResult = nil
+		return
+	}
+	probe.Relay = p.config.RelayAddress
+	release := p.limiter.acquire(p.getHostIP())
+	_, result, err := checkRelayed(probe)
+	release()
+	if !result.Answered {
+		p.debugf("Peer(%v, %v, %v): relay %v: %v", p.uuid, p.getHostIP(), p.getIP(), probe.Relay, err)
+	}
+	p.relayResult = &result
+}
+
+// relayProbe returns the probe of the target checked on behalf of the
+// node asking, as this node checks its peers: the liveness endpoint of
+// the peer when configured, its ping endpoint otherwise. It returns
+// false when the target isn't a peer, the relay not being meant to
+// reach anything else.
+func (pw *PeersWatcher) relayProbe(target string, timeout int) (Probe, bool) {
+	ip, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return Probe{}, false
+	}
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.peersMapByIP[ip] == nil {
+		return Probe{}, false
+	}
+	// Each relayed check connects anew, as the checks of the node
+	// asking would, rather than reuse the connection of the last one
+	probe := Probe{
+		Address:  target,
+		Path:     defaultCheckPath,
+		Expected: expectedResponse,
+		Options:  utils.Options{Timeout: timeout, DisableKeepAlives: true},
+	}
+	if path := pw.config.LivenessPath; path != "" {
+		probe.Path = path
+		probe.Expected = pw.config.HealthExpected
+		probe.Options.StatusOnly = pw.config.HealthExpected == ""
+	}
+	return probe, true
+}
+
+// relayHandler checks a peer on behalf of the node asking, always
+// answering a RelayResponse unless the request is malformed so that it
+// can tell a failure of the target from one of the relay. Only the
+// peers of this node can be targeted.
+func (pw *PeersWatcher) relayHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		http.Error(w, fmt.Sprintf("invalid target %q: %v", target, err), http.StatusBadRequest)
+		return
+	}
+	timeout := DefaultPeerConnectionTimeoutInterval
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", v), http.StatusBadRequest)
+			return
+		}
+		timeout = n
+	}
+	if timeout > maxRelayTimeout {
+		timeout = maxRelayTimeout
+	}
+	probe, known := pw.relayProbe(target, timeout)
+	if !known {
+		http.Error(w, fmt.Sprintf("target %v isn't a peer", target), http.StatusForbidden)
+		return
+	}
+	log.Debugf("relaying a check of %v for %v", target, getSourceIP(r))
+
+	start := time.Now()
+	ok, err := utils.IsReachableWithOptions(fmt.Sprintf("http://%v%v", probe.Address, probe.Path), probe.Expected, probe.Options)
+	resp := utils.RelayResponse{
+		Reachable: ok,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if !ok {
+		resp.Reason = utils.ReasonOf(err)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// RelayResult returns what the relay answered on the last check of the
+// peer, false when not checked through PeerConfig.RelayAddress or
+// before the first check
+func (p *Peer) RelayResult() (RelayResult, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if p.relayResult == nil {
+		return RelayResult{}, false
+	}
+	return *p.relayResult, true
+}
@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/rancher/go-rancher-metadata/metadata"
+	"github.com/rancher/log"
+)
+
+// Ingest replaces the peers of a replica, see Config.Replica, with the
+// statuses read from r. The format is the one of WriteSnapshot, a JSON
+// array of PeerStatus, as written by the instance doing the checks.
+func (pw *PeersWatcher) Ingest(r io.Reader) error {
+	var statuses []PeerStatus
+	if err := json.NewDecoder(r).Decode(&statuses); err != nil {
+		return err
+	}
+	pw.IngestSnapshot(statuses)
+	return nil
+}
+
+// IngestFile is Ingest reading the snapshot written to the file at
+// path, see WriteSnapshot
+func (pw *PeersWatcher) IngestFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pw.Ingest(f)
+}
+
+// IngestSnapshot replaces the peers of a replica with the given
+// statuses, reported as they are by the status and summary APIs.
+// The connectivity state is the one they tell.
+func (pw *PeersWatcher) IngestSnapshot(statuses []PeerStatus) {
+	pw.Lock()
+	defer pw.Unlock()
+	peers := make(map[string]*Peer, len(statuses))
+	peersByIP := make(map[string]*Peer, len(statuses))
+	ok := true
+	for _, status := range statuses {
+		aPeer, found := pw.peers[status.UUID]
+		if !found {
+			aPeer = pw.newPeer(status.UUID)
+		}
+		aPeer.setReplicaStatus(status)
+		peers[status.UUID] = aPeer
+		peersByIP[status.IP] = aPeer
+		if status.Considered && status.Enabled && status.Selected && !status.Reachable {
+			ok = false
+		}
+	}
+	log.Debugf("PeersWatcher: ingested the statuses of %v peers", len(statuses))
+	pw.peers = peers
+	pw.peersMapByIP = peersByIP
+	if ok == pw.ok {
+		pw.okRounds++
+	} else {
+		pw.okRounds = 0
+	}
+	pw.ok = ok
+	pw.updateNetworkHealth()
+}
+
+// setReplicaStatus makes the peer report the given status, it must
+// be called with the lock of the watcher held
+func (p *Peer) setReplicaStatus(status PeerStatus) {
+	p.Lock()
+	defer p.Unlock()
+	p.replicaStatus = &status
+	p.container = &metadata.Container{UUID: status.UUID, PrimaryIp: status.IP, Labels: status.Labels}
+	p.host = &metadata.Host{AgentIP: status.HostIP}
+	p.count = status.Count
+	p.reportedReachable = status.Reachable
+	p.failureReason = status.FailureReason
+	p.lastChecked = status.LastChecked
+	p.disabled = !status.Enabled
+	p.unselected = !status.Selected
+}
+
+// startReplica is the Start of a replica, the peers aren't checked
+func (pw *PeersWatcher) startReplica(ctx context.Context, errs Errors) error {
+	log.Infof("PeersWatcher: replica, only reporting the ingested statuses")
+	pw.Lock()
+	pw.started = true
+	pw.Unlock()
+	pw.lifecycle.goRun("watcher loop", pw.runReplica)
+	// Not tracked, it's the one calling Stop once ctx is done
+	go func() {
+		select {
+		case <-ctx.Done():
+			pw.Stop()
+		case <-pw.exit:
+		}
+	}()
+	return errs.errOrNil()
+}
+
+// runReplica is the Run of a replica: it never checks the peers, it
+// ingests the snapshot at Config.ReplicaSnapshot, if any, every
+// CheckInterval
+func (pw *PeersWatcher) runReplica() {
+	defer close(pw.runDone)
+	for {
+		if path := pw.config.ReplicaSnapshot; path != "" {
+			if err := pw.IngestFile(path); err != nil {
+				log.Errorf("PeersWatcher: error ingesting snapshot %v: %v", path, err)
+			}
+			pw.fireEvents()
+		}
+
+		select {
+		case <-pw.exit:
+			log.Infof("PeersWatcher: stopped")
+			return
+		case <-clockOrReal(pw.config.Clock).After(pw.checkInterval()):
+		}
+	}
+}
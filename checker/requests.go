@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// requestsPerCheck returns how many requests a check of the peer
+// sends, see PeerConfig.RequestsPerCheck, 1 in the modes not working
+// over HTTP. It must be called with the lock held.
+func (p *Peer) requestsPerCheck() int {
+	switch p.mode() {
+	case ModeHTTP, ModeHTTPS, ModeUnix, ModeHTTP3:
+	default:
+		return 1
+	}
+	if p.config.RequestsPerCheck <= 1 {
+		return 1
+	}
+	return p.config.RequestsPerCheck
+}
+
+// runRequests does the check as RequestsPerCheck requests, of which
+// RequestsMinSuccesses are to succeed, RequestsConcurrency at a time.
+// The first request runs in the slot of the limiter the check holds,
+// the others only in the slots free right away, so that the bound of
+// concurrent checks holds. The requests not sent by the time the next
+// check is due count as failed. It's just runCheck for a single
+// request. It must be called with the lock held.
+func (p *Peer) runRequests(checker Checker, probe Probe) checkResult {
+	n := p.requestsPerCheck()
+	if n == 1 || p.failureInjected() {
+		return p.runCheck(checker, probe)
+	}
+	min := p.config.RequestsMinSuccesses
+	if min <= 0 || min > n {
+		min = n
+	}
+	concurrency := p.config.RequestsConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	deadline := p.checkDeadline()
+	mode, hostIP := p.mode(), p.getHostIP()
+
+	// The releases of the slots the requests run in, the one of the
+	// check being released by the caller
+	slots := make(chan func(), concurrency)
+	slots <- func() {}
+	held := 1
+	results := make(chan checkResult, n)
+	var wg sync.WaitGroup
+	sent := 0
+	for ; sent < n; sent++ {
+		if held < concurrency {
+			if release, ok := p.limiter.tryAcquire(hostIP); ok {
+				slots <- release
+				held++
+			}
+		}
+		release := <-slots
+		if sent > 0 && !time.Now().Before(deadline) {
+			slots <- release
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- p.probeOnce(mode, checker, probe)
+			slots <- release
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for ; held > 0; held-- {
+		release := <-slots
+		release()
+	}
+
+	var lastErr error
+	var latency time.Duration
+	succeeded := 0
+	for r := range results {
+		r.ok, r.err = p.capLatency(r.ok, r.err, r.latency)
+		p.recordResult(checker, r)
+		latency += r.latency
+		if r.ok {
+			succeeded++
+		} else {
+			lastErr = r.err
+		}
+	}
+	ratio := float64(succeeded) / float64(n)
+	p.requestSuccessRatio = &ratio
+	result := checkResult{latency: latency / time.Duration(sent)}
+	if succeeded >= min {
+		result.ok = true
+		return result
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("out of time after %v requests", sent)
+	}
+	result.err = &utils.CheckError{
+		Reason: utils.ReasonOf(lastErr),
+		Err:    fmt.Errorf("%v of %v requests succeeded, %v needed: %v", succeeded, n, min, lastErr),
+	}
+	return result
+}
+
+// RequestSuccessRatio returns the fraction of the requests of the last
+// check that succeeded, see PeerConfig.RequestsPerCheck. It's false
+// until a check sent several requests.
+func (p *Peer) RequestSuccessRatio() (float64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if p.requestSuccessRatio == nil {
+		return 0, false
+	}
+	return *p.requestSuccessRatio, true
+}
@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resultsBuffer is the number of transitions a subscriber of the
+// results stream can lag behind before they're dropped for it
+const resultsBuffer = 64
+
+// resultsStream fans the transitions of the peers out to the
+// subscribers of ResultsService.StreamResults, never blocking the
+// peers: a subscriber lagging behind misses the transitions
+type resultsStream struct {
+	sync.Mutex
+	subscribers map[int]chan Event
+	next        int
+	dropped     uint64
+}
+
+func newResultsStream() *resultsStream {
+	return &resultsStream{subscribers: make(map[int]chan Event)}
+}
+
+// subscribe returns the channel of the transitions to come, and the
+// function to call once done with it
+func (s *resultsStream) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, resultsBuffer)
+	s.Lock()
+	id := s.next
+	s.next++
+	s.subscribers[id] = ch
+	s.Unlock()
+	return ch, func() {
+		s.Lock()
+		delete(s.subscribers, id)
+		s.Unlock()
+	}
+}
+
+// publish hands the transition to the subscribers, a nil
+// resultsStream discards it
+func (s *resultsStream) publish(e Event) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// ResultsService streams the transitions of the peers and returns
+// their snapshot to the programs embedding the checker, on top of the
+// event stream and the snapshot of the watcher. It's only the
+// in-process side of the service: the opt-in gRPC server exposing
+// StreamResults and GetSnapshot on a port of its own isn't
+// implemented, grpc not being vendored in this tree.
+type ResultsService struct {
+	pw *PeersWatcher
+}
+
+// ResultsService returns the implementation of the Results service
+// of the watcher
+func (pw *PeersWatcher) ResultsService() *ResultsService {
+	return &ResultsService{pw: pw}
+}
+
+// StreamResults sends the transitions of the peers as they happen
+// until the context is done or send fails, returning the error of
+// either. It first replays the ones kept by the event log that
+// happened after since, unless it's zero.
+func (s *ResultsService) StreamResults(ctx context.Context, since time.Time, send func(Event) error) error {
+	events, unsubscribe := s.pw.results.subscribe()
+	defer unsubscribe()
+
+	// The transitions happening while replaying arrive on both
+	replayed := make(map[Event]bool)
+	if !since.IsZero() {
+		for _, e := range s.pw.Events() {
+			if !e.Time.After(since) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+			replayed[e] = true
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-events:
+			if replayed[e] {
+				delete(replayed, e)
+				continue
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetSnapshot returns the current status of all the peers
+func (s *ResultsService) GetSnapshot() []PeerStatus {
+	return s.pw.Snapshot()
+}
+
+// Dropped returns the number of transitions the subscribers of
+// StreamResults missed, lagging behind
+func (s *ResultsService) Dropped() uint64 {
+	return atomic.LoadUint64(&s.pw.results.dropped)
+}
@@ -0,0 +1,79 @@
+package checker
+
+import (
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// retry checks the peer again after a failed check as long as its
+// retry budget allows, see PeerConfig.RetryBudget, and returns the
+// result of the last attempt. Each attempt is a whole check, all its
+// RequestsPerCheck requests. The injected failures aren't retried.
+// It must be called with the lock held.
+func (p *Peer) retry(checker Checker, probe Probe, r checkResult) checkResult {
+	for !r.ok && utils.ReasonOf(r.err) != utils.FailureInjected && p.takeRetryToken() {
+		p.debugf("Peer(%v, %v, %v): retrying failed check (%v), %.1f retries left", p.uuid, p.getHostIP(), p.getIP(), r.err, p.retryTokens)
+		r = p.runRequests(checker, probe)
+	}
+	return r
+}
+
+// retryRefill returns how many retries are added back to the budget
+// per minute, RetryBudget unless configured otherwise
+func (p *Peer) retryRefill() float64 {
+	if p.config.RetryRefill > 0 {
+		return p.config.RetryRefill
+	}
+	return float64(p.config.RetryBudget)
+}
+
+// refillRetryTokens adds back to the budget the retries earned since
+// its last refill, it must be called with the lock held
+func (p *Peer) refillRetryTokens() {
+	now := p.now()
+	budget := float64(p.config.RetryBudget)
+	if p.retryRefilledAt.IsZero() {
+		p.retryTokens = budget
+	} else if elapsed := now.Sub(p.retryRefilledAt); elapsed > 0 {
+		p.retryTokens += elapsed.Minutes() * p.retryRefill()
+	}
+	if p.retryTokens > budget {
+		p.retryTokens = budget
+	}
+	p.retryRefilledAt = now
+}
+
+// takeRetryToken informs if a failed check can be retried, using one
+// retry of the budget. It must be called with the lock held.
+func (p *Peer) takeRetryToken() bool {
+	if p.config.RetryBudget <= 0 {
+		return false
+	}
+	p.refillRetryTokens()
+	if p.retryTokens < 1 {
+		return false
+	}
+	p.retryTokens--
+	return true
+}
+
+// retriesLeft returns the retries left in the budget, nil when the
+// failed checks aren't retried. It must be called with the lock held.
+func (p *Peer) retriesLeft() *float64 {
+	if p.config.RetryBudget <= 0 {
+		return nil
+	}
+	p.refillRetryTokens()
+	left := p.retryTokens
+	return &left
+}
+
+// RetriesLeft returns the retries left in the budget of the peer, see
+// PeerConfig.RetryBudget, 0 when the failed checks aren't retried
+func (p *Peer) RetriesLeft() float64 {
+	p.Lock()
+	defer p.Unlock()
+	if left := p.retriesLeft(); left != nil {
+		return *left
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// reverseDNSTTL is how long the PTR of a peer is cached, a failed
+// lookup being retried as late
+const reverseDNSTTL = 10 * time.Minute
+
+// ReverseDNS returns the name the PrimaryIp of the peer resolves back
+// to, for readable reports, or "" until it's resolved or when it has
+// none. It's resolved in the background on the first call and cached.
+func (p *Peer) ReverseDNS() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.reverseDNSName()
+}
+
+// reverseDNSName returns the cached PTR of the current IP of the peer,
+// starting its lookup when it's missing or stale. It must be called
+// with the lock held.
+func (p *Peer) reverseDNSName() string {
+	ip := p.getIP()
+	if ip == "" {
+		return ""
+	}
+	stale := p.reverseDNSIP != ip || p.now().Sub(p.reverseDNSAt) > reverseDNSTTL
+	if stale && !p.reverseDNSPending {
+		p.reverseDNSPending = true
+		timeout := time.Duration(p.config.ConnectionTimeout) * time.Millisecond
+		p.goRun("reverse DNS lookup", func() { p.resolveReverseDNS(ip, timeout) })
+	}
+	if p.reverseDNSIP != ip {
+		return ""
+	}
+	return p.reverseDNS
+}
+
+// resolveReverseDNS looks up the PTR of ip within timeout, if any,
+// without the lock held
+func (p *Peer) resolveReverseDNS(ip string, timeout time.Duration) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var name string
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if err != nil {
+		p.debugf("Peer(%v): couldn't resolve %v back: %v", p.uuid, ip, err)
+	}
+	p.reverseDNSPending = false
+	p.reverseDNSIP = ip
+	p.reverseDNSAt = p.now()
+	p.reverseDNS = name
+}
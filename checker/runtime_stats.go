@@ -0,0 +1,31 @@
+package checker
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// reportRuntimeStats periodically logs the resources used by the
+// checker along with the number of peers, to follow its footprint
+// as the services grow. It returns once the watcher is stopped.
+func (pw *PeersWatcher) reportRuntimeStats(interval time.Duration) {
+	clock := clockOrReal(pw.config.Clock)
+	for {
+		select {
+		case <-pw.exit:
+			return
+		case <-clock.After(interval):
+		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		pw.Lock()
+		peers := len(pw.peers) + len(pw.targetPeers)
+		startupQueue := len(pw.startupQueue)
+		pw.Unlock()
+		log.Infof("PeersWatcher: runtime stats: peers=%v goroutines=%v heapAlloc=%v heapObjects=%v maxHostConcurrency=%v startupQueue=%v",
+			peers, runtime.NumGoroutine(), mem.HeapAlloc, mem.HeapObjects, pw.MaxHostConcurrency(), startupQueue)
+	}
+}
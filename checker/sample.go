@@ -0,0 +1,103 @@
+package checker
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// sample keeps SampleSize of the peers found in metadata selected
+// for checking, the others being left alone. The whole selection is
+// drawn again every SampleInterval, in between peers going away are
+// replaced by random ones. It must be called with the lock held, the
+// peers being (de)selected by fireEvents, once the lock is released.
+func (pw *PeersWatcher) sample() {
+	if pw.config.SampleSize <= 0 {
+		return
+	}
+	if pw.random == nil {
+		seed := time.Now().UnixNano()
+		if pw.config.Seed != nil {
+			seed = *pw.config.Seed
+		}
+		pw.random = rand.New(rand.NewSource(seed))
+	}
+
+	now := clockOrReal(pw.config.Clock).Now()
+	interval := time.Duration(pw.config.SampleInterval) * time.Millisecond
+	redraw := pw.lastSampled.IsZero() || (interval > 0 && now.Sub(pw.lastSampled) >= interval)
+	if redraw {
+		pw.lastSampled = now
+	}
+
+	uuids := make([]string, 0, len(pw.peers))
+	wasSelected := make(map[string]bool, len(pw.peers))
+	isSelected := make(map[string]bool, len(pw.peers))
+	selected := 0
+	for uuid, aPeer := range pw.peers {
+		uuids = append(uuids, uuid)
+		wasSelected[uuid] = aPeer.lastStatus().Selected
+		if !redraw && wasSelected[uuid] {
+			isSelected[uuid] = true
+			selected++
+		}
+	}
+	// Sorted so that a seeded selection is reproducible
+	sort.Strings(uuids)
+	for _, i := range pw.random.Perm(len(uuids)) {
+		if selected >= pw.config.SampleSize {
+			break
+		}
+		uuid := uuids[i]
+		if isSelected[uuid] {
+			continue
+		}
+		log.Debugf("PeersWatcher: selecting peer %v for checking", uuid)
+		isSelected[uuid] = true
+		selected++
+	}
+
+	for _, uuid := range uuids {
+		if isSelected[uuid] == wasSelected[uuid] {
+			continue
+		}
+		aPeer, selected := pw.peers[uuid], isSelected[uuid]
+		pw.queueEvent(func() { aPeer.setSelected(selected) })
+	}
+}
+
+func (p *Peer) setSelected(selected bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.unselected = !selected
+}
+
+// Selected informs if the peer is among the ones checked when
+// only a sample of the peers is
+func (p *Peer) Selected() bool {
+	p.Lock()
+	defer p.Unlock()
+	return !p.unselected
+}
+
+// Selected returns the uuids of the peers currently selected for
+// checking, all of them unless SampleSize is set
+func (pw *PeersWatcher) Selected() []string {
+	pw.Lock()
+	peers := make([]*Peer, 0, len(pw.peers))
+	for _, aPeer := range pw.peers {
+		peers = append(peers, aPeer)
+	}
+	pw.Unlock()
+
+	var uuids []string
+	for _, aPeer := range peers {
+		if aPeer.lastStatus().Selected {
+			uuids = append(uuids, aPeer.uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
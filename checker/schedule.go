@@ -0,0 +1,103 @@
+package checker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleWindow is a daily time window during which the peers are
+// checked at their own interval, e.g. more often during business hours
+type ScheduleWindow struct {
+	// Start and End are minutes since midnight, the window wrapping
+	// around midnight when End is before Start
+	Start, End int
+	// Interval between two consecutive checks of a peer during
+	// the window, in milliseconds
+	Interval int
+}
+
+// ParseScheduleWindow parses a window in the HH:MM-HH:MM=interval
+// form, e.g. 08:00-18:00=2000
+func ParseScheduleWindow(s string) (ScheduleWindow, error) {
+	var w ScheduleWindow
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return w, fmt.Errorf("missing interval in schedule window %v", s)
+	}
+	interval, err := strconv.Atoi(parts[1])
+	if err != nil || interval <= 0 {
+		return w, fmt.Errorf("invalid interval in schedule window %v", s)
+	}
+	w.Interval = interval
+
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(bounds) != 2 {
+		return w, fmt.Errorf("invalid bounds in schedule window %v", s)
+	}
+	if w.Start, err = parseClockTime(bounds[0]); err != nil {
+		return w, fmt.Errorf("invalid start in schedule window %v: %v", s, err)
+	}
+	if w.End, err = parseClockTime(bounds[1]); err != nil {
+		return w, fmt.Errorf("invalid end in schedule window %v: %v", s, err)
+	}
+	return w, nil
+}
+
+// parseClockTime returns the minutes since midnight of a HH:MM time
+func parseClockTime(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w ScheduleWindow) contains(minute int) bool {
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// schedule picks the interval between the checks according to
+// the time of the day
+type schedule struct {
+	windows  []ScheduleWindow
+	location *time.Location
+}
+
+func newSchedule(windows []ScheduleWindow, location *time.Location) *schedule {
+	if len(windows) == 0 {
+		return nil
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	return &schedule{windows: windows, location: location}
+}
+
+// interval returns the interval of the first window containing the
+// given time, the default one when none does. A nil schedule always
+// returns the default.
+func (s *schedule) interval(now time.Time, defaultInterval int) int {
+	if s == nil {
+		return defaultInterval
+	}
+	t := now.In(s.location)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		if w.contains(minute) {
+			return w.Interval
+		}
+	}
+	return defaultInterval
+}
+
+// baseInterval returns the interval between two checks of the peer
+// currently in effect according to the schedule, in milliseconds. It
+// must be called with the lock held.
+func (p *Peer) baseInterval() int {
+	return p.schedule.interval(p.now(), p.config.CheckInterval)
+}
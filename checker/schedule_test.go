@@ -0,0 +1,69 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleIntervalFollowsWindows(t *testing.T) {
+	day, err := ParseScheduleWindow("08:00-18:00=2000")
+	if err != nil {
+		t.Fatalf("error parsing window: %v", err)
+	}
+	night, err := ParseScheduleWindow("22:00-06:00=30000")
+	if err != nil {
+		t.Fatalf("error parsing window: %v", err)
+	}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+	s := newSchedule([]ScheduleWindow{day, night}, paris)
+
+	for _, tc := range []struct {
+		at       string
+		interval int
+	}{
+		{"2017-06-01T07:00:00Z", 2000},  // 09:00 in Paris
+		{"2017-06-01T17:00:00Z", 5000},  // 19:00 in Paris
+		{"2017-06-01T23:30:00Z", 30000}, // 01:30 in Paris
+	} {
+		now, _ := time.Parse(time.RFC3339, tc.at)
+		if got := s.interval(now, 5000); got != tc.interval {
+			t.Errorf("at %v expected interval %v, got %v", tc.at, tc.interval, got)
+		}
+	}
+}
+
+func TestConfiguredSeedGivesEachPeerItsSchedule(t *testing.T) {
+	seed := int64(42)
+	p1, _ := newTestPeer("10.42.0.1")
+	p2, _ := newTestPeer("10.42.0.2")
+	p2.uuid = "c2"
+	p1.config.Seed, p2.config.Seed = &seed, &seed
+
+	if p1.deriveSeed() == p2.deriveSeed() {
+		t.Fatalf("expected the peers to have different seeds")
+	}
+	again, _ := newTestPeer("10.42.0.3")
+	again.config.Seed = &seed
+	if again.deriveSeed() != p1.deriveSeed() {
+		t.Fatalf("expected the seed of a peer to be reproducible")
+	}
+}
+
+func TestPeersWatcherPerturbsSeedCollisions(t *testing.T) {
+	seed := int64(42)
+	pw := &PeersWatcher{}
+	pw.config.Seed = &seed
+	p1, _ := newTestPeer("10.42.0.1")
+	p2, _ := newTestPeer("10.42.0.2")
+	p1.config.Seed, p2.config.Seed = &seed, &seed
+
+	// The same uuid under both, as if the hash collided
+	pw.assignSeed(p1)
+	pw.assignSeed(p2)
+	if p1.seed == p2.seed {
+		t.Fatalf("expected the colliding seeds to be perturbed")
+	}
+}
@@ -0,0 +1,34 @@
+package checker
+
+import (
+	"time"
+)
+
+// Scheduler decides when each peer is checked, the default one
+// waiting for the check interval, jittered, see PeerConfig.Scheduler
+type Scheduler interface {
+	// Next returns how long to wait before the next check of the
+	// peer. It's called without the lock of the peer held.
+	Next(p *Peer) time.Duration
+}
+
+// jitteredScheduler waits for the interval of the peer, adaptive or
+// of its schedule window, minus the jitter
+type jitteredScheduler struct{}
+
+// Next returns the interval of the peer minus the jitter, the first
+// time plus the phase of the peer, see PeersWatcher.spreadProbes
+func (jitteredScheduler) Next(p *Peer) time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	d := p.getHostCheckSleepDuration() + p.phase
+	p.phase = 0
+	return d
+}
+
+func schedulerOrDefault(s Scheduler) Scheduler {
+	if s == nil {
+		return jitteredScheduler{}
+	}
+	return s
+}
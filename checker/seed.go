@@ -0,0 +1,55 @@
+package checker
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// deriveSeed returns the seed of the random source of the peer, from
+// the IP of its host so that the schedule of the checks of a host is
+// stable across restarts, from the time for the fixed targets
+func (p *Peer) deriveSeed() int64 {
+	if p.config.Seed != nil {
+		// Each peer has its own schedule, derived from the seed
+		h := fnv.New64a()
+		h.Write([]byte(strconv.FormatInt(*p.config.Seed, 10)))
+		h.Write([]byte(p.uuid))
+		return int64(h.Sum64())
+	}
+	if p.host == nil || p.host.AgentIP == "" {
+		return time.Now().UTC().UnixNano()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(p.host.AgentIP))
+	return int64(h.Sum64())
+}
+
+// assignSeed seeds the random source of a new peer, changing its seed
+// when another peer has the same one, unless collisions are allowed.
+// It must be called with the lock held, before the peer is started.
+func (pw *PeersWatcher) assignSeed(aPeer *Peer) {
+	seed := aPeer.deriveSeed()
+	if !pw.config.AllowSeedCollisions {
+		if pw.seeds == nil {
+			pw.seeds = make(map[int64]string)
+		}
+		for other, taken := pw.seeds[seed]; taken; other, taken = pw.seeds[seed] {
+			perturbed := seed*6364136223846793005 + 1442695040888963407
+			log.Infof("PeersWatcher: peer %v has the same seed as peer %v, changing it from %v to %v", aPeer.uuid, other, seed, perturbed)
+			seed = perturbed
+		}
+		pw.seeds[seed] = aPeer.uuid
+	}
+	aPeer.seedRandom(seed)
+}
+
+// releaseSeed forgets the seed of a removed peer, it must be called
+// with the lock held
+func (pw *PeersWatcher) releaseSeed(aPeer *Peer) {
+	if pw.seeds[aPeer.seed] == aPeer.uuid {
+		delete(pw.seeds, aPeer.seed)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/rancher/connectivity-check/utils"
 	"github.com/rancher/log"
 )
 
@@ -19,14 +22,26 @@ type Server struct {
 	port int
 	cc   ConnectivityChecker
 	l    net.Listener
+	mux  *http.ServeMux
 }
 
 // NewServer ...
 func NewServer(port int, cc ConnectivityChecker) (*Server, error) {
-	return &Server{
+	s := &Server{
 		port: port,
 		cc:   cc,
-	}, nil
+		mux:  http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/ping", s.pingHandler)
+	s.mux.HandleFunc("/connectivity", s.connectivityHandler)
+	s.mux.HandleFunc(payloadPath, s.payloadHandler)
+	return s, nil
+}
+
+// HandleFunc registers an additional handler, it must be
+// called before Run
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
 }
 
 // GetPort ...
@@ -37,14 +52,15 @@ func (s *Server) GetPort() int {
 // Shutdown ...
 func (s *Server) Shutdown() error {
 	log.Infof("Shutting down server")
+	if s.l == nil {
+		return nil
+	}
 	return s.l.Close()
 }
 
 // Run ...
 func (s *Server) Run() error {
 	log.Infof("Starting webserver on port: %v", s.port)
-	http.HandleFunc("/ping", s.pingHandler)
-	http.HandleFunc("/connectivity", s.connectivityHandler)
 
 	l, err := net.Listen("tcp", fmt.Sprintf(":%v", s.port))
 	if err != nil {
@@ -52,7 +68,7 @@ func (s *Server) Run() error {
 		return err
 	}
 	s.l = l
-	go http.Serve(l, nil)
+	go http.Serve(l, s.mux)
 
 	return nil
 }
@@ -67,8 +83,28 @@ func getSourceIP(r *http.Request) string {
 }
 
 func (s *Server) pingHandler(w http.ResponseWriter, r *http.Request) {
+	received := time.Now().UnixNano()
+	w.Header().Set(utils.ReceivedHeader, strconv.FormatInt(received, 10))
 	reqIP := getSourceIP(r)
+	if source := r.Header.Get(utils.SourceHeader); source != "" {
+		log.Debugf("ping from %v (%v)", reqIP, source)
+	}
+	if id := r.Header.Get(utils.CorrelationHeader); id != "" {
+		log.Debugf("ping from %v, correlation ID %v", reqIP, id)
+	}
 	s.cc.Update(reqIP)
+	nonce := r.Header.Get(utils.NonceHeader)
+	if nonce != "" {
+		w.Header().Set(utils.NonceHeader, nonce)
+	}
+	// The checking nodes not negotiating the schema, e.g. of an
+	// older version, get the plain pong they expect
+	if v := r.Header.Get(utils.SchemaHeader); v != "" && utils.RequestedSchema(v) >= utils.SchemaJSON {
+		w.Header().Set(utils.SchemaHeader, strconv.Itoa(utils.SchemaJSON))
+		writeJSON(w, utils.PingResponse{Status: expectedResponse, Received: received, Nonce: nonce})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	fmt.Fprintf(w, "pong")
 }
 
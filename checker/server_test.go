@@ -1,8 +1,33 @@
 package checker
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/rancher/connectivity-check/utils"
 	"github.com/rancher/go-rancher-metadata/metadata"
 	"github.com/rancher/log"
 )
@@ -32,3 +57,431 @@ func TestServer(t *testing.T) {
 
 	s.Run()
 }
+
+func TestPingNegotiatesSchema(t *testing.T) {
+	s := &Server{cc: &PeersWatcher{}}
+	ts := httptest.NewServer(http.HandlerFunc(s.pingHandler))
+	defer ts.Close()
+
+	for _, negotiate := range []bool{false, true} {
+		opts := utils.Options{Timeout: 1000, NegotiateSchema: negotiate, Nonce: "n1"}
+		ok, timing, err := utils.IsReachableWithTiming(ts.URL+"/ping", expectedResponse, opts)
+		if !ok {
+			t.Fatalf("expected the ping to succeed with negotiate=%v, got %v", negotiate, err)
+		}
+		expected := 0
+		if negotiate {
+			expected = utils.SchemaJSON
+		}
+		if timing.SchemaVersion != expected {
+			t.Fatalf("expected schema %v with negotiate=%v, got %v", expected, negotiate, timing.SchemaVersion)
+		}
+	}
+}
+
+func TestPingContentType(t *testing.T) {
+	s := &Server{cc: &PeersWatcher{}}
+	ts := httptest.NewServer(http.HandlerFunc(s.pingHandler))
+	defer ts.Close()
+
+	opts := utils.Options{Timeout: 1000, ContentType: "text/plain"}
+	if ok, err := utils.IsReachableWithOptions(ts.URL+"/ping", expectedResponse, opts); !ok {
+		t.Fatalf("expected the pong to be text/plain, got %v", err)
+	}
+	opts.ContentType = "application/json"
+	ok, err := utils.IsReachableWithOptions(ts.URL+"/ping", expectedResponse, opts)
+	if ok || utils.ReasonOf(err) != utils.FailureContentType {
+		t.Fatalf("expected a content type mismatch, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestExpectedHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Health", "ok")
+		w.Write([]byte("healthy"))
+	}))
+	defer ts.Close()
+
+	opts := utils.Options{Timeout: 1000, ExpectedHeader: "X-Health", ExpectedHeaderValue: "ok", HeaderOnly: true}
+	if ok, err := utils.IsReachableWithOptions(ts.URL, expectedResponse, opts); !ok {
+		t.Fatalf("expected the header to be enough, got %v", err)
+	}
+	opts.ExpectedHeaderValue = "degraded"
+	ok, err := utils.IsReachableWithOptions(ts.URL, expectedResponse, opts)
+	if ok || utils.ReasonOf(err) != utils.FailureHeaderMismatch {
+		t.Fatalf("expected a header mismatch, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/ready":
+			w.Write([]byte("ready"))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.Mode = ModeHTTP
+	p.config.LivenessPath = "/healthz"
+	p.config.ReadinessPath = "/ready"
+	live, _ := p.probe()
+	if live.Path != "/healthz" || !live.Options.StatusOnly {
+		t.Fatalf("expected the liveness probe to accept any 2xx status, got %+v", live)
+	}
+	if ok, err := utils.IsReachableWithOptions(ts.URL+live.Path, live.Expected, live.Options); !ok {
+		t.Fatalf("expected a 204 to be live, got %v", err)
+	}
+	ready := p.healthProbe(live, p.config.ReadinessPath)
+	if ok, err := utils.IsReachableWithOptions(ts.URL+ready.Path, ready.Expected, ready.Options); !ok {
+		t.Fatalf("expected a 200 to be ready, got %v", err)
+	}
+	if ok, _ := utils.IsReachableWithOptions(ts.URL+"/down", ready.Expected, ready.Options); ok {
+		t.Fatalf("expected a 503 not to be ready")
+	}
+
+	// With HealthExpected, the body must match
+	p.config.HealthExpected = "ready"
+	ready = p.healthProbe(live, p.config.ReadinessPath)
+	if ok, err := utils.IsReachableWithOptions(ts.URL+ready.Path, ready.Expected, ready.Options); !ok {
+		t.Fatalf("expected the body to match, got %v", err)
+	}
+	live = p.healthProbe(live, p.config.LivenessPath)
+	ok, err := utils.IsReachableWithOptions(ts.URL+live.Path, live.Expected, live.Options)
+	if ok || utils.ReasonOf(err) != utils.FailureStatusCode {
+		t.Fatalf("expected a 204 without the body not to be live, got ok=%v err=%v", ok, err)
+	}
+}
+
+// failingRoundTripper fails every request, as a QUIC transport whose
+// handshake fails
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("handshake did not complete in time")
+}
+
+// refusedRoundTripper fails every request as refused by the peer
+type refusedRoundTripper struct{}
+
+func (refusedRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvmsg", syscall.ECONNREFUSED)}
+}
+
+func TestHTTP3Mode(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(expectedResponse))
+	}))
+	defer ts.Close()
+
+	// The transport of the test server stands for the QUIC one
+	probe := Probe{
+		Address:  strings.TrimPrefix(ts.URL, "https://"),
+		Path:     defaultCheckPath,
+		Expected: expectedResponse,
+		Options:  utils.Options{Timeout: 1000, HTTP3Transport: ts.Client().Transport},
+	}
+	if ok, err := (http3Checker{}).Check(probe); !ok {
+		t.Fatalf("expected the check over the HTTP/3 transport to succeed, got %v", err)
+	}
+	probe.Options.HTTP3Transport = failingRoundTripper{}
+	ok, err := (http3Checker{}).Check(probe)
+	if ok || utils.ReasonOf(err) != utils.FailureQUICHandshake {
+		t.Fatalf("expected a QUIC handshake failure, got ok=%v err=%v", ok, err)
+	}
+
+	// The other failures are classified as over TCP
+	probe.Options.HTTP3Transport = refusedRoundTripper{}
+	ok, err = (http3Checker{}).Check(probe)
+	if ok || utils.ReasonOf(err) != utils.FailureRefused {
+		t.Fatalf("expected the peer refusing, got ok=%v err=%v", ok, err)
+	}
+}
+
+// countingDial returns a DialFunc counting its connections in n
+func countingDial(n *int32) utils.DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(n, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+}
+
+func TestDialFuncsDontShareTransports(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(expectedResponse))
+	}))
+	defer ts.Close()
+
+	// The closures of the same function have the same code pointer
+	var first, second int32
+	for _, opts := range []utils.Options{
+		{Timeout: 1000, DialFunc: countingDial(&first), DialFuncID: utils.NewDialFuncID()},
+		{Timeout: 1000, DialFunc: countingDial(&second), DialFuncID: utils.NewDialFuncID()},
+		{Timeout: 1000, DialFunc: countingDial(&second)},
+	} {
+		if ok, err := utils.IsReachableWithOptions(ts.URL, expectedResponse, opts); !ok {
+			t.Fatalf("expected the check to succeed, got %v", err)
+		}
+	}
+	if first != 1 || second != 2 {
+		t.Fatalf("expected every DialFunc to dial its own connections, got %v and %v", first, second)
+	}
+}
+
+// freePort returns a local port likely free
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestSourcePortsDontKeepConnections(t *testing.T) {
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(expectedResponse))
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	first, second := freePort(t), freePort(t)
+	for _, port := range []int{first, second, first} {
+		opts := utils.Options{Timeout: 1000, SourcePort: port}
+		ok, timing, err := utils.IsReachableWithTiming(ts.URL, expectedResponse, opts)
+		if !ok {
+			t.Fatalf("expected the check from port %v to succeed, got %v", port, err)
+		}
+		if _, localPort, _ := net.SplitHostPort(timing.LocalAddress); localPort != strconv.Itoa(port) {
+			t.Fatalf("expected the check to originate from port %v, got %v", port, timing.LocalAddress)
+		}
+	}
+	if conns != 3 {
+		t.Fatalf("expected every check to open its connection, got %v", conns)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	cfg := DefaultConfig()
+	cfg.LivenessPath = "/healthz"
+	pw := &PeersWatcher{
+		config:       cfg,
+		peersMapByIP: map[string]*Peer{"127.0.0.1": {uuid: "c1"}},
+	}
+	relay := httptest.NewServer(http.HandlerFunc(pw.relayHandler))
+	defer relay.Close()
+
+	// The relay checks the peers as it's configured to
+	probe := Probe{
+		Address: strings.TrimPrefix(target.URL, "http://"),
+		Relay:   strings.TrimPrefix(relay.URL, "http://"),
+		Options: utils.Options{Timeout: 1000},
+	}
+	ok, result, err := checkRelayed(probe)
+	if !ok || !result.Answered || !result.Reachable {
+		t.Fatalf("expected the relayed check to succeed, got ok=%v result=%+v err=%v", ok, result, err)
+	}
+
+	// Only the peers can be targeted
+	other := probe
+	other.Address = "10.1.2.3:80"
+	ok, result, err = checkRelayed(other)
+	if ok || result.Answered || utils.ReasonOf(err) != utils.FailureRelay {
+		t.Fatalf("expected the relay to refuse a target which isn't a peer, got ok=%v result=%+v err=%v", ok, result, err)
+	}
+
+	target.Close()
+	ok, result, err = checkRelayed(probe)
+	if ok || !result.Answered || result.Reachable || utils.ReasonOf(err) != utils.FailureRefused {
+		t.Fatalf("expected the relay to report the target refusing, got ok=%v result=%+v err=%v", ok, result, err)
+	}
+
+	relay.Close()
+	ok, result, err = checkRelayed(probe)
+	if ok || result.Answered || utils.ReasonOf(err) != utils.FailureRelay {
+		t.Fatalf("expected a relay failure, got ok=%v result=%+v err=%v", ok, result, err)
+	}
+}
+
+func TestPeerRelayedCheckBesideDirectOne(t *testing.T) {
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer relay.Close()
+
+	p, tc := newTestPeer("10.42.0.1")
+	p.config.Mode = ModeHTTP
+	p.config.RelayAddress = strings.TrimPrefix(relay.URL, "http://")
+	p.doWork()
+	if !p.reachable() || len(tc.probes) != 1 {
+		t.Fatalf("expected the peer reachable through its direct check, got %v after %v probes", p.reachable(), len(tc.probes))
+	}
+	result, found := p.RelayResult()
+	if !found || result.Answered || result.Via != p.config.RelayAddress {
+		t.Fatalf("expected the relay failure to be reported apart, got %+v found=%v", result, found)
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate
+// of the given serial and its key in dir
+func writeClientCertificate(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "checker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The reload goes by the modification time
+	future := time.Now().Add(time.Duration(serial) * time.Second)
+	os.Chtimes(certFile, future, future)
+	return certFile, keyFile
+}
+
+func TestHTTPSModeWithClientCertificate(t *testing.T) {
+	var mu sync.Mutex
+	var serials []int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		serials = append(serials, r.TLS.PeerCertificates[0].SerialNumber.Int64())
+		mu.Unlock()
+		w.Write([]byte(expectedResponse))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	probe := Probe{
+		Address:  strings.TrimPrefix(ts.URL, "https://"),
+		Path:     defaultCheckPath,
+		Expected: expectedResponse,
+		Options:  utils.Options{Timeout: 1000, DisableKeepAlives: true},
+	}
+	ok, err := (httpsChecker{}).Check(probe)
+	if ok || utils.ReasonOf(err) != utils.FailureTLSAuth {
+		t.Fatalf("expected a TLS auth failure without client certificate, got ok=%v err=%v", ok, err)
+	}
+
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, err := utils.LoadClientCertificate(writeClientCertificate(t, dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Options.ClientCertificate = cert
+	if ok, err := (httpsChecker{}).Check(probe); !ok {
+		t.Fatalf("expected the check with the client certificate to succeed, got %v", err)
+	}
+	writeClientCertificate(t, dir, 2)
+	if ok, err := (httpsChecker{}).Check(probe); !ok {
+		t.Fatalf("expected the check with the rotated certificate to succeed, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(serials, []int64{1, 2}) {
+		t.Fatalf("expected the certificate reloaded on rotation, got the serials %v", serials)
+	}
+}
+
+func TestRancherStateWriter(t *testing.T) {
+	var mu sync.Mutex
+	labels := map[string]string{"io.rancher.stack.name": "ipsec"}
+	puts := 0
+	var api *httptest.Server
+	api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if user, pass, _ := r.BasicAuth(); user != "access" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2-beta/containers" && r.URL.Query().Get("uuid") == "c1":
+			writeJSON(w, map[string]interface{}{"data": []interface{}{map[string]interface{}{
+				"id":     "1i42",
+				"labels": labels,
+				"links":  map[string]string{"self": api.URL + "/v2-beta/containers/1i42"},
+			}}})
+		case r.Method == http.MethodGet && r.URL.Path == "/v2-beta/containers":
+			writeJSON(w, map[string]interface{}{"data": []interface{}{}})
+		case r.Method == http.MethodPut && r.URL.Path == "/v2-beta/containers/1i42":
+			var update struct {
+				Labels map[string]string `json:"labels"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			labels = update.Labels
+			puts++
+			writeJSON(w, map[string]interface{}{"id": "1i42"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	writer, err := NewRancherStateWriter(api.URL+"/v2-beta/", "access", "secret", "")
+	if err != nil {
+		t.Fatalf("error creating the writer: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := writer.WriteState(PeerStatus{UUID: "c1", Reachable: false}); err != nil {
+			t.Fatalf("error writing the state: %v", err)
+		}
+	}
+	mu.Lock()
+	expected := map[string]string{"io.rancher.stack.name": "ipsec", DefaultStateLabel: "unreachable"}
+	if !reflect.DeepEqual(labels, expected) || puts != 1 {
+		t.Fatalf("expected the state added to the labels once, got %v after %v updates", labels, puts)
+	}
+	mu.Unlock()
+
+	if err := writer.WriteState(PeerStatus{UUID: "c2", Reachable: true}); err == nil {
+		t.Fatalf("expected an error writing the state of an unknown container")
+	}
+	if _, err := NewRancherStateWriter("rancher:8080", "", "", ""); err == nil {
+		t.Fatalf("expected an error for a URL without scheme")
+	}
+}
@@ -0,0 +1,48 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/log"
+)
+
+// startSettling starts the settling period of Config.SettlingPeriod,
+// it must be called with the lock held
+func (pw *PeersWatcher) startSettling() {
+	if pw.config.SettlingPeriod <= 0 {
+		return
+	}
+	pw.settlingUntil = clockOrReal(pw.config.Clock).Now().Add(time.Duration(pw.config.SettlingPeriod) * time.Millisecond)
+	log.Infof("PeersWatcher: settling until %v, not reporting the transitions", pw.settlingUntil)
+}
+
+// settling must be called with the lock held
+func (pw *PeersWatcher) settling() bool {
+	return clockOrReal(pw.config.Clock).Now().Before(pw.settlingUntil)
+}
+
+// Settling informs if the watcher is still in its settling period
+// after starting, see Config.SettlingPeriod
+func (pw *PeersWatcher) Settling() bool {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.settling()
+}
+
+// isSettling informs if the transitions of the peer are silenced
+// because the watcher just started, it must be called with the lock
+// held
+func (p *Peer) isSettling() bool {
+	return p.now().Before(p.settlingUntil)
+}
+
+// reportSettledState reports the state of the peer once the settling
+// period is over, as its first transition. It must be called with
+// the lock held.
+func (p *Peer) reportSettledState() {
+	if p.settlingUntil.IsZero() || p.isSettling() {
+		return
+	}
+	p.settlingUntil = time.Time{}
+	p.transition(p.reportedReachable)
+}
@@ -0,0 +1,84 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// replayClock is the Clock of the simulated peers, set to the time of
+// every record replayed
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	return c.now
+}
+
+// After never fires, the simulated peers being updated directly
+func (c *replayClock) After(d time.Duration) <-chan time.Time {
+	return nil
+}
+
+// discardLogger is the Logger of the simulated peers, their
+// transitions being returned rather than logged
+type discardLogger struct{}
+
+func (discardLogger) Debugf(format string, args ...interface{}) {}
+func (discardLogger) Infof(format string, args ...interface{})  {}
+func (discardLogger) Warnf(format string, args ...interface{})  {}
+func (discardLogger) Errorf(format string, args ...interface{}) {}
+
+// Simulate replays the recorded results of the checks through the
+// state machine of the peers with the given settings, and returns the
+// transitions it reports, oldest first. It tells what FailFast,
+// RecoverySuccesses, FailureWeights or a window would have made of
+// the same results, see SimulateHistory. Only the settings deciding on
+// the reachability from the results matter, no check being done.
+func Simulate(records []CheckRecord, cfg PeerConfig) []Event {
+	clock := &replayClock{}
+	cfg.Clock = clock
+	cfg.OnStateChange = nil
+	peers := make(map[string]*Peer)
+	var events []Event
+	for _, r := range records {
+		p, found := peers[r.UUID]
+		if !found {
+			p = &Peer{uuid: r.UUID, config: cfg, logger: newSyncLogger(discardLogger{})}
+			peers[r.UUID] = p
+		}
+		clock.now = r.Time
+
+		wasReachable := p.reportedReachable
+		if r.OK {
+			p.failureReason = utils.FailureNone
+			p.updateSuccess()
+		} else {
+			p.failureReason = r.FailureReason
+			p.updateFailure(r.FailureReason)
+		}
+		p.pendingEvents = nil
+		if p.reportedReachable != wasReachable {
+			events = append(events, newEvent(PeerStatus{
+				UUID:          r.UUID,
+				Reachable:     p.reportedReachable,
+				FailureReason: p.failureReason,
+			}, r.Time))
+		}
+	}
+	return events
+}
+
+// SimulateHistory is Simulate over the history written to path,
+// including the rotated files, see Config.HistoryFile
+func SimulateHistory(path string, cfg PeerConfig) ([]Event, error) {
+	var records []CheckRecord
+	err := ReplayHistory(path, func(r CheckRecord) {
+		records = append(records, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Simulate(records, cfg), nil
+}
@@ -0,0 +1,54 @@
+package checker
+
+import (
+	"sort"
+)
+
+const (
+	// ChangeAdded is a peer found only in the new snapshot
+	ChangeAdded = "added"
+	// ChangeRemoved is a peer found only in the old snapshot
+	ChangeRemoved = "removed"
+	// ChangeFlipped is a peer whose reachability changed between the
+	// snapshots
+	ChangeFlipped = "flipped"
+)
+
+// PeerChange is a difference of a peer between two snapshots, see
+// DiffSnapshots. Old is nil for an added peer, New for a removed one.
+type PeerChange struct {
+	UUID string      `json:"uuid"`
+	Kind string      `json:"kind"`
+	Old  *PeerStatus `json:"old,omitempty"`
+	New  *PeerStatus `json:"new,omitempty"`
+}
+
+// DiffSnapshots returns the peers added, removed or whose reachability
+// flipped from the old snapshot to the new one, e.g. two calls of
+// PeersWatcher.Snapshot, sorted by UUID
+func DiffSnapshots(old, new []PeerStatus) []PeerChange {
+	oldByUUID := make(map[string]*PeerStatus, len(old))
+	for i := range old {
+		oldByUUID[old[i].UUID] = &old[i]
+	}
+
+	var changes []PeerChange
+	for i := range new {
+		n := &new[i]
+		o, found := oldByUUID[n.UUID]
+		delete(oldByUUID, n.UUID)
+		switch {
+		case !found:
+			changes = append(changes, PeerChange{UUID: n.UUID, Kind: ChangeAdded, New: n})
+		case o.Reachable != n.Reachable:
+			changes = append(changes, PeerChange{UUID: n.UUID, Kind: ChangeFlipped, Old: o, New: n})
+		}
+	}
+	for uuid, o := range oldByUUID {
+		changes = append(changes, PeerChange{UUID: uuid, Kind: ChangeRemoved, Old: o})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].UUID < changes[j].UUID
+	})
+	return changes
+}
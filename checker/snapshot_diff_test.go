@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"testing"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	old := []PeerStatus{
+		{UUID: "kept", Reachable: true},
+		{UUID: "flipped", Reachable: true},
+		{UUID: "removed", Reachable: true},
+	}
+	new := []PeerStatus{
+		{UUID: "added", Reachable: false},
+		{UUID: "flipped", Reachable: false},
+		{UUID: "kept", Reachable: true, LastLatency: 1},
+	}
+
+	changes := DiffSnapshots(old, new)
+	expected := []struct{ uuid, kind string }{
+		{"added", ChangeAdded},
+		{"flipped", ChangeFlipped},
+		{"removed", ChangeRemoved},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v changes, got %+v", len(expected), changes)
+	}
+	for i, e := range expected {
+		if changes[i].UUID != e.uuid || changes[i].Kind != e.kind {
+			t.Errorf("expected %v to be %v, got %+v", e.uuid, e.kind, changes[i])
+		}
+	}
+	if changes[1].Old.Reachable != true || changes[1].New.Reachable != false {
+		t.Errorf("expected the flipped peer to go from reachable to unreachable, got %+v", changes[1])
+	}
+	if changes[0].Old != nil || changes[2].New != nil {
+		t.Errorf("expected no old status for the added peer nor new one for the removed peer")
+	}
+
+	if changes := DiffSnapshots(new, new); len(changes) != 0 {
+		t.Fatalf("expected no changes between identical snapshots, got %+v", changes)
+	}
+}
@@ -0,0 +1,34 @@
+package checker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteSnapshot writes the Snapshot of all the peers as JSON to the
+// file at path. The file is replaced atomically, a reader never sees
+// it partially written, and the concurrent calls are serialized.
+func (pw *PeersWatcher) WriteSnapshot(path string) error {
+	pw.snapshotMu.Lock()
+	defer pw.snapshotMu.Unlock()
+
+	data, err := json.MarshalIndent(pw.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
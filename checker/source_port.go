@@ -0,0 +1,31 @@
+package checker
+
+const (
+	// SourcePortRandom lets the system pick the source port of the
+	// checks, as usual
+	SourcePortRandom = "random"
+	// SourcePortFixed makes all the checks originate from SourcePort
+	SourcePortFixed = "fixed"
+	// SourcePortPool makes the checks originate from the ports within
+	// SourcePortMin and SourcePortMax in turn
+	SourcePortPool = "pool"
+)
+
+// nextSourcePort returns the source port of the next check of the
+// peer, see PeerConfig.SourcePortPolicy, 0 letting the system pick it.
+// It must be called with the lock held.
+func (p *Peer) nextSourcePort() int {
+	switch p.config.SourcePortPolicy {
+	case SourcePortFixed:
+		return p.config.SourcePort
+	case SourcePortPool:
+		size := p.config.SourcePortMax - p.config.SourcePortMin + 1
+		if size <= 0 {
+			return 0
+		}
+		port := p.config.SourcePortMin + p.sourcePortIndex%size
+		p.sourcePortIndex++
+		return port
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+)
+
+// sourceAddress resolves a source of the checks, see
+// PeerConfig.SourceAddresses: an IP is used as is, an interface
+// name is resolved to its first IPv4 address
+func sourceAddress(source string) (string, error) {
+	if net.ParseIP(source) != nil {
+		return source, nil
+	}
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address on interface %v", source)
+}
+
+// nextSource returns the source of the next check of the peer, the
+// SourceAddresses being used in turn, empty when there are none. It
+// must be called with the lock held.
+func (p *Peer) nextSource() string {
+	if len(p.config.SourceAddresses) == 0 {
+		return ""
+	}
+	source := p.config.SourceAddresses[p.sourceIndex%len(p.config.SourceAddresses)]
+	p.sourceIndex++
+	return source
+}
+
+// setSource makes the probe originate from the given source, it must
+// be called with the lock held
+func (p *Peer) setSource(probe *Probe, source string) error {
+	p.lastSource = source
+	if source == "" {
+		return nil
+	}
+	address, err := sourceAddress(source)
+	if err != nil {
+		return fmt.Errorf("source %v: %v", source, err)
+	}
+	probe.Options.SourceAddress = address
+	return nil
+}
+
+// recordSourceResult records the result of a check done from the
+// last source, it must be called with the lock held
+func (p *Peer) recordSourceResult(ok bool) {
+	if p.lastSource == "" {
+		return
+	}
+	if p.sourceResults == nil {
+		p.sourceResults = make(map[string]bool)
+	}
+	if previous, found := p.sourceResults[p.lastSource]; found && previous != ok {
+		if ok {
+			p.logger.Infof("Peer(%v, %v, %v): reachable from %v again", p.uuid, p.getHostIP(), p.getIP(), p.lastSource)
+		} else {
+			p.logger.Errorf("Peer(%v, %v, %v): unreachable from %v", p.uuid, p.getHostIP(), p.getIP(), p.lastSource)
+		}
+	}
+	p.sourceResults[p.lastSource] = ok
+}
+
+// SourceReachability returns whether the peer was reachable on the
+// last check from each of the SourceAddresses, nil when there are
+// none
+func (p *Peer) SourceReachability() map[string]bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.sourceResultsCopy()
+}
+
+// sourceResultsCopy must be called with the lock held
+func (p *Peer) sourceResultsCopy() map[string]bool {
+	if p.sourceResults == nil {
+		return nil
+	}
+	results := make(map[string]bool, len(p.sourceResults))
+	for source, ok := range p.sourceResults {
+		results[source] = ok
+	}
+	return results
+}
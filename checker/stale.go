@@ -0,0 +1,110 @@
+package checker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rancher/go-rancher-metadata/metadata"
+)
+
+const (
+	// DefaultStaleCheckInterval is the default interval, in
+	// milliseconds, at which the peers stale in metadata are still
+	// checked
+	DefaultStaleCheckInterval = 600000
+)
+
+// containerChanged informs if the container of the peer changed in
+// metadata, it must be called with the lock held
+func (p *Peer) containerChanged(container *metadata.Container) bool {
+	if p.container == nil || container == nil {
+		return p.container != container
+	}
+	return container.State != p.container.State ||
+		container.PrimaryIp != p.container.PrimaryIp ||
+		container.StartCount != p.container.StartCount ||
+		container.CreateIndex != p.container.CreateIndex
+}
+
+// metadataUpdated returns when the container of the peer was last
+// updated in metadata: the time held by PeerConfig.StaleTimestampLabel,
+// RFC 3339 or seconds since the epoch, when set, otherwise the last
+// time its container was seen changing, or the start of the checks. It
+// must be called with the lock held.
+func (p *Peer) metadataUpdated() time.Time {
+	if label := p.config.StaleTimestampLabel; label != "" && p.container != nil {
+		if v := p.container.Labels[label]; v != "" {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				return t
+			}
+			if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return time.Unix(secs, 0)
+			}
+		}
+	}
+	if !p.metadataUpdatedAt.IsZero() {
+		return p.metadataUpdatedAt
+	}
+	return p.startedAt
+}
+
+// isStale informs if the container of the peer wasn't updated in
+// metadata for PeerConfig.StaleAfter, it must be called with the lock
+// held
+func (p *Peer) isStale() bool {
+	if p.config.StaleAfter <= 0 || p.target != nil {
+		return false
+	}
+	updated := p.metadataUpdated()
+	return !updated.IsZero() && p.now().Sub(updated) >= time.Duration(p.config.StaleAfter)*time.Millisecond
+}
+
+// skipStale informs if the check due is to be skipped, the peer being
+// stale and checked less than StaleCheckInterval ago. It must be
+// called with the lock held.
+func (p *Peer) skipStale() bool {
+	if !p.isStale() {
+		return false
+	}
+	interval := p.config.StaleCheckInterval
+	if interval <= 0 {
+		interval = DefaultStaleCheckInterval
+	}
+	now := p.now()
+	if !p.lastStaleCheck.IsZero() && now.Sub(p.lastStaleCheck) < time.Duration(interval)*time.Millisecond {
+		p.staleSkips++
+		return true
+	}
+	p.lastStaleCheck = now
+	return false
+}
+
+// Stale informs if the container of the peer wasn't updated in
+// metadata for PeerConfig.StaleAfter, the peer being then only checked
+// every StaleCheckInterval
+func (p *Peer) Stale() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.isStale()
+}
+
+// StaleSkips returns the number of checks of the peer skipped because
+// it was stale in metadata
+func (p *Peer) StaleSkips() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.staleSkips
+}
+
+// StaleSkips returns the number of checks of the peers skipped because
+// they were stale in metadata, see PeerConfig.StaleAfter
+func (pw *PeersWatcher) StaleSkips() uint64 {
+	pw.Lock()
+	peers := pw.allPeers()
+	pw.Unlock()
+	var skips uint64
+	for _, aPeer := range peers {
+		skips += aPeer.lastStatus().StaleSkips
+	}
+	return skips
+}
@@ -0,0 +1,73 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/rancher/log"
+)
+
+const (
+	// DefaultStartupInterval is the default interval, in milliseconds,
+	// at which at most StartupRate new peers are started
+	DefaultStartupInterval = 1000
+)
+
+// queueStartup queues the new peers to be started by runStartupQueue,
+// in order. It must be called with the lock held.
+func (pw *PeersWatcher) queueStartup(peers []*Peer) {
+	pw.startupQueue = append(pw.startupQueue, peers...)
+}
+
+// dequeueStartup drops a peer gone before being started, it must be
+// called with the lock held
+func (pw *PeersWatcher) dequeueStartup(aPeer *Peer) {
+	for i, queued := range pw.startupQueue {
+		if queued == aPeer {
+			pw.startupQueue = append(pw.startupQueue[:i], pw.startupQueue[i+1:]...)
+			return
+		}
+	}
+}
+
+// runStartupQueue starts the queued new peers, at most StartupRate
+// every StartupInterval, so that a big scale up doesn't create
+// thousands of goroutines at once
+func (pw *PeersWatcher) runStartupQueue() {
+	interval := pw.config.StartupInterval
+	if interval <= 0 {
+		interval = DefaultStartupInterval
+	}
+	clock := clockOrReal(pw.config.Clock)
+	for {
+		pw.Lock()
+		n := pw.config.StartupRate
+		if n > len(pw.startupQueue) {
+			n = len(pw.startupQueue)
+		}
+		batch := pw.startupQueue[:n]
+		pw.startupQueue = append([]*Peer(nil), pw.startupQueue[n:]...)
+		for _, aPeer := range batch {
+			if err := aPeer.Start(); err != nil {
+				log.Errorf("error starting peer %v: %v", aPeer.uuid, err)
+			}
+		}
+		if len(pw.startupQueue) > 0 {
+			log.Debugf("PeersWatcher: %v peers waiting to be started", len(pw.startupQueue))
+		}
+		pw.Unlock()
+
+		select {
+		case <-pw.exit:
+			return
+		case <-clock.After(time.Duration(interval) * time.Millisecond):
+		}
+	}
+}
+
+// StartupQueueDepth returns the number of new peers waiting to be
+// started, see Config.StartupRate
+func (pw *PeersWatcher) StartupQueueDepth() int {
+	pw.Lock()
+	defer pw.Unlock()
+	return len(pw.startupQueue)
+}
@@ -0,0 +1,147 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStateLabel is the label of the containers of the peers
+	// the RancherStateWriter writes their reachability to
+	DefaultStateLabel = "io.rancher.connectivity-check.state"
+
+	// rancherAPITimeout bounds a request to the Rancher API
+	rancherAPITimeout = 10 * time.Second
+	// rancherBodyLimit bounds how much of a response of the Rancher
+	// API is read
+	rancherBodyLimit = 1 << 20
+)
+
+// RancherStateWriter is a StateWriter setting the reachability of a
+// peer, reachable or unreachable, as a label of its container through
+// the Rancher API, e.g. with the CATTLE_URL, CATTLE_ACCESS_KEY and
+// CATTLE_SECRET_KEY given to the agent. The other labels are kept.
+type RancherStateWriter struct {
+	url       string
+	accessKey string
+	secretKey string
+	label     string
+	client    *http.Client
+}
+
+// rancherContainer is what the writer needs of a container of the
+// Rancher API
+type rancherContainer struct {
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels"`
+	Links  struct {
+		Self string `json:"self"`
+	} `json:"links"`
+}
+
+// NewRancherStateWriter returns a RancherStateWriter going through the
+// Rancher API at the given URL, e.g. http://rancher:8080/v2-beta, with
+// the given API keys, and writing the given label, DefaultStateLabel
+// when empty
+func NewRancherStateWriter(apiURL, accessKey, secretKey, label string) (*RancherStateWriter, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Rancher API URL %q, expected http(s)://host[:port]/version", apiURL)
+	}
+	if label == "" {
+		label = DefaultStateLabel
+	}
+	return &RancherStateWriter{
+		url:       strings.TrimSuffix(apiURL, "/"),
+		accessKey: accessKey,
+		secretKey: secretKey,
+		label:     label,
+		client:    &http.Client{Timeout: rancherAPITimeout},
+	}, nil
+}
+
+func (w *RancherStateWriter) WriteState(status PeerStatus) error {
+	container, err := w.container(status.UUID)
+	if err != nil {
+		return err
+	}
+	state := "unreachable"
+	if status.Reachable {
+		state = "reachable"
+	}
+	if container.Labels[w.label] == state {
+		return nil
+	}
+	labels := map[string]string{w.label: state}
+	for k, v := range container.Labels {
+		if k != w.label {
+			labels[k] = v
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"labels": labels})
+	if err != nil {
+		return err
+	}
+	self := container.Links.Self
+	if self == "" {
+		self = fmt.Sprintf("%v/containers/%v", w.url, container.ID)
+	}
+	return w.do(http.MethodPut, self, body, nil)
+}
+
+// container returns the container of the Rancher API with the given
+// uuid
+func (w *RancherStateWriter) container(uuid string) (rancherContainer, error) {
+	var collection struct {
+		Data []rancherContainer `json:"data"`
+	}
+	q := url.Values{}
+	q.Set("uuid", uuid)
+	if err := w.do(http.MethodGet, fmt.Sprintf("%v/containers?%v", w.url, q.Encode()), nil, &collection); err != nil {
+		return rancherContainer{}, err
+	}
+	if len(collection.Data) == 0 {
+		return rancherContainer{}, fmt.Errorf("container %v not found in the Rancher API", uuid)
+	}
+	return collection.Data[0], nil
+}
+
+// do sends a request to the Rancher API, decoding the response in
+// result when not nil
+func (w *RancherStateWriter) do(method, u string, body []byte, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if w.accessKey != "" {
+		req.SetBasicAuth(w.accessKey, w.secretKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting the Rancher API: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error requesting the Rancher API: %v %v got StatusCode: %v", method, u, resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, rancherBodyLimit)).Decode(result)
+}
@@ -0,0 +1,41 @@
+package checker
+
+const (
+	// DefaultSuccessRateMinChecks is the default number of checks
+	// of a peer before its success rate is deemed meaningful
+	DefaultSuccessRateMinChecks = 5
+)
+
+// SuccessRate returns the fraction of the checks of the peer which
+// succeeded over the lifetime of the process, 0 before any check. It's
+// not confident until PeerConfig.SuccessRateMinChecks checks were
+// done, a single early failure making it 0.
+func (p *Peer) SuccessRate() (rate float64, confident bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.successRate(), p.successRateConfident()
+}
+
+// successRateConfident must be called with the lock held
+func (p *Peer) successRateConfident() bool {
+	min := p.config.SuccessRateMinChecks
+	if min <= 0 {
+		min = DefaultSuccessRateMinChecks
+	}
+	return p.attempts >= uint64(min)
+}
+
+func (p *Peer) successRate() float64 {
+	if p.attempts == 0 {
+		return 0
+	}
+	return float64(p.successes) / float64(p.attempts)
+}
+
+// Attempts returns the number of checks of the peer
+// and how many of them succeeded
+func (p *Peer) Attempts() (attempts, successes uint64) {
+	p.Lock()
+	defer p.Unlock()
+	return p.attempts, p.successes
+}
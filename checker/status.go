@@ -0,0 +1,317 @@
+package checker
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/log"
+)
+
+// PeerStatus is a point in time copy of the state of a Peer
+type PeerStatus struct {
+	UUID                 string                      `json:"uuid"`
+	HostIP               string                      `json:"hostIP"`
+	IP                   string                      `json:"ip"`
+	Mode                 string                      `json:"mode"`
+	Labels               map[string]string           `json:"labels,omitempty"`
+	Considered           bool                        `json:"considered"`
+	Enabled              bool                        `json:"enabled"`
+	Selected             bool                        `json:"selected"`
+	Draining             bool                        `json:"draining"`
+	Reachable            bool                        `json:"reachable"`
+	HostReachable        bool                        `json:"hostReachable"`
+	HostLatency          time.Duration               `json:"hostLatency,omitempty"`
+	DirectReachable      bool                        `json:"directReachable"`
+	VIPReachable         *bool                       `json:"vipReachable,omitempty"`
+	VIPLatency           time.Duration               `json:"vipLatency,omitempty"`
+	NeighborReachable    *bool                       `json:"neighborReachable,omitempty"`
+	HealthClass          string                      `json:"healthClass"`
+	UnsaturatedSince     time.Time                   `json:"unsaturatedSince"`
+	LastLatency          time.Duration               `json:"lastLatency"`
+	LastConnectTime      time.Duration               `json:"lastConnectTime,omitempty"`
+	BaselineLatency      time.Duration               `json:"baselineLatency"`
+	LossRate             float64                     `json:"lossRate"`
+	SuccessRate          float64                     `json:"successRate"`
+	SuccessRateConfident bool                        `json:"successRateConfident"`
+	ConsecutiveFailures  int                         `json:"consecutiveFailures"`
+	Count                int                         `json:"count"`
+	WindowFill           int                         `json:"windowFill,omitempty"`
+	WindowSuccesses      int                         `json:"windowSuccesses,omitempty"`
+	FailureReason        utils.FailureReason         `json:"failureReason,omitempty"`
+	LastChecked          time.Time                   `json:"lastChecked"`
+	ActualInterval       time.Duration               `json:"actualInterval,omitempty"`
+	OverrideRemaining    time.Duration               `json:"overrideRemaining,omitempty"`
+	DownSince            time.Time                   `json:"downSince"`
+	DownCause            string                      `json:"downCause,omitempty"`
+	DNSMismatch          bool                        `json:"dnsMismatch"`
+	ReverseDNS           string                      `json:"reverseDns,omitempty"`
+	SuspectedMTU         bool                        `json:"suspectedMTU"`
+	CertExpiry           time.Time                   `json:"certExpiry,omitempty"`
+	ForwardLatency       time.Duration               `json:"forwardLatency,omitempty"`
+	ReturnLatency        time.Duration               `json:"returnLatency,omitempty"`
+	OpenConnections      int64                       `json:"openConnections"`
+	ConnectionReuseRate  float64                     `json:"connectionReuseRate"`
+	Endpoint             string                      `json:"endpoint,omitempty"`
+	Ports                map[int]bool                `json:"ports,omitempty"`
+	ParallelSuccesses    int                         `json:"parallelSuccesses,omitempty"`
+	CorrelationID        string                      `json:"correlationId,omitempty"`
+	Live                 bool                        `json:"live"`
+	Ready                bool                        `json:"ready"`
+	Sources              map[string]bool             `json:"sources,omitempty"`
+	SchemaVersion        int                         `json:"schemaVersion,omitempty"`
+	ReasonStreaks        map[utils.FailureReason]int `json:"reasonStreaks,omitempty"`
+	IgnoredReasons       []utils.FailureReason       `json:"ignoredReasons,omitempty"`
+	TCPMSS               int                         `json:"tcpMss,omitempty"`
+	DiagnosticValue      *float64                    `json:"diagnosticValue,omitempty"`
+	Quarantined          bool                        `json:"quarantined"`
+	Stuck                bool                        `json:"stuck"`
+	RetriesLeft          *float64                    `json:"retriesLeft,omitempty"`
+	RequestSuccessRatio  *float64                    `json:"requestSuccessRatio,omitempty"`
+	Relay                *RelayResult                `json:"relay,omitempty"`
+	TooSlowChecks        uint64                      `json:"tooSlowChecks,omitempty"`
+	Stale                bool                        `json:"stale"`
+	StaleSkips           uint64                      `json:"staleSkips,omitempty"`
+	Restarts             int                         `json:"restarts,omitempty"`
+	QuarantinedUntil     time.Time                   `json:"quarantinedUntil"`
+}
+
+// Status returns the current status of the peer
+func (p *Peer) Status() PeerStatus {
+	p.Lock()
+	defer p.Unlock()
+	return p.status()
+}
+
+// publishStatus publishes the status of the peer for lastStatus, it
+// must be called with the lock held
+func (p *Peer) publishStatus() {
+	status := p.status()
+	p.publishedMu.Lock()
+	p.published = status
+	p.publishedMu.Unlock()
+}
+
+// lastStatus returns the current status of the peer, or the one
+// published as its check in flight started rather than waiting for
+// the check to be over
+func (p *Peer) lastStatus() PeerStatus {
+	if p.TryLock() {
+		defer p.Unlock()
+		return p.status()
+	}
+	p.publishedMu.Lock()
+	defer p.publishedMu.Unlock()
+	return p.published
+}
+
+func (p *Peer) status() PeerStatus {
+	if p.replicaStatus != nil {
+		return *p.replicaStatus
+	}
+	var labels map[string]string
+	if p.container != nil && len(p.container.Labels) > 0 {
+		labels = make(map[string]string, len(p.container.Labels))
+		for k, v := range p.container.Labels {
+			labels[k] = v
+		}
+	}
+	var diagnosticValue *float64
+	if p.hasDiagnosticValue {
+		value := p.diagnosticValue
+		diagnosticValue = &value
+	}
+	var vipReachable *bool
+	if p.vipChecked {
+		reachable := p.vipReachable
+		vipReachable = &reachable
+	}
+	var neighborReachable *bool
+	if p.neighborChecked {
+		reachable := p.neighborReachable
+		neighborReachable = &reachable
+	}
+	return PeerStatus{
+		UUID:                 p.uuid,
+		HostIP:               p.getHostIP(),
+		IP:                   p.getIP(),
+		Mode:                 p.mode(),
+		Labels:               labels,
+		Considered:           p.consider(),
+		Enabled:              !p.disabled,
+		Selected:             !p.unselected,
+		Draining:             p.draining,
+		Reachable:            p.reportedReachable,
+		HostReachable:        p.hostReachable,
+		HostLatency:          p.hostLatency,
+		DirectReachable:      p.directReachable,
+		VIPReachable:         vipReachable,
+		VIPLatency:           p.vipLatency,
+		NeighborReachable:    neighborReachable,
+		HealthClass:          p.healthClass(),
+		UnsaturatedSince:     p.unsaturatedSince,
+		LastLatency:          p.lastLatency,
+		LastConnectTime:      p.lastConnectTime,
+		BaselineLatency:      p.baselineLatency,
+		LossRate:             p.lossRate,
+		SuccessRate:          p.successRate(),
+		SuccessRateConfident: p.successRateConfident(),
+		ConsecutiveFailures:  p.consecutiveFailures,
+		Count:                p.count,
+		WindowFill:           len(p.window),
+		WindowSuccesses:      p.windowSuccesses(),
+		FailureReason:        p.failureReason,
+		LastChecked:          p.lastChecked,
+		ActualInterval:       p.actualInterval,
+		OverrideRemaining:    p.overrideRemaining(),
+		DownSince:            p.downSince,
+		DownCause:            p.downCause,
+		DNSMismatch:          p.dnsMismatch,
+		ReverseDNS:           p.reverseDNSName(),
+		SuspectedMTU:         p.suspectedMTU,
+		CertExpiry:           p.certNotAfter,
+		ForwardLatency:       p.forwardLatency,
+		ReturnLatency:        p.returnLatency,
+		OpenConnections:      p.openConnections(),
+		ConnectionReuseRate:  p.connectionReuseRate(),
+		Endpoint:             p.lastAddress,
+		Ports:                p.portResultsCopy(),
+		ParallelSuccesses:    p.parallelSuccesses,
+		CorrelationID:        p.correlationID,
+		Live:                 p.live,
+		Ready:                p.ready,
+		Sources:              p.sourceResultsCopy(),
+		SchemaVersion:        p.schemaVersion,
+		ReasonStreaks:        p.reasonStreaksCopy(),
+		IgnoredReasons:       p.ignoredReasonsList(),
+		TCPMSS:               p.config.TCPMSS,
+		DiagnosticValue:      diagnosticValue,
+		Quarantined:          p.isQuarantined(),
+		Stuck:                p.Stuck(),
+		RetriesLeft:          p.retriesLeft(),
+		RequestSuccessRatio:  p.requestSuccessRatio,
+		Relay:                p.relayResult,
+		TooSlowChecks:        p.tooSlow,
+		Stale:                p.isStale(),
+		StaleSkips:           p.staleSkips,
+		Restarts:             p.restarts,
+		QuarantinedUntil:     p.quarantinedUntil,
+	}
+}
+
+// allPeers returns the peers found in metadata followed by
+// the fixed targets, it must be called with the lock held
+func (pw *PeersWatcher) allPeers() []*Peer {
+	peers := make([]*Peer, 0, len(pw.peers)+len(pw.targetPeers))
+	for _, aPeer := range pw.peers {
+		peers = append(peers, aPeer)
+	}
+	return append(peers, pw.targetPeers...)
+}
+
+// Statuses returns the status of every peer and target
+func (pw *PeersWatcher) Statuses() []PeerStatus {
+	pw.Lock()
+	peers := pw.allPeers()
+	pw.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, aPeer := range peers {
+		statuses = append(statuses, aPeer.Status())
+	}
+	return statuses
+}
+
+// Snapshot returns the status of every peer and target without
+// waiting for the checks in flight, the peers being checked reporting
+// their status as their check started, see lastStatus
+func (pw *PeersWatcher) Snapshot() []PeerStatus {
+	pw.Lock()
+	peers := pw.allPeers()
+	pw.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, aPeer := range peers {
+		statuses = append(statuses, aPeer.lastStatus())
+	}
+	return statuses
+}
+
+// peersWithUUID returns the peers and targets with the given uuid, it
+// must be called with the lock held
+func (pw *PeersWatcher) peersWithUUID(uuid string) []*Peer {
+	var peers []*Peer
+	for _, aPeer := range pw.allPeers() {
+		if aPeer.uuid == uuid {
+			peers = append(peers, aPeer)
+		}
+	}
+	return peers
+}
+
+// StatusGroup holds the status of the peers sharing the
+// same value of a label
+type StatusGroup struct {
+	Reachable int          `json:"reachable"`
+	Total     int          `json:"total"`
+	Peers     []PeerStatus `json:"peers"`
+}
+
+// groupStatuses groups the statuses by the value of the given label,
+// the peers without it being grouped under the empty value
+func groupStatuses(statuses []PeerStatus, label string) map[string]*StatusGroup {
+	groups := make(map[string]*StatusGroup)
+	for _, status := range statuses {
+		value := status.Labels[label]
+		group, ok := groups[value]
+		if !ok {
+			group = &StatusGroup{}
+			groups[value] = group
+		}
+		group.Total++
+		if status.Reachable {
+			group.Reachable++
+		}
+		group.Peers = append(group.Peers, status)
+	}
+	return groups
+}
+
+// statusHandler reports the status of the peers, grouped by the
+// label given by the group query parameter, Config.StatusGroupLabel
+// by default, if any. Otherwise, for huge clusters, the statuses
+// can be paginated with the offset and limit query parameters, or
+// streamed as JSON lines with format=ndjson. They're exported as CSV
+// with format=csv, see ExportCSV.
+func (pw *PeersWatcher) statusHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	label := query.Get("group")
+	if label == "" {
+		label = pw.config.StatusGroupLabel
+	}
+	if label == "" {
+		switch {
+		case query.Get("format") == "ndjson":
+			streamStatuses(w, pw.Snapshot())
+		case query.Get("format") == "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			if err := pw.ExportCSV(w); err != nil {
+				log.Errorf("error writing response: %v", err)
+			}
+		case query.Get("offset") != "" || query.Get("limit") != "":
+			pw.writeStatusPage(w, r)
+		default:
+			writeJSON(w, pw.Snapshot())
+		}
+		return
+	}
+	writeJSON(w, groupStatuses(pw.Snapshot(), label))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("error writing response: %v", err)
+	}
+}
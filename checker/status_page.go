@@ -0,0 +1,98 @@
+package checker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/rancher/log"
+)
+
+const (
+	// totalCountHeader carries the number of peers of a paginated
+	// response of /status
+	totalCountHeader = "X-Total-Count"
+
+	// streamFlushEvery is how many statuses are streamed between two
+	// flushes of the response
+	streamFlushEvery = 100
+)
+
+// SnapshotPage returns the statuses of the peers and targets from
+// offset, sorted by UUID, at most limit of them when not 0, along
+// with the number of peers. Only the statuses of the peers of the page
+// are copied, so that a page of a huge cluster is cheap, without
+// waiting for the checks in flight, see Snapshot.
+func (pw *PeersWatcher) SnapshotPage(offset, limit int) ([]PeerStatus, int) {
+	pw.Lock()
+	peers := pw.allPeers()
+	total := len(peers)
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].uuid < peers[j].uuid
+	})
+	if offset > len(peers) {
+		offset = len(peers)
+	}
+	peers = peers[offset:]
+	if limit > 0 && limit < len(peers) {
+		peers = peers[:limit]
+	}
+	pw.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, aPeer := range peers {
+		statuses = append(statuses, aPeer.lastStatus())
+	}
+	return statuses, total
+}
+
+// queryInt returns the integer query parameter of the given name,
+// 0 when missing
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
+
+// writeStatusPage reports the page of the statuses given by the
+// offset and limit query parameters, the number of peers being in
+// the X-Total-Count header
+func (pw *PeersWatcher) writeStatusPage(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset")
+	if err != nil {
+		http.Error(w, "invalid offset: "+r.URL.Query().Get("offset"), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		http.Error(w, "invalid limit: "+r.URL.Query().Get("limit"), http.StatusBadRequest)
+		return
+	}
+	statuses, total := pw.SnapshotPage(offset, limit)
+	w.Header().Set(totalCountHeader, strconv.Itoa(total))
+	writeJSON(w, statuses)
+}
+
+// streamStatuses reports the statuses as JSON lines, flushed as they
+// are written so that the response is never buffered whole
+func streamStatuses(w http.ResponseWriter, statuses []PeerStatus) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, status := range statuses {
+		if err := enc.Encode(status); err != nil {
+			log.Errorf("error streaming statuses: %v", err)
+			return
+		}
+		if flusher != nil && (i+1)%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+}
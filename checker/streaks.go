@@ -0,0 +1,42 @@
+package checker
+
+import (
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// recordReasonStreak counts the failed check of the given reason in
+// the streak of its reason, the streaks of the other reasons being
+// broken, see PeerConfig.ReasonStreaks. It must be called with the
+// lock held.
+func (p *Peer) recordReasonStreak(reason utils.FailureReason) {
+	if !p.config.ReasonStreaks {
+		return
+	}
+	if reason == utils.FailureNone {
+		reason = utils.FailureOther
+	}
+	streak := p.reasonStreaks[reason]
+	p.reasonStreaks = map[utils.FailureReason]int{reason: streak + 1}
+}
+
+// ReasonStreaks returns the number of consecutive failed checks of
+// the reason of the last ones, e.g. 10 straight timeouts, nil when
+// the last check succeeded or PeerConfig.ReasonStreaks isn't set.
+// Alternating reasons keep the streaks at 1.
+func (p *Peer) ReasonStreaks() map[utils.FailureReason]int {
+	p.Lock()
+	defer p.Unlock()
+	return p.reasonStreaksCopy()
+}
+
+// reasonStreaksCopy must be called with the lock held
+func (p *Peer) reasonStreaksCopy() map[utils.FailureReason]int {
+	if p.reasonStreaks == nil {
+		return nil
+	}
+	streaks := make(map[utils.FailureReason]int, len(p.reasonStreaks))
+	for reason, streak := range p.reasonStreaks {
+		streaks[reason] = streak
+	}
+	return streaks
+}
@@ -0,0 +1,95 @@
+package checker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultStuckCheckMultiple is the default number of check
+	// intervals a peer can go without being checked before being
+	// reported stuck
+	DefaultStuckCheckMultiple = 10
+)
+
+// detectStuckPeers flags the peers which should be checked but whose
+// last check is too old, a safety net against the bugs stopping the
+// checks without anything else noticing. It must be called with the
+// lock held.
+func (pw *PeersWatcher) detectStuckPeers() {
+	multiple := pw.config.StuckCheckMultiple
+	if multiple <= 0 {
+		multiple = DefaultStuckCheckMultiple
+	}
+	now := clockOrReal(pw.config.Clock).Now()
+	for _, aPeer := range pw.allPeers() {
+		aPeer.updateStuck(multiple, now)
+	}
+}
+
+// updateStuck records if the peer went more than multiple intervals
+// without being checked, warning when it becomes stuck. Peers left
+// out of the checks, e.g. disabled or not considered, aren't stuck.
+// The lock being held for the whole check, a peer whose lock is taken,
+// e.g. by a check that hangs, isn't waited for: it's stuck once now is
+// past the deadline published when last seen.
+func (p *Peer) updateStuck(multiple int, now time.Time) {
+	if !p.TryLock() {
+		deadline := atomic.LoadInt64(&p.stuckDeadline)
+		p.setStuck(deadline != 0 && now.UnixNano() > deadline, multiple)
+		return
+	}
+	defer p.Unlock()
+	stuck := false
+	var deadline int64
+	if !p.disabled && !p.unselected && p.consider() {
+		last := p.lastChecked
+		if last.IsZero() {
+			last = p.startedAt
+		}
+		limit := time.Duration(multiple) * p.checkIntervalDuration()
+		if !last.IsZero() {
+			deadline = last.Add(limit).UnixNano()
+			stuck = p.now().Sub(last) > limit
+		}
+	}
+	atomic.StoreInt64(&p.stuckDeadline, deadline)
+	p.setStuck(stuck, multiple)
+}
+
+// setStuck records if the peer is stuck, logging when it changes. It's
+// called with the lock of the peer held or the one of its watcher,
+// either guarding the uuid.
+func (p *Peer) setStuck(stuck bool, multiple int) {
+	var flag int32
+	if stuck {
+		flag = 1
+	}
+	if atomic.SwapInt32(&p.stuck, flag) == flag {
+		return
+	}
+	if stuck {
+		p.logger.Warnf("Peer(%v): stuck, not checked in more than %v intervals", p.uuid, multiple)
+	} else {
+		p.logger.Infof("Peer(%v): no longer stuck", p.uuid)
+	}
+}
+
+// Stuck informs if the peer should be checked but wasn't in a while,
+// see Config.StuckCheckMultiple
+func (p *Peer) Stuck() bool {
+	return atomic.LoadInt32(&p.stuck) == 1
+}
+
+// StuckPeers returns the uuids of the peers reported stuck
+func (pw *PeersWatcher) StuckPeers() []string {
+	pw.Lock()
+	defer pw.Unlock()
+	var uuids []string
+	for _, aPeer := range pw.allPeers() {
+		if aPeer.Stuck() {
+			uuids = append(uuids, aPeer.uuid)
+		}
+	}
+	return uuids
+}
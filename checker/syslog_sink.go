@@ -0,0 +1,135 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package checker
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rancher/log"
+)
+
+// syslogBuffer is how many transitions can be pending for syslog,
+// the transitions beyond are dropped
+const syslogBuffer = 256
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+var syslogSeverities = map[string]syslog.Priority{
+	"emerg": syslog.LOG_EMERG, "alert": syslog.LOG_ALERT, "crit": syslog.LOG_CRIT,
+	"err": syslog.LOG_ERR, "warning": syslog.LOG_WARNING, "notice": syslog.LOG_NOTICE,
+	"info": syslog.LOG_INFO, "debug": syslog.LOG_DEBUG,
+}
+
+// SyslogSink sends the transitions of the peers to syslog, one
+// structured line each, for alerting based on syslog. The lines are
+// sent by a goroutine, so that a slow or unreachable syslog server
+// never delays the checks: the connection is established again on
+// the next transition once lost, and the transitions are dropped when
+// too many are pending.
+type SyslogSink struct {
+	network, address string
+	priority         syslog.Priority
+	tag              string
+	writer           *syslog.Writer
+	lines            chan string
+	done             chan struct{}
+	dropped          uint64
+}
+
+// NewSyslogSink returns a SyslogSink sending to the local syslog
+// when address is empty, to the syslog server at address over network,
+// e.g. udp or tcp, otherwise. The facility and the severity are named
+// as in syslog.conf, e.g. daemon and warning.
+func NewSyslogSink(network, address, facility, severity, tag string) (*SyslogSink, error) {
+	f, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %v", facility)
+	}
+	s, ok := syslogSeverities[strings.ToLower(severity)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog severity: %v", severity)
+	}
+	if address == "" {
+		// The local syslog is reached over its unix socket
+		network = ""
+	}
+	sink := &SyslogSink{
+		network:  network,
+		address:  address,
+		priority: f | s,
+		tag:      tag,
+		lines:    make(chan string, syslogBuffer),
+		done:     make(chan struct{}),
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// StateChanged sends the transition of a peer to syslog, it has the
+// signature of PeerConfig.OnStateChange
+func (s *SyslogSink) StateChanged(peer *Peer, reachable bool) {
+	status := peer.Status()
+	line := fmt.Sprintf("peer=%v host=%v ip=%v reachable=%v", status.UUID, status.HostIP, status.IP, reachable)
+	if !reachable && status.FailureReason != "" {
+		line += fmt.Sprintf(" reason=%q", status.FailureReason)
+	}
+	select {
+	case s.lines <- line:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns the number of transitions dropped because too many
+// were pending
+func (s *SyslogSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *SyslogSink) run() {
+	defer close(s.done)
+	for line := range s.lines {
+		if err := s.write(line); err != nil {
+			log.Errorf("error sending transition to syslog: %v", err)
+		}
+	}
+	if s.writer != nil {
+		s.writer.Close()
+	}
+}
+
+// write sends a line, connecting to syslog first if not connected
+// yet or the connection was lost
+func (s *SyslogSink) write(line string) error {
+	if s.writer == nil {
+		w, err := syslog.Dial(s.network, s.address, s.priority, s.tag)
+		if err != nil {
+			return err
+		}
+		s.writer = w
+	}
+	if _, err := s.writer.Write([]byte(line)); err != nil {
+		s.writer.Close()
+		s.writer = nil
+		return err
+	}
+	return nil
+}
+
+// Close sends the pending transitions and closes the connection to
+// syslog, StateChanged must no longer be called
+func (s *SyslogSink) Close() error {
+	close(s.lines)
+	<-s.done
+	return nil
+}
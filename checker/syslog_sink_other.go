@@ -0,0 +1,31 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package checker
+
+import (
+	"fmt"
+)
+
+// SyslogSink sends the transitions of the peers to syslog, which
+// isn't available on this platform
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error, syslog isn't available on this
+// platform
+func NewSyslogSink(network, address, facility, severity, tag string) (*SyslogSink, error) {
+	return nil, fmt.Errorf("syslog isn't supported on this platform")
+}
+
+// StateChanged does nothing
+func (s *SyslogSink) StateChanged(peer *Peer, reachable bool) {}
+
+// Dropped returns 0
+func (s *SyslogSink) Dropped() uint64 {
+	return 0
+}
+
+// Close does nothing
+func (s *SyslogSink) Close() error {
+	return nil
+}
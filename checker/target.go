@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Target is a fixed endpoint which is checked like a peer,
+// but without being backed by metadata
+type Target struct {
+	Name string
+	IP   string
+	Port int
+	Path string
+	Mode string
+}
+
+// ParseTarget parses a target in the [mode://]ip[:port][/path] form,
+// e.g. tcp://10.43.0.1:6443 or 10.42.0.10:8080/healthz, an IPv6
+// address being bracketed, e.g. [fd00::1]:8080 or [fd00::1]/healthz
+func ParseTarget(s string) (Target, error) {
+	t := Target{Name: s, Mode: ModeHTTP, Port: DefaultCheckPort, Path: defaultCheckPath}
+
+	rest := s
+	if i := strings.Index(rest, "://"); i >= 0 {
+		t.Mode = rest[:i]
+		rest = rest[i+3:]
+	}
+	if _, err := getChecker(t.Mode); err != nil {
+		return t, err
+	}
+
+	if i := strings.Index(rest, "/"); i >= 0 {
+		t.Path = rest[i:]
+		rest = rest[:i]
+	}
+
+	host, portStr, err := net.SplitHostPort(rest)
+	if err != nil {
+		host = rest
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	} else {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return t, fmt.Errorf("invalid port in target %v: %v", s, err)
+		}
+		t.Port = port
+	}
+	if host == "" {
+		return t, fmt.Errorf("missing ip in target %v", s)
+	}
+	t.IP = host
+
+	return t, nil
+}
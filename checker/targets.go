@@ -0,0 +1,69 @@
+package checker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+)
+
+// targetResult is the result of the probe of a target of a peer done
+// in the background, e.g. the agent of its host
+type targetResult struct {
+	ok      bool
+	err     error
+	latency time.Duration
+}
+
+// checkDeadline returns when a check of the peer starting now is to be
+// done, so that it's before the next one is due, leaving it the time
+// of a request at least. It must be called with the lock held.
+func (p *Peer) checkDeadline() time.Time {
+	timeout := time.Duration(p.connectionTimeout()) * time.Millisecond
+	d := p.checkIntervalDuration() - timeout
+	if d < timeout {
+		d = timeout
+	}
+	return time.Now().Add(d)
+}
+
+// startTarget probes a target of the peer in the background, within
+// the limiter, the result being sent on the returned channel. The
+// target counts as failed when it can't be probed by the deadline. It
+// must be called with the lock held, check being done without it.
+func (p *Peer) startTarget(name string, deadline time.Time, check func() (bool, error)) <-chan targetResult {
+	result := make(chan targetResult, 1)
+	hostIP := p.getHostIP()
+	p.goRun(name, func() {
+		release := p.limiter.acquire(hostIP)
+		defer release()
+		if !time.Now().Before(deadline) {
+			result <- targetResult{err: errTargetDeadline}
+			return
+		}
+		start := time.Now()
+		ok, err := check()
+		result <- targetResult{ok: ok, err: err, latency: time.Since(start)}
+	})
+	return result
+}
+
+// errTargetDeadline is the failure of a target not probed by the
+// deadline of the check
+var errTargetDeadline = &utils.CheckError{
+	Reason: utils.FailureCheckDeadline,
+	Err:    fmt.Errorf("not probed before the next check was due"),
+}
+
+// awaitTarget waits for the result of a target probed in the
+// background, until the deadline of the check
+func awaitTarget(result <-chan targetResult, deadline time.Time) targetResult {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r
+	case <-timer.C:
+		return targetResult{err: errTargetDeadline}
+	}
+}
@@ -0,0 +1,26 @@
+package checker
+
+import (
+	"context"
+)
+
+// Tracer creates the spans wrapping the checks of the peers. It's
+// meant to be backed by the tracing library of the embedder, e.g. an
+// OpenTelemetry tracer, so that the checks get correlated with the
+// application traces.
+type Tracer interface {
+	// StartSpan starts a span, child of the one carried by ctx if
+	// any, and returns the context carrying the new span
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced check, or request of a check
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+const (
+	probeSpanName   = "connectivity-check.probe"
+	requestSpanName = "connectivity-check.request"
+)
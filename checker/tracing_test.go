@@ -0,0 +1,74 @@
+package checker
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+// recordedSpan is a Span of the recordingTracer
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+// recordingTracer is a Tracer recording the spans it starts, along
+// with their parent
+type recordingTracer struct {
+	sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.Lock()
+	defer t.Unlock()
+	span := &recordedSpan{name: name, attributes: make(map[string]interface{})}
+	span.parent, _ = ctx.Value(spanKey{}).(*recordedSpan)
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestPeerTracesRequestsAsChildrenOfCheck(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	tracer := &recordingTracer{}
+	p.config.Tracer = tracer
+	p.doWork()
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected the spans of the check and of its request, got %v", len(tracer.spans))
+	}
+	check, request := tracer.spans[0], tracer.spans[1]
+	if check.name != probeSpanName || check.parent != nil || check.attributes["peer.uuid"] != p.uuid {
+		t.Fatalf("expected the root span of the check, got %+v", check)
+	}
+	if request.name != requestSpanName || request.parent != check || request.attributes["request.ok"] != true {
+		t.Fatalf("expected the span of the request child of the check, got %+v", request)
+	}
+	if !check.ended || !request.ended {
+		t.Fatalf("expected both spans to be ended")
+	}
+	// The Checker gets the span of its request along with the probe
+	if got := tc.probes[0].Context.Value(spanKey{}); got != request {
+		t.Fatalf("expected the probe to carry the span of the request, got %+v", got)
+	}
+}
+
+func TestPeerProbesWithoutTracer(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	p.doWork()
+	if len(tc.probes) != 1 || tc.probes[0].Context == nil {
+		t.Fatalf("expected a probe with a context even without tracer, got %+v", tc.probes)
+	}
+}
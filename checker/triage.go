@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"sort"
+)
+
+// PeersByHealth returns a Snapshot of the peers and targets with the
+// worst first: the unreachable ones, down for the longest first, then
+// the reachable ones, the slowest first
+func (pw *PeersWatcher) PeersByHealth() []PeerStatus {
+	statuses := pw.Snapshot()
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Reachable != b.Reachable {
+			return !a.Reachable
+		}
+		if !a.DownSince.Equal(b.DownSince) {
+			return a.DownSince.Before(b.DownSince)
+		}
+		if a.LastLatency != b.LastLatency {
+			return a.LastLatency > b.LastLatency
+		}
+		return a.UUID < b.UUID
+	})
+	return statuses
+}
+
+// PeersByHost returns a Snapshot of the peers and targets grouped by
+// the AgentIP of their host, each group sorted by uuid. A host whose
+// peers are all unreachable points at the host or the path to it
+// rather than at its containers, see UnreachableHosts.
+func (pw *PeersWatcher) PeersByHost() map[string][]PeerStatus {
+	byHost := make(map[string][]PeerStatus)
+	for _, status := range pw.Snapshot() {
+		byHost[status.HostIP] = append(byHost[status.HostIP], status)
+	}
+	for _, statuses := range byHost {
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].UUID < statuses[j].UUID })
+	}
+	return byHost
+}
+
+// UnreachableHosts returns the AgentIPs of the hosts, sorted, whose
+// considered peers are all unreachable
+func (pw *PeersWatcher) UnreachableHosts() []string {
+	var hosts []string
+	for hostIP, statuses := range pw.PeersByHost() {
+		considered, reachable := 0, 0
+		for _, status := range statuses {
+			if !status.Considered || !status.Enabled {
+				continue
+			}
+			considered++
+			if status.Reachable {
+				reachable++
+			}
+		}
+		if considered > 0 && reachable == 0 {
+			hosts = append(hosts, hostIP)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// ModeStats sums up the peers and targets checked with a mode
+type ModeStats struct {
+	Peers       int `json:"peers"`
+	Reachable   int `json:"reachable"`
+	Unreachable int `json:"unreachable"`
+}
+
+// ModeSummary returns, from a Snapshot, how many peers and targets are
+// checked with each mode and how many of them are reachable, telling
+// whether the modes got assigned as intended and which fail. The peers
+// not considered or disabled count in Peers only.
+func (pw *PeersWatcher) ModeSummary() map[string]ModeStats {
+	summary := make(map[string]ModeStats)
+	for _, status := range pw.Snapshot() {
+		stats := summary[status.Mode]
+		stats.Peers++
+		if status.Considered && status.Enabled {
+			if status.Reachable {
+				stats.Reachable++
+			} else {
+				stats.Unreachable++
+			}
+		}
+		summary[status.Mode] = stats
+	}
+	return summary
+}
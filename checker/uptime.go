@@ -0,0 +1,41 @@
+package checker
+
+import (
+	"time"
+)
+
+// accumulateUptime adds the time elapsed since the last result to the
+// time spent in the reachability reported until now, it's to be called
+// on every result before the reachability changes. The time before the
+// first result isn't counted. It must be called with the lock held.
+func (p *Peer) accumulateUptime() {
+	now := p.now()
+	up, down := p.uptimes(now)
+	p.uptime, p.downtime = up, down
+	p.uptimeSince = now
+}
+
+// uptimes returns the time spent reachable and unreachable up to now,
+// it must be called with the lock held
+func (p *Peer) uptimes(now time.Time) (up, down time.Duration) {
+	up, down = p.uptime, p.downtime
+	if p.uptimeSince.IsZero() {
+		return up, down
+	}
+	if elapsed := now.Sub(p.uptimeSince); elapsed > 0 {
+		if p.reportedReachable {
+			up += elapsed
+		} else {
+			down += elapsed
+		}
+	}
+	return up, down
+}
+
+// Uptime returns the total time the peer was reported reachable, and
+// unreachable, since its first check, including the current stretch
+func (p *Peer) Uptime() (up, down time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	return p.uptimes(p.now())
+}
@@ -0,0 +1,132 @@
+package checker
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// VIPPolicyDirect keeps the reachability of a peer the one of its
+	// container IP, the VIP being reported on its own
+	VIPPolicyDirect = "direct"
+	// VIPPolicyBoth requires the container IP and the VIP of a peer
+	// to be reachable for the peer to be
+	VIPPolicyBoth = "both"
+	// VIPPolicyEither requires the container IP or the VIP of a peer
+	// to be reachable for the peer to be
+	VIPPolicyEither = "either"
+)
+
+// setVIP records the VIP of the service of the peer found in the
+// metadata, empty when there is none
+func (p *Peer) setVIP(vip string) {
+	p.Lock()
+	defer p.Unlock()
+	if vip != p.vip {
+		p.logger.Infof("Peer(%v): VIP changed from %q to %q", p.uuid, p.vip, vip)
+	}
+	p.vip = vip
+}
+
+// vipCheckEnabled informs if the VIP of the peer is probed along with
+// its container IP, there being nothing to probe without a VIP or
+// over a unix socket. It must be called with the lock held.
+func (p *Peer) vipCheckEnabled() bool {
+	return p.config.VIPCheck && p.vip != "" && p.target == nil && p.mode() != ModeUnix
+}
+
+// checkVIP probes the VIP of the peer like its container IP was, and
+// returns the reachability of the peer combining both of them as
+// asked by PeerConfig.VIPPolicy. It must be called with the lock
+// held.
+func (p *Peer) checkVIP(checker Checker, probe Probe, directOK bool, directErr error) (bool, error) {
+	p.vipChecked = false
+	probe, enabled := p.vipProbe(probe)
+	if !enabled {
+		return directOK, directErr
+	}
+	release := p.limiter.acquire(p.getHostIP())
+	start := time.Now()
+	ok, err := checker.Check(probe)
+	latency := time.Since(start)
+	release()
+	return p.recordVIP(targetResult{ok: ok, err: err, latency: latency}, directOK, directErr)
+}
+
+// vipProbe returns the probe of the VIP of the peer, the one of its
+// container IP with the VIP as address. It returns false when the VIP
+// isn't checked. It must be called with the lock held.
+func (p *Peer) vipProbe(probe Probe) (Probe, bool) {
+	if !p.vipCheckEnabled() {
+		return probe, false
+	}
+	_, port, err := net.SplitHostPort(probe.Address)
+	if err != nil {
+		p.logger.Errorf("Peer(%v): VIP check: %v", p.uuid, err)
+		return probe, false
+	}
+	probe.Address = net.JoinHostPort(p.vip, port)
+	return probe, true
+}
+
+// startVIPCheck probes the VIP of the peer in the background, see
+// startTarget, nil when the VIP isn't checked. It must be called with
+// the lock held.
+func (p *Peer) startVIPCheck(checker Checker, probe Probe, deadline time.Time) <-chan targetResult {
+	probe, enabled := p.vipProbe(probe)
+	if !enabled {
+		return nil
+	}
+	return p.startTarget("VIP check", deadline, func() (bool, error) {
+		return checker.Check(probe)
+	})
+}
+
+// recordVIP records the result of a check of the VIP of the peer, and
+// returns the reachability of the peer combining it with the one of
+// its container IP, see checkVIP. It must be called with the lock
+// held.
+func (p *Peer) recordVIP(r targetResult, directOK bool, directErr error) (bool, error) {
+	ok, err := r.ok, r.err
+	p.vipLatency = r.latency
+	if ok != p.vipReachable {
+		if ok {
+			p.logger.Infof("Peer(%v, %v, %v): VIP %v became reachable", p.uuid, p.getHostIP(), p.getIP(), p.vip)
+		} else {
+			p.logger.Errorf("Peer(%v, %v, %v): VIP %v became unreachable (%v)", p.uuid, p.getHostIP(), p.getIP(), p.vip, err)
+		}
+	}
+	p.vipChecked = true
+	p.vipReachable = ok
+
+	switch p.config.VIPPolicy {
+	case VIPPolicyBoth:
+		if !directOK {
+			return false, directErr
+		}
+		return ok, err
+	case VIPPolicyEither:
+		if directOK {
+			return true, nil
+		}
+		return ok, err
+	}
+	return directOK, directErr
+}
+
+// DirectReachable informs if the container IP of the peer was
+// reachable on its last check, regardless of its VIP
+func (p *Peer) DirectReachable() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.directReachable
+}
+
+// VIPReachable informs if the VIP of the service of the peer was
+// reachable on its last check, see PeerConfig.VIPCheck. The second
+// value is false when the VIP wasn't checked, e.g. there is none.
+func (p *Peer) VIPReachable() (bool, bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.vipReachable, p.vipChecked
+}
@@ -0,0 +1,48 @@
+package checker
+
+import (
+	"fmt"
+	"time"
+)
+
+// warmup sends the warmup probes of a peer first seen, measuring
+// its baseline latency and warming up the connections. They don't
+// affect the reachability of the peer. It must be called with the
+// lock held.
+func (p *Peer) warmup(checker Checker, probe Probe) {
+	var total time.Duration
+	succeeded := 0
+	for ; p.warmups < p.config.WarmupProbes; p.warmups++ {
+		release := p.limiter.acquire(p.getHostIP())
+		start := time.Now()
+		ok, _ := checker.Check(probe)
+		latency := time.Since(start)
+		release()
+		if ok {
+			total += latency
+			succeeded++
+		}
+	}
+	if succeeded > 0 {
+		p.baselineLatency = total / time.Duration(succeeded)
+		p.debugf("Peer(%v, %v, %v): baseline latency %v", p.uuid, p.getHostIP(), p.getIP(), p.baselineLatency)
+	}
+}
+
+// BaselineLatency returns the latency of the peer measured when first
+// seen, by the warmup probes if any succeeded, by the first successful
+// check otherwise
+func (p *Peer) BaselineLatency() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.baselineLatency
+}
+
+// relativeLatency describes the latency relative to the baseline,
+// if known
+func (p *Peer) relativeLatency(latency time.Duration) string {
+	if p.baselineLatency == 0 {
+		return latency.String()
+	}
+	return fmt.Sprintf("%v, %.1fx baseline", latency, float64(latency)/float64(p.baselineLatency))
+}
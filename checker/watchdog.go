@@ -0,0 +1,40 @@
+package checker
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// watchdogInitialBackoff is how long the check loop of a peer waits
+	// before restarting after its first panic, doubled on each panic in
+	// a row up to watchdogMaxBackoff. A loop running longer than that
+	// before panicking starts over from watchdogInitialBackoff.
+	watchdogInitialBackoff = time.Second
+	watchdogMaxBackoff     = time.Minute
+)
+
+// runRecovered runs the check loop of the peer, recovering a panic so
+// that a bug in a check doesn't stop the peer from being checked
+// forever. It returns false when the loop panicked.
+func (p *Peer) runRecovered() (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.Lock()
+			p.restarts++
+			p.Unlock()
+			p.logger.Errorf("Peer(%v): check loop panicked: %v\n%s", p.uuid, r, debug.Stack())
+			stopped = false
+		}
+	}()
+	p.runLoop()
+	return true
+}
+
+// Restarts returns the number of times the check loop of the peer was
+// restarted after a panic
+func (p *Peer) Restarts() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.restarts
+}
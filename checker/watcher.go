@@ -1,9 +1,14 @@
 package checker
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
 	"sync"
 	"time"
 
+	"github.com/rancher/connectivity-check/utils"
 	"github.com/rancher/go-rancher-metadata/metadata"
 	"github.com/rancher/log"
 )
@@ -14,42 +19,160 @@ const (
 
 type PeersWatcher struct {
 	sync.Mutex
-	ok                    bool
-	s                     *Server
-	mc                    metadata.Client
-	peers                 map[string]*Peer
-	peersMapByIP          map[string]*Peer
-	exit                  chan bool
-	peerCheckInterval     int
-	peerConnectionTimeout int
+	// settingsMu serializes the settings applied to the peers once
+	// the lock is released, so that they land in the order they're set
+	settingsMu         sync.Mutex
+	ok                 bool
+	s                  *Server
+	mc                 metadata.Client
+	peers              map[string]*Peer
+	peersMapByIP       map[string]*Peer
+	targetPeers        []*Peer
+	exit               chan bool
+	config             Config
+	quarantines        map[string]time.Time
+	disabled           map[string]bool
+	debugPeers         map[string]bool
+	draining           bool
+	startupQueue       []*Peer
+	override           *OverrideConfig
+	overrideUntil      time.Time
+	overrideGeneration int
+	ignoredReasons     map[string][]utils.FailureReason
+	maxLatencies       map[string]time.Duration
+	random             *rand.Rand
+	lastSampled        time.Time
+
+	networkUnhealthy    bool
+	healthCrossingSince time.Time
+	pendingEvents       []func()
+	lastRecheck         time.Time
+	seeds               map[int64]string
+	canary              *Peer
+	// droppedByRemoved counts the notifications dropped by the
+	// removed peers, see DroppedNotifications
+	droppedByRemoved uint64
+	okRounds         int
+	snapshotMu       sync.Mutex
+	metadataChanges  chan struct{}
+	settlingUntil    time.Time
+	probeCache       *probeCache
+	limiter          *limiter
+	breakers         *breakers
+	exporter         *stateExporter
+	events           *eventLog
+	results          *resultsStream
+	churn            *churn
+	logger           *asyncLogger
+	schedule         *schedule
+	history          *historyWriter
+	flows            *flowExporter
+	clientCert       *utils.ClientCertificate
+	// dialFuncID identifies PeerConfig.DialFunc, see utils.NewDialFuncID
+	dialFuncID uint64
+	// checker, when set, checks the peers instead of the Checker of
+	// their mode, see Peer.checker
+	checker   Checker
+	lifecycle lifecycle
+	runDone   chan struct{}
+	started   bool
+	stopOnce  sync.Once
+	stopErr   error
 }
 
 type mdInfo struct {
 	ipsecState        string
+	vip               string
+	selfIP            string
 	connCheckState    string
 	hostsMap          map[string]*metadata.Host
 	peerContainersMap map[string]*metadata.Container
 	ccContainersMap   map[string]*metadata.Container
 }
 
-func NewPeersWatcher(port, peerCheckInterval, peerConnectionTimeout int, mc metadata.Client) (*PeersWatcher, error) {
-	log.Debugf("creating new PeersWatcher with port=%v, peerCheckInterval=%v", port, peerCheckInterval)
+func NewPeersWatcher(cfg Config, mc metadata.Client) (*PeersWatcher, error) {
+	log.Debugf("creating new PeersWatcher with config=%+v", cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.checkTimeouts()
 
 	pw := &PeersWatcher{mc: mc,
-		peerCheckInterval:     peerCheckInterval,
-		peerConnectionTimeout: peerConnectionTimeout,
-		exit: make(chan bool),
+		config:   cfg,
+		exit:     make(chan bool),
+		runDone:  make(chan struct{}),
+		limiter:  newLimiter(cfg.MaxChecksPerHost),
+		breakers: newBreakers(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		events:   newEventLog(cfg.EventLogSize),
+		results:  newResultsStream(),
+		churn:    newChurn(cfg.ChurnWindow),
+	}
+	pw.metadataChanges = make(chan struct{}, 1)
+	pw.schedule = newSchedule(cfg.Schedule, cfg.ScheduleLocation)
+	pw.probeCache = newProbeCache(time.Duration(cfg.ProbeCacheTTL) * time.Millisecond)
+	if cfg.HistoryFile != "" {
+		pw.history = newHistoryWriter(cfg.HistoryFile, cfg.HistoryMaxSize, cfg.HistoryMaxFiles)
+	}
+	if cfg.FlowCollector != "" {
+		pw.flows = newFlowExporter(cfg.FlowCollector, cfg.FlowBatchSize, cfg.FlowFlushInterval)
+	}
+	if cfg.ClientCertFile != "" {
+		cert, err := utils.LoadClientCertificate(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			log.Errorf("error loading client certificate: %v", err)
+			return nil, err
+		}
+		pw.clientCert = cert
+	}
+	if cfg.DialFunc != nil {
+		pw.dialFuncID = utils.NewDialFuncID()
 	}
-	s, err := NewServer(port, pw)
+	s, err := NewServer(cfg.Port, pw)
 	if err != nil {
 		log.Errorf("error creating server: %v", err)
 		return nil, err
 	}
+	utils.SetOpenConnectionsWarning(cfg.OpenConnectionsWarning)
+	if cfg.AsyncLogBuffer > 0 {
+		pw.logger = newAsyncLogger(cfg.AsyncLogBuffer, cfg.SyncTransitionLogs, cfg.Logger)
+	} else {
+		pw.logger = newSyncLogger(cfg.Logger)
+	}
+	if s, ok := cfg.Metrics.(churnSink); ok {
+		s.SetChurnRate(pw.ChurnRate)
+	}
+	if s, ok := cfg.Metrics.(hostConcurrencySink); ok {
+		s.SetMaxHostConcurrency(pw.MaxHostConcurrency)
+	}
+	if cfg.StateWriter != nil {
+		pw.exporter = newStateExporter(cfg.StateWriter, cfg.StateWriteInterval)
+	}
+
+	s.HandleFunc("/status", pw.statusHandler)
+	s.HandleFunc("/status/config", pw.configHandler)
+	s.HandleFunc("/quorum", pw.quorumHandler)
+	s.HandleFunc("/events", pw.eventsHandler)
+	s.HandleFunc("/breakers", pw.breakersHandler)
+	s.HandleFunc("/recheck", pw.recheckHandler)
+	s.HandleFunc(utils.RelayPath, pw.relayHandler)
+	if h, ok := cfg.Metrics.(http.Handler); ok {
+		s.HandleFunc("/metrics", h.ServeHTTP)
+	}
 	pw.s = s
 	return pw, nil
 }
 
-func getInfoFromMetadata(mc metadata.Client) (*mdInfo, error) {
+// NewPeersWatcherWithClock is the same as NewPeersWatcher but the
+// watcher and its peers use the given Clock for the passing of time,
+// so that tests can drive the checks by advancing it
+func NewPeersWatcherWithClock(clock Clock, cfg Config, mc metadata.Client) (*PeersWatcher, error) {
+	cfg.Clock = clock
+	return NewPeersWatcher(cfg, mc)
+}
+
+// getInfoFromMetadata fetches the peers, leaving out the ones on the
+// host of this node unless includeSelf is set, see Config.SelfPolicy
+func getInfoFromMetadata(mc metadata.Client, includeSelf bool) (*mdInfo, error) {
 	mdInfo := &mdInfo{
 		hostsMap:          make(map[string]*metadata.Host),
 		peerContainersMap: make(map[string]*metadata.Container),
@@ -61,6 +184,7 @@ func getInfoFromMetadata(mc metadata.Client) (*mdInfo, error) {
 		log.Errorf("error fetching self host from metadata: %v", err)
 		return mdInfo, err
 	}
+	mdInfo.selfIP = selfHost.AgentIP
 
 	hosts, err := mc.GetHosts()
 	if err != nil {
@@ -68,8 +192,20 @@ func getInfoFromMetadata(mc metadata.Client) (*mdInfo, error) {
 		return mdInfo, err
 	}
 
+	// A host with the AgentIP of this one is this host too, e.g.
+	// registered again under a new UUID
+	selfHosts := map[string]bool{selfHost.UUID: true}
+	for _, aHost := range hosts {
+		if selfHost.AgentIP != "" && aHost.AgentIP == selfHost.AgentIP {
+			selfHosts[aHost.UUID] = true
+		}
+	}
+	isSelf := func(hostUUID string) bool {
+		return !includeSelf && selfHosts[hostUUID]
+	}
+
 	for index, aHost := range hosts {
-		if aHost.UUID == selfHost.UUID {
+		if isSelf(aHost.UUID) {
 			continue
 		}
 		mdInfo.hostsMap[aHost.UUID] = &hosts[index]
@@ -81,9 +217,10 @@ func getInfoFromMetadata(mc metadata.Client) (*mdInfo, error) {
 		return mdInfo, err
 	}
 	mdInfo.ipsecState = selfService.State
+	mdInfo.vip = selfService.Vip
 
 	for index, aPeer := range selfService.Containers {
-		if aPeer.HostUUID == selfHost.UUID {
+		if isSelf(aPeer.HostUUID) {
 			continue
 		}
 		mdInfo.peerContainersMap[aPeer.UUID] = &selfService.Containers[index]
@@ -100,7 +237,7 @@ func getInfoFromMetadata(mc metadata.Client) (*mdInfo, error) {
 		}
 		mdInfo.connCheckState = aService.State
 		for index, c := range aService.Containers {
-			if c.HostUUID == selfHost.UUID {
+			if isSelf(c.HostUUID) {
 				continue
 			}
 			mdInfo.ccContainersMap[c.HostUUID] = &aService.Containers[index]
@@ -110,13 +247,15 @@ func getInfoFromMetadata(mc metadata.Client) (*mdInfo, error) {
 	return mdInfo, err
 }
 
-func (pw *PeersWatcher) doWork() {
+// doWork syncs the peers with metadata and figures out the current
+// connectivity state, it returns the errors met starting new peers
+func (pw *PeersWatcher) doWork() error {
 	pw.Lock()
 	defer pw.Unlock()
 	log.Debugf("PeersWatcher: doWork: start")
 
 	// Get peers info from metadata
-	mdInfo, err := getInfoFromMetadata(pw.mc)
+	mdInfo, err := getInfoFromMetadata(pw.mc, pw.config.SelfPolicy == SelfInclude)
 	if err != nil {
 		log.Errorf("error fetching hostsMap: %v", err)
 	}
@@ -124,21 +263,30 @@ func (pw *PeersWatcher) doWork() {
 	log.Debugf("peerContainersMap: %v", mdInfo.peerContainersMap)
 	log.Debugf("ccContainersMap: %v", mdInfo.ccContainersMap)
 
+	var startErrs Errors
 	newPeersMap := make(map[string]*Peer)
 	newPeersMapByIP := make(map[string]*Peer)
+	var toStart []*Peer
 	// Update or create peers
 	for uuid, aPeerContainer := range mdInfo.peerContainersMap {
 		aPeer, found := pw.peers[uuid]
 		if found {
 			//log.Debugf("updating peer: %v", *aPeerContainer)
-			aPeer.Lock()
-			aPeer.container = aPeerContainer
-			aPeer.ccContainer = mdInfo.ccContainersMap[aPeerContainer.HostUUID]
-			aPeer.host = mdInfo.hostsMap[aPeerContainer.HostUUID]
-			aPeer.Unlock()
+			aPeer.Update(aPeerContainer,
+				mdInfo.ccContainersMap[aPeerContainer.HostUUID],
+				mdInfo.hostsMap[aPeerContainer.HostUUID])
+			pw.queueEvent(aPeer.fireEvents)
 			newPeersMap[uuid] = aPeer
 			newPeersMapByIP[aPeerContainer.PrimaryIp] = aPeer
 			delete(pw.peers, uuid)
+		} else if aPeer = pw.recreatedPeer(mdInfo, aPeerContainer); aPeer != nil {
+			pw.rebindPeer(aPeer, aPeerContainer)
+			aPeer.Update(aPeerContainer,
+				mdInfo.ccContainersMap[aPeerContainer.HostUUID],
+				mdInfo.hostsMap[aPeerContainer.HostUUID])
+			pw.queueEvent(aPeer.fireEvents)
+			newPeersMap[uuid] = aPeer
+			newPeersMapByIP[aPeerContainer.PrimaryIp] = aPeer
 		} else {
 			host, ok := mdInfo.hostsMap[aPeerContainer.HostUUID]
 			if !ok || host == nil {
@@ -146,41 +294,73 @@ func (pw *PeersWatcher) doWork() {
 				continue
 			}
 			log.Infof("new peer container: %v", *aPeerContainer)
-			aPeer = &Peer{
-				uuid:              uuid,
-				container:         aPeerContainer,
-				ccContainer:       mdInfo.ccContainersMap[aPeerContainer.HostUUID],
-				host:              host,
-				checkInterval:     pw.peerCheckInterval,
-				connectionTimeout: pw.peerConnectionTimeout,
-				exit:              make(chan bool),
+			aPeer = pw.newPeer(uuid)
+			aPeer.container = aPeerContainer
+			aPeer.ccContainer = mdInfo.ccContainersMap[aPeerContainer.HostUUID]
+			aPeer.host = host
+			aPeer.unselected = pw.config.SampleSize > 0
+			if mode, ok := labelMode(aPeerContainer); ok {
+				aPeer.config.Mode = mode
 			}
+			aPeer.updateConsidered(aPeer.consider())
+			pw.queueEvent(aPeer.fireEvents)
+			pw.assignSeed(aPeer)
 			newPeersMap[uuid] = aPeer
 			newPeersMapByIP[aPeerContainer.PrimaryIp] = aPeer
-			aPeer.Start()
+			toStart = append(toStart, aPeer)
+		}
+	}
+	var local map[string]bool
+	if policy := pw.config.LocalIPPolicy; policy != "" && policy != LocalIPProbe {
+		local = localAddresses(mdInfo.selfIP)
+	}
+	for _, aPeer := range newPeersMap {
+		aPeer.setVIP(mdInfo.vip)
+		aPeer.setLocalAddresses(local)
+	}
+	// The new peers are checked right away, so they're started
+	// spread across their hosts
+	toStart = pw.orderProbes(toStart)
+	pw.spreadProbes(toStart)
+	if pw.config.StartupRate > 0 {
+		pw.queueStartup(toStart)
+		toStart = nil
+	}
+	for _, aPeer := range toStart {
+		if err := aPeer.Start(); err != nil {
+			log.Errorf("error starting peer %v: %v", aPeer.uuid, err)
+			startErrs = append(startErrs, fmt.Errorf("error starting peer %v: %v", aPeer.uuid, err))
 		}
 	}
 
-	// Delete peers
+	// Delete peers, unless the metadata couldn't be fetched or they
+	// are missing from it for less than MetadataGracePeriod
 	for uuid, aPeer := range pw.peers {
+		if err != nil || aPeer.retainMissing() {
+			newPeersMap[uuid] = aPeer
+			aPeer.Lock()
+			newPeersMapByIP[aPeer.getIP()] = aPeer
+			aPeer.Unlock()
+			continue
+		}
 		log.Infof("peer container deleted: %v", *(aPeer.container))
-		aPeer.Shutdown()
-		delete(pw.peers, uuid)
+		pw.removePeer(uuid, aPeer)
 	}
 
 	pw.peers = newPeersMap
 	pw.peersMapByIP = newPeersMapByIP
+	pw.sample()
 
 	// Figure out current connectivity state
 	ok := true
 	if shouldConsider(mdInfo) {
 		for peerIP, peer := range pw.peersMapByIP {
-			if !peer.Consider() {
+			if !peer.Consider() || !peer.active() || peer.skippedLocal() {
 				log.Debugf("Peer(%v): not considered for connectivity state", peer.uuid)
 				continue
 			}
 			log.Debugf("peer(%v): %+v", peerIP, peer)
-			if peer.count == 0 {
+			if !peer.reachable() {
 				ok = false
 				log.Debugf("peer: %v is not reachable", peerIP)
 			}
@@ -189,62 +369,338 @@ func (pw *PeersWatcher) doWork() {
 		log.Debugf("PeersWatcher: skipping actual peers state ipsecState=%v connCheckState=%v",
 			mdInfo.ipsecState, mdInfo.connCheckState)
 	}
+	if ok == pw.ok {
+		pw.okRounds++
+	} else {
+		pw.okRounds = 0
+	}
 	pw.ok = ok
+	pw.updateNetworkHealth()
+	pw.detectStuckPeers()
 
 	log.Debugf("PeersWatcher: current connectivity state=%v", pw.ok)
 	log.Debugf("PeersWatcher: doWork: end")
+	return startErrs.errOrNil()
+}
 
+// removePeer stops the peer gone from metadata and drops what's kept
+// about it, it must be called with the lock held
+func (pw *PeersWatcher) removePeer(uuid string, aPeer *Peer) {
+	aPeer.Shutdown()
+	pw.dequeueStartup(aPeer)
+	pw.releaseSeed(aPeer)
+	pw.churn.forget(uuid)
+	pw.droppedByRemoved += aPeer.DroppedNotifications()
+	aPeer.Lock()
+	aPeer.updateConsidered(false)
+	// Under the lock, a check in flight can't add the series back
+	pw.forgetMetrics(uuid)
+	aPeer.Unlock()
+	pw.queueEvent(aPeer.fireEvents)
+	delete(pw.peers, uuid)
 }
 
 func (pw *PeersWatcher) Run() {
+	defer close(pw.runDone)
+	pw.Lock()
+	// Once started, the first round was done by Start
+	wait := pw.started
+	pw.Unlock()
 	for {
-		select {
-		case _, ok := <-pw.exit:
-			if !ok {
-				log.Infof("PeersWatcher: stopped")
-				return
+		if !wait {
+			select {
+			case _, ok := <-pw.exit:
+				if !ok {
+					log.Infof("PeersWatcher: stopped")
+					return
+				}
+			default:
+				pw.doWork()
+				pw.fireEvents()
 			}
-		default:
-			pw.doWork()
 		}
+		wait = false
+
+		select {
+		case <-pw.exit:
+		case <-clockOrReal(pw.config.Clock).After(pw.checkInterval()):
+		case <-pw.metadataChanges:
+			pw.debounceMetadataChanges()
+		}
+	}
+}
+
+// newPeer returns a Peer, not started yet, configured from the
+// settings of the watcher, it must be called with the lock held
+func (pw *PeersWatcher) newPeer(uuid string) *Peer {
+	config := pw.peerConfig()
+	var settlingUntil time.Time
+	if pw.settling() {
+		settlingUntil = pw.settlingUntil
+	}
+	var overrideBaseline *OverrideConfig
+	if pw.override != nil {
+		baseline := overridable(pw.config.PeerConfig)
+		overrideBaseline = &baseline
+	}
+	return &Peer{
+		uuid:             uuid,
+		config:           config,
+		onTransition:     pw.peerTransitioned,
+		limiter:          pw.limiter,
+		breakers:         pw.breakers,
+		logger:           pw.logger,
+		schedule:         pw.schedule,
+		probeCache:       pw.probeCache,
+		history:          pw.history,
+		flows:            pw.flows,
+		exit:             make(chan bool),
+		quarantinedUntil: pw.quarantines[uuid],
+		settlingUntil:    settlingUntil,
+		overrideBaseline: overrideBaseline,
+		overrideUntil:    pw.overrideUntil,
+		debug:            pw.debugPeers[uuid],
+		ignoredReasons:   reasonSet(pw.ignoredReasons[uuid]),
+		maxLatency:       pw.maxLatencies[uuid],
+		clientCert:       pw.clientCert,
+		dialFuncID:       pw.dialFuncID,
+		checker:          pw.checker,
+		lifecycle:        &pw.lifecycle,
+		disabled:         pw.disabled[uuid],
+		draining:         pw.draining,
+	}
+}
+
+// peerTransitioned is called with every transition of the peers,
+// after their OnStateChange hook, to dispatch it
+func (pw *PeersWatcher) peerTransitioned(peer *Peer, event Event) {
+	pw.events.add(event)
+	pw.results.publish(event)
+	pw.churn.record(peer.uuid, event.Time)
+	if pw.exporter != nil {
+		pw.exporter.add(peer.Status())
+	}
+}
 
-		time.Sleep(time.Duration(pw.peerCheckInterval) * time.Millisecond)
+func (pw *PeersWatcher) startTargets() Errors {
+	var errs Errors
+	for index := range pw.config.Targets {
+		t := pw.config.Targets[index]
+		log.Infof("PeersWatcher: checking target: %+v", t)
+		aPeer := pw.newPeer(t.Name)
+		aPeer.target = &t
+		pw.targetPeers = append(pw.targetPeers, aPeer)
+		pw.assignSeed(aPeer)
+	}
+	targets := pw.orderProbes(pw.targetPeers)
+	pw.spreadProbes(targets)
+	for _, aPeer := range targets {
+		if err := aPeer.Start(); err != nil {
+			errs = append(errs, fmt.Errorf("error starting target %v: %v", aPeer.uuid, err))
+		}
 	}
+	return errs
+}
+
+// Targets returns the peers checking the fixed targets
+func (pw *PeersWatcher) Targets() []*Peer {
+	pw.Lock()
+	defer pw.Unlock()
+	return append([]*Peer(nil), pw.targetPeers...)
 }
 
-func (pw *PeersWatcher) Start() error {
+// Start starts the webserver and the checks of all the peers, the
+// errors met along the way are aggregated into the returned error.
+// The watcher is stopped once ctx is done.
+func (pw *PeersWatcher) Start(ctx context.Context) error {
 	log.Debugf("PeersWatcher: Start")
-	go pw.Run()
-	go pw.s.Run()
-	return nil
+	var errs Errors
+	if err := pw.s.Run(); err != nil {
+		errs = append(errs, fmt.Errorf("error starting server: %v", err))
+	}
+
+	if pw.logger.async() {
+		pw.lifecycle.goRun("async logger", pw.logger.run)
+	}
+	if pw.history != nil {
+		pw.lifecycle.goRun("history writer", pw.history.run)
+	}
+	if pw.flows != nil {
+		pw.lifecycle.goRun("flow exporter", pw.flows.run)
+	}
+	pw.limiter.setRamp(pw.config.MaxConcurrentChecks, pw.config.RampInitialChecks,
+		time.Duration(pw.config.RampPeriod)*time.Millisecond)
+	if pw.config.Replica {
+		return pw.startReplica(ctx, errs)
+	}
+
+	pw.Lock()
+	pw.startSettling()
+	errs = append(errs, pw.startTargets()...)
+	if err := pw.startCanary(); err != nil {
+		errs = append(errs, fmt.Errorf("error starting canary: %v", err))
+	}
+	pw.Unlock()
+
+	if err := pw.doWork(); err != nil {
+		errs = append(errs, err)
+	}
+	pw.fireEvents()
+
+	pw.Lock()
+	pw.started = true
+	pw.Unlock()
+	if pw.exporter != nil {
+		pw.lifecycle.goRun("state exporter", pw.exporter.run)
+	}
+	pw.lifecycle.goRun("watcher loop", pw.Run)
+	if pw.config.StartupRate > 0 {
+		pw.lifecycle.goRun("startup queue", pw.runStartupQueue)
+	}
+	if pw.config.RuntimeStatsInterval > 0 {
+		pw.lifecycle.goRun("runtime stats", func() {
+			pw.reportRuntimeStats(time.Duration(pw.config.RuntimeStatsInterval) * time.Millisecond)
+		})
+	}
+	// Not tracked, it's the one calling Stop once ctx is done
+	go func() {
+		select {
+		case <-ctx.Done():
+			pw.Stop()
+		case <-pw.exit:
+		}
+	}()
+	return errs.errOrNil()
+}
+
+// DroppedLogs returns the number of logs of the checks dropped
+// because the asynchronous logger couldn't keep up
+func (pw *PeersWatcher) DroppedLogs() uint64 {
+	return pw.logger.Dropped()
+}
+
+// DroppedFlows returns the number of flow records dropped because
+// they piled up or the collector couldn't be reached, see
+// Config.FlowCollector
+func (pw *PeersWatcher) DroppedFlows() uint64 {
+	return pw.flows.Dropped()
+}
+
+// DroppedHistory returns the number of results of the checks dropped
+// because they piled up faster than the history file was written, see
+// Config.HistoryFile
+func (pw *PeersWatcher) DroppedHistory() uint64 {
+	return pw.history.Dropped()
+}
+
+// MaxHostConcurrency returns the most checks which ran at the same
+// time against a single host so far, telling how well the checks are
+// spread across the hosts, see Config.ProbeOrder
+func (pw *PeersWatcher) MaxHostConcurrency() int {
+	return pw.limiter.MaxHostConcurrency()
 }
 
+// ConcurrencyLimit returns the current bound of concurrent
+// checks, 0 meaning no bound
+func (pw *PeersWatcher) ConcurrencyLimit() int {
+	return pw.limiter.Allowed()
+}
+
+// Ok informs if all the considered peers are reachable, and if the
+// canary is, see Config.Canary
 func (pw *PeersWatcher) Ok() bool {
 	pw.Lock()
 	defer pw.Unlock()
-	return pw.ok
+	return pw.ok && !pw.draining && pw.canaryHealthy()
 }
 
 func (pw *PeersWatcher) Update(peerIP string) {
 	log.Debugf("PeersWatcher: update status for %v", peerIP)
 	pw.Lock()
-	defer pw.Unlock()
 	peer, found := pw.peersMapByIP[peerIP]
+	pw.Unlock()
 	if found {
 		peer.UpdateSuccess()
 	}
 }
 
+// Shutdown stops the watcher, see Stop
 func (pw *PeersWatcher) Shutdown() error {
+	return pw.Stop()
+}
+
+// Stop stops the webserver and all the peers, and waits for their
+// checks and the other goroutines of the watcher to end, for up to
+// Config.ShutdownTimeout: the ones still running then are reported in
+// the returned error. It's safe to call it several times, concurrently
+// too: all the calls return once stopped, with the same error.
+func (pw *PeersWatcher) Stop() error {
+	pw.stopOnce.Do(func() {
+		pw.stopErr = pw.stop()
+	})
+	return pw.stopErr
+}
+
+func (pw *PeersWatcher) stop() error {
 	log.Infof("PeersWatcher: shutdown")
+	var errs Errors
 	if err := pw.s.Shutdown(); err != nil {
 		log.Errorf("error shutting down server: %v", err)
-		return err
+		errs = append(errs, fmt.Errorf("error shutting down server: %v", err))
 	}
 
+	deadline := pw.shutdownDeadline()
 	close(pw.exit)
+	pw.Lock()
+	started := pw.started
+	pw.Unlock()
+	var stuck Errors
+	if started {
+		stuck = append(stuck, pw.lifecycle.wait(deadline, "watcher loop")...)
+	}
+
+	pw.Lock()
+	peers := pw.allPeers()
+	if pw.canary != nil {
+		peers = append(peers, pw.canary)
+	}
+	pw.peers = nil
+	pw.peersMapByIP = nil
+	pw.targetPeers = nil
+	pw.canary = nil
+	pw.Unlock()
+
+	for _, aPeer := range peers {
+		if err := aPeer.Shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("error shutting down peer %v: %v", aPeer.uuid, err))
+		}
+	}
+	stuck = append(stuck, waitPeers(deadline, peers)...)
+	// The writers flush what they hold, the logger going last so that
+	// their errors are logged
+	if started && pw.exporter != nil {
+		close(pw.exporter.exit)
+	}
+	if started && pw.history != nil {
+		close(pw.history.exit)
+	}
+	if started && pw.flows != nil {
+		close(pw.flows.exit)
+	}
+	if started {
+		stuck = append(stuck, pw.lifecycle.wait(deadline, "state exporter", "history writer", "flow exporter")...)
+	}
+	if started && pw.logger.async() {
+		close(pw.logger.exit)
+	}
+	stuck = append(stuck, pw.lifecycle.wait(deadline)...)
+	for _, err := range stuck {
+		log.Errorf("PeersWatcher: %v", err)
+	}
+	errs = append(errs, stuck...)
 
-	return nil
+	log.Infof("PeersWatcher: shutdown complete")
+	return errs.errOrNil()
 }
 
 func shouldConsider(mdInfo *mdInfo) bool {
@@ -0,0 +1,908 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/connectivity-check/utils"
+	"github.com/rancher/go-rancher-metadata/metadata"
+)
+
+// fakeMetadata is a metadata.Client with no peers, only the
+// calls made by the watcher are implemented
+type fakeMetadata struct {
+	metadata.Client
+}
+
+func (fakeMetadata) GetSelfHost() (metadata.Host, error) {
+	return metadata.Host{UUID: "self"}, nil
+}
+
+func (fakeMetadata) GetHosts() ([]metadata.Host, error) {
+	return nil, nil
+}
+
+func (fakeMetadata) GetSelfService() (metadata.Service, error) {
+	return metadata.Service{State: "active"}, nil
+}
+
+func (fakeMetadata) GetServices() ([]metadata.Service, error) {
+	return nil, nil
+}
+
+func TestPeersWatcherDrivenByClock(t *testing.T) {
+	tc := &testChecker{ok: true}
+
+	var mu sync.Mutex
+	var transitions []bool
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.Targets = []Target{{Name: "t1", IP: "10.42.0.1", Port: 80, Mode: testMode}}
+	cfg.OnStateChange = func(peer *Peer, reachable bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, reachable)
+	}
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	pw, err := NewPeersWatcherWithClock(clock, cfg, fakeMetadata{})
+	if err != nil {
+		t.Fatalf("error creating the watcher: %v", err)
+	}
+	pw.checker = tc
+	if err := pw.Start(context.Background()); err != nil {
+		t.Fatalf("error starting the watcher: %v", err)
+	}
+	defer pw.Stop()
+
+	// The watcher and the target each wait for the next round
+	interval := time.Duration(cfg.CheckInterval) * time.Millisecond
+	clock.waitForWaiters(t, 2)
+	tc.Lock()
+	tc.ok = false
+	tc.Unlock()
+	for i := 0; i < 2; i++ {
+		clock.Add(interval)
+		clock.waitForWaiters(t, 2)
+	}
+
+	tc.Lock()
+	checks := len(tc.probes)
+	tc.Unlock()
+	if checks != 3 {
+		t.Fatalf("expected 3 checks, got %v", checks)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(transitions) != 2 || !transitions[0] || transitions[1] {
+		t.Fatalf("expected to become reachable then unreachable, got %v", transitions)
+	}
+}
+
+func TestPeersWatcherDetectsStuckPeers(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p.config.Clock = clock
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+
+	p.doWork()
+	pw.detectStuckPeers()
+	if p.Stuck() {
+		t.Fatalf("expected a peer just checked not to be stuck")
+	}
+
+	// lastChecked never advancing, as if the checks were blocked
+	interval := p.checkIntervalDuration()
+	clock.Add(time.Duration(DefaultStuckCheckMultiple+1) * interval)
+	pw.detectStuckPeers()
+	if !p.Stuck() {
+		t.Fatalf("expected the peer to be stuck")
+	}
+	if stuck := pw.StuckPeers(); len(stuck) != 1 || stuck[0] != p.uuid {
+		t.Fatalf("expected %v to be reported stuck, got %v", p.uuid, stuck)
+	}
+
+	p.doWork()
+	pw.detectStuckPeers()
+	if p.Stuck() {
+		t.Fatalf("expected the peer not to be stuck once checked again")
+	}
+}
+
+func TestPeersWatcherDetectsPeerStuckInCheck(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p.config.Clock = clock
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+	pw.config.Clock = clock
+
+	p.doWork()
+	pw.detectStuckPeers()
+
+	// The lock held as by a check that hangs
+	p.Lock()
+	defer p.Unlock()
+	done := make(chan struct{})
+	go func() {
+		pw.detectStuckPeers()
+		clock.Add(time.Duration(DefaultStuckCheckMultiple+1) * p.checkIntervalDuration())
+		pw.detectStuckPeers()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the detection not to wait for the lock of the peer")
+	}
+	if !p.Stuck() {
+		t.Fatalf("expected the peer hanging in its check to be stuck")
+	}
+}
+
+func TestPeersWatcherSnapshotDoesntWaitForChecks(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+	p.doWork()
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+	p.doWork()
+
+	// The lock held as by a check in flight
+	p.Lock()
+	defer p.Unlock()
+	done := make(chan []PeerStatus)
+	go func() {
+		done <- pw.Snapshot()
+		page, _ := pw.SnapshotPage(0, 1)
+		done <- page
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case statuses := <-done:
+			if len(statuses) != 1 || statuses[0].UUID != p.uuid || !statuses[0].Reachable {
+				t.Fatalf("expected the status published by the last check, got %+v", statuses)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the snapshot not to wait for the check in flight")
+		}
+	}
+}
+
+func TestPeersWatcherSampleDoesntWaitForChecks(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	p.unselected = true
+	pw := &PeersWatcher{config: Config{SampleSize: 1}, peers: map[string]*Peer{p.uuid: p}}
+
+	// The lock held as by a check in flight
+	p.Lock()
+	done := make(chan []string)
+	go func() {
+		pw.Lock()
+		pw.sample()
+		pw.Unlock()
+		done <- pw.Selected()
+	}()
+	select {
+	case uuids := <-done:
+		if len(uuids) != 0 {
+			t.Fatalf("expected the peer to be selected once its check is over, got %v", uuids)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the sample not to wait for the check in flight")
+	}
+	p.Unlock()
+
+	pw.fireEvents()
+	if uuids := pw.Selected(); len(uuids) != 1 || uuids[0] != p.uuid {
+		t.Fatalf("expected the peer to be selected, got %v", uuids)
+	}
+}
+
+func TestPeersWatcherNetworkHealthDoesntWaitForChecks(t *testing.T) {
+	p, tc := newTestPeer("10.42.0.1")
+	pw := &PeersWatcher{
+		config: Config{HealthyFraction: 1, UnhealthyFraction: 1},
+		peers:  map[string]*Peer{p.uuid: p},
+	}
+	tc.ok = false
+	p.doWork()
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+	p.doWork()
+
+	// The lock held as by a check in flight
+	p.Lock()
+	defer p.Unlock()
+	done := make(chan bool)
+	go func() {
+		pw.Lock()
+		pw.updateNetworkHealth()
+		pw.Unlock()
+		done <- pw.networkUnhealthy
+	}()
+	select {
+	case unhealthy := <-done:
+		if !unhealthy {
+			t.Fatalf("expected the status published by the last check to make the network unhealthy")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the network health not to wait for the check in flight")
+	}
+}
+
+func TestPeersWatcherQuorumDoesntWaitForChecks(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+	p.doWork()
+	p.lastChecked = p.lastChecked.Add(-p.checkIntervalDuration())
+	p.doWork()
+
+	// The lock held as by a check in flight
+	p.Lock()
+	defer p.Unlock()
+	done := make(chan bool)
+	go func() { done <- pw.QuorumReachable(1) }()
+	select {
+	case reachable := <-done:
+		if !reachable {
+			t.Fatalf("expected the status published by the last check to make the quorum")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the quorum not to wait for the check in flight")
+	}
+}
+
+// flakyStateWriter is a StateWriter failing the given number of
+// writes, calling onFailure if set, before recording the states
+// written
+type flakyStateWriter struct {
+	failures  int
+	onFailure func()
+	written   []PeerStatus
+}
+
+func (w *flakyStateWriter) WriteState(status PeerStatus) error {
+	if w.failures > 0 {
+		w.failures--
+		if w.onFailure != nil {
+			w.onFailure()
+		}
+		return errors.New("API unavailable")
+	}
+	w.written = append(w.written, status)
+	return nil
+}
+
+func TestStateExporterRetriesFailedWrites(t *testing.T) {
+	w := &flakyStateWriter{failures: 1}
+	e := newStateExporter(w, 0)
+	e.add(PeerStatus{UUID: "c1", Reachable: false})
+	e.flush()
+	if len(w.written) != 0 {
+		t.Fatalf("expected the write to fail, got %+v", w.written)
+	}
+	e.flush()
+	if len(w.written) != 1 || w.written[0].Reachable {
+		t.Fatalf("expected the failed state to be written again, got %+v", w.written)
+	}
+
+	// A newer state added while writing isn't overwritten by the
+	// failed one
+	w.failures, w.written = 1, nil
+	w.onFailure = func() { e.add(PeerStatus{UUID: "c1", Reachable: true}) }
+	e.add(PeerStatus{UUID: "c1", Reachable: false})
+	e.flush()
+	e.flush()
+	if len(w.written) != 1 || !w.written[0].Reachable {
+		t.Fatalf("expected only the newer state to be written, got %+v", w.written)
+	}
+}
+
+// expectWatcherUnlocked fails unless the watcher stays unlocked while
+// set waits for the peer, held as by a check in flight
+func expectWatcherUnlocked(t *testing.T, pw *PeersWatcher, p *Peer, set func()) {
+	p.Lock()
+	defer p.Unlock()
+	go set()
+	unlocked := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pw.Lock()
+		pw.Unlock()
+		close(unlocked)
+	}()
+	select {
+	case <-unlocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the watcher not to stay locked while the setting waits for the peer")
+	}
+}
+
+func TestPeersWatcherSettingsDontLockWatcher(t *testing.T) {
+	for name, set := range map[string]func(pw *PeersWatcher, uuid string){
+		"debug": func(pw *PeersWatcher, uuid string) {
+			pw.SetPeerDebug(uuid, true)
+		},
+		"quarantine": func(pw *PeersWatcher, uuid string) {
+			pw.Quarantine(uuid, time.Now().Add(time.Hour))
+		},
+		"unquarantine": func(pw *PeersWatcher, uuid string) {
+			pw.Unquarantine(uuid)
+		},
+		"enabled": func(pw *PeersWatcher, uuid string) {
+			pw.SetPeerEnabled(uuid, false)
+		},
+		"failures": func(pw *PeersWatcher, uuid string) {
+			pw.InjectFailures(time.Minute, uuid)
+		},
+		"mode": func(pw *PeersWatcher, uuid string) {
+			pw.SetCheckMode(testMode)
+		},
+	} {
+		p, _ := newTestPeer("10.42.0.1")
+		pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+		t.Run(name, func(t *testing.T) {
+			expectWatcherUnlocked(t, pw, p, func() { set(pw, p.uuid) })
+		})
+	}
+}
+
+func TestPeersWatcherWaitConverged(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}, started: true, okRounds: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*convergePollInterval)
+	defer cancel()
+	if err := pw.WaitConverged(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected not to converge before the peer is checked, got %v", err)
+	}
+
+	p.doWork()
+	if err := pw.WaitConverged(context.Background()); err != nil {
+		t.Fatalf("expected to converge once the peer is checked, got %v", err)
+	}
+}
+
+func TestPeersWatcherDrain(t *testing.T) {
+	p, _ := newTestPeer("10.42.0.1")
+	var signaled []bool
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}, ok: true}
+	pw.config.OnDrainChange = func(draining bool) { signaled = append(signaled, draining) }
+
+	pw.BeginDrain()
+	if pw.Ok() || !p.Draining() || p.active() {
+		t.Fatalf("expected not ok and the peer not checked while draining")
+	}
+	pw.EndDrain()
+	if !pw.Ok() || p.Draining() || !p.active() {
+		t.Fatalf("expected ok and the peer checked again after the drain")
+	}
+	if len(signaled) != 2 || !signaled[0] || signaled[1] {
+		t.Fatalf("expected the drain to be signaled as it began and ended, got %v", signaled)
+	}
+}
+
+func TestPeersWatcherIngest(t *testing.T) {
+	pw := &PeersWatcher{config: Config{Replica: true}}
+	snapshot := `[
+		{"uuid": "c1", "ip": "10.42.0.1", "considered": true, "enabled": true, "selected": true, "reachable": true, "count": 3},
+		{"uuid": "c2", "ip": "10.42.0.2", "considered": true, "enabled": true, "selected": true, "failureReason": "connect timeout"}
+	]`
+	if err := pw.Ingest(strings.NewReader(snapshot)); err != nil {
+		t.Fatalf("expected the snapshot to be ingested, got %v", err)
+	}
+	if pw.Ok() {
+		t.Fatalf("expected not ok with c2 unreachable")
+	}
+	statuses := pw.Statuses()
+	if len(statuses) != 2 {
+		t.Fatalf("expected the statuses of 2 peers, got %v", len(statuses))
+	}
+	for _, status := range statuses {
+		if status.UUID == "c2" && status.FailureReason != utils.FailureConnectTimeout {
+			t.Fatalf("expected the ingested failure reason, got %v", status.FailureReason)
+		}
+	}
+
+	if err := pw.Ingest(strings.NewReader(`[{"uuid": "c1", "considered": true, "enabled": true, "selected": true, "reachable": true}]`)); err != nil {
+		t.Fatalf("expected the snapshot to be ingested, got %v", err)
+	}
+	if !pw.Ok() || len(pw.Statuses()) != 1 {
+		t.Fatalf("expected ok with c2 gone")
+	}
+}
+
+func TestPeersWatcherOverrideReverts(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	p, _ := newTestPeer("10.42.0.1")
+	p.config.Clock = clock
+	baseline := p.config.CheckInterval
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+	pw.config.Clock = clock
+
+	if err := pw.OverrideFor(time.Minute, OverrideConfig{CheckInterval: 500}); err != nil {
+		t.Fatalf("error overriding the interval: %v", err)
+	}
+	if status := p.Status(); p.config.CheckInterval != 500 || status.OverrideRemaining != time.Minute {
+		t.Fatalf("expected the interval overridden for a minute, got %v for %v", p.config.CheckInterval, status.OverrideRemaining)
+	}
+	if pw.config.CheckInterval != 0 || pw.checkInterval() != 500*time.Millisecond {
+		t.Fatalf("expected the override apart from the config, got %v and %v", pw.config.CheckInterval, pw.checkInterval())
+	}
+
+	clock.waitForWaiters(t, 1)
+	clock.Add(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if o, _ := pw.ActiveOverride(); o == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the override to revert")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if status := p.Status(); p.config.CheckInterval != baseline || status.OverrideRemaining != 0 {
+		t.Fatalf("expected the interval back to %v, got %v", baseline, p.config.CheckInterval)
+	}
+}
+
+func TestPeersWatcherRejectsOverrideOfTimeoutsPastInterval(t *testing.T) {
+	pw := &PeersWatcher{}
+	pw.config.PeerConfig = DefaultConfig().PeerConfig
+	interval := pw.config.CheckInterval
+	for _, o := range []OverrideConfig{
+		{ConnectionTimeout: interval},
+		{ReadTimeout: interval},
+		{CheckInterval: pw.config.ConnectionTimeout},
+	} {
+		if err := pw.OverrideFor(time.Minute, o); err == nil {
+			t.Fatalf("expected the override %+v to be rejected", o)
+		}
+	}
+	if o, _ := pw.ActiveOverride(); o != nil {
+		t.Fatalf("expected no override in place, got %+v", o)
+	}
+}
+
+func TestPeersWatcherPeersByHost(t *testing.T) {
+	pw := &PeersWatcher{}
+	pw.IngestSnapshot([]PeerStatus{
+		{UUID: "c2", HostIP: "192.168.0.1", Considered: true, Enabled: true, Selected: true},
+		{UUID: "c1", HostIP: "192.168.0.1", Considered: true, Enabled: true, Selected: true},
+		{UUID: "c3", HostIP: "192.168.0.2", Considered: true, Enabled: true, Selected: true, Reachable: true},
+	})
+
+	byHost := pw.PeersByHost()
+	if len(byHost) != 2 || len(byHost["192.168.0.1"]) != 2 || byHost["192.168.0.1"][0].UUID != "c1" {
+		t.Fatalf("expected the peers grouped by host and sorted, got %+v", byHost)
+	}
+	if hosts := pw.UnreachableHosts(); len(hosts) != 1 || hosts[0] != "192.168.0.1" {
+		t.Fatalf("expected 192.168.0.1 to be unreachable, got %v", hosts)
+	}
+}
+
+func TestPeersWatcherModeSummary(t *testing.T) {
+	pw := &PeersWatcher{}
+	pw.IngestSnapshot([]PeerStatus{
+		{UUID: "c1", Mode: ModeHTTP, Considered: true, Enabled: true, Reachable: true},
+		{UUID: "c2", Mode: ModeHTTP, Considered: true, Enabled: true},
+		{UUID: "c3", Mode: ModeTCP, Considered: true, Enabled: true},
+		{UUID: "c4", Mode: ModeTCP, Considered: true},
+	})
+
+	expected := map[string]ModeStats{
+		ModeHTTP: {Peers: 2, Reachable: 1, Unreachable: 1},
+		ModeTCP:  {Peers: 2, Unreachable: 1},
+	}
+	if summary := pw.ModeSummary(); !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+}
+
+// countingMetadata counts the rounds reading metadata
+type countingMetadata struct {
+	fakeMetadata
+	sync.Mutex
+	rounds int
+}
+
+func (m *countingMetadata) GetSelfHost() (metadata.Host, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.rounds++
+	return m.fakeMetadata.GetSelfHost()
+}
+
+func (m *countingMetadata) Rounds() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.rounds
+}
+
+func TestPeersWatcherCoalescesMetadataChanges(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.MetadataDebounce = 50
+	mc := &countingMetadata{}
+	pw, err := NewPeersWatcher(cfg, mc)
+	if err != nil {
+		t.Fatalf("error creating the watcher: %v", err)
+	}
+	if err := pw.Start(context.Background()); err != nil {
+		t.Fatalf("error starting the watcher: %v", err)
+	}
+	defer pw.Stop()
+
+	// The round of Start, Run waiting for the next one
+	for mc.Rounds() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Duration(cfg.MetadataDebounce) * time.Millisecond)
+	before := mc.Rounds()
+	if before != 1 {
+		t.Fatalf("expected a single round at startup, got %v", before)
+	}
+	for i := 0; i < 100; i++ {
+		pw.MetadataChanged()
+	}
+	time.Sleep(time.Duration(6*cfg.MetadataDebounce) * time.Millisecond)
+	if rounds := mc.Rounds() - before; rounds != 1 {
+		t.Fatalf("expected the changes to be applied in a single round, got %v", rounds)
+	}
+}
+
+type nopStateWriter struct{}
+
+func (nopStateWriter) WriteState(PeerStatus) error { return nil }
+
+func TestPeersWatcherStopLeavesNoGoroutine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer collector.Close()
+
+	before := runtime.NumGoroutine()
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.Targets = []Target{{Name: "t1", IP: "10.42.0.1", Port: 80, Mode: testMode}}
+	cfg.AsyncLogBuffer = 16
+	cfg.HistoryFile = filepath.Join(dir, "history.json")
+	cfg.FlowCollector = collector.LocalAddr().String()
+	cfg.StateWriter = nopStateWriter{}
+	cfg.RuntimeStatsInterval = 10
+	cfg.StartupRate = 1
+	cfg.ShutdownTimeout = 5000
+	pw, err := NewPeersWatcher(cfg, fakeMetadata{})
+	if err != nil {
+		t.Fatalf("error creating the watcher: %v", err)
+	}
+	pw.checker = &testChecker{ok: true}
+	if err := pw.Start(context.Background()); err != nil {
+		t.Fatalf("error starting the watcher: %v", err)
+	}
+	pw.OverrideFor(time.Hour, OverrideConfig{})
+	if err := pw.Stop(); err != nil {
+		t.Fatalf("error stopping the watcher: %v", err)
+	}
+
+	// The goroutine waiting for ctx ends right after Stop
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("expected at most %v goroutines after Stop, got %v:\n%s",
+				before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPeersWatcherExportCSV(t *testing.T) {
+	pw := &PeersWatcher{}
+	pw.IngestSnapshot([]PeerStatus{
+		{UUID: "c2", HostIP: "192.168.0.2", IP: "10.42.0.2", Reachable: true, LastLatency: 1500 * time.Microsecond, SuccessRate: 1},
+		{UUID: "c1,\"quoted\"", HostIP: "192.168.0.1", IP: "10.42.0.1", ConsecutiveFailures: 3,
+			DownSince: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC), SuccessRate: 0.25},
+	})
+
+	var buf bytes.Buffer
+	if err := pw.ExportCSV(&buf); err != nil {
+		t.Fatalf("error exporting CSV: %v", err)
+	}
+	expected := "uuid,host,ip,reachable,failureCount,downSince,lastLatencyMs,successRate\n" +
+		"\"c1,\"\"quoted\"\"\",192.168.0.1,10.42.0.1,false,3,2017-01-02T03:04:05Z,0,0.25\n" +
+		"c2,192.168.0.2,10.42.0.2,true,0,,1.5,1\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+}
+
+func TestPeersWatcherChurnRate(t *testing.T) {
+	at := time.Unix(1500000000, 0)
+	clock := &fakeClock{now: at}
+	pw := &PeersWatcher{churn: newChurn(60000)}
+	pw.config.Clock = clock
+
+	// The first transition of a peer isn't churn
+	pw.churn.record("c1", at)
+	pw.churn.record("c1", at.Add(time.Second))
+	pw.churn.record("c2", at.Add(2*time.Second))
+	pw.churn.record("c1", at.Add(3*time.Second))
+	clock.Add(5 * time.Second)
+	if got := pw.ChurnRate(); got != 2 {
+		t.Fatalf("expected 2 transitions per minute, got %v", got)
+	}
+	clock.Add(time.Minute)
+	if got := pw.ChurnRate(); got != 0 {
+		t.Fatalf("expected the transitions out of the window, got %v", got)
+	}
+
+	sink := NewPrometheusSink()
+	sink.SetChurnRate(func() float64 { return 1.5 })
+	rec := httptest.NewRecorder()
+	sink.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "connectivity_check_churn_rate 1.5\n") {
+		t.Fatalf("expected the churn rate gauge, got:\n%v", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "connectivity_check_connection_reuse_rate ") {
+		t.Fatalf("expected the connection reuse rate gauge, got:\n%v", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "connectivity_check_open_connections ") {
+		t.Fatalf("expected the open connections gauge, got:\n%v", rec.Body.String())
+	}
+}
+
+func TestPeersWatcherSpreadsProbesAcrossHosts(t *testing.T) {
+	var peers []*Peer
+	for i, host := range []string{"192.168.0.1", "192.168.0.1", "192.168.0.2", "192.168.0.2"} {
+		p, _ := newTestPeer(fmt.Sprintf("10.42.0.%v", i+1))
+		p.uuid = fmt.Sprintf("c%v", i+1)
+		p.host.AgentIP = host
+		p.config.CheckInterval = 4000
+		peers = append(peers, p)
+	}
+	pw := &PeersWatcher{}
+	ordered := pw.orderProbes(peers)
+	pw.spreadProbes(ordered)
+
+	// The peers of a same host fall due half an interval apart
+	for i, uuid := range []string{"c1", "c3", "c2", "c4"} {
+		if ordered[i].uuid != uuid || ordered[i].phase != time.Duration(i)*time.Second {
+			t.Fatalf("expected %v with a phase of %vs at %v, got %v with %v", uuid, i, i, ordered[i].uuid, ordered[i].phase)
+		}
+	}
+	// The phase only offsets the first wait
+	p := ordered[3]
+	first := jitteredScheduler{}.Next(p)
+	if next := (jitteredScheduler{}).Next(p); first-next < 2*time.Second {
+		t.Fatalf("expected the first wait to be offset by the phase, got %v then %v", first, next)
+	}
+
+	sink := NewPrometheusSink()
+	sink.SetMaxHostConcurrency(func() int { return 2 })
+	rec := httptest.NewRecorder()
+	sink.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "connectivity_check_max_host_concurrency 2\n") {
+		t.Fatalf("expected the host concurrency gauge, got:\n%v", rec.Body.String())
+	}
+}
+
+func TestPrometheusSinkForgetsRemovedPeers(t *testing.T) {
+	sink := NewPrometheusSink()
+	for _, uuid := range []string{"c1", "c2"} {
+		sink.IncrSuccess(uuid)
+		sink.IncrFailure(uuid, utils.FailureRefused)
+		sink.ObserveLatency(uuid, time.Millisecond)
+		sink.SetReachable(uuid, true)
+	}
+	p, _ := newTestPeer("10.42.0.1")
+	p.uuid = "c1"
+	pw := &PeersWatcher{peers: map[string]*Peer{p.uuid: p}}
+	pw.config.Metrics = sink
+	pw.removePeer(p.uuid, p)
+
+	rec := httptest.NewRecorder()
+	sink.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rec.Body.String(); strings.Contains(body, `"c1"`) || !strings.Contains(body, `"c2"`) {
+		t.Fatalf("expected only the series of c2 to be left, got:\n%v", body)
+	}
+	if len(pw.peers) != 0 {
+		t.Fatalf("expected the peer to be removed, got %v", pw.peers)
+	}
+}
+
+func TestStatsdSinkPrefix(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer server.Close()
+
+	// The prefix isn't a format
+	sink, err := NewStatsdSink(server.LocalAddr().String(), "cc%v")
+	if err != nil {
+		t.Fatalf("error creating the sink: %v", err)
+	}
+	defer sink.Close()
+	sink.IncrFailure("c1", utils.FailureConnectTimeout)
+
+	buf := make([]byte, 512)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if got, expected := string(buf[:n]), "cc%v.c1.failures.connect_timeout:1|c"; got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestPeersWatcherCanaryHealth(t *testing.T) {
+	canary, tc := newTestPeer("127.0.0.1")
+	pw := &PeersWatcher{canary: canary, ok: true}
+	if !pw.Ok() || !pw.NetworkHealthy() {
+		t.Fatalf("expected healthy before the canary is checked")
+	}
+
+	tc.ok = false
+	canary.doWork()
+	if pw.Ok() || pw.NetworkHealthy() {
+		t.Fatalf("expected neither Ok nor NetworkHealthy with the canary unreachable")
+	}
+	tc.ok = true
+	canary.lastChecked = canary.lastChecked.Add(-canary.checkIntervalDuration())
+	canary.doWork()
+	if !pw.Ok() || !pw.NetworkHealthy() {
+		t.Fatalf("expected both Ok and NetworkHealthy with the canary reachable")
+	}
+
+	// The lock held as by a check in flight
+	canary.Lock()
+	defer canary.Unlock()
+	done := make(chan bool)
+	go func() { done <- pw.CanaryHealthy() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the canary health not to wait for the check in flight")
+	}
+}
+
+// graceMetadata is a metadata.Client with a peer on host h1, which
+// can go missing, or the services fail to be fetched
+type graceMetadata struct {
+	fakeMetadata
+	sync.Mutex
+	missing bool
+	failing bool
+}
+
+func (m *graceMetadata) GetHosts() ([]metadata.Host, error) {
+	return []metadata.Host{{UUID: "h1", AgentIP: "192.168.0.1", State: "active"}}, nil
+}
+
+func (m *graceMetadata) GetSelfService() (metadata.Service, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.failing {
+		return metadata.Service{}, errors.New("metadata unavailable")
+	}
+	service := metadata.Service{State: "active"}
+	if !m.missing {
+		service.Containers = []metadata.Container{{UUID: "c1", HostUUID: "h1", PrimaryIp: "10.42.0.1", State: "running"}}
+	}
+	return service, nil
+}
+
+func (m *graceMetadata) set(missing, failing bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.missing, m.failing = missing, failing
+}
+
+func TestPeersWatcherKeepsMissingPeersForGracePeriod(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.Mode = testMode
+	cfg.MetadataGracePeriod = 10000
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	mc := &graceMetadata{}
+	pw, err := NewPeersWatcherWithClock(clock, cfg, mc)
+	if err != nil {
+		t.Fatalf("error creating the watcher: %v", err)
+	}
+	pw.checker = &testChecker{ok: true}
+	defer pw.Stop()
+	hasPeer := func() bool {
+		pw.Lock()
+		defer pw.Unlock()
+		_, found := pw.peers["c1"]
+		return found && pw.peersMapByIP["10.42.0.1"] != nil
+	}
+
+	pw.doWork()
+	if !hasPeer() {
+		t.Fatalf("expected the peer to be created")
+	}
+
+	// A failed fetch doesn't tell the peer is gone, whatever the
+	// grace period
+	mc.set(false, true)
+	clock.Add(time.Minute)
+	pw.doWork()
+	if !hasPeer() {
+		t.Fatalf("expected the peer to be kept on a failed fetch")
+	}
+
+	mc.set(true, false)
+	pw.doWork()
+	clock.Add(5 * time.Second)
+	pw.doWork()
+	if !hasPeer() {
+		t.Fatalf("expected the missing peer to be kept within the grace period")
+	}
+	clock.Add(6 * time.Second)
+	pw.doWork()
+	if hasPeer() {
+		t.Fatalf("expected the missing peer to be deleted after the grace period")
+	}
+}
+
+func TestPeersWatcherRebindMovesPeerState(t *testing.T) {
+	var mu sync.Mutex
+	var transitions []bool
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.ResetCountOnRebind = true
+	cfg.OnStateChange = func(peer *Peer, reachable bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, reachable)
+	}
+	pw, err := NewPeersWatcher(cfg, fakeMetadata{})
+	if err != nil {
+		t.Fatalf("error creating the watcher: %v", err)
+	}
+	until := time.Now().Add(time.Hour)
+	pw.Lock()
+	aPeer := pw.newPeer("c1")
+	aPeer.container = &metadata.Container{UUID: "c1", HostUUID: "h1", PrimaryIp: "10.42.0.1"}
+	aPeer.reportedReachable = true
+	pw.peers = map[string]*Peer{"c1": aPeer}
+	pw.quarantines = map[string]time.Time{"c1": until}
+	pw.disabled = map[string]bool{"c1": true}
+	pw.maxLatencies = map[string]time.Duration{"c1": time.Second}
+	pw.rebindPeer(aPeer, &metadata.Container{UUID: "c2", HostUUID: "h1", PrimaryIp: "10.42.0.2"})
+	pw.Unlock()
+	aPeer.fireEvents()
+
+	pw.Lock()
+	quarantined, disabled, maxLatency := pw.quarantines["c2"], pw.disabled["c2"], pw.maxLatencies["c2"]
+	_, stale := pw.quarantines["c1"]
+	pw.Unlock()
+	if !quarantined.Equal(until) || !disabled || maxLatency != time.Second || stale {
+		t.Fatalf("expected the state of the peer to move to its new uuid, got quarantine=%v disabled=%v maxLatency=%v", quarantined, disabled, maxLatency)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(transitions, []bool{false}) {
+		t.Fatalf("expected the reset to report the peer down, got %v", transitions)
+	}
+}
@@ -0,0 +1,47 @@
+package checker
+
+// windowEnabled informs if the reachability of the peer is the one of
+// the sliding window of its last results rather than of the counter,
+// see PeerConfig.WindowSize. It must be called with the lock held.
+func (p *Peer) windowEnabled() bool {
+	return p.config.WindowSize > 0
+}
+
+// windowMinSuccesses returns how many of the results in the window
+// must be successes for the peer to be reachable, a majority unless
+// configured otherwise
+func (p *Peer) windowMinSuccesses() int {
+	if p.config.WindowMinSuccesses > 0 {
+		return p.config.WindowMinSuccesses
+	}
+	return (p.config.WindowSize + 1) / 2
+}
+
+// recordWindow adds the result of a check to the window, the oldest
+// one going away once it's full, and sets the count from the fraction
+// of successes in it. It must be called with the lock held.
+func (p *Peer) recordWindow(ok bool) {
+	size := p.config.WindowSize
+	if len(p.window) < size {
+		p.window = append(p.window, ok)
+	} else {
+		p.window[p.windowNext%size] = ok
+		p.windowNext = (p.windowNext + 1) % size
+	}
+	if p.windowSuccesses() >= p.windowMinSuccesses() {
+		p.count = maxCount
+	} else {
+		p.count = 0
+	}
+}
+
+// windowSuccesses must be called with the lock held
+func (p *Peer) windowSuccesses() int {
+	successes := 0
+	for _, ok := range p.window {
+		if ok {
+			successes++
+		}
+	}
+	return successes
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rancher/connectivity-check/checker"
 	"github.com/rancher/connectivity-check/utils"
@@ -26,6 +30,11 @@ func main() {
 	app := cli.NewApp()
 	app.Name = appName
 	app.Version = VERSION
+	// Every setting can be given either as a flag or as the environment
+	// variable named in its EnvVar, so that the checker can be
+	// configured entirely by the environment of its container. A flag
+	// takes precedence over its environment variable, which takes
+	// precedence over the default.
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "metadata-address",
@@ -44,6 +53,813 @@ func main() {
 			Value:  checker.DefaultPeerConnectionTimeoutInterval,
 			EnvVar: "PEER_CONNECTION_TIMEOUT",
 		},
+		cli.BoolFlag{
+			Name:   "clamp-connection-timeout",
+			Usage:  "Shorten a peer connection timeout too long for the check interval instead of only warning about it",
+			EnvVar: "CONNECTIVITY_CHECK_CLAMP_CONNECTION_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "peer-connect-timeout",
+			Usage:  "Customize the timeout in milliseconds for establishing the connection to a peer (default: 0, disabled)",
+			EnvVar: "PEER_CONNECT_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "peer-read-timeout",
+			Usage:  "Customize the timeout in milliseconds for reading the response of a peer once connected (default: 0, disabled)",
+			EnvVar: "PEER_READ_TIMEOUT",
+		},
+		cli.Float64Flag{
+			Name:   "adaptive-timeout-factor",
+			Usage:  "Time out the checks of a peer after its average latency times this factor rather than the connection timeout (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_ADAPTIVE_TIMEOUT_FACTOR",
+		},
+		cli.IntFlag{
+			Name:   "adaptive-timeout-ceiling",
+			Usage:  "Customize the longest adaptive timeout in milliseconds (default: 0, the check interval)",
+			EnvVar: "CONNECTIVITY_CHECK_ADAPTIVE_TIMEOUT_CEILING",
+		},
+		cli.StringFlag{
+			Name:   "check-mode",
+			Usage:  "Mode used to check the peers: http, https, tcp, unix, tls, ports or parallel",
+			Value:  checker.ModeHTTP,
+			EnvVar: "CONNECTIVITY_CHECK_MODE",
+		},
+		cli.StringFlag{
+			Name:   "relay-address",
+			Usage:  "Address, in host:port form, of the peer asked to check the others in the http mode too, along with their direct check (default: none, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_RELAY_ADDRESS",
+		},
+		cli.StringFlag{
+			Name:   "check-method",
+			Usage:  "Method of the requests of the HTTP checks: GET, HEAD (only the status code is checked) or POST",
+			Value:  "GET",
+			EnvVar: "CONNECTIVITY_CHECK_METHOD",
+		},
+		cli.StringFlag{
+			Name:   "check-body",
+			Usage:  "Body sent with the requests of the HTTP checks",
+			EnvVar: "CONNECTIVITY_CHECK_BODY",
+		},
+		cli.BoolFlag{
+			Name:   "measure-connect-time",
+			Usage:  "Measure how long establishing the connection of the HTTP and TCP checks takes, exposed in status",
+			EnvVar: "CONNECTIVITY_CHECK_MEASURE_CONNECT_TIME",
+		},
+		cli.BoolFlag{
+			Name:   "reason-streaks",
+			Usage:  "Count the consecutive failed checks by reason, exposed in status",
+			EnvVar: "CONNECTIVITY_CHECK_REASON_STREAKS",
+		},
+		cli.StringFlag{
+			Name:   "connect-proxy",
+			Usage:  "Address of an HTTP proxy the checks are tunneled through with CONNECT (default: none, direct)",
+			EnvVar: "CONNECTIVITY_CHECK_CONNECT_PROXY",
+		},
+		cli.StringFlag{
+			Name:   "expected-header",
+			Usage:  "Header the responses of the HTTP checks must carry, as Name: value (default: none)",
+			EnvVar: "CONNECTIVITY_CHECK_EXPECTED_HEADER",
+		},
+		cli.BoolFlag{
+			Name:   "header-only",
+			Usage:  "With expected-header, don't compare the body of the responses",
+			EnvVar: "CONNECTIVITY_CHECK_HEADER_ONLY",
+		},
+		cli.StringFlag{
+			Name:   "check-content-type",
+			Usage:  "Media type the responses of the HTTP checks must have, e.g. text/plain (default: none, any)",
+			EnvVar: "CONNECTIVITY_CHECK_CONTENT_TYPE",
+		},
+		cli.IntFlag{
+			Name:   "metadata-grace-period",
+			Usage:  "Keep checking a peer with its last known metadata for up to this many milliseconds when it goes missing, 0 skips it right away",
+			EnvVar: "CONNECTIVITY_CHECK_METADATA_GRACE_PERIOD",
+		},
+		cli.StringFlag{
+			Name:   "host-check",
+			Usage:  "Check the agent of the host of the peers: also (in addition to their container) or only (instead of it)",
+			EnvVar: "CONNECTIVITY_CHECK_HOST_CHECK",
+		},
+		cli.IntFlag{
+			Name:   "host-check-port",
+			Usage:  "Port of the agent of the hosts checked with host-check",
+			EnvVar: "CONNECTIVITY_CHECK_HOST_CHECK_PORT",
+		},
+		cli.StringFlag{
+			Name:   "host-check-path",
+			Usage:  "Path requested on the agent of the hosts when checked over HTTP",
+			EnvVar: "CONNECTIVITY_CHECK_HOST_CHECK_PATH",
+		},
+		cli.StringFlag{
+			Name:   "host-check-mode",
+			Usage:  "Mode used to check the agent of the hosts: tcp or http",
+			Value:  checker.ModeTCP,
+			EnvVar: "CONNECTIVITY_CHECK_HOST_CHECK_MODE",
+		},
+		cli.IntFlag{
+			Name:   "confirm-down-port",
+			Usage:  "Port of a secondary endpoint on the host of a peer checked when it becomes unreachable, e.g. its agent (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_CONFIRM_DOWN_PORT",
+		},
+		cli.StringFlag{
+			Name:   "confirm-down-path",
+			Usage:  "Path requested on the secondary endpoint when checked over HTTP",
+			EnvVar: "CONNECTIVITY_CHECK_CONFIRM_DOWN_PATH",
+		},
+		cli.StringFlag{
+			Name:   "confirm-down-mode",
+			Usage:  "Mode used to check the secondary endpoint: tcp or http",
+			Value:  checker.ModeTCP,
+			EnvVar: "CONNECTIVITY_CHECK_CONFIRM_DOWN_MODE",
+		},
+		cli.BoolFlag{
+			Name:   "concurrent-host-check",
+			Usage:  "Probe the agent of the host and the container of a peer at the same time",
+			EnvVar: "CONNECTIVITY_CHECK_CONCURRENT_HOST_CHECK",
+		},
+		cli.BoolFlag{
+			Name:   "concurrent-targets",
+			Usage:  "Probe the container, the agent of the host and the VIP of a peer at the same time",
+			EnvVar: "CONNECTIVITY_CHECK_CONCURRENT_TARGETS",
+		},
+		cli.StringFlag{
+			Name:   "liveness-path",
+			Usage:  "Path checked on the peers in the http mode rather than their ping endpoint",
+			EnvVar: "CONNECTIVITY_CHECK_LIVENESS_PATH",
+		},
+		cli.StringFlag{
+			Name:   "readiness-path",
+			Usage:  "Path checked on the live peers in the http mode to tell if they are ready (default: none, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_READINESS_PATH",
+		},
+		cli.StringFlag{
+			Name:   "readiness-policy",
+			Value:  checker.ReadinessReport,
+			Usage:  "Whether a peer must be ready to be reachable: report or require",
+			EnvVar: "CONNECTIVITY_CHECK_READINESS_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "health-expected",
+			Usage:  "Body the liveness and readiness endpoints must answer (default: none, any 2xx status)",
+			EnvVar: "CONNECTIVITY_CHECK_HEALTH_EXPECTED",
+		},
+		cli.BoolFlag{
+			Name:   "vip-check",
+			Usage:  "Probe the VIP of the service of the peers too, when there is one",
+			EnvVar: "CONNECTIVITY_CHECK_VIP_CHECK",
+		},
+		cli.StringFlag{
+			Name:   "vip-policy",
+			Value:  checker.VIPPolicyDirect,
+			Usage:  "How the container IP and the VIP of a peer make its reachability: direct, both or either",
+			EnvVar: "CONNECTIVITY_CHECK_VIP_POLICY",
+		},
+		cli.BoolFlag{
+			Name:   "neighbor-check",
+			Usage:  "Look the peers on the same subnet up in the neighbor table too, linux only",
+			EnvVar: "CONNECTIVITY_CHECK_NEIGHBOR_CHECK",
+		},
+		cli.BoolFlag{
+			Name:   "host-port",
+			Usage:  "Probe the peers through the host port their container maps the checked port to, if any",
+			EnvVar: "CONNECTIVITY_CHECK_HOST_PORT",
+		},
+		cli.StringFlag{
+			Name:   "local-ip-policy",
+			Value:  checker.LocalIPProbe,
+			Usage:  "How the peers whose IP is one of this host are handled: probe, skip or reachable",
+			EnvVar: "CONNECTIVITY_CHECK_LOCAL_IP_POLICY",
+		},
+		cli.BoolFlag{
+			Name:   "verbose-failures",
+			Usage:  "Log at debug level the request and the response of the failed HTTP checks",
+			EnvVar: "CONNECTIVITY_CHECK_VERBOSE_FAILURES",
+		},
+		cli.StringSliceFlag{
+			Name:   "redact-header",
+			Usage:  "Header whose value isn't logged with verbose-failures (can be repeated)",
+			EnvVar: "CONNECTIVITY_CHECK_REDACT_HEADERS",
+		},
+		cli.IntFlag{
+			Name:   "probe-cache-ttl",
+			Usage:  "Customize how long in milliseconds the result of a probe is shared with the other peers probing the same endpoint (default: 0, not shared)",
+			EnvVar: "CONNECTIVITY_CHECK_PROBE_CACHE_TTL",
+		},
+		cli.StringFlag{
+			Name:   "self-policy",
+			Usage:  "How the peers on the host of this node are checked: exclude, include, or canary to check this node over the loopback instead",
+			Value:  checker.SelfExclude,
+			EnvVar: "CONNECTIVITY_CHECK_SELF_POLICY",
+		},
+		cli.BoolFlag{
+			Name:   "canary",
+			Usage:  "Check the ping endpoint of this node over the loopback, telling a broken checker from unreachable peers",
+			EnvVar: "CONNECTIVITY_CHECK_CANARY",
+		},
+		cli.IntFlag{
+			Name:   "max-concurrent-checks",
+			Usage:  "Most checks running at the same time overall (default: 0, no limit)",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_CONCURRENT_CHECKS",
+		},
+		cli.IntFlag{
+			Name:   "max-checks-per-host",
+			Usage:  "Most checks running at the same time against the same host (default: 0, no limit)",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_CHECKS_PER_HOST",
+		},
+		cli.StringFlag{
+			Name:   "probe-order",
+			Usage:  "Order of the peers checked together, on start or on a recheck: interleaved, alternating between their hosts, or listed",
+			Value:  checker.ProbeOrderInterleaved,
+			EnvVar: "CONNECTIVITY_CHECK_PROBE_ORDER",
+		},
+		cli.IntFlag{
+			Name:   "startup-rate",
+			Usage:  "Most new peers started every startup interval, the others waiting (default: 0, all at once)",
+			EnvVar: "CONNECTIVITY_CHECK_STARTUP_RATE",
+		},
+		cli.IntFlag{
+			Name:   "startup-interval",
+			Usage:  "Interval in ms at which at most startup-rate new peers are started",
+			Value:  checker.DefaultStartupInterval,
+			EnvVar: "CONNECTIVITY_CHECK_STARTUP_INTERVAL",
+		},
+		cli.IntSliceFlag{
+			Name:   "check-port",
+			Usage:  "Port of the peers checked in the ports mode, can be repeated",
+			EnvVar: "CONNECTIVITY_CHECK_PORTS",
+		},
+		cli.StringFlag{
+			Name:   "ports-policy",
+			Usage:  "Ports of the peers to be open in the ports mode for them to be reachable: all or any",
+			Value:  checker.PortsAll,
+			EnvVar: "CONNECTIVITY_CHECK_PORTS_POLICY",
+		},
+		cli.IntFlag{
+			Name:   "parallel-connections",
+			Usage:  fmt.Sprintf("Customize how many connections are established at the same time in the parallel mode (default: %v)", checker.DefaultParallelConnections),
+			EnvVar: "CONNECTIVITY_CHECK_PARALLEL_CONNECTIONS",
+		},
+		cli.IntFlag{
+			Name:   "parallel-min-successes",
+			Usage:  "Customize how many of the connections of the parallel mode are to succeed (default: 0, all)",
+			EnvVar: "CONNECTIVITY_CHECK_PARALLEL_MIN_SUCCESSES",
+		},
+		cli.IntFlag{
+			Name:   "requests-per-check",
+			Usage:  "Customize how many requests each check sends in the modes working over HTTP (default: 1)",
+			EnvVar: "CONNECTIVITY_CHECK_REQUESTS_PER_CHECK",
+		},
+		cli.IntFlag{
+			Name:   "requests-min-successes",
+			Usage:  "Customize how many of the requests of a check are to succeed (default: 0, all)",
+			EnvVar: "CONNECTIVITY_CHECK_REQUESTS_MIN_SUCCESSES",
+		},
+		cli.IntFlag{
+			Name:   "requests-concurrency",
+			Usage:  "Customize how many of the requests of a check are sent at a time (default: 1, one after the other)",
+			EnvVar: "CONNECTIVITY_CHECK_REQUESTS_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   "tls-port",
+			Usage:  "Port of the peers checked in the tls and https modes",
+			Value:  checker.DefaultTLSPort,
+			EnvVar: "CONNECTIVITY_CHECK_TLS_PORT",
+		},
+		cli.StringFlag{
+			Name:   "tls-server-name",
+			Usage:  "Name sent and validated in the tls and https modes, the IP of the peer by default",
+			EnvVar: "CONNECTIVITY_CHECK_TLS_SERVER_NAME",
+		},
+		cli.BoolFlag{
+			Name:   "tls-verify",
+			Usage:  "Validate the certificate of the peers in the tls and https modes instead of only completing the handshake",
+			EnvVar: "CONNECTIVITY_CHECK_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "client-cert-file",
+			Usage:  "Client certificate, PEM encoded, presented to the peers requiring mutual TLS in the tls and https modes",
+			EnvVar: "CONNECTIVITY_CHECK_CLIENT_CERT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "client-key-file",
+			Usage:  "Key of the client certificate, PEM encoded",
+			EnvVar: "CONNECTIVITY_CHECK_CLIENT_KEY_FILE",
+		},
+		cli.IntFlag{
+			Name:   "stale-after",
+			Usage:  "Time in milliseconds a container goes without being updated in metadata for its peer to be checked only every stale-check-interval (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_STALE_AFTER",
+		},
+		cli.IntFlag{
+			Name:   "stale-check-interval",
+			Usage:  "Interval in milliseconds at which the peers stale in metadata are still checked",
+			Value:  checker.DefaultStaleCheckInterval,
+			EnvVar: "CONNECTIVITY_CHECK_STALE_CHECK_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "stale-timestamp-label",
+			Usage:  "Label of the containers holding when they were last updated, RFC 3339 or seconds since the epoch",
+			EnvVar: "CONNECTIVITY_CHECK_STALE_TIMESTAMP_LABEL",
+		},
+		cli.StringFlag{
+			Name:   "consider-policy",
+			Usage:  "Containers that must be running for a peer to be checked: strict (both the peer and connectivity-check containers) or lenient (the peer container only)",
+			Value:  checker.ConsiderStrict,
+			EnvVar: "CONNECTIVITY_CHECK_CONSIDER_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "socket-path",
+			Usage:  "Unix socket the checks connect to when using the unix mode",
+			EnvVar: "CONNECTIVITY_CHECK_SOCKET_PATH",
+		},
+		cli.IntFlag{
+			Name:   "dscp",
+			Usage:  "DSCP value used to mark the packets of the checks (default: 0, unmarked)",
+			EnvVar: "CONNECTIVITY_CHECK_DSCP",
+		},
+		cli.IntFlag{
+			Name:   "tcp-mss",
+			Usage:  "TCP maximum segment size of the connections of the checks, linux only (default: 0, the system's)",
+			EnvVar: "CONNECTIVITY_CHECK_TCP_MSS",
+		},
+		cli.BoolFlag{
+			Name:   "disable-keep-alives",
+			Usage:  "Use a new connection for every check instead of reusing them",
+			EnvVar: "CONNECTIVITY_CHECK_DISABLE_KEEP_ALIVES",
+		},
+		cli.IntFlag{
+			Name:   "keepalive-idle",
+			Usage:  "Customize how long in milliseconds a connection is idle before the first TCP keepalive probe, linux only (default: 0, the system default)",
+			EnvVar: "CONNECTIVITY_CHECK_KEEPALIVE_IDLE",
+		},
+		cli.IntFlag{
+			Name:   "keepalive-interval",
+			Usage:  "Customize the interval in milliseconds between the TCP keepalive probes, linux only (default: 0, the system default)",
+			EnvVar: "CONNECTIVITY_CHECK_KEEPALIVE_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   "keepalive-count",
+			Usage:  "Customize how many unanswered TCP keepalive probes close a connection, linux only (default: 0, the system default)",
+			EnvVar: "CONNECTIVITY_CHECK_KEEPALIVE_COUNT",
+		},
+		cli.IntFlag{
+			Name:   "idle-conn-timeout",
+			Usage:  "Customize how long in milliseconds an idle connection is kept for reuse (default: 0, no limit)",
+			EnvVar: "CONNECTIVITY_CHECK_IDLE_CONN_TIMEOUT",
+		},
+		cli.BoolFlag{
+			Name:   "correlation-ids",
+			Usage:  "Send a correlation ID with every check, logged by this node and the checked peer",
+			EnvVar: "CONNECTIVITY_CHECK_CORRELATION_IDS",
+		},
+		cli.IntFlag{
+			Name:   "max-idle-conns-per-peer",
+			Usage:  "Customize how many idle connections to each peer are kept for reuse (default: 0, the net/http default)",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_IDLE_CONNS_PER_PEER",
+		},
+		cli.IntFlag{
+			Name:   "max-conns-per-peer",
+			Usage:  "Customize how many connections to each peer can be open (default: 0, no limit)",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_CONNS_PER_PEER",
+		},
+		cli.IntFlag{
+			Name:   "max-latency",
+			Usage:  "Latency in milliseconds above which a check fails as too slow (default: 0, no cap)",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_LATENCY",
+		},
+		cli.IntFlag{
+			Name:   "degraded-latency",
+			Usage:  "Average latency in milliseconds above which a reachable peer is considered degraded (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_DEGRADED_LATENCY",
+		},
+		cli.IntFlag{
+			Name:   "hourly-window-days",
+			Usage:  "Number of days over which the success rate of the peers is kept by hour of the day (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_HOURLY_WINDOW_DAYS",
+		},
+		cli.IntFlag{
+			Name:   "degraded-period",
+			Usage:  "Time in milliseconds a flaky peer's count must stay below its maximum for it to be considered degraded (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_DEGRADED_PERIOD",
+		},
+		cli.IntFlag{
+			Name:   "max-adaptive-interval",
+			Usage:  "Let the interval in milliseconds between the checks of a steadily reachable peer grow up to this cap, 0 disables it",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_ADAPTIVE_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   "recovery-successes",
+			Usage:  "Number of checks in a row which must succeed for an unreachable peer to be reported reachable again",
+			Value:  1,
+			EnvVar: "CONNECTIVITY_CHECK_RECOVERY_SUCCESSES",
+		},
+		cli.IntFlag{
+			Name:   "success-rate-min-checks",
+			Usage:  "Number of checks of a peer before its success rate is reported as confident",
+			Value:  checker.DefaultSuccessRateMinChecks,
+			EnvVar: "CONNECTIVITY_CHECK_SUCCESS_RATE_MIN_CHECKS",
+		},
+		cli.IntFlag{
+			Name:   "retry-budget",
+			Usage:  "Most retries a peer has for its failed checks, refilled over time (default: 0, no retry)",
+			EnvVar: "CONNECTIVITY_CHECK_RETRY_BUDGET",
+		},
+		cli.Float64Flag{
+			Name:   "retry-refill",
+			Usage:  "Retries added back per minute to the budget of a peer (default: 0, the retry budget)",
+			EnvVar: "CONNECTIVITY_CHECK_RETRY_REFILL",
+		},
+		cli.IntFlag{
+			Name:   "window-size",
+			Usage:  "Number of last results whose successes make the reachability of a peer (default: 0, the counter)",
+			EnvVar: "CONNECTIVITY_CHECK_WINDOW_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "window-min-successes",
+			Usage:  "Successes in the window for a peer to be reachable (default: 0, a majority)",
+			EnvVar: "CONNECTIVITY_CHECK_WINDOW_MIN_SUCCESSES",
+		},
+		cli.IntFlag{
+			Name:   "burst-size",
+			Usage:  "Number of probes sent to a peer to measure the packet loss once its latency becomes unstable (default: 0, no burst)",
+			EnvVar: "CONNECTIVITY_CHECK_BURST_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "burst-jitter-threshold",
+			Usage:  "Standard deviation of the latency in milliseconds above which a burst is sent to a peer",
+			EnvVar: "CONNECTIVITY_CHECK_BURST_JITTER_THRESHOLD",
+		},
+		cli.IntFlag{
+			Name:   "warmup-probes",
+			Usage:  "Number of probes sent to a peer first seen to measure its baseline latency, not affecting its reachability",
+			EnvVar: "CONNECTIVITY_CHECK_WARMUP_PROBES",
+		},
+		cli.BoolFlag{
+			Name:   "fail-fast",
+			Usage:  "Mark a peer unreachable on its first failed check",
+			EnvVar: "CONNECTIVITY_CHECK_FAIL_FAST",
+		},
+		cli.StringSliceFlag{
+			Name:   "failure-weight",
+			Usage:  "How much a failure of a reason changes the count of a peer, as reason=weight, e.g. \"connection refused\"=0 (can be repeated, default: -1)",
+			EnvVar: "CONNECTIVITY_CHECK_FAILURE_WEIGHTS",
+		},
+		cli.IntFlag{
+			Name:   "settling-period",
+			Usage:  "Customize how long in milliseconds the transitions of the peers aren't reported after starting (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_SETTLING_PERIOD",
+		},
+		cli.BoolFlag{
+			Name:   "watch-metadata",
+			Usage:  "Update the peers as soon as metadata changes rather than every check interval",
+			EnvVar: "CONNECTIVITY_CHECK_WATCH_METADATA",
+		},
+		cli.IntFlag{
+			Name:   "metadata-debounce",
+			Usage:  fmt.Sprintf("Customize the window in milliseconds within which the changes of metadata are coalesced (default: %v)", checker.DefaultMetadataDebounce),
+			EnvVar: "CONNECTIVITY_CHECK_METADATA_DEBOUNCE",
+		},
+		cli.IntFlag{
+			Name:   "sample-size",
+			Usage:  "Check only a random sample of this many peers, 0 checks all of them",
+			EnvVar: "CONNECTIVITY_CHECK_SAMPLE_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "sample-interval",
+			Usage:  "Interval in milliseconds at which a new sample of the peers is drawn",
+			Value:  300000,
+			EnvVar: "CONNECTIVITY_CHECK_SAMPLE_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   "async-log-buffer",
+			Usage:  "Log the checks asynchronously through a buffer of this many entries, dropping logs when full, 0 logs synchronously",
+			EnvVar: "CONNECTIVITY_CHECK_ASYNC_LOG_BUFFER",
+		},
+		cli.BoolFlag{
+			Name:   "sync-transition-logs",
+			Usage:  "Keep logging the peers becoming reachable or unreachable synchronously with async-log-buffer",
+			EnvVar: "CONNECTIVITY_CHECK_SYNC_TRANSITION_LOGS",
+		},
+		cli.BoolFlag{
+			Name:   "probe-by-name",
+			Usage:  "Check the peers by the DNS name of their container instead of their IP, reporting when DNS disagrees with metadata",
+			EnvVar: "CONNECTIVITY_CHECK_PROBE_BY_NAME",
+		},
+		cli.BoolFlag{
+			Name:   "reset-count-on-rebind",
+			Usage:  "Check a peer whose container was recreated as a new peer instead of keeping its state",
+			EnvVar: "CONNECTIVITY_CHECK_RESET_COUNT_ON_REBIND",
+		},
+		cli.BoolFlag{
+			Name:   "allow-seed-collisions",
+			Usage:  "Keep the peers whose checks got the same random seed as they are instead of changing their seed",
+			EnvVar: "CONNECTIVITY_CHECK_ALLOW_SEED_COLLISIONS",
+		},
+		cli.StringFlag{
+			Name:   "loop-order",
+			Value:  checker.LoopProbeFirst,
+			Usage:  "Whether the peers are checked before sleeping or after: probe-first or sleep-first",
+			EnvVar: "CONNECTIVITY_CHECK_LOOP_ORDER",
+		},
+		cli.StringFlag{
+			Name:   "jitter-distribution",
+			Value:  checker.JitterUniform,
+			Usage:  "Distribution of the jitter of the checks: uniform or exponential",
+			EnvVar: "CONNECTIVITY_CHECK_JITTER_DISTRIBUTION",
+		},
+		cli.IntFlag{
+			Name:   "max-check-interval",
+			Usage:  "Customize the longest interval in milliseconds between two checks of a peer, capping the adaptive and scheduled intervals (default: 0, no cap)",
+			EnvVar: "CONNECTIVITY_CHECK_MAX_CHECK_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   "latency-interval-min",
+			Usage:  "Shortest interval in milliseconds between two checks of a reachable peer whose latency is slow or variable, used along with latency-interval-max (default: 0, intervals don't follow the latency)",
+			EnvVar: "CONNECTIVITY_CHECK_LATENCY_INTERVAL_MIN",
+		},
+		cli.IntFlag{
+			Name:   "latency-interval-max",
+			Usage:  "Longest interval in milliseconds between two checks of a reachable peer whose latency is fast and stable, used along with latency-interval-min (default: 0, intervals don't follow the latency)",
+			EnvVar: "CONNECTIVITY_CHECK_LATENCY_INTERVAL_MAX",
+		},
+		cli.IntFlag{
+			Name:   "stuck-check-multiple",
+			Usage:  fmt.Sprintf("Customize after how many check intervals without a check a peer is reported stuck (default: %v)", checker.DefaultStuckCheckMultiple),
+			EnvVar: "CONNECTIVITY_CHECK_STUCK_CHECK_MULTIPLE",
+		},
+		cli.BoolFlag{
+			Name:   "http2",
+			Usage:  "Check the peers over HTTP/2 without TLS instead of HTTP/1.1, for health servers only speaking HTTP/2",
+			EnvVar: "CONNECTIVITY_CHECK_HTTP2",
+		},
+		cli.StringFlag{
+			Name:   "diagnostic-path",
+			Usage:  "Path of a JSON diagnostic endpoint of the peers fetched on every successful check, along with diagnostic-field",
+			EnvVar: "CONNECTIVITY_CHECK_DIAGNOSTIC_PATH",
+		},
+		cli.StringFlag{
+			Name:   "diagnostic-field",
+			Usage:  "Dotted path of the number extracted from the diagnostic endpoint of the peers, e.g. stats.loss",
+			EnvVar: "CONNECTIVITY_CHECK_DIAGNOSTIC_FIELD",
+		},
+		cli.StringSliceFlag{
+			Name:   "source-address",
+			Usage:  "Local IP or interface the checks originate from, several are used in turn",
+			EnvVar: "CONNECTIVITY_CHECK_SOURCE_ADDRESSES",
+		},
+		cli.StringFlag{
+			Name:   "source-port-policy",
+			Value:  checker.SourcePortRandom,
+			Usage:  "How the source port of the checks is picked: random, fixed or pool",
+			EnvVar: "CONNECTIVITY_CHECK_SOURCE_PORT_POLICY",
+		},
+		cli.IntFlag{
+			Name:   "source-port",
+			Usage:  "Source port of the checks with the fixed source port policy",
+			EnvVar: "CONNECTIVITY_CHECK_SOURCE_PORT",
+		},
+		cli.IntFlag{
+			Name:   "source-port-min",
+			Usage:  "First source port of the checks with the pool source port policy",
+			EnvVar: "CONNECTIVITY_CHECK_SOURCE_PORT_MIN",
+		},
+		cli.IntFlag{
+			Name:   "source-port-max",
+			Usage:  "Last source port of the checks with the pool source port policy",
+			EnvVar: "CONNECTIVITY_CHECK_SOURCE_PORT_MAX",
+		},
+		cli.StringSliceFlag{
+			Name:   "debug-peer",
+			Usage:  "UUID of a peer whose debug logs are enabled whatever the log level",
+			EnvVar: "CONNECTIVITY_CHECK_DEBUG_PEERS",
+		},
+		cli.StringSliceFlag{
+			Name:   "ignore-peer-reason",
+			Usage:  "Failure reason neutral for a single peer, as uuid=reason, e.g. uuid=\"connection refused\"",
+			EnvVar: "CONNECTIVITY_CHECK_IGNORE_PEER_REASONS",
+		},
+		cli.BoolFlag{
+			Name:   "debug-peers-only",
+			Usage:  "Drop the debug logs of the peers but the ones of the debug peers",
+			EnvVar: "CONNECTIVITY_CHECK_DEBUG_PEERS_ONLY",
+		},
+		cli.BoolFlag{
+			Name:   "negotiate-schema",
+			Usage:  "Ask the peers to answer the checks with the latest schema of the ping response they know",
+			EnvVar: "CONNECTIVITY_CHECK_NEGOTIATE_SCHEMA",
+		},
+		cli.StringFlag{
+			Name:   "source-identity",
+			Usage:  "Identity of this node, e.g. its host name, sent with the checks so the peers log who is checking them",
+			EnvVar: "CONNECTIVITY_CHECK_SOURCE_IDENTITY",
+		},
+		cli.IntFlag{
+			Name:   "mtu-probe-size",
+			Usage:  "Size in bytes of the large probe sent after a failed HTTP check to detect MTU issues, 0 disables it",
+			EnvVar: "CONNECTIVITY_CHECK_MTU_PROBE_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "open-connections-warning",
+			Usage:  "Number of open connections of the checks at which a warning is logged, 0 disables it",
+			EnvVar: "CONNECTIVITY_CHECK_OPEN_CONNECTIONS_WARNING",
+		},
+		cli.IntFlag{
+			Name:   "runtime-stats-interval",
+			Usage:  "Interval in milliseconds at which the goroutines and memory used by the checker are logged, 0 disables it",
+			EnvVar: "CONNECTIVITY_CHECK_RUNTIME_STATS_INTERVAL",
+		},
+		cli.BoolFlag{
+			Name:   "replica",
+			Usage:  "Only report the statuses of the peers read from the replica snapshot, never checking them",
+			EnvVar: "CONNECTIVITY_CHECK_REPLICA",
+		},
+		cli.StringFlag{
+			Name:   "replica-snapshot",
+			Usage:  "Snapshot written by another instance, see snapshot-path, ingested on every check interval by a replica",
+			EnvVar: "CONNECTIVITY_CHECK_REPLICA_SNAPSHOT",
+		},
+		cli.StringFlag{
+			Name:   "status-group-label",
+			Usage:  "Label of the containers by which the peers are grouped on /status (default: none, not grouped)",
+			EnvVar: "CONNECTIVITY_CHECK_STATUS_GROUP_LABEL",
+		},
+		cli.StringFlag{
+			Name:   "snapshot-path",
+			Usage:  "File the statuses of all the peers are written to as JSON on SIGUSR2 (default: none, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_SNAPSHOT_PATH",
+		},
+		cli.BoolFlag{
+			Name:   "write-state",
+			Usage:  "Write the reachability of the peers as a label of their container through the Rancher API",
+			EnvVar: "CONNECTIVITY_CHECK_WRITE_STATE",
+		},
+		cli.StringFlag{
+			Name:   "state-label",
+			Value:  checker.DefaultStateLabel,
+			Usage:  "Label of the containers of the peers their reachability is written to",
+			EnvVar: "CONNECTIVITY_CHECK_STATE_LABEL",
+		},
+		cli.IntFlag{
+			Name:   "state-write-interval",
+			Value:  checker.DefaultStateWriteInterval,
+			Usage:  "Minimum interval in milliseconds between two writes of the state of a peer",
+			EnvVar: "CONNECTIVITY_CHECK_STATE_WRITE_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "rancher-url",
+			Usage:  "URL of the Rancher API the state of the peers is written through",
+			EnvVar: "CATTLE_URL",
+		},
+		cli.StringFlag{
+			Name:   "rancher-access-key",
+			Usage:  "Access key of the Rancher API",
+			EnvVar: "CATTLE_ACCESS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "rancher-secret-key",
+			Usage:  "Secret key of the Rancher API",
+			EnvVar: "CATTLE_SECRET_KEY",
+		},
+		cli.IntFlag{
+			Name:   "event-log-size",
+			Usage:  "Number of transitions of the peers kept for the /events endpoint",
+			Value:  checker.DefaultEventLogSize,
+			EnvVar: "CONNECTIVITY_CHECK_EVENT_LOG_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "history-file",
+			Usage:  "File the results of all the checks are appended to as JSON lines",
+			EnvVar: "CONNECTIVITY_CHECK_HISTORY_FILE",
+		},
+		cli.IntFlag{
+			Name:   "history-max-size",
+			Usage:  "Size in bytes beyond which the history file is rotated",
+			Value:  checker.DefaultHistoryMaxSize,
+			EnvVar: "CONNECTIVITY_CHECK_HISTORY_MAX_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "history-max-files",
+			Usage:  "Number of rotated history files kept",
+			Value:  checker.DefaultHistoryMaxFiles,
+			EnvVar: "CONNECTIVITY_CHECK_HISTORY_MAX_FILES",
+		},
+		cli.IntFlag{
+			Name:   "churn-window",
+			Usage:  "Window (in ms) over which the transitions of the peers are counted for the churn rate",
+			Value:  checker.DefaultChurnWindow,
+			EnvVar: "CONNECTIVITY_CHECK_CHURN_WINDOW",
+		},
+		cli.IntFlag{
+			Name:   "breaker-threshold",
+			Usage:  "Number of checks in a row of the peers of a host failing for all of them to back off together (default: 0, disabled)",
+			EnvVar: "CONNECTIVITY_CHECK_BREAKER_THRESHOLD",
+		},
+		cli.IntFlag{
+			Name:   "breaker-cooldown",
+			Usage:  "How long (in ms) the peers of a host back off before a single one is checked as a trial",
+			Value:  checker.DefaultBreakerCooldown,
+			EnvVar: "CONNECTIVITY_CHECK_BREAKER_COOLDOWN",
+		},
+		cli.Float64Flag{
+			Name:   "healthy-fraction",
+			Usage:  "Fraction of the peers reachable at or above which the network becomes healthy again (default: 0, the health of the network not tracked)",
+			EnvVar: "CONNECTIVITY_CHECK_HEALTHY_FRACTION",
+		},
+		cli.Float64Flag{
+			Name:   "unhealthy-fraction",
+			Usage:  "Fraction of the peers reachable below which the network becomes unhealthy (default: 0, the health of the network not tracked)",
+			EnvVar: "CONNECTIVITY_CHECK_UNHEALTHY_FRACTION",
+		},
+		cli.IntFlag{
+			Name:   "network-health-delay",
+			Usage:  "How long (in ms) the fraction of the peers reachable must stay across a threshold for the health of the network to change",
+			EnvVar: "CONNECTIVITY_CHECK_NETWORK_HEALTH_DELAY",
+		},
+		cli.IntFlag{
+			Name:   "shutdown-timeout",
+			Usage:  "How long (in ms) to wait for the checks and the other goroutines to end when stopping",
+			Value:  checker.DefaultShutdownTimeout,
+			EnvVar: "CONNECTIVITY_CHECK_SHUTDOWN_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "flow-collector",
+			Usage:  "host:port of the collector the flow records of the checks are sent to over UDP (default: none)",
+			EnvVar: "CONNECTIVITY_CHECK_FLOW_COLLECTOR",
+		},
+		cli.IntFlag{
+			Name:   "flow-batch-size",
+			Usage:  "Number of flow records sent together",
+			Value:  checker.DefaultFlowBatchSize,
+			EnvVar: "CONNECTIVITY_CHECK_FLOW_BATCH_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "flow-flush-interval",
+			Usage:  "Interval (in ms) at which the pending flow records are sent",
+			Value:  checker.DefaultFlowFlushInterval,
+			EnvVar: "CONNECTIVITY_CHECK_FLOW_FLUSH_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "metrics",
+			Usage:  "Where the metrics of the checks are exported: none, prometheus (served on /metrics) or statsd",
+			Value:  "none",
+			EnvVar: "CONNECTIVITY_CHECK_METRICS",
+		},
+		cli.BoolFlag{
+			Name:   "syslog",
+			Usage:  "Send the transitions of the peers to syslog",
+			EnvVar: "CONNECTIVITY_CHECK_SYSLOG",
+		},
+		cli.StringFlag{
+			Name:   "syslog-network",
+			Usage:  "Network of the syslog server the transitions are sent to: udp or tcp, unused for the local syslog",
+			Value:  "udp",
+			EnvVar: "CONNECTIVITY_CHECK_SYSLOG_NETWORK",
+		},
+		cli.StringFlag{
+			Name:   "syslog-address",
+			Usage:  "Address of the syslog server the transitions are sent to (default: none, the local syslog)",
+			EnvVar: "CONNECTIVITY_CHECK_SYSLOG_ADDRESS",
+		},
+		cli.StringFlag{
+			Name:   "syslog-facility",
+			Usage:  "Facility of the transitions sent to syslog",
+			Value:  "daemon",
+			EnvVar: "CONNECTIVITY_CHECK_SYSLOG_FACILITY",
+		},
+		cli.StringFlag{
+			Name:   "syslog-severity",
+			Usage:  "Severity of the transitions sent to syslog",
+			Value:  "warning",
+			EnvVar: "CONNECTIVITY_CHECK_SYSLOG_SEVERITY",
+		},
+		cli.StringFlag{
+			Name:   "statsd-address",
+			Usage:  "Address of the statsd server the metrics are sent to",
+			Value:  "127.0.0.1:8125",
+			EnvVar: "CONNECTIVITY_CHECK_STATSD_ADDRESS",
+		},
+		cli.StringFlag{
+			Name:   "statsd-prefix",
+			Usage:  "Prefix of the names of the metrics sent to statsd",
+			Value:  "connectivity_check",
+			EnvVar: "CONNECTIVITY_CHECK_STATSD_PREFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "schedule",
+			Usage:  "Daily window during which the peers are checked at another interval, in the HH:MM-HH:MM=milliseconds form (can be repeated)",
+			EnvVar: "CONNECTIVITY_CHECK_SCHEDULE",
+		},
+		cli.StringFlag{
+			Name:   "schedule-timezone",
+			Usage:  "Time zone of the schedule windows, e.g. Europe/Paris",
+			Value:  "UTC",
+			EnvVar: "CONNECTIVITY_CHECK_SCHEDULE_TIMEZONE",
+		},
+		cli.StringSliceFlag{
+			Name:   "target",
+			Usage:  "Fixed endpoint to check in addition to the peers, in the [mode://]ip[:port][/path] form (can be repeated)",
+			EnvVar: "CONNECTIVITY_CHECK_TARGETS",
+		},
 		cli.IntFlag{
 			Name:  "port",
 			Value: checker.DefaultServerPort,
@@ -80,21 +896,270 @@ func run(c *cli.Context) error {
 	}
 	log.Infof("Successfully connected to metadata")
 
-	cc, err := checker.New(
-		portToUse,
-		c.Int("connectivity-check-interval"),
-		c.Int("peer-connection-timeout"),
-		mc,
-	)
+	cfg := checker.DefaultConfig()
+	cfg.Port = portToUse
+	cfg.CheckInterval = c.Int("connectivity-check-interval")
+	cfg.JitterDistribution = c.String("jitter-distribution")
+	cfg.LoopOrder = c.String("loop-order")
+	cfg.ConnectionTimeout = c.Int("peer-connection-timeout")
+	cfg.ClampConnectionTimeout = c.Bool("clamp-connection-timeout")
+	cfg.ConnectTimeout = c.Int("peer-connect-timeout")
+	cfg.ReadTimeout = c.Int("peer-read-timeout")
+	cfg.AdaptiveTimeoutFactor = c.Float64("adaptive-timeout-factor")
+	cfg.AdaptiveTimeoutCeiling = c.Int("adaptive-timeout-ceiling")
+	cfg.Mode = c.String("check-mode")
+	cfg.RelayAddress = c.String("relay-address")
+	cfg.TLSPort = c.Int("tls-port")
+	cfg.Ports = c.IntSlice("check-port")
+	cfg.ParallelConnections = c.Int("parallel-connections")
+	cfg.ParallelMinSuccesses = c.Int("parallel-min-successes")
+	cfg.RequestsPerCheck = c.Int("requests-per-check")
+	cfg.RequestsMinSuccesses = c.Int("requests-min-successes")
+	cfg.RequestsConcurrency = c.Int("requests-concurrency")
+	cfg.MaxConcurrentChecks = c.Int("max-concurrent-checks")
+	cfg.MaxChecksPerHost = c.Int("max-checks-per-host")
+	cfg.ProbeOrder = c.String("probe-order")
+	cfg.StartupRate = c.Int("startup-rate")
+	cfg.Replica = c.Bool("replica")
+	cfg.ReplicaSnapshot = c.String("replica-snapshot")
+	cfg.StartupInterval = c.Int("startup-interval")
+	cfg.Canary = c.Bool("canary")
+	cfg.SelfPolicy = c.String("self-policy")
+	cfg.ProbeCacheTTL = c.Int("probe-cache-ttl")
+	cfg.PortsPolicy = c.String("ports-policy")
+	cfg.TLSServerName = c.String("tls-server-name")
+	cfg.TLSVerify = c.Bool("tls-verify")
+	cfg.ClientCertFile = c.String("client-cert-file")
+	cfg.ClientKeyFile = c.String("client-key-file")
+	cfg.ConsiderPolicy = c.String("consider-policy")
+	cfg.MetadataGracePeriod = c.Int("metadata-grace-period")
+	cfg.StaleAfter = c.Int("stale-after")
+	cfg.StaleCheckInterval = c.Int("stale-check-interval")
+	cfg.StaleTimestampLabel = c.String("stale-timestamp-label")
+	cfg.HostCheck = c.String("host-check")
+	cfg.HostCheckPort = c.Int("host-check-port")
+	cfg.HostCheckPath = c.String("host-check-path")
+	cfg.HostCheckMode = c.String("host-check-mode")
+	cfg.ConfirmDownPort = c.Int("confirm-down-port")
+	cfg.ConfirmDownPath = c.String("confirm-down-path")
+	cfg.ConfirmDownMode = c.String("confirm-down-mode")
+	cfg.ConcurrentHostCheck = c.Bool("concurrent-host-check")
+	cfg.ConcurrentTargets = c.Bool("concurrent-targets")
+	cfg.LivenessPath = c.String("liveness-path")
+	cfg.ReadinessPath = c.String("readiness-path")
+	cfg.ReadinessPolicy = c.String("readiness-policy")
+	cfg.HealthExpected = c.String("health-expected")
+	cfg.VIPCheck = c.Bool("vip-check")
+	cfg.VIPPolicy = c.String("vip-policy")
+	cfg.LocalIPPolicy = c.String("local-ip-policy")
+	cfg.HostPort = c.Bool("host-port")
+	cfg.NeighborCheck = c.Bool("neighbor-check")
+	cfg.CheckMethod = c.String("check-method")
+	cfg.CheckBody = c.String("check-body")
+	cfg.CheckContentType = c.String("check-content-type")
+	cfg.ExpectedHeader = c.String("expected-header")
+	cfg.HeaderOnly = c.Bool("header-only")
+	cfg.ConnectProxy = c.String("connect-proxy")
+	cfg.ReasonStreaks = c.Bool("reason-streaks")
+	cfg.MeasureConnectTime = c.Bool("measure-connect-time")
+	cfg.VerboseFailures = c.Bool("verbose-failures")
+	cfg.RedactHeaders = c.StringSlice("redact-header")
+	cfg.SocketPath = c.String("socket-path")
+	cfg.DSCP = c.Int("dscp")
+	cfg.TCPMSS = c.Int("tcp-mss")
+	cfg.DisableKeepAlives = c.Bool("disable-keep-alives")
+	cfg.IdleConnTimeout = c.Int("idle-conn-timeout")
+	cfg.KeepAliveIdle = c.Int("keepalive-idle")
+	cfg.KeepAliveInterval = c.Int("keepalive-interval")
+	cfg.KeepAliveCount = c.Int("keepalive-count")
+	cfg.MaxIdleConnsPerPeer = c.Int("max-idle-conns-per-peer")
+	cfg.MaxConnsPerPeer = c.Int("max-conns-per-peer")
+	cfg.MaxLatency = c.Int("max-latency")
+	cfg.DegradedLatency = c.Int("degraded-latency")
+	cfg.DegradedPeriod = c.Int("degraded-period")
+	cfg.HourlyWindowDays = c.Int("hourly-window-days")
+	cfg.FailFast = c.Bool("fail-fast")
+	cfg.BurstSize = c.Int("burst-size")
+	cfg.BurstJitterThreshold = c.Int("burst-jitter-threshold")
+	cfg.WarmupProbes = c.Int("warmup-probes")
+	cfg.RecoverySuccesses = c.Int("recovery-successes")
+	cfg.RetryBudget = c.Int("retry-budget")
+	cfg.SuccessRateMinChecks = c.Int("success-rate-min-checks")
+	cfg.RetryRefill = c.Float64("retry-refill")
+	cfg.WindowSize = c.Int("window-size")
+	cfg.WindowMinSuccesses = c.Int("window-min-successes")
+	cfg.MaxAdaptiveInterval = c.Int("max-adaptive-interval")
+	cfg.OpenConnectionsWarning = c.Int("open-connections-warning")
+	cfg.ProbeByName = c.Bool("probe-by-name")
+	cfg.StuckCheckMultiple = c.Int("stuck-check-multiple")
+	cfg.MaxCheckInterval = c.Int("max-check-interval")
+	cfg.LatencyIntervalMin = c.Int("latency-interval-min")
+	cfg.LatencyIntervalMax = c.Int("latency-interval-max")
+	cfg.AllowSeedCollisions = c.Bool("allow-seed-collisions")
+	cfg.ResetCountOnRebind = c.Bool("reset-count-on-rebind")
+	cfg.SourceAddresses = c.StringSlice("source-address")
+	cfg.SourcePortPolicy = c.String("source-port-policy")
+	cfg.SourcePort = c.Int("source-port")
+	cfg.SourcePortMin = c.Int("source-port-min")
+	cfg.SourcePortMax = c.Int("source-port-max")
+	cfg.DebugPeersOnly = c.Bool("debug-peers-only")
+	cfg.NegotiateSchema = c.Bool("negotiate-schema")
+	cfg.SourceIdentity = c.String("source-identity")
+	cfg.CorrelationIDs = c.Bool("correlation-ids")
+	cfg.DiagnosticPath = c.String("diagnostic-path")
+	cfg.HTTP2 = c.Bool("http2")
+	cfg.DiagnosticField = c.String("diagnostic-field")
+	cfg.MTUProbeSize = c.Int("mtu-probe-size")
+	cfg.MetadataDebounce = c.Int("metadata-debounce")
+	cfg.SettlingPeriod = c.Int("settling-period")
+	cfg.SampleSize = c.Int("sample-size")
+	cfg.SampleInterval = c.Int("sample-interval")
+	cfg.AsyncLogBuffer = c.Int("async-log-buffer")
+	cfg.RuntimeStatsInterval = c.Int("runtime-stats-interval")
+	cfg.StatusGroupLabel = c.String("status-group-label")
+	cfg.EventLogSize = c.Int("event-log-size")
+	cfg.HistoryFile = c.String("history-file")
+	cfg.HistoryMaxSize = c.Int("history-max-size")
+	cfg.HistoryMaxFiles = c.Int("history-max-files")
+	cfg.ShutdownTimeout = c.Int("shutdown-timeout")
+	cfg.BreakerThreshold = c.Int("breaker-threshold")
+	cfg.BreakerCooldown = c.Int("breaker-cooldown")
+	cfg.HealthyFraction = c.Float64("healthy-fraction")
+	cfg.UnhealthyFraction = c.Float64("unhealthy-fraction")
+	cfg.NetworkHealthDelay = c.Int("network-health-delay")
+	cfg.ChurnWindow = c.Int("churn-window")
+	cfg.FlowCollector = c.String("flow-collector")
+	cfg.FlowBatchSize = c.Int("flow-batch-size")
+	cfg.FlowFlushInterval = c.Int("flow-flush-interval")
+	cfg.SyncTransitionLogs = c.Bool("sync-transition-logs")
+	switch c.String("metrics") {
+	case "none":
+	case "prometheus":
+		cfg.Metrics = checker.NewPrometheusSink()
+	case "statsd":
+		sink, err := checker.NewStatsdSink(c.String("statsd-address"), c.String("statsd-prefix"))
+		if err != nil {
+			log.Errorf("error creating statsd sink: %v", err)
+			return err
+		}
+		defer sink.Close()
+		cfg.Metrics = sink
+	default:
+		err := fmt.Errorf("unknown metrics sink: %v", c.String("metrics"))
+		log.Errorf("%v", err)
+		return err
+	}
+	if c.Bool("syslog") {
+		sink, err := checker.NewSyslogSink(c.String("syslog-network"), c.String("syslog-address"),
+			c.String("syslog-facility"), c.String("syslog-severity"), "connectivity-check")
+		if err != nil {
+			log.Errorf("error creating syslog sink: %v", err)
+			return err
+		}
+		defer sink.Close()
+		cfg.OnStateChange = sink.StateChanged
+	}
+	if c.Bool("write-state") {
+		writer, err := checker.NewRancherStateWriter(c.String("rancher-url"), c.String("rancher-access-key"),
+			c.String("rancher-secret-key"), c.String("state-label"))
+		if err != nil {
+			log.Errorf("error creating the state writer: %v", err)
+			return err
+		}
+		cfg.StateWriter = writer
+		cfg.StateWriteInterval = c.Int("state-write-interval")
+	}
+	for _, aWindow := range c.StringSlice("schedule") {
+		w, err := checker.ParseScheduleWindow(aWindow)
+		if err != nil {
+			log.Errorf("invalid schedule: %v", err)
+			return err
+		}
+		cfg.Schedule = append(cfg.Schedule, w)
+	}
+	loc, err := time.LoadLocation(c.String("schedule-timezone"))
+	if err != nil {
+		log.Errorf("invalid schedule timezone: %v", err)
+		return err
+	}
+	cfg.ScheduleLocation = loc
+	for _, s := range c.StringSlice("failure-weight") {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			err := fmt.Errorf("expected reason=weight, got %v", s)
+			log.Errorf("invalid failure-weight: %v", err)
+			return err
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil {
+			log.Errorf("invalid failure-weight: %v", err)
+			return err
+		}
+		if cfg.FailureWeights == nil {
+			cfg.FailureWeights = map[utils.FailureReason]int{}
+		}
+		cfg.FailureWeights[utils.FailureReason(parts[0])] = weight
+	}
+	for _, aTarget := range c.StringSlice("target") {
+		t, err := checker.ParseTarget(aTarget)
+		if err != nil {
+			log.Errorf("invalid target: %v", err)
+			return err
+		}
+		cfg.Targets = append(cfg.Targets, t)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Errorf("invalid configuration: %v", err)
+		return err
+	}
+	log.Infof("effective configuration: %+v", cfg)
+
+	cc, err := checker.NewWithConfig(cfg, mc)
 	if err != nil {
 		log.Errorf("Error creating new checker: %v", err)
 		return err
 	}
 
-	if err := cc.Start(); err != nil {
+	for _, uuid := range c.StringSlice("debug-peer") {
+		cc.SetPeerDebug(uuid, true)
+	}
+	ignoredReasons := map[string][]utils.FailureReason{}
+	for _, s := range c.StringSlice("ignore-peer-reason") {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			err := fmt.Errorf("expected uuid=reason, got %v", s)
+			log.Errorf("invalid ignore-peer-reason: %v", err)
+			return err
+		}
+		ignoredReasons[parts[0]] = append(ignoredReasons[parts[0]], utils.FailureReason(parts[1]))
+	}
+	for uuid, reasons := range ignoredReasons {
+		cc.SetPeerIgnoredReasons(uuid, reasons)
+	}
+
+	if err := cc.Start(context.Background()); err != nil {
 		log.Errorf("Failed to start: %v", err)
 	}
 
+	if c.Bool("watch-metadata") {
+		go mc.OnChange(5, func(string) { cc.MetadataChanged() })
+	}
+
+	if path := c.String("snapshot-path"); path != "" {
+		dumpCh := make(chan os.Signal, 1)
+		signal.Notify(dumpCh, syscall.SIGUSR2)
+		go func() {
+			for range dumpCh {
+				if err := cc.WriteSnapshot(path); err != nil {
+					log.Errorf("error writing the snapshot to %v: %v", path, err)
+					continue
+				}
+				log.Infof("snapshot written to %v", path)
+			}
+		}()
+	}
+
 	sCh := make(chan os.Signal, 2)
 	signal.Notify(sCh, os.Interrupt, syscall.SIGTERM)
 	<-sCh
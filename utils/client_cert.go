@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// FailureTLSAuth is used when the peer rejected the client
+// certificate, or required one and none was configured
+const FailureTLSAuth FailureReason = "tls auth"
+
+// tlsAuthAlerts are the alerts a peer sends when it rejects the
+// client certificate
+var tlsAuthAlerts = []string{
+	"tls: certificate required",
+	"tls: bad certificate",
+	"tls: unknown certificate authority",
+	"tls: certificate expired",
+	"tls: certificate revoked",
+	"tls: unsupported certificate",
+	"tls: access denied",
+}
+
+// ClientCertificate is the certificate presented by the TLS checks to
+// the peers requiring mutual TLS. It's reloaded from its files when
+// they change, e.g. on rotation, the last one loaded being kept while
+// the new one doesn't load.
+type ClientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// LoadClientCertificate loads the certificate and the key, PEM
+// encoded, from the given files
+func LoadClientCertificate(certFile, keyFile string) (*ClientCertificate, error) {
+	c := &ClientCertificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the files again if they changed since last loaded,
+// it must be called with the lock held
+func (c *ClientCertificate) reload() error {
+	var modTimes [2]time.Time
+	for i, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if c.cert != nil && modTimes == c.modTimes {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading client certificate %v: %v", c.certFile, err)
+	}
+	if c.cert != nil {
+		logrus.Infof("reloaded client certificate %v", c.certFile)
+	}
+	c.cert, c.modTimes = &cert, modTimes
+	return nil
+}
+
+// GetClientCertificate is the tls.Config hook presenting the
+// certificate, reloaded first if its files changed
+func (c *ClientCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.reload(); err != nil {
+		logrus.Errorf("%v, presenting the last one loaded", err)
+	}
+	return c.cert, nil
+}
+
+// tlsConfig returns the settings of the TLS connections of the checks
+func tlsConfig(serverName string, opts Options) *tls.Config {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: !opts.TLSVerify,
+	}
+	if opts.ClientCertificate != nil {
+		config.GetClientCertificate = opts.ClientCertificate.GetClientCertificate
+	}
+	return config
+}
+
+// isTLSAuthError informs if the error is the peer rejecting the
+// client certificate
+func isTLSAuthError(err error) bool {
+	msg := err.Error()
+	for _, alert := range tlsAuthAlerts {
+		if strings.Contains(msg, alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTLSError informs if the error happened while doing the TLS
+// handshake or validating the certificate of the peer
+func isTLSError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "tls: ") || strings.Contains(msg, "x509: ")
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// proxyError is returned when the tunnel through the CONNECT proxy
+// couldn't be established, see Options.ConnectProxy
+type proxyError struct {
+	err error
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("CONNECT proxy: %v", e.err)
+}
+
+// Unwrap returns the error met establishing the tunnel
+func (e *proxyError) Unwrap() error {
+	return e.err
+}
+
+// bufferedConn is a connection whose first bytes were already read
+// into a buffer along with the response of the proxy
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// dialThroughProxy returns a DialFunc establishing the connections
+// through a tunnel opened by an HTTP CONNECT to the proxy at the given
+// address, the rest of the check being the same as without it. The
+// CONNECT is bounded by the deadline of the context, by connectTimeout
+// milliseconds otherwise.
+func dialThroughProxy(dial DialFunc, proxy string, connectTimeout int) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, proxy)
+		if err != nil {
+			return nil, &proxyError{err: err}
+		}
+		deadline, ok := ctx.Deadline()
+		if !ok && connectTimeout > 0 {
+			deadline, ok = time.Now().Add(toDuration(connectTimeout)), true
+		}
+		if ok {
+			conn.SetDeadline(deadline)
+		}
+
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: address},
+			Host:   address,
+			Header: make(http.Header),
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, &proxyError{err: err}
+		}
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, req)
+		if err != nil {
+			conn.Close()
+			return nil, &proxyError{err: err}
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, &proxyError{err: fmt.Errorf("CONNECT %v got %v", address, resp.Status)}
+		}
+
+		conn.SetDeadline(time.Time{})
+		if r.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, r: r}, nil
+		}
+		return conn, nil
+	}
+}
+
+// isProxyError informs if err comes from establishing the tunnel
+// through the CONNECT proxy
+func isProxyError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*proxyError); ok {
+			return true
+		}
+		u, ok := err.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			return false
+		}
+		err = u.Unwrap()
+	}
+	return false
+}
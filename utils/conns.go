@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// openConnsWarningInterval is the least interval between two
+// warnings about the number of open connections
+const openConnsWarningInterval = time.Minute
+
+var (
+	openConns        int64
+	openConnsWarning int64
+	// openConnsWarned is when the last warning was logged, in
+	// nanoseconds since the epoch
+	openConnsWarned int64
+
+	openConnsByAddressMu sync.Mutex
+	openConnsByAddress   = make(map[string]int64)
+)
+
+// trackedConn keeps the count of the open connections up to date
+type trackedConn struct {
+	net.Conn
+	address   string
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(&openConns, -1)
+		openConnsByAddressMu.Lock()
+		defer openConnsByAddressMu.Unlock()
+		if openConnsByAddress[c.address]--; openConnsByAddress[c.address] <= 0 {
+			delete(openConnsByAddress, c.address)
+		}
+	})
+	return c.Conn.Close()
+}
+
+// trackConns wraps dial so that the connections it establishes
+// are counted until closed
+func trackConns(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		n := atomic.AddInt64(&openConns, 1)
+		if limit := atomic.LoadInt64(&openConnsWarning); limit > 0 && n >= limit {
+			warnOpenConns(n, time.Now())
+		}
+		openConnsByAddressMu.Lock()
+		openConnsByAddress[address]++
+		openConnsByAddressMu.Unlock()
+		return &trackedConn{Conn: conn, address: address}, nil
+	}
+}
+
+// warnOpenConns logs that n connections are open, at most once
+// every openConnsWarningInterval
+func warnOpenConns(n int64, now time.Time) {
+	last := atomic.LoadInt64(&openConnsWarned)
+	if last != 0 && now.Sub(time.Unix(0, last)) < openConnsWarningInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&openConnsWarned, last, now.UnixNano()) {
+		return
+	}
+	logrus.Warnf("%v connections of the checks are open, file descriptors or ephemeral ports may run out", n)
+}
+
+// OpenConnections returns the number of connections of the checks
+// currently open, including the idle ones kept for reuse
+func OpenConnections() int64 {
+	return atomic.LoadInt64(&openConns)
+}
+
+// OpenConnectionsTo returns the number of connections of the checks
+// currently open to the given address, including the idle ones
+func OpenConnectionsTo(address string) int64 {
+	openConnsByAddressMu.Lock()
+	defer openConnsByAddressMu.Unlock()
+	return openConnsByAddress[address]
+}
+
+// SetOpenConnectionsWarning sets the number of open connections at
+// which a warning is logged, 0 disables the warning
+func SetOpenConnectionsWarning(n int) {
+	atomic.StoreInt64(&openConnsWarning, int64(n))
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// newDialer returns the dialer used by all the checks
+func newDialer(opts Options) *net.Dialer {
+	d := &net.Dialer{
+		Timeout: toDuration(opts.ConnectTimeout),
+	}
+	if opts.SourceAddress != "" || opts.SourcePort > 0 {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(opts.SourceAddress), Port: opts.SourcePort}
+	}
+	keepAlive := opts.KeepAliveIdle > 0 || opts.KeepAliveInterval > 0 || opts.KeepAliveCount > 0
+	if keepAlive {
+		// The keepalive is set up by the Control below, the
+		// defaults of the dialer would override it
+		d.KeepAlive = -1
+	}
+	if opts.DSCP > 0 || keepAlive || opts.SourcePort > 0 || opts.MSS > 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				if opts.DSCP > 0 {
+					if err := setTOS(fd, network, opts.DSCP<<2); err != nil {
+						logrus.Warnf("couldn't set DSCP %v on connection to %v: %v", opts.DSCP, address, err)
+					}
+				}
+				if opts.MSS > 0 {
+					if err := setMSS(fd, opts.MSS); err != nil {
+						logrus.Warnf("couldn't set TCP MSS %v on connection to %v: %v", opts.MSS, address, err)
+					}
+				}
+				if opts.SourcePort > 0 {
+					// The port is likely still in TIME_WAIT from
+					// the previous check
+					if err := setReuseAddr(fd); err != nil {
+						logrus.Warnf("couldn't reuse source port %v on connection to %v: %v", opts.SourcePort, address, err)
+					}
+				}
+				if keepAlive {
+					if err := setKeepAlive(fd, opts.KeepAliveIdle, opts.KeepAliveInterval, opts.KeepAliveCount); err != nil {
+						logrus.Warnf("couldn't set the TCP keepalive of connection to %v: %v", address, err)
+					}
+				}
+			})
+		}
+	}
+	return d
+}
+
+// sourcePortKey is the key of the context value holding the source
+// port of the connection of a request, see withSourcePort
+type sourcePortKey struct{}
+
+// withSourcePort returns the context of a request whose connection is
+// to originate from the given port, if set, the transports being
+// shared by the checks from all the ports
+func withSourcePort(ctx context.Context, port int) context.Context {
+	if port <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, sourcePortKey{}, port)
+}
+
+// DialFunc establishes the connections of the checks, it has the
+// signature of net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dialFunc returns the DialFunc used by the checks, the one given
+// in the options, e.g. routing through a service mesh, or the
+// standard dialer. The connections are counted until closed.
+func dialFunc(opts Options) DialFunc {
+	var dial DialFunc
+	switch {
+	case opts.DialFunc == nil:
+		d := newDialer(opts)
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if port, ok := ctx.Value(sourcePortKey{}).(int); ok {
+				portOpts := opts
+				portOpts.SourcePort = port
+				return newDialer(portOpts).DialContext(ctx, network, address)
+			}
+			return d.DialContext(ctx, network, address)
+		}
+	case opts.ConnectTimeout <= 0:
+		dial = opts.DialFunc
+	default:
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, toDuration(opts.ConnectTimeout))
+			defer cancel()
+			return opts.DialFunc(ctx, network, address)
+		}
+	}
+	if opts.ConnectProxy != "" {
+		dial = dialThroughProxy(dial, opts.ConnectProxy, opts.ConnectTimeout)
+	}
+	return trackConns(dial)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// jsonValueLimit bounds how much of a JSON document is read
+const jsonValueLimit = 1 << 20
+
+// FetchJSONValue requests the given URL and extracts from the JSON
+// document of the response the number found at field, a path of
+// object keys and array indexes separated by dots, e.g.
+// "queues.0.depth"
+func FetchJSONValue(url, field string, opts Options) (float64, error) {
+	client := http.Client{
+		Timeout:   toDuration(opts.Timeout),
+		Transport: getTransport(opts),
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(withSourcePort(req.Context(), opts.SourcePort))
+	if opts.SourceIdentity != "" {
+		req.Header.Set(SourceHeader, opts.SourceIdentity)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("got StatusCode: %v", resp.StatusCode)
+	}
+
+	var doc interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jsonValueLimit)).Decode(&doc); err != nil {
+		return 0, err
+	}
+	return lookupJSONNumber(doc, field)
+}
+
+func lookupJSONNumber(doc interface{}, field string) (float64, error) {
+	value := doc
+	for _, key := range strings.Split(field, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			found, ok := v[key]
+			if !ok {
+				return 0, fmt.Errorf("field %v not found", field)
+			}
+			value = found
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return 0, fmt.Errorf("field %v not found", field)
+			}
+			value = v[index]
+		default:
+			return 0, fmt.Errorf("field %v not found", field)
+		}
+	}
+	n, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("field %v is not a number: %v", field, value)
+	}
+	return n, nil
+}
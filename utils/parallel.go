@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ConnectParallel establishes n TCP connections to the given address at
+// the same time, keeping them open until all the attempts are over, and
+// returns how many succeeded along with the error of a failed one
+func ConnectParallel(address string, n int, opts Options) (int, error) {
+	logrus.Debugf("opening %v connections to %v at once", n, address)
+
+	timeout := opts.ConnectTimeout
+	if timeout == 0 || (opts.Timeout > 0 && opts.Timeout < timeout) {
+		timeout = opts.Timeout
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, toDuration(timeout))
+		defer cancel()
+	}
+
+	dial := dialFunc(opts)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var conns []net.Conn
+	var firstErr error
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := dial(ctx, "tcp", address)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = classifyError(err, false)
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}()
+	}
+	wg.Wait()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns), firstErr
+}
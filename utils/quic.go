@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strings"
+)
+
+// quicHandshakeErrors are the errors of the QUIC implementations, e.g.
+// quic-go, failing the handshake rather than the connection to the
+// peer
+var quicHandshakeErrors = []string{
+	"handshake did not complete in time",
+	"CRYPTO_ERROR",
+	"no compatible QUIC version found",
+	"VERSION_NEGOTIATION_ERROR",
+}
+
+// isQUICHandshakeError informs if the error of the HTTP3Transport is
+// the QUIC handshake failing
+func isQUICHandshakeError(err error) bool {
+	msg := err.Error()
+	for _, handshakeErr := range quicHandshakeErrors {
+		if strings.Contains(msg, handshakeErr) {
+			return true
+		}
+	}
+	return false
+}
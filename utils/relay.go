@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// RelayPath is the endpoint through which a peer is asked to
+	// check another one on behalf of the checking node
+	RelayPath = "/relay"
+
+	// FailureRelay is used when the relay itself couldn't be asked,
+	// as opposed to the reason it reported for the target
+	FailureRelay FailureReason = "relay error"
+
+	// relayBodyLimit bounds how much of a relay response is read
+	relayBodyLimit = 4096
+)
+
+// RelayResponse is the response of RelayPath, what the relay got
+// checking the target
+type RelayResponse struct {
+	Reachable bool          `json:"reachable"`
+	Reason    FailureReason `json:"reason,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	// LatencyMs is how long the check of the target by the relay took
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// RelayURL returns the URL asking the relay to check the target, both
+// in host:port form, within the given timeout in milliseconds
+func RelayURL(relay, target string, timeout int) string {
+	q := url.Values{}
+	q.Set("target", target)
+	if timeout > 0 {
+		q.Set("timeout", strconv.Itoa(timeout))
+	}
+	return fmt.Sprintf("http://%v%v?%v", relay, RelayPath, q.Encode())
+}
+
+// RequestRelay asks the relay to check the target, giving it half of
+// opts.Timeout so that its answer arrives within the other half. The
+// error is a CheckError with FailureRelay when the relay couldn't be
+// asked, the response telling how the check of the target went.
+func RequestRelay(relay, target string, opts Options) (RelayResponse, error) {
+	client := http.Client{
+		Timeout:   toDuration(opts.Timeout),
+		Transport: getTransport(opts),
+	}
+	req, err := http.NewRequest(http.MethodGet, RelayURL(relay, target, opts.Timeout/2), nil)
+	if err != nil {
+		return RelayResponse{}, &CheckError{Reason: FailureOther, Err: err}
+	}
+	req = req.WithContext(withSourcePort(req.Context(), opts.SourcePort))
+	if opts.SourceIdentity != "" {
+		req.Header.Set(SourceHeader, opts.SourceIdentity)
+	}
+	if opts.CorrelationID != "" {
+		req.Header.Set(CorrelationHeader, opts.CorrelationID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return RelayResponse{}, &CheckError{Reason: FailureRelay, Err: fmt.Errorf("relay %v: %v", relay, err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RelayResponse{}, &CheckError{Reason: FailureRelay, Err: fmt.Errorf("relay %v: got StatusCode: %v", relay, resp.StatusCode)}
+	}
+	var relayed RelayResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, relayBodyLimit)).Decode(&relayed); err != nil {
+		return RelayResponse{}, &CheckError{Reason: FailureRelay, Err: fmt.Errorf("relay %v: %v", relay, err)}
+	}
+	return relayed, nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	// SchemaHeader carries, in a request, the latest version of the
+	// schema of the ping response the checking node understands and,
+	// in the response, the version the checked peer answered with
+	SchemaHeader = "X-Connectivity-Check-Schema"
+
+	// SchemaLegacy is the plain pong of the peers not negotiating
+	SchemaLegacy = 1
+	// SchemaJSON is a PingResponse encoded as JSON
+	SchemaJSON = 2
+	// SchemaVersion is the latest version of the schema
+	SchemaVersion = SchemaJSON
+
+	// schemaBodyLimit bounds how much of a JSON response is read
+	schemaBodyLimit = 4096
+)
+
+// PingResponse is the response of the ping endpoint with SchemaJSON
+type PingResponse struct {
+	// Status is the plain response of SchemaLegacy, i.e. pong
+	Status string `json:"status"`
+	// Received is when the request was received, in nanoseconds since
+	// the epoch
+	Received int64 `json:"received,omitempty"`
+	// Nonce is the nonce sent with the request, if any
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// RequestedSchema returns the version of the schema to answer a
+// request with, given the value of its SchemaHeader
+func RequestedSchema(header string) int {
+	v, err := strconv.Atoi(header)
+	if err != nil || v < SchemaLegacy {
+		return SchemaLegacy
+	}
+	if v > SchemaVersion {
+		return SchemaVersion
+	}
+	return v
+}
+
+// pingStatus returns what a response with the given version of the
+// schema says in the place of the plain pong
+func pingStatus(version int, body []byte) string {
+	if version < SchemaJSON {
+		return string(body)
+	}
+	var resp PingResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return string(body)
+	}
+	return resp.Status
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"syscall"
+)
+
+// setKeepAlive enables the TCP keepalive, the durations are in
+// milliseconds but the system only takes whole seconds, 0 keeps
+// the default of the system
+func setKeepAlive(fd uintptr, idle, interval, count int) error {
+	if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if idle > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, roundUpToSeconds(idle)); err != nil {
+			return err
+		}
+	}
+	if interval > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, roundUpToSeconds(interval)); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	}
+	return nil
+}
+
+func roundUpToSeconds(ms int) int {
+	return (ms + 999) / 1000
+}
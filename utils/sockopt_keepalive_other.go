@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import (
+	"errors"
+)
+
+func setKeepAlive(fd uintptr, idle, interval, count int) error {
+	return errors.New("tuning the TCP keepalive is only supported on linux")
+}
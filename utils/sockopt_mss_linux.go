@@ -0,0 +1,11 @@
+package utils
+
+import (
+	"syscall"
+)
+
+// setMSS clamps the maximum segment size of the connection, the
+// system may still lower it to the MTU of the path
+func setMSS(fd uintptr, mss int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, mss)
+}
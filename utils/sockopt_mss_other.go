@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import (
+	"errors"
+)
+
+func setMSS(fd uintptr, mss int) error {
+	return errors.New("setting the TCP MSS is only supported on linux")
+}
@@ -0,0 +1,13 @@
+package utils
+
+import (
+	"errors"
+)
+
+func setTOS(fd uintptr, network string, tos int) error {
+	return errors.New("setting the ToS is not supported on windows")
+}
+
+func setReuseAddr(fd uintptr) error {
+	return errors.New("reusing the source port is not supported on windows")
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// FailureTLS is used when the TLS handshake failed or the
+// certificate didn't validate
+const FailureTLS FailureReason = "tls handshake"
+
+// IsTLSReachable checks if a TLS handshake can be completed with the
+// given address, it returns the expiry of the certificate served.
+// The certificate is validated only with TLSVerify.
+func IsTLSReachable(address string, opts Options) (bool, time.Time, error) {
+	logrus.Debugf("is %v Reachable over TLS", address)
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, toDuration(opts.Timeout))
+		defer cancel()
+	}
+	conn, err := dialFunc(opts)(ctx, "tcp", address)
+	if err != nil {
+		return false, time.Time{}, classifyError(err, false)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	serverName := opts.TLSServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(address)
+	}
+	tlsConn := tls.Client(conn, tlsConfig(serverName, opts))
+	if err := tlsConn.Handshake(); err != nil {
+		if isTimeout(err) {
+			return false, time.Time{}, &CheckError{Reason: FailureReadTimeout, Err: err}
+		}
+		if isTLSAuthError(err) {
+			return false, time.Time{}, &CheckError{Reason: FailureTLSAuth, Err: err}
+		}
+		return false, time.Time{}, &CheckError{Reason: FailureTLS, Err: err}
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return false, time.Time{}, &CheckError{Reason: FailureTLS, Err: fmt.Errorf("no certificate served")}
+	}
+	return true, certs[0].NotAfter, nil
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// transportKey holds the options affecting how the connections
+// are established and kept, checks with the same key share a
+// transport and so their idle connections
+type transportKey struct {
+	ConnectTimeout    int
+	DSCP              int
+	MSS               int
+	DisableKeepAlives bool
+	IdleConnTimeout   int
+	MaxIdleConns      int
+	MaxConns          int
+	KeepAliveIdle     int
+	KeepAliveInterval int
+	KeepAliveCount    int
+	HTTP2             bool
+	SocketPath        string
+	SourceAddress     string
+	// SourcePorts is set when the checks pick their source port, the
+	// port being given to the dialer by the request, see
+	// withSourcePort
+	SourcePorts       bool
+	ConnectProxy      string
+	TLSServerName     string
+	TLSVerify         bool
+	ClientCertificate *ClientCertificate
+	// DialFuncID identifies the custom DialFunc, if any
+	DialFuncID uint64
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[transportKey]*http.Transport)
+	dialFuncIDs  uint64
+
+	connsTotal  uint64
+	connsReused uint64
+)
+
+func getTransport(opts Options) *http.Transport {
+	key := transportKey{
+		ConnectTimeout:    opts.ConnectTimeout,
+		DSCP:              opts.DSCP,
+		MSS:               opts.MSS,
+		DisableKeepAlives: opts.DisableKeepAlives,
+		IdleConnTimeout:   opts.IdleConnTimeout,
+		MaxIdleConns:      opts.MaxIdleConnsPerHost,
+		MaxConns:          opts.MaxConnsPerHost,
+		KeepAliveIdle:     opts.KeepAliveIdle,
+		KeepAliveInterval: opts.KeepAliveInterval,
+		KeepAliveCount:    opts.KeepAliveCount,
+		HTTP2:             opts.HTTP2,
+		SocketPath:        opts.SocketPath,
+		SourceAddress:     opts.SourceAddress,
+		ConnectProxy:      opts.ConnectProxy,
+		TLSServerName:     opts.TLSServerName,
+		TLSVerify:         opts.TLSVerify,
+		ClientCertificate: opts.ClientCertificate,
+	}
+	if opts.SourcePort > 0 {
+		// A connection kept would be reused from its port rather
+		// than a new one originating from the next
+		opts.SourcePort = 0
+		opts.DisableKeepAlives = true
+		key.DisableKeepAlives = true
+		key.SourcePorts = true
+	}
+	if opts.DialFunc != nil {
+		if opts.DialFuncID == 0 {
+			// Nothing tells the functions apart, so the transport
+			// isn't shared and doesn't keep its connections
+			opts.DisableKeepAlives = true
+			return newTransport(opts)
+		}
+		key.DialFuncID = opts.DialFuncID
+	}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	t, ok := transports[key]
+	if !ok {
+		t = newTransport(opts)
+		transports[key] = t
+	}
+	return t
+}
+
+func newTransport(opts Options) *http.Transport {
+	dial := dialFunc(opts)
+	t := &http.Transport{
+		DialContext:       dial,
+		DisableKeepAlives: opts.DisableKeepAlives,
+		IdleConnTimeout:   toDuration(opts.IdleConnTimeout),
+
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     opts.MaxConnsPerHost,
+
+		TLSClientConfig: tlsConfig(opts.TLSServerName, opts),
+	}
+	t.Protocols = new(http.Protocols)
+	if opts.HTTP2 {
+		t.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		t.Protocols.SetHTTP1(true)
+	}
+	if opts.SocketPath != "" {
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx, "unix", opts.SocketPath)
+		}
+	}
+	return t
+}
+
+// NewDialFuncID returns a new identity for a DialFunc, see
+// Options.DialFuncID
+func NewDialFuncID() uint64 {
+	return atomic.AddUint64(&dialFuncIDs, 1)
+}
+
+func recordConn(reused bool) {
+	atomic.AddUint64(&connsTotal, 1)
+	if reused {
+		atomic.AddUint64(&connsReused, 1)
+	}
+}
+
+// ConnectionStats returns the number of connections used by the
+// HTTP checks and how many of them were reused idle connections
+func ConnectionStats() (total, reused uint64) {
+	return atomic.LoadUint64(&connsTotal), atomic.LoadUint64(&connsReused)
+}
+
+// ConnectionReuseRate returns the fraction of the HTTP checks
+// which reused an idle connection
+func ConnectionReuseRate() float64 {
+	total, reused := ConnectionStats()
+	if total == 0 {
+		return 0
+	}
+	return float64(reused) / float64(total)
+}
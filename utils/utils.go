@@ -1,48 +1,614 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
+// FailureReason describes why a reachability check failed
+type FailureReason string
+
+const (
+	// FailureNone is used when the check didn't fail
+	FailureNone FailureReason = ""
+	// FailureConnectTimeout is used when the connection couldn't be
+	// established in time, usually meaning the path is down
+	FailureConnectTimeout FailureReason = "connect timeout"
+	// FailureReadTimeout is used when the connection was established
+	// but the response didn't arrive in time, usually meaning the
+	// remote application is slow
+	FailureReadTimeout FailureReason = "read timeout"
+	// FailureRefused is used when the remote end refused the connection
+	FailureRefused FailureReason = "connection refused"
+	// FailureConnect is used for any other error while connecting
+	FailureConnect FailureReason = "connect error"
+	// FailureStatusCode is used when the response had an unexpected status code
+	FailureStatusCode FailureReason = "unexpected status code"
+	// FailureBodyMismatch is used when the response body didn't match
+	FailureBodyMismatch FailureReason = "body mismatch"
+	// FailureContentType is used when the response didn't have the
+	// expected content type, e.g. an error page of a proxy
+	FailureContentType FailureReason = "content type mismatch"
+	// FailureHeaderMismatch is used when the response didn't carry
+	// the expected header value, see Options.ExpectedHeader
+	FailureHeaderMismatch FailureReason = "header mismatch"
+	// FailureProxy is used when the tunnel through the CONNECT proxy
+	// couldn't be established, the proxy being down or refusing it
+	FailureProxy FailureReason = "proxy error"
+	// FailureQUICHandshake is used when the QUIC handshake of the
+	// check over HTTP/3 failed, see Options.HTTP3Transport
+	FailureQUICHandshake FailureReason = "quic handshake"
+	// FailureNonceMismatch is used when the response didn't carry
+	// back the nonce sent with the request
+	FailureNonceMismatch FailureReason = "nonce mismatch"
+	// FailureTooSlow is used when the check succeeded but took longer
+	// than the latency allowed for the peer
+	FailureTooSlow FailureReason = "too slow"
+	// FailureCheckDeadline is used when a target of a check couldn't
+	// be probed before the next check was due
+	FailureCheckDeadline FailureReason = "check deadline"
+	// FailureInjected is used for the failures injected on purpose,
+	// e.g. for chaos drills, rather than met while checking
+	FailureInjected FailureReason = "injected"
+	// FailureOther is used when the failure couldn't be classified
+	FailureOther FailureReason = "other"
+)
+
+const (
+	// bodyReadMargin is how much is read beyond the length of the
+	// expected body, enough to tell a longer body from the expected
+	// one and to show some of it when reporting the mismatch
+	bodyReadMargin = 64
+
+	// NonceHeader carries the nonce sent with a check, the
+	// checked peer echoes it back in the response
+	NonceHeader = "X-Connectivity-Check-Nonce"
+
+	// SourceHeader carries the identity of the node doing the check,
+	// so the checked peer can tell who is checking it
+	SourceHeader = "X-Connectivity-Check-Source"
+
+	// ReceivedHeader carries when the checked peer received the
+	// request, in nanoseconds since the epoch
+	ReceivedHeader = "X-Connectivity-Check-Received"
+
+	// CorrelationHeader carries the correlation ID of a check, so
+	// that the logs of the checked peer can be tied to it
+	CorrelationHeader = "X-Correlation-ID"
+)
+
+// CheckError is returned by the reachability checks, it carries
+// the reason of the failure along with the underlying error
+type CheckError struct {
+	Reason FailureReason
+	Err    error
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Reason, e.Err)
+}
+
+// ReasonOf returns the FailureReason carried by err
+func ReasonOf(err error) FailureReason {
+	if err == nil {
+		return FailureNone
+	}
+	if ce, ok := err.(*CheckError); ok {
+		return ce.Reason
+	}
+	return FailureOther
+}
+
+// Options holds the settings used while checking reachability.
+// All the durations are in milliseconds, 0 disables a timeout.
+type Options struct {
+	// Timeout bounds the whole check
+	Timeout int
+	// ConnectTimeout bounds establishing the connection
+	ConnectTimeout int
+	// ReadTimeout bounds waiting for and reading the response
+	ReadTimeout int
+	// DSCP value marking the packets of the checks, 0 leaves them unmarked
+	DSCP int
+	// MSS clamps the TCP maximum segment size of the connections of
+	// the checks, 0 leaves it to the system. Only supported on linux.
+	MSS int
+	// KeepAliveIdle is how long a connection stays idle before the
+	// first TCP keepalive probe, KeepAliveInterval the time between
+	// the probes and KeepAliveCount how many unanswered probes close
+	// the connection. 0 keeps the default of the system, setting any
+	// of them enables the keepalive. They're only supported on linux,
+	// elsewhere a warning is logged and the defaults are kept.
+	KeepAliveIdle     int
+	KeepAliveInterval int
+	KeepAliveCount    int
+	// DisableKeepAlives makes every HTTP check use a new connection,
+	// for when reusing connections would mask a flaky path
+	DisableKeepAlives bool
+	// IdleConnTimeout bounds how long an idle connection is kept
+	// for reuse, 0 means no limit
+	IdleConnTimeout int
+	// MaxIdleConnsPerHost bounds the idle connections kept for reuse
+	// to each address, 0 uses the default of net/http
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections to each address, a check
+	// waits for one to be available, 0 means no limit
+	MaxConnsPerHost int
+	// HTTP2 makes the HTTP checks speak HTTP/2 without TLS, with
+	// prior knowledge, for the servers only speaking HTTP/2. Otherwise
+	// HTTP/1.1 is used, even where HTTP/2 could be negotiated, so the
+	// checks behave the same whatever the server supports.
+	HTTP2 bool
+	// SocketPath, when set, makes the HTTP checks connect to the
+	// given unix socket instead of the host of the URL
+	SocketPath string
+	// DialFunc, when set, establishes the connections instead of
+	// the standard dialer, e.g. through a service mesh
+	DialFunc DialFunc
+	// DialFuncID identifies DialFunc, see NewDialFuncID, the checks
+	// with the same one sharing their idle connections. Without it,
+	// the checks with a DialFunc don't keep their connections.
+	DialFuncID uint64
+	// HTTP3Transport, when set, sends the HTTP checks over HTTP/3
+	// instead, it's to be a QUIC RoundTripper owning its TLS settings.
+	// The failures of the QUIC handshake are then FailureQUICHandshake.
+	HTTP3Transport http.RoundTripper
+	// TLSServerName is the name sent and validated by the TLS checks,
+	// the host of the address when empty
+	TLSServerName string
+	// TLSVerify makes the TLS checks validate the certificate, its
+	// chain, name and expiry
+	TLSVerify bool
+	// ClientCertificate, when set, is presented by the TLS checks to
+	// the peers requiring mutual TLS. Their rejecting it is
+	// FailureTLSAuth.
+	ClientCertificate *ClientCertificate
+	// Method of the HTTP checks, GET when empty. With HEAD the
+	// response has no body, so only its status code is checked and
+	// the expected result is ignored.
+	Method string
+	// Body sent with the HTTP checks, e.g. with POST
+	Body string
+	// VerboseFailures logs, at debug level, the request and the
+	// response of the failed HTTP checks
+	VerboseFailures bool
+	// RedactHeaders are the headers whose values aren't logged
+	// with VerboseFailures
+	RedactHeaders []string
+	// Nonce, when set, is sent with the request and must be echoed
+	// back, proving the response comes from the checked peer
+	Nonce string
+	// SourceIdentity, when set, is sent with the HTTP checks to
+	// identify the node doing them, e.g. its host name or IP
+	SourceIdentity string
+	// CorrelationID, when set, is sent with the HTTP checks to
+	// correlate them across the logs of the services
+	CorrelationID string
+	// SourceAddress, when set, is the local IP the connections of
+	// the checks originate from
+	SourceAddress string
+	// SourcePort, when set, is the local port the connections of the
+	// checks originate from, reused right away. The connections of
+	// these checks aren't kept.
+	SourcePort int
+	// NegotiateSchema asks the HTTP checks to be answered with the
+	// latest SchemaVersion the checked peer knows, a legacy peer
+	// answering a plain pong
+	NegotiateSchema bool
+	// ContentType, when set, is the media type the responses of the
+	// HTTP checks must have, e.g. text/plain, the parameters such as
+	// the charset being ignored
+	ContentType string
+	// ConnectProxy, when set, is the address of an HTTP proxy the
+	// connections of the checks are tunneled through with CONNECT
+	ConnectProxy string
+	// TraceConnect measures how long establishing the TCP connection
+	// takes, apart from the whole check, see Timing.Connect
+	TraceConnect bool
+	// ExpectedHeader, when set, is the name of a header the response
+	// must carry with ExpectedHeaderValue, e.g. X-Health: ok. With
+	// HeaderOnly the body isn't compared.
+	ExpectedHeader      string
+	ExpectedHeaderValue string
+	HeaderOnly          bool
+	// StatusOnly accepts any 2xx status, the body not being compared,
+	// e.g. for the liveness and readiness endpoints of the peers
+	StatusOnly bool
+}
+
+func toDuration(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
 // IsReachable checks if the given IP address responds
 // to given URL request and the response has right values
 func IsReachable(url, result string, connectionTimeout int) (bool, error) {
+	return IsReachableWithOptions(url, result, Options{Timeout: connectionTimeout})
+}
+
+// IsReachableWithOptions is the same as IsReachable but allows
+// customizing how the check is done
+func IsReachableWithOptions(url, result string, opts Options) (bool, error) {
+	return isReachable(url, result, opts, &Timing{})
+}
+
+// Timing holds when the steps of an HTTP check happened
+type Timing struct {
+	// WroteRequest is when the request was sent
+	WroteRequest time.Time
+	// PeerReceived is when the peer received the request by its own
+	// clock, zero when it didn't report it
+	PeerReceived time.Time
+	// FirstByte is when the first byte of the response arrived
+	FirstByte time.Time
+	// SchemaVersion is the version of the schema of the response, see
+	// Options.NegotiateSchema, 0 when not negotiated
+	SchemaVersion int
+	// Connect is how long establishing the TCP connection took, see
+	// Options.TraceConnect, 0 when not measured or reused
+	Connect time.Duration
+	// LocalAddress is the local address of the connection the check
+	// went over, empty when it failed before connecting
+	LocalAddress string
+	// Reused informs if the connection was an idle one kept from a
+	// previous check
+	Reused bool
+	// BodyBytes is how many bytes of the body of the response were read
+	BodyBytes int64
+}
+
+// IsReachableWithTiming is the same as IsReachableWithOptions but
+// also returns when the steps of the check happened
+func IsReachableWithTiming(url, result string, opts Options) (bool, Timing, error) {
+	var timing Timing
+	ok, err := isReachable(url, result, opts, &timing)
+	return ok, timing, err
+}
+
+func isReachable(url, result string, opts Options, timing *Timing) (bool, error) {
 	logrus.Debugf("is %v Reachable", url)
 
-	timeout := time.Duration(connectionTimeout) * time.Millisecond
 	client := http.Client{
-		Timeout: timeout,
+		Timeout: toDuration(opts.Timeout),
+	}
+	if opts.HTTP3Transport != nil {
+		client.Transport = opts.HTTP3Transport
+	} else {
+		client.Transport = getTransport(opts)
 	}
 
-	resp, err := client.Get(url)
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var reqBody io.Reader
+	if opts.Body != "" {
+		reqBody = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
-		return false, err
+		return false, &CheckError{Reason: FailureOther, Err: err}
+	}
+	if opts.Nonce != "" {
+		req.Header.Set(NonceHeader, opts.Nonce)
+	}
+	if opts.SourceIdentity != "" {
+		req.Header.Set(SourceHeader, opts.SourceIdentity)
+	}
+	if opts.CorrelationID != "" {
+		req.Header.Set(CorrelationHeader, opts.CorrelationID)
+	}
+	if opts.NegotiateSchema {
+		req.Header.Set(SchemaHeader, strconv.Itoa(SchemaVersion))
 	}
 
+	// Once connected, the read timeout starts ticking
+	var connected, readExpired int32
+	ctx, cancel := context.WithCancel(withSourcePort(context.Background(), opts.SourcePort))
+	defer cancel()
+	var readTimerMu sync.Mutex
+	var readTimer *time.Timer
+	defer func() {
+		readTimerMu.Lock()
+		defer readTimerMu.Unlock()
+		if readTimer != nil {
+			readTimer.Stop()
+		}
+	}()
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			timing.WroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			timing.FirstByte = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			atomic.StoreInt32(&connected, 1)
+			recordConn(info.Reused)
+			timing.Reused = info.Reused
+			timing.LocalAddress = info.Conn.LocalAddr().String()
+			if opts.ReadTimeout > 0 {
+				readTimerMu.Lock()
+				defer readTimerMu.Unlock()
+				readTimer = time.AfterFunc(toDuration(opts.ReadTimeout), func() {
+					atomic.StoreInt32(&readExpired, 1)
+					cancel()
+				})
+			}
+		},
+	}
+	if opts.TraceConnect {
+		traceConnect(trace, timing)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if atomic.LoadInt32(&readExpired) == 1 {
+			return false, &CheckError{Reason: FailureReadTimeout, Err: err}
+		}
+		if opts.HTTP3Transport != nil && isQUICHandshakeError(err) {
+			return false, &CheckError{Reason: FailureQUICHandshake, Err: err}
+		}
+		return false, classifyError(err, atomic.LoadInt32(&connected) == 1)
+	}
+	defer resp.Body.Close()
+
 	logrus.Debugf("resp: %+v", resp)
+	if v := resp.Header.Get(ReceivedHeader); v != "" {
+		if ns, err := strconv.ParseInt(v, 10, 64); err == nil {
+			timing.PeerReceived = time.Unix(0, ns)
+		}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("got StatusCode: %v", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK && !(opts.StatusOnly && resp.StatusCode/100 == 2) {
+		if opts.VerboseFailures {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, verboseBodyLimit+1))
+			logFailure(req, resp, body, opts)
+		}
+		return false, &CheckError{
+			Reason: FailureStatusCode,
+			Err:    fmt.Errorf("got StatusCode: %v", resp.StatusCode),
+		}
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if ok, err := checkContentType(resp, opts.ContentType); !ok {
+		logFailure(req, resp, nil, opts)
+		return false, err
+	}
+
+	if ok, err := checkHeader(resp, opts.ExpectedHeader, opts.ExpectedHeaderValue); !ok {
+		logFailure(req, resp, nil, opts)
 		return false, err
 	}
 
-	if string(body) != result {
-		return false, fmt.Errorf("response from peer: %v didn't match expected: %v", string(body), result)
+	if method == http.MethodHead || opts.StatusOnly || (opts.HeaderOnly && opts.ExpectedHeader != "") {
+		ok, err := checkNonce(resp, opts.Nonce)
+		if !ok {
+			logFailure(req, resp, nil, opts)
+		}
+		return ok, err
 	}
 
-	resp.Body.Close()
+	limit := int64(len(result) + bodyReadMargin)
+	if opts.NegotiateSchema {
+		timing.SchemaVersion = SchemaLegacy
+		if v := resp.Header.Get(SchemaHeader); v != "" {
+			timing.SchemaVersion = RequestedSchema(v)
+		}
+		if timing.SchemaVersion >= SchemaJSON {
+			limit = schemaBodyLimit
+		}
+	}
+
+	// Only what is needed for the comparison is read, so that
+	// a huge or endless body doesn't get buffered
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+	timing.BodyBytes = int64(len(body))
+	if err != nil {
+		if atomic.LoadInt32(&readExpired) == 1 {
+			return false, &CheckError{Reason: FailureReadTimeout, Err: err}
+		}
+		return false, classifyError(err, true)
+	}
+
+	if got := pingStatus(timing.SchemaVersion, body); got != result {
+		logFailure(req, resp, body, opts)
+		if len(got) > len(result) && timing.SchemaVersion < SchemaJSON {
+			got += "..."
+		}
+		return false, &CheckError{
+			Reason: FailureBodyMismatch,
+			Err:    fmt.Errorf("response from peer: %v didn't match expected: %v", got, result),
+		}
+	}
+
+	ok, err := checkNonce(resp, opts.Nonce)
+	if !ok {
+		logFailure(req, resp, body, opts)
+	}
+	return ok, err
+}
+
+// checkContentType checks that the response has the expected media
+// type, if any
+func checkContentType(resp *http.Response, expected string) (bool, error) {
+	if expected == "" {
+		return true, nil
+	}
+	got := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(got)
+	if err != nil || !strings.EqualFold(mediaType, expected) {
+		return false, &CheckError{
+			Reason: FailureContentType,
+			Err:    fmt.Errorf("response from peer had content type: %q, expected: %q", got, expected),
+		}
+	}
+	return true, nil
+}
+
+// checkHeader checks that the response carries the expected value of
+// the given header, if any
+func checkHeader(resp *http.Response, name, expected string) (bool, error) {
+	if name == "" {
+		return true, nil
+	}
+	if got := resp.Header.Get(name); got != expected {
+		return false, &CheckError{
+			Reason: FailureHeaderMismatch,
+			Err:    fmt.Errorf("response from peer had header %v: %q, expected: %q", name, got, expected),
+		}
+	}
+	return true, nil
+}
+
+// checkNonce checks that the response carries back the
+// nonce sent with the request, if any
+func checkNonce(resp *http.Response, nonce string) (bool, error) {
+	if nonce != "" {
+		if got := resp.Header.Get(NonceHeader); got != nonce {
+			return false, &CheckError{
+				Reason: FailureNonceMismatch,
+				Err:    fmt.Errorf("response from peer carried nonce: %q, expected: %q", got, nonce),
+			}
+		}
+	}
 	return true, nil
 }
 
+// IsTCPReachable checks if a TCP connection can be established
+// to the given address
+func IsTCPReachable(address string, opts Options) (bool, error) {
+	ok, _, err := IsTCPReachableWithTiming(address, opts)
+	return ok, err
+}
+
+// IsTCPReachableWithTiming is the same as IsTCPReachable but also
+// returns how long establishing the connection took, see
+// Options.TraceConnect
+func IsTCPReachableWithTiming(address string, opts Options) (bool, Timing, error) {
+	var timing Timing
+	logrus.Debugf("is %v Reachable over TCP", address)
+
+	timeout := opts.ConnectTimeout
+	if timeout == 0 || (opts.Timeout > 0 && opts.Timeout < timeout) {
+		timeout = opts.Timeout
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, toDuration(timeout))
+		defer cancel()
+	}
+	start := time.Now()
+	conn, err := dialFunc(opts)(ctx, "tcp", address)
+	if err != nil {
+		return false, timing, classifyError(err, false)
+	}
+	if opts.TraceConnect {
+		timing.Connect = time.Since(start)
+	}
+	timing.LocalAddress = conn.LocalAddr().String()
+	conn.Close()
+	return true, timing, nil
+}
+
+// traceConnect records in timing how long the TCP handshake of the
+// request took, from the first attempt to the one that succeeded
+func traceConnect(trace *httptrace.ClientTrace, timing *Timing) {
+	var mu sync.Mutex
+	var start time.Time
+	trace.ConnectStart = func(network, addr string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if start.IsZero() {
+			start = time.Now()
+		}
+	}
+	trace.ConnectDone = func(network, addr string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil && timing.Connect == 0 {
+			timing.Connect = time.Since(start)
+		}
+	}
+}
+
+// classifyError figures out the FailureReason of an error returned
+// while doing the request, connected tells if the connection to
+// the remote end was established before the error happened
+func classifyError(err error, connected bool) error {
+	if isProxyError(err) {
+		return &CheckError{Reason: FailureProxy, Err: err}
+	}
+	if isTLSAuthError(err) {
+		return &CheckError{Reason: FailureTLSAuth, Err: err}
+	}
+	if isTLSError(err) {
+		return &CheckError{Reason: FailureTLS, Err: err}
+	}
+	if connected {
+		if isTimeout(err) {
+			return &CheckError{Reason: FailureReadTimeout, Err: err}
+		}
+		return &CheckError{Reason: FailureOther, Err: err}
+	}
+	if isTimeout(err) {
+		return &CheckError{Reason: FailureConnectTimeout, Err: err}
+	}
+	if isRefused(err) {
+		return &CheckError{Reason: FailureRefused, Err: err}
+	}
+	return &CheckError{Reason: FailureConnect, Err: err}
+}
+
+func isTimeout(err error) bool {
+	if ue, ok := err.(interface {
+		Timeout() bool
+	}); ok {
+		return ue.Timeout()
+	}
+	return false
+}
+
+func isRefused(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case syscall.Errno:
+			return e == syscall.ECONNREFUSED
+		case *os.SyscallError:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *url.Error:
+			err = e.Err
+		case interface {
+			Unwrap() error
+		}:
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 // IsValidPort checks if the input port string is valid.
 // Valid port range : 1025 - 65535
 func IsValidPort(port int) bool {
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// verboseBodyLimit bounds how much of the body of a response
+	// is logged for a failed check
+	verboseBodyLimit = 512
+
+	redacted = "<redacted>"
+)
+
+// logFailure logs, at debug level, the request and the response of
+// a failed HTTP check, the sensitive headers being redacted
+func logFailure(req *http.Request, resp *http.Response, body []byte, opts Options) {
+	if !opts.VerboseFailures || logrus.GetLevel() < logrus.DebugLevel {
+		return
+	}
+	logrus.Debugf("failed check request: %v %v headers=%v", req.Method, req.URL, redactHeaders(req.Header, opts.RedactHeaders))
+	got := string(body)
+	if len(got) > verboseBodyLimit {
+		got = got[:verboseBodyLimit] + "..."
+	}
+	logrus.Debugf("failed check response: %v headers=%v body=%q", resp.Status, redactHeaders(resp.Header, opts.RedactHeaders), got)
+}
+
+func redactHeaders(h http.Header, sensitive []string) http.Header {
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		out[name] = values
+		for _, s := range sensitive {
+			if strings.EqualFold(name, s) {
+				out[name] = []string{redacted}
+				break
+			}
+		}
+	}
+	return out
+}